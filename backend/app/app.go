@@ -33,8 +33,15 @@ func App(path string) {
 		log.Error().AnErr("DatabaseError", err).Msg("Failed to set up connection to database. Application spinning down.")
 		os.Exit(1)
 	}
+	if err = initGeo(config.Geo); err != nil {
+		log.Error().Err(err).Msg("Failed to load gazetteer. Application spinning down.")
+		os.Exit(1)
+	}
 	initStorers(config.Store)
-	initServices(config.Store, storers)
+	if err = initServices(config, storers); err != nil {
+		log.Error().Err(err).Msg("Invalid resampling of renditions. Application spinning down.")
+		os.Exit(1)
+	}
 
 	r := gin.New()
 
@@ -43,7 +50,10 @@ func App(path string) {
 
 	restrictedCORS := cors.DefaultConfig()
 	restrictedCORS.AllowOrigins = []string{"https://raw.ninja", "https://rawninja.net", config.Auth.FrontendRoot}
-	restrictedCORS.AllowHeaders = []string{"Authorization", "Origin", "Content-Length", "Content-Type"}
+	restrictedCORS.AllowHeaders = []string{"Authorization", "Origin", "Content-Length", "Content-Type",
+		"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata"}
+	restrictedCORS.ExposeHeaders = []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+		"Upload-Offset", "Upload-Length", "Upload-Expires", "Upload-Photo-Id"}
 	restrictedCORS.AllowCredentials = true
 
 	r.Use(web.LoggingMiddleware)
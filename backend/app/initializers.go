@@ -15,7 +15,10 @@ import (
 	"github.com/inokone/photostorage/auth/user"
 	"github.com/inokone/photostorage/collection"
 	"github.com/inokone/photostorage/common"
+	"github.com/inokone/photostorage/export"
+	"github.com/inokone/photostorage/geo"
 	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/mail"
 	"github.com/inokone/photostorage/onetime"
 	"github.com/inokone/photostorage/photo"
 	"github.com/inokone/photostorage/ruleset"
@@ -82,7 +85,7 @@ func levelFor(level string) logger.LogLevel {
 
 func initStorers(c *common.ImageStoreConfig) {
 	storers.Photos = photo.NewGORMStorer(db)
-	storers.Images = image.NewStorer(c)
+	storers.Images = image.NewStorer(c, db)
 	storers.Users = user.NewGORMStorer(db)
 	storers.Roles = role.NewGORMStorer(db)
 	storers.Accounts = account.NewGORMStorer(db)
@@ -90,10 +93,47 @@ func initStorers(c *common.ImageStoreConfig) {
 	storers.Rules = rule.NewGORMStorer(db)
 	storers.RuleSets = ruleset.NewGORMStorer(db)
 	storers.OneTime = onetime.NewGORMStorer(db)
+	storers.Tickets = photo.NewGORMTicketStorer(db)
+	storers.Jobs = photo.NewGORMJobStorer(db)
+	storers.Takeouts = export.NewGORMTakeoutStorer(db)
 }
 
-func initServices(c *common.ImageStoreConfig, storers web.Storers) {
-	services.Load = *photo.NewLoadService(storers.Photos, storers.Images, c)
+func initServices(c *common.AppConfig, storers web.Storers) error {
+	resampling, err := image.NewResampling(c.Store)
+	if err != nil {
+		return err
+	}
+	services.Resampling = resampling
+	services.Load = *photo.NewLoadService(storers.Photos, storers.Images, c.Store)
+	uploader := photo.NewUploadService(storers.Photos, storers.Images, storers.Tickets, c.Store, resampling)
+	uploader.StartCleanup()
+	services.Jobs = photo.NewJobService(uploader, storers.Jobs, storers.Users, c.Store.UploadWorkers)
+	services.Jobs.Start()
+	services.Takeouts = export.NewTakeoutService(storers.Takeouts, storers.Users, storers.Photos, storers.Collections,
+		storers.RuleSets, storers.Images, storers.OneTime, mail.NewService(c.Mail), c.Auth.BackendRoot+"/api/public/v1/onetime/archive/")
+	services.Takeouts.Start()
+	return nil
+}
+
+func initGeo(c *common.GeoConfig) error {
+	var (
+		g   *geo.Gazetteer
+		err error
+	)
+	if len(c.GazetteerPath) > 0 {
+		g, err = geo.Open(os.DirFS(c.GazetteerPath))
+	} else {
+		g, err = geo.Embedded()
+	}
+	if err != nil {
+		return err
+	}
+	if c.MaxDistance > 0 {
+		g.MaxDistance = c.MaxDistance
+	}
+	geo.SetDefault(g)
+	log.Info().Int("places", g.Len()).Msg("Gazetteer loaded.")
+	return nil
 }
 
 func initLog() {
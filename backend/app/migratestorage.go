@@ -0,0 +1,63 @@
+package app
+
+import (
+	"os"
+
+	"github.com/inokone/photostorage/common"
+	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/photo"
+	"github.com/rs/zerolog/log"
+)
+
+const migrationWorkers = 8
+
+// MigrateStorage copies the images of all photos from the configured image store to the image store configured in
+// the target env file, e.g. from local disk to S3 or between buckets. Copies are verified with checksums. The
+// migration is resumable and can run while the application is serving from the configured store: run it again to
+// copy the photos uploaded in the meantime, then switch the configuration to the target.
+func MigrateStorage(path string, target string) {
+	var err error
+
+	if err = initConf(path); err != nil {
+		log.Err(err).Msg("Failed to load application configuration.")
+		os.Exit(1)
+	}
+
+	if err = initDb(config.Database, config.Log); err != nil {
+		log.Err(err).Msg("Failed to set up connection to database. Application spinning down.")
+		os.Exit(1)
+	}
+
+	tc, err := common.LoadImageStoreConfig(target)
+	if err != nil {
+		log.Err(err).Str("target", target).Msg("Failed to load target image store configuration. Application spinning down.")
+		os.Exit(1)
+	}
+	if tc.Deduplicate {
+		log.Error().Str("target", target).Msg("Deduplication is not supported on the target image store. Application spinning down.")
+		os.Exit(1)
+	}
+
+	s := photo.NewMigrationService(photo.NewGORMStorer(db), image.NewStorer(config.Store, db), image.NewStorer(tc, db), migrationWorkers)
+	report, err := s.Migrate()
+	if err != nil {
+		log.Err(err).Msg("Storage migration failed. Application spinning down.")
+		os.Exit(1)
+	}
+
+	log.Info().
+		Str("target", target).
+		Int64("photos", report.Photos).
+		Int64("copied", report.Copied).
+		Int64("skipped", report.Skipped).
+		Int64("frozen", report.Frozen).
+		Int64("bytes", report.Bytes).
+		Int64("failed", report.Failed).
+		Msg("Storage migration finished")
+	if report.Failed > 0 {
+		log.Warn().Msg("Some images failed to migrate, run the migration again before switching the image store.")
+	}
+	if report.Frozen > 0 {
+		log.Warn().Msg("RAWs in frozen storage were not migrated, restore them and run the migration again before switching the image store.")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/inokone/photostorage/auth/role"
 	"github.com/inokone/photostorage/auth/user"
 	"github.com/inokone/photostorage/collection"
+	"github.com/inokone/photostorage/export"
 	"github.com/inokone/photostorage/image"
 	"github.com/inokone/photostorage/onetime"
 	"github.com/inokone/photostorage/photo"
@@ -50,7 +51,7 @@ func Migrate(path string) {
 	}
 
 	if err := db.AutoMigrate(&photo.Photo{}, &role.Role{}, &user.User{}, &descriptor.Descriptor{}, &image.Metadata{}, &account.Account{},
-		&collection.Collection{}, &rule.Rule{}, &ruleset.RuleSet{}, &onetime.Access{}); err != nil {
+		&collection.Collection{}, &rule.Rule{}, &ruleset.RuleSet{}, &onetime.Access{}, &photo.UploadTicket{}, &image.Blob{}, &image.BlobRef{}, &image.ReplicationTask{}, &photo.UploadJob{}, &export.Takeout{}); err != nil {
 		log.Err(err).Msg("Database migration failed. Application spinning down.")
 		os.Exit(1)
 	}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"errors"
+	"os"
+
+	"github.com/inokone/photostorage/image"
+	"github.com/rs/zerolog/log"
+)
+
+// Reencrypt re-wraps the data keys of all stored images with the active master key after a key rotation, so the
+// previous master key can be removed from the configuration. Every image of the store is walked: photos, takeout
+// archives and the RAWs of uploads in flight. Images stored before encryption was enabled are encrypted while
+// plaintext images are allowed. Cached renders are deleted instead of re-wrapped.
+func Reencrypt(path string) {
+	var err error
+
+	if err = initConf(path); err != nil {
+		log.Err(err).Msg("Failed to load application configuration.")
+		os.Exit(1)
+	}
+
+	if err = initDb(config.Database, config.Log); err != nil {
+		log.Err(err).Msg("Failed to set up connection to database. Application spinning down.")
+		os.Exit(1)
+	}
+
+	images := image.NewStorer(config.Store, db)
+	rotator, ok := images.(image.Rotator)
+	if !ok {
+		log.Error().Err(image.ErrNotEncrypted).Msg("Re-encryption failed. Application spinning down.")
+		os.Exit(1)
+	}
+
+	variants := []image.Variant{image.RawVariant, image.ArchiveVariant}
+	for _, r := range image.Renditions {
+		variants = append(variants, r.Variant)
+	}
+
+	var count, rotated, failed int
+	err = images.List(func(id string) error {
+		count++
+		for _, v := range variants {
+			if !stored(images, id, v) {
+				continue
+			}
+			done, err := rotator.Rotate(id, v)
+			if errors.Is(err, image.ErrNotEncrypted) {
+				return err
+			}
+			if err != nil {
+				log.Warn().Err(err).Str("id", id).Str("variant", string(v)).Msg("Failed to re-encrypt image")
+				failed++
+				continue
+			}
+			if done {
+				rotated++
+			}
+		}
+		if err := images.DeleteVariants(id, image.RenderPrefix); err != nil {
+			log.Warn().Err(err).Str("id", id).Msg("Failed to delete cached renders")
+		}
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msg("Re-encryption failed. Application spinning down.")
+		os.Exit(1)
+	}
+
+	log.Info().Int("images", count).Int("rotated", rotated).Int("failed", failed).Msg("Re-encryption finished")
+}
+
+// stored tells whether the variant of the image is stored, e.g. takeout archives have no renditions. Variants that
+// can not be decrypted are stored, their re-encryption fails.
+func stored(images image.Storer, id string, variant image.Variant) bool {
+	_, err := images.Stat(id, variant)
+	return err == nil || errors.Is(err, image.ErrUnknownKey) || errors.Is(err, image.ErrCorruptObject)
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"os"
+
+	"github.com/inokone/photostorage/image"
+	"github.com/rs/zerolog/log"
+)
+
+// Resync makes the replica of the image store, named by the path of its configuration file, identical to the
+// primary image store after it fell behind: copies missing or different images and deletes images not on the
+// primary. Images written to the replica while the resync is running are kept.
+func Resync(path string, replica string) {
+	var err error
+
+	if err = initConf(path); err != nil {
+		log.Err(err).Msg("Failed to load application configuration.")
+		os.Exit(1)
+	}
+
+	if err = initDb(config.Database, config.Log); err != nil {
+		log.Err(err).Msg("Failed to set up connection to database. Application spinning down.")
+		os.Exit(1)
+	}
+
+	images, err := image.NewReplicas(config.Store, db)
+	if err != nil {
+		log.Err(err).Msg("Failed to set up image store replicas. Application spinning down.")
+		os.Exit(1)
+	}
+	report, err := images.Resync(replica)
+	if err != nil {
+		log.Err(err).Str("replica", replica).Msg("Resync failed. Application spinning down.")
+		os.Exit(1)
+	}
+
+	log.Info().
+		Str("replica", replica).
+		Int("images", report.Images).
+		Int("copied", report.Copied).
+		Int("moved", report.Moved).
+		Int("deleted", report.Deleted).
+		Int("failed", report.Failed).
+		Msg("Resync finished")
+}
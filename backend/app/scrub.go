@@ -0,0 +1,60 @@
+package app
+
+import (
+	"os"
+
+	"github.com/inokone/photostorage/export"
+	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/photo"
+	"github.com/rs/zerolog/log"
+)
+
+// Scrub verifies the integrity of the image store against the photos in the database: reports missing RAWs and
+// renditions, checksum mismatches, images of deleted photos and orphan images. Findings are repaired where possible,
+// unless dry run is requested.
+func Scrub(path string, dryRun bool) {
+	var err error
+
+	if err = initConf(path); err != nil {
+		log.Err(err).Msg("Failed to load application configuration.")
+		os.Exit(1)
+	}
+
+	if err = initDb(config.Database, config.Log); err != nil {
+		log.Err(err).Msg("Failed to set up connection to database. Application spinning down.")
+		os.Exit(1)
+	}
+
+	resampling, err := image.NewResampling(config.Store)
+	if err != nil {
+		log.Err(err).Msg("Invalid resampling of renditions. Application spinning down.")
+		os.Exit(1)
+	}
+
+	var (
+		photos  = photo.NewGORMStorer(db)
+		images  = image.NewStorer(config.Store, db)
+		uploads = photo.NewUploadService(photos, images, photo.NewGORMTicketStorer(db), config.Store, resampling)
+		jobs    = photo.NewJobService(uploads, photo.NewGORMJobStorer(db), nil, 0)
+	)
+	s := photo.NewScrubService(photos, images, resampling, dryRun, export.NewGORMTakeoutStorer(db), uploads, jobs)
+	report, err := s.Scrub()
+	if err != nil {
+		log.Err(err).Msg("Scrub failed. Application spinning down.")
+		os.Exit(1)
+	}
+
+	log.Info().
+		Bool("dry_run", dryRun).
+		Int("photos", report.Photos).
+		Int("missing_raws", report.MissingRaws).
+		Int("missing_renditions", report.MissingRenditions).
+		Int("checksum_mismatches", report.ChecksumMismatches).
+		Int("checksums_recorded", report.ChecksumsRecorded).
+		Int("hashes_recorded", report.HashesRecorded).
+		Int("purged", report.Purged).
+		Int("orphans", report.Orphans).
+		Int("repaired", report.Repaired).
+		Int("failed", report.Failed).
+		Msg("Scrub finished")
+}
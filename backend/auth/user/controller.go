@@ -162,6 +162,7 @@ func (c Controller) Update(g *gin.Context) {
 
 	usr.FirstName = in.FirstName
 	usr.LastName = in.LastName
+	usr.DuplicatePolicy = in.DuplicatePolicy
 
 	if err = c.users.Update(usr); err != nil {
 		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid user parameters provided!"})
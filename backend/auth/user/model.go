@@ -11,19 +11,20 @@ import (
 
 // User is the user representation for database storage.
 type User struct {
-	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
-	Email     string    `gorm:"type:varchar(255);uniqueIndex;not null"`
-	PassHash  string    `gorm:"type:varchar(100)"`
-	FirstName string    `gorm:"type:varchar(100)"`
-	LastName  string    `gorm:"type:varchar(100)"`
-	Role      role.Role `gorm:"foreignKey:RoleID"`
-	Source    string    `gorm:"type:varchar(255)"`
-	Enabled   bool      `gorm:"default:true"`
-	RoleID    int
-	Status    Status
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt
+	ID              uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	Email           string    `gorm:"type:varchar(255);uniqueIndex;not null"`
+	PassHash        string    `gorm:"type:varchar(100)"`
+	FirstName       string    `gorm:"type:varchar(100)"`
+	LastName        string    `gorm:"type:varchar(100)"`
+	Role            role.Role `gorm:"foreignKey:RoleID"`
+	Source          string    `gorm:"type:varchar(255)"`
+	Enabled         bool      `gorm:"default:true"`
+	RoleID          int
+	Status          Status
+	DuplicatePolicy string `gorm:"type:varchar(16)"` // preferred handling of photos already uploaded
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       gorm.DeletedAt
 }
 
 // Status id the accound status of the user
@@ -74,13 +75,14 @@ func (u *User) IsActive() bool {
 // AsProfile is a method of the `User` struct. It converts a `User` object into a `Profile` object.
 func (u *User) AsProfile() Profile {
 	return Profile{
-		ID:        u.ID.String(),
-		Email:     u.Email,
-		FirstName: u.FirstName,
-		LastName:  u.LastName,
-		Role:      u.Role.AsProfileRole(),
-		Status:    string(u.Status),
-		Source:    u.Source,
+		ID:              u.ID.String(),
+		Email:           u.Email,
+		FirstName:       u.FirstName,
+		LastName:        u.LastName,
+		Role:            u.Role.AsProfileRole(),
+		Status:          string(u.Status),
+		Source:          u.Source,
+		DuplicatePolicy: u.DuplicatePolicy,
 	}
 }
 
@@ -115,13 +117,14 @@ type Credentials struct {
 
 // Profile is the JSON user representation for authenticated users
 type Profile struct {
-	ID        string           `json:"id"`
-	Email     string           `json:"email"`
-	FirstName string           `json:"first_name"`
-	LastName  string           `json:"last_name"`
-	Role      role.ProfileRole `json:"role"`
-	Status    string           `json:"status"`
-	Source    string           `json:"source"`
+	ID              string           `json:"id"`
+	Email           string           `json:"email"`
+	FirstName       string           `json:"first_name"`
+	LastName        string           `json:"last_name"`
+	Role            role.ProfileRole `json:"role"`
+	Status          string           `json:"status"`
+	Source          string           `json:"source"`
+	DuplicatePolicy string           `json:"duplicate_policy" binding:"omitempty,oneof=reject skip link"`
 }
 
 // Registration is the JSON user representation for registration/signup process
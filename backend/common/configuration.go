@@ -1,6 +1,8 @@
 package common
 
 import (
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/spf13/viper"
@@ -29,13 +31,51 @@ type RDBConfig struct {
 type ImageStoreConfig struct {
 	Type         string `mapstructure:"IMG_STORE_TYPE"`
 	Path         string `mapstructure:"IMG_STORE_PATH"`
+	ColdPath     string `mapstructure:"IMG_STORE_COLD_PATH"`
 	Quota        int64  `mapstructure:"IMG_STORE_QUOTA"`
 	RawBucket    string `mapstructure:"IMG_STORE_RAW_BUCKET"`
 	ThumbBucket  string `mapstructure:"IMG_STORE_THUMB_BUCKET"`
 	AwsKey       string `mapstructure:"IMG_STORE_AWS_KEY"`
 	AwsSecret    string `mapstructure:"IMG_STORE_AWS_SECRET"`
+	AwsRegion    string `mapstructure:"IMG_STORE_AWS_REGION"`
+	Endpoint     string `mapstructure:"IMG_STORE_ENDPOINT"`
+	PathStyle    bool   `mapstructure:"IMG_STORE_PATH_STYLE"`
+	SSE          string `mapstructure:"IMG_STORE_SSE"`
+	KMSKeyID     string `mapstructure:"IMG_STORE_KMS_KEY_ID"`
 	UsePresigned bool   `mapstructure:"IMG_STORE_USE_PRESIGNED"`
 	PresignedTTL int64  `mapstructure:"IMG_STORE_PRESIGNED_TTL"`
+	Deduplicate  bool   `mapstructure:"IMG_STORE_DEDUPLICATE"`
+	// EncryptionKeys is a comma separated list of `id:base64-key` master keys, images are encrypted at rest when set
+	EncryptionKeys  string `mapstructure:"IMG_STORE_ENCRYPTION_KEYS"`
+	EncryptionKeyID string `mapstructure:"IMG_STORE_ENCRYPTION_KEY_ID"`
+	// EncryptionPlaintext lets images stored before encryption was enabled be read as plaintext until encrypted, images
+	// without encryption header are rejected as corrupt otherwise
+	EncryptionPlaintext bool   `mapstructure:"IMG_STORE_ENCRYPTION_PLAINTEXT"`
+	SFTPAddress         string `mapstructure:"IMG_STORE_SFTP_ADDRESS"`
+	SFTPUser            string `mapstructure:"IMG_STORE_SFTP_USER"`
+	SFTPPassword        string `mapstructure:"IMG_STORE_SFTP_PASSWORD"`
+	SFTPKeyPath         string `mapstructure:"IMG_STORE_SFTP_KEY_PATH"`
+	// SFTPHostKey is the public key of the SFTP server in authorized_keys format, the server is verified against it
+	SFTPHostKey    string `mapstructure:"IMG_STORE_SFTP_HOST_KEY"`
+	WebDAVURL      string `mapstructure:"IMG_STORE_WEBDAV_URL"`
+	WebDAVUser     string `mapstructure:"IMG_STORE_WEBDAV_USER"`
+	WebDAVPassword string `mapstructure:"IMG_STORE_WEBDAV_PASSWORD"`
+	// Replicas is a comma separated list of env files with the image store configuration of the replicas
+	Replicas string `mapstructure:"IMG_STORE_REPLICAS"`
+	// Replication is either "sync" to write the replicas with the primary, or "async" to write them from a queue
+	Replication string `mapstructure:"IMG_STORE_REPLICATION"`
+	// StagingPath is a local directory for partial resumable uploads, shared by all instances of the application
+	StagingPath   string `mapstructure:"IMG_STORE_STAGING_PATH"`
+	MaxUploadSize int64  `mapstructure:"IMG_STORE_MAX_UPLOAD_SIZE"`
+	// UploadWorkers is the number of workers processing asynchronous uploads
+	UploadWorkers int `mapstructure:"IMG_STORE_UPLOAD_WORKERS"`
+	// ThumbKernel is the resampling kernel of the renditions: nearest, approxbilinear, bilinear or catmullrom
+	ThumbKernel  string `mapstructure:"IMG_STORE_THUMB_KERNEL"`
+	ThumbQuality int    `mapstructure:"IMG_STORE_THUMB_QUALITY"`
+	// ThumbUpscale lets images smaller than the renditions be enlarged to the size of the renditions
+	ThumbUpscale   bool `mapstructure:"IMG_STORE_THUMB_UPSCALE"`
+	ThumbMaxWidth  int  `mapstructure:"IMG_STORE_THUMB_MAX_WIDTH"`
+	ThumbMaxHeight int  `mapstructure:"IMG_STORE_THUMB_MAX_HEIGHT"`
 }
 
 // MessagingConfig is a configuration of the message bus.
@@ -72,6 +112,14 @@ type MailConfig struct {
 	SMTPPort       int    `mapstructure:"MAIL_SMTP_PORT"`
 }
 
+// GeoConfig is a configuration of the reverse geocoding of photo locations.
+type GeoConfig struct {
+	// GazetteerPath is a directory with a GeoNames dump replacing the embedded gazetteer, e.g. with `cities1000.txt`,
+	// `admin1CodesASCII.txt`, `countryInfo.txt` and optionally `countryBounds.txt` for coordinates without a place nearby
+	GazetteerPath string  `mapstructure:"GEO_GAZETTEER_PATH"`
+	MaxDistance   float64 `mapstructure:"GEO_MAX_DISTANCE"`
+}
+
 // LogConfig is a configuration of the logging.
 type LogConfig struct {
 	LogLevel  string `mapstructure:"LOG_LEVEL"`
@@ -87,6 +135,7 @@ type AppConfig struct {
 	Mail     *MailConfig
 	Web      *WebConfig
 	Msg      *MessagingConfig
+	Geo      *GeoConfig
 }
 
 // LoadConfig is a function loading the configuration from app.env file in the runtime directory or environment variables.
@@ -99,6 +148,7 @@ func LoadConfig(path string) (*AppConfig, error) {
 	var ml MailConfig
 	var wb WebConfig
 	var ms MessagingConfig
+	var ge GeoConfig
 	viper.AddConfigPath(path)
 	viper.AddConfigPath(".")
 	viper.AddConfigPath("/etc/rawninja/")
@@ -109,8 +159,8 @@ func LoadConfig(path string) (*AppConfig, error) {
 	viper.SetDefault("JWT_EXPIRATION_HOURS", 24)
 	viper.SetDefault("DB_SSL_MODE", "disable")
 	viper.SetDefault("PORT", 8080)
-	viper.SetDefault("IMG_STORE_USE_PRESIGNED", false)
-	viper.SetDefault("IMG_STORE_PRESIGNED_TTL", 300)
+	viper.SetDefault("GEO_MAX_DISTANCE", 50)
+	imageStoreDefaults(viper.GetViper())
 	viper.AutomaticEnv()
 
 	err := viper.ReadInConfig()
@@ -138,5 +188,42 @@ func LoadConfig(path string) (*AppConfig, error) {
 	if err = viper.Unmarshal(&ms); err != nil {
 		return nil, err
 	}
-	return &AppConfig{Database: &db, Store: &is, Auth: &au, Log: &lg, Mail: &ml, Web: &wb, Msg: &ms}, nil
+	if err = viper.Unmarshal(&ge); err != nil {
+		return nil, err
+	}
+	return &AppConfig{Database: &db, Store: &is, Auth: &au, Log: &lg, Mail: &ml, Web: &wb, Msg: &ms, Geo: &ge}, nil
+}
+
+// LoadImageStoreConfig is a function loading an image store configuration from the env file, e.g. of a replica.
+// Environment variables are not considered, as they configure the image store of the application.
+func LoadImageStoreConfig(file string) (*ImageStoreConfig, error) {
+	var is ImageStoreConfig
+	v := viper.New()
+	v.SetConfigFile(file)
+	v.SetConfigType("env")
+	imageStoreDefaults(v)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	if err := v.Unmarshal(&is); err != nil {
+		return nil, err
+	}
+	return &is, nil
+}
+
+func imageStoreDefaults(v *viper.Viper) {
+	v.SetDefault("IMG_STORE_USE_PRESIGNED", false)
+	v.SetDefault("IMG_STORE_PRESIGNED_TTL", 300)
+	v.SetDefault("IMG_STORE_DEDUPLICATE", false)
+	v.SetDefault("IMG_STORE_AWS_REGION", "eu-central-1")
+	v.SetDefault("IMG_STORE_PATH_STYLE", false)
+	v.SetDefault("IMG_STORE_REPLICATION", "sync")
+	v.SetDefault("IMG_STORE_STAGING_PATH", filepath.Join(os.TempDir(), "rawninja-staging"))
+	v.SetDefault("IMG_STORE_MAX_UPLOAD_SIZE", 512<<20)
+	v.SetDefault("IMG_STORE_UPLOAD_WORKERS", 4)
+	v.SetDefault("IMG_STORE_THUMB_KERNEL", "catmullrom")
+	v.SetDefault("IMG_STORE_THUMB_QUALITY", 85)
+	v.SetDefault("IMG_STORE_THUMB_UPSCALE", false)
+	v.SetDefault("IMG_STORE_THUMB_MAX_WIDTH", 1000)
+	v.SetDefault("IMG_STORE_THUMB_MAX_HEIGHT", 1000)
 }
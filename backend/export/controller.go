@@ -0,0 +1,210 @@
+package export
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/collection"
+	"github.com/inokone/photostorage/common"
+	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/photo"
+	"github.com/rs/zerolog/log"
+)
+
+// Controller is a struct for all REST handlers related to exports in the application.
+type Controller struct {
+	collections collection.Storer
+	photos      photo.Storer
+	service     *Service
+	takeouts    *TakeoutService
+}
+
+// NewController creates a new `Controller` instance based on the collection and photo persistence and the takeout
+// service provided in the parameters.
+func NewController(collections collection.Storer, photos photo.Storer, images image.Loader, takeouts *TakeoutService) Controller {
+	return Controller{
+		collections: collections,
+		photos:      photos,
+		service:     NewService(images),
+		takeouts:    takeouts,
+	}
+}
+
+// Collection is a method of `Controller`. Handles export requests of albums and uploads, streaming a ZIP archive of
+// the RAW files of the collection.
+// @Summary Collection export endpoint
+// @Schemes
+// @Tags photos
+// @Description Downloads the RAW files of an album or upload as a ZIP archive
+// @Produce application/zip
+// @Param id path string true "ID of the album or upload"
+// @Param manifest query string false "Format of the metadata manifest included: json or csv"
+// @Success 200 {file} file
+// @Failure 400 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Router /albums/{id}/export [get]
+// @Router /uploads/{id}/export [get]
+func (c Controller) Collection(g *gin.Context) {
+	id, err := uuid.Parse(g.Param("id"))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid identifier!"})
+		return
+	}
+	manifest, err := ParseManifest(g.Query("manifest"))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid manifest format!"})
+		return
+	}
+	cl, err := c.collections.ByID(id)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Collection does not exist!"})
+		return
+	}
+	if err = authorize(g, cl.UserID); err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Collection does not exist!"})
+		return
+	}
+	c.stream(g, cl.Name, cl.Photos, manifest)
+}
+
+// Photos is a method of `Controller`. Handles export requests of a selection of photos, streaming a ZIP archive of
+// the RAW files.
+// @Summary Photo selection export endpoint
+// @Schemes
+// @Tags photos
+// @Description Downloads the RAW files of the selected photos as a ZIP archive
+// @Accept json
+// @Produce application/zip
+// @Param data body export.Request true "IDs of the photos to export"
+// @Success 200 {file} file
+// @Failure 400 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Router /photos/export [post]
+func (c Controller) Photos(g *gin.Context) {
+	var req Request
+	if err := g.ShouldBindJSON(&req); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid photo identifiers!"})
+		return
+	}
+	manifest, err := ParseManifest(req.Manifest)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid manifest format!"})
+		return
+	}
+	usr, err := currentUser(g)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Error with the session. Please log in again!"})
+		return
+	}
+
+	photos := make([]photo.Photo, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		p, err := c.photos.Load(id.String())
+		if err != nil || p.UserID != usr.ID {
+			g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Photo does not exist!"})
+			return
+		}
+		photos = append(photos, *p)
+	}
+	c.stream(g, "photos", photos, manifest)
+}
+
+// Takeout is a method of `Controller`. Handles takeout requests of the current user, exporting all data of the
+// account asynchronously. The download link of the archive is sent by e-mail when it is ready.
+// @Summary Account takeout endpoint
+// @Schemes
+// @Tags account
+// @Description Requests an archive of all photos, albums, rule sets and the profile of the user
+// @Produce json
+// @Success 202 {object} export.TakeoutResponse
+// @Failure 401 {object} common.StatusMessage
+// @Failure 409 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /account/takeout [post]
+func (c Controller) Takeout(g *gin.Context) {
+	usr, err := currentUser(g)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Error with the session. Please log in again!"})
+		return
+	}
+	t, err := c.takeouts.Request(usr)
+	if errors.Is(err, ErrTakeoutInProgress) {
+		g.AbortWithStatusJSON(http.StatusConflict, common.StatusMessage{Code: 409, Message: "A takeout is already in progress!"})
+		return
+	}
+	if err != nil {
+		log.Err(err).Msg("Failed to request takeout!")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Unknown error!"})
+		return
+	}
+	g.JSON(http.StatusAccepted, t.AsResp())
+}
+
+// TakeoutStatus is a method of `Controller`. Handles status requests of a takeout of the current user.
+// @Summary Account takeout status endpoint
+// @Schemes
+// @Tags account
+// @Description Returns the status of a takeout of the user
+// @Produce json
+// @Param id path string true "ID of the takeout"
+// @Success 200 {object} export.TakeoutResponse
+// @Failure 400 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Router /account/takeout/{id} [get]
+func (c Controller) TakeoutStatus(g *gin.Context) {
+	id, err := uuid.Parse(g.Param("id"))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid identifier!"})
+		return
+	}
+	usr, err := currentUser(g)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Error with the session. Please log in again!"})
+		return
+	}
+	t, err := c.takeouts.Status(usr, id)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Takeout does not exist!"})
+		return
+	}
+	g.JSON(http.StatusOK, t.AsResp())
+}
+
+func (c Controller) stream(g *gin.Context, name string, photos []photo.Photo, manifest Manifest) {
+	name = strings.TrimSpace(strings.NewReplacer("/", "-", "\\", "-", "\"", "").Replace(name))
+	if len(name) == 0 {
+		name = "photos"
+	}
+	g.Header("Content-Type", "application/zip")
+	g.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+	g.Status(http.StatusOK)
+	if err := c.service.Write(g.Writer, photos, manifest); err != nil {
+		log.Err(err).Msg("Failed to stream export!") // the response has been sent, the archive is incomplete
+		g.Abort()
+	}
+}
+
+func authorize(g *gin.Context, userID uuid.UUID) error {
+	usr, err := currentUser(g)
+	if err != nil {
+		return err
+	}
+	if userID.String() != usr.ID.String() {
+		return errors.New("user is not authorized")
+	}
+	return nil
+}
+
+func currentUser(g *gin.Context) (*user.User, error) {
+	u, ok := g.Get("user")
+	if !ok {
+		return nil, errors.New("user could not be extracted from session")
+	}
+	usr := u.(*user.User)
+	return usr, nil
+}
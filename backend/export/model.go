@@ -0,0 +1,188 @@
+package export
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/collection"
+	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/photo"
+	"github.com/inokone/photostorage/ruleset"
+)
+
+// ErrUnknownManifest is an error for exports with a manifest format that does not exist
+var ErrUnknownManifest = errors.New("unknown manifest format")
+
+// Manifest is the format of the metadata manifest included in an export
+type Manifest string
+
+const (
+	// NoManifest is for exports without a manifest
+	NoManifest Manifest = ""
+	// JSONManifest is for exports with a `manifest.json` file
+	JSONManifest Manifest = "json"
+	// CSVManifest is for exports with a `manifest.csv` file
+	CSVManifest Manifest = "csv"
+)
+
+// ParseManifest parses the name of a manifest format, empty for no manifest.
+func ParseManifest(s string) (Manifest, error) {
+	switch m := Manifest(strings.ToLower(strings.TrimSpace(s))); m {
+	case NoManifest, JSONManifest, CSVManifest:
+		return m, nil
+	}
+	return NoManifest, ErrUnknownManifest
+}
+
+// Request is the JSON representation of a request to export a selection of photos
+type Request struct {
+	IDs      []uuid.UUID `json:"ids" binding:"required,min=1,max=10000"`
+	Manifest string      `json:"manifest"`
+}
+
+// Entry is the JSON representation of a photo in the manifest of an export. The file is the name of the RAW in the
+// archive, empty if the RAW could not be exported, the error tells why.
+type Entry struct {
+	ID       string         `json:"id"`
+	File     string         `json:"file"`
+	FileName string         `json:"filename"`
+	Format   string         `json:"format"`
+	Uploaded time.Time      `json:"uploaded"`
+	Tags     []string       `json:"tags"`
+	Favorite bool           `json:"favorite"`
+	Rating   int8           `json:"rating"`
+	Metadata image.Response `json:"metadata"`
+	Error    string         `json:"error,omitempty"`
+}
+
+func newEntry(p *photo.Photo) Entry {
+	return Entry{
+		ID:       p.ID.String(),
+		FileName: p.Desc.FileName,
+		Format:   string(p.Desc.Format),
+		Uploaded: p.Desc.Uploaded,
+		Tags:     p.Desc.Tags,
+		Favorite: p.Desc.Favorite,
+		Rating:   p.Desc.Rating,
+		Metadata: p.Desc.Metadata.AsResp(),
+	}
+}
+
+// csvHeader is the header of CSV manifests, the columns of `record`.
+var csvHeader = []string{"id", "file", "filename", "format", "uploaded", "tags", "favorite", "rating", "timestamp",
+	"width", "height", "camera_make", "camera_model", "lens_make", "lens_model", "iso", "aperture", "shutter", "error"}
+
+func (e Entry) record() []string {
+	return []string{
+		e.ID,
+		e.File,
+		e.FileName,
+		e.Format,
+		e.Uploaded.UTC().Format(time.RFC3339),
+		strings.Join(e.Tags, ";"),
+		strconv.FormatBool(e.Favorite),
+		strconv.Itoa(int(e.Rating)),
+		e.Metadata.Timestamp.UTC().Format(time.RFC3339),
+		strconv.Itoa(e.Metadata.Width),
+		strconv.Itoa(e.Metadata.Height),
+		e.Metadata.CameraMake,
+		e.Metadata.CameraModel,
+		e.Metadata.LensMake,
+		e.Metadata.LensModel,
+		strconv.Itoa(e.Metadata.ISO),
+		strconv.FormatFloat(e.Metadata.Aperture, 'f', -1, 64),
+		strconv.FormatFloat(e.Metadata.Shutter, 'f', -1, 64),
+		e.Error,
+	}
+}
+
+// Account is all data of a user exported by a takeout.
+type Account struct {
+	Profile  user.Profile
+	Photos   []photo.Photo
+	Albums   []collection.Collection
+	Uploads  []collection.Collection
+	RuleSets []ruleset.RuleSet
+}
+
+// TakeoutManifest is the JSON representation of all data of a user, the `manifest.json` file of a takeout archive.
+// The photos reference the RAW files and the thumbnails in the archive, the collections reference the photos by ID.
+type TakeoutManifest struct {
+	Created  time.Time         `json:"created"`
+	Profile  user.Profile      `json:"profile"`
+	Photos   []TakeoutEntry    `json:"photos"`
+	Albums   []CollectionEntry `json:"albums"`
+	Uploads  []CollectionEntry `json:"uploads"`
+	RuleSets []ruleset.Resp    `json:"rulesets"`
+}
+
+// TakeoutEntry is the JSON representation of a photo in the manifest of a takeout. The thumbnail is the name of the
+// thumbnail in the archive, empty if the thumbnail could not be exported.
+type TakeoutEntry struct {
+	Entry
+	Thumbnail string `json:"thumbnail"`
+}
+
+// CollectionEntry is the JSON representation of an album or upload in the manifest of a takeout.
+type CollectionEntry struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Tags    []string  `json:"tags"`
+	Created time.Time `json:"created"`
+	RuleSet string    `json:"ruleset,omitempty"`
+	Photos  []string  `json:"photos"`
+}
+
+func newCollectionEntry(c *collection.Collection) CollectionEntry {
+	e := CollectionEntry{
+		ID:      c.ID.String(),
+		Name:    c.Name,
+		Tags:    c.Tags,
+		Created: c.CreatedAt,
+		Photos:  make([]string, len(c.Photos)),
+	}
+	if c.RuleSetID != nil {
+		e.RuleSet = c.RuleSetID.String()
+	}
+	for i, p := range c.Photos {
+		e.Photos[i] = p.ID.String()
+	}
+	return e
+}
+
+// Takeout is a struct representing a request of a user for the export of all their data. The archive is generated
+// asynchronously into the image store with the ID of the takeout, the user gets a download link expiring at the
+// expiry time by e-mail.
+type Takeout struct {
+	ID        uuid.UUID       `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID       `gorm:"type:uuid;index"`
+	Status    photo.JobStatus `gorm:"type:varchar(16);index"`
+	Error     string
+	Expires   *time.Time `gorm:"index"`
+	CreatedAt time.Time
+	UpdatedAt time.Time `gorm:"index"`
+}
+
+// AsResp is a method of `Takeout` to convert to JSON representation.
+func (t Takeout) AsResp() TakeoutResponse {
+	return TakeoutResponse{
+		ID:      t.ID.String(),
+		Status:  t.Status,
+		Error:   t.Error,
+		Created: t.CreatedAt,
+		Expires: t.Expires,
+	}
+}
+
+// TakeoutResponse is the JSON representation of a takeout
+type TakeoutResponse struct {
+	ID      string          `json:"id"`
+	Status  photo.JobStatus `json:"status"`
+	Error   string          `json:"error,omitempty"`
+	Created time.Time       `json:"created"`
+	Expires *time.Time      `json:"expires,omitempty"`
+}
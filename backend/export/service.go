@@ -0,0 +1,225 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/photo"
+	"github.com/inokone/photostorage/ruleset"
+	"github.com/rs/zerolog/log"
+)
+
+// Service is a service exporting photos into ZIP archives.
+type Service struct {
+	images image.Loader
+}
+
+// NewService creates a `Service` instance reading the RAW files from the image loader.
+func NewService(images image.Loader) *Service {
+	return &Service{
+		images: images,
+	}
+}
+
+// Write is a method of `Service` streaming a ZIP archive of the RAW files of the photos into the writer, named by the
+// original file names made unique, with the manifest of the photos. The RAW files are streamed one by one and are
+// not compressed, as they hardly compress. RAW files that can not be read, e.g. in frozen storage, are left out and
+// the reason is recorded in the manifest. Returns an error if the archive could not be written, the archive is
+// incomplete in that case.
+func (s Service) Write(w io.Writer, photos []photo.Photo, manifest Manifest) error {
+	var (
+		zw      = zip.NewWriter(w)
+		names   = newNames()
+		entries = make([]Entry, 0, len(photos))
+		file    string
+	)
+	if manifest != NoManifest {
+		file = names.unique("manifest." + string(manifest))
+	}
+
+	for i := range photos {
+		p := &photos[i]
+		e := newEntry(p)
+		if err := s.add(zw, names, p, &e, ""); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+
+	if manifest != NoManifest {
+		if err := writeManifest(zw, file, manifest, entries); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// WriteTakeout is a method of `Service` writing a ZIP archive of all data of an account into the writer: the RAW
+// files into `photos/`, the thumbnails into `thumbnails/` and the descriptors, collections, rule sets and profile into
+// the `manifest.json` referencing the files. Files that can not be read are left out, the reason is recorded in the
+// manifest. Returns an error if the archive could not be written, the archive is incomplete in that case.
+func (s Service) WriteTakeout(w io.Writer, a Account) error {
+	var (
+		zw     = zip.NewWriter(w)
+		raws   = newNames()
+		thumbs = newNames()
+		m      = TakeoutManifest{
+			Created:  time.Now(),
+			Profile:  a.Profile,
+			Photos:   make([]TakeoutEntry, 0, len(a.Photos)),
+			Albums:   make([]CollectionEntry, len(a.Albums)),
+			Uploads:  make([]CollectionEntry, len(a.Uploads)),
+			RuleSets: make([]ruleset.Resp, len(a.RuleSets)),
+		}
+		err error
+	)
+
+	for i := range a.Photos {
+		p := &a.Photos[i]
+		e := TakeoutEntry{Entry: newEntry(p)}
+		if err = s.add(zw, raws, p, &e.Entry, "photos/"); err != nil {
+			return err
+		}
+		if err = s.addThumbnail(zw, thumbs, p, &e); err != nil {
+			return err
+		}
+		m.Photos = append(m.Photos, e)
+	}
+	for i := range a.Albums {
+		m.Albums[i] = newCollectionEntry(&a.Albums[i])
+	}
+	for i := range a.Uploads {
+		m.Uploads[i] = newCollectionEntry(&a.Uploads[i])
+	}
+	for i, rs := range a.RuleSets {
+		if m.RuleSets[i], err = rs.AsResp(); err != nil {
+			return err
+		}
+	}
+
+	fw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(m); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// add adds the RAW file of the photo to the archive in the directory and records its name in the entry. Errors
+// reading the RAW are recorded in the entry, only errors writing the archive are returned.
+func (s Service) add(zw *zip.Writer, names *names, p *photo.Photo, e *Entry, dir string) error {
+	if p.Tier == image.FrozenTier {
+		e.Error = "photo is in frozen storage"
+		return nil
+	}
+	r, err := s.images.Open(p.ID.String(), image.RawVariant)
+	if err != nil {
+		log.Err(err).Str("id", e.ID).Msg("Failed to open RAW for export")
+		e.Error = "RAW file could not be read"
+		return nil
+	}
+	defer r.Close()
+
+	e.File = dir + names.unique(fileName(p))
+	return store(zw, e.File, p.Desc.Uploaded, r)
+}
+
+// addThumbnail adds the thumbnail of the photo to the archive and records its name in the entry, the name of the RAW
+// in the archive with a `.jpg` extension. Photos without a readable thumbnail are left out, only errors writing the
+// archive are returned.
+func (s Service) addThumbnail(zw *zip.Writer, names *names, p *photo.Photo, e *TakeoutEntry) error {
+	r, err := s.images.Open(p.ID.String(), image.ThumbnailVariant)
+	if err != nil {
+		log.Err(err).Str("id", e.ID).Msg("Failed to open thumbnail for export")
+		return nil
+	}
+	defer r.Close()
+
+	name := path.Base(e.File)
+	if len(e.File) == 0 {
+		name = fileName(p)
+	}
+	e.Thumbnail = "thumbnails/" + names.unique(name+".jpg")
+	return store(zw, e.Thumbnail, p.Desc.Uploaded, r)
+}
+
+// store copies the content into the archive without compression, as images hardly compress.
+func store(zw *zip.Writer, name string, modified time.Time, content io.Reader) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, content)
+	return err
+}
+
+func writeManifest(zw *zip.Writer, file string, manifest Manifest, entries []Entry) error {
+	fw, err := zw.Create(file)
+	if err != nil {
+		return err
+	}
+	if manifest == JSONManifest {
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	cw := csv.NewWriter(fw)
+	if err = cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err = cw.Write(e.record()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// fileName returns the original file name of the photo, safe to use in an archive.
+func fileName(p *photo.Photo) string {
+	name := strings.TrimSpace(path.Base(strings.ReplaceAll(p.Desc.FileName, "\\", "/")))
+	name = strings.TrimLeft(name, ".")
+	if len(name) == 0 || name == "/" {
+		return p.ID.String() + "." + string(p.Desc.Format)
+	}
+	return name
+}
+
+// names is a set of the file names in an archive, case insensitive as most file systems extracting the archive.
+type names struct {
+	used map[string]bool
+}
+
+func newNames() *names {
+	return &names{used: make(map[string]bool)}
+}
+
+// unique returns the name, or if the name is already used the name with the first free counter, e.g. `IMG_1 (2).CR2`.
+func (n *names) unique(name string) string {
+	var (
+		ext  = path.Ext(name)
+		base = strings.TrimSuffix(name, ext)
+		res  = name
+	)
+	for i := 2; n.used[strings.ToLower(res)]; i++ {
+		res = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	n.used[strings.ToLower(res)] = true
+	return res
+}
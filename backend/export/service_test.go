@@ -0,0 +1,201 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/collection"
+	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/photo"
+	"github.com/inokone/photostorage/photo/descriptor"
+	"github.com/inokone/photostorage/ruleset"
+)
+
+func TestUnique(t *testing.T) {
+	n := newNames()
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "IMG_1.CR2", want: "IMG_1.CR2"},
+		{in: "IMG_1.CR2", want: "IMG_1 (2).CR2"},
+		{in: "img_1.cr2", want: "img_1 (3).cr2"},
+		{in: "IMG_1 (2).CR2", want: "IMG_1 (2) (2).CR2"},
+		{in: "README", want: "README"},
+		{in: "README", want: "README (2)"},
+	}
+	for _, tt := range tests {
+		if actual := n.unique(tt.in); actual != tt.want {
+			t.Errorf("unique(%v) = %v; want %v", tt.in, actual, tt.want)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	newPhoto := func(name string, content string, tier image.Tier) photo.Photo {
+		p := photo.Photo{ID: uuid.New(), Tier: tier, Desc: descriptor.Descriptor{FileName: name, Format: "cr2"}}
+		if err := images.Store(p.ID.String(), image.RawVariant, []byte(content)); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		return p
+	}
+	photos := []photo.Photo{
+		newPhoto("IMG_1.CR2", "first", image.StandardTier),
+		newPhoto("IMG_1.CR2", "second", image.StandardTier),
+		newPhoto("../manifest.csv", "third", image.StandardTier),
+		newPhoto("IMG_2.CR2", "frozen", image.FrozenTier),
+	}
+
+	for _, manifest := range []Manifest{JSONManifest, CSVManifest} {
+		t.Run(string(manifest), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := NewService(images).Write(&buf, photos, manifest); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("Reading archive failed: %v", err)
+			}
+			files := make(map[string]string)
+			for _, f := range zr.File {
+				r, _ := f.Open()
+				b, _ := io.ReadAll(r)
+				files[f.Name] = string(b)
+			}
+			want := map[string]string{"IMG_1.CR2": "first", "IMG_1 (2).CR2": "second"}
+			if manifest == CSVManifest {
+				want["manifest (2).csv"] = "third"
+			} else {
+				want["manifest.csv"] = "third"
+			}
+			for name, content := range want {
+				if files[name] != content {
+					t.Errorf("File %v = %v; want %v", name, files[name], content)
+				}
+			}
+			if len(files) != len(want)+1 {
+				t.Errorf("Archive has %v files; want %v", len(files), len(want)+1)
+			}
+
+			var errors int
+			if manifest == JSONManifest {
+				var entries []Entry
+				if err = json.Unmarshal([]byte(files["manifest.json"]), &entries); err != nil {
+					t.Fatalf("Parsing manifest failed: %v", err)
+				}
+				for _, e := range entries {
+					if len(e.Error) > 0 {
+						errors++
+					}
+				}
+			} else {
+				records, err := csv.NewReader(bytes.NewReader([]byte(files["manifest.csv"]))).ReadAll()
+				if err != nil {
+					t.Fatalf("Parsing manifest failed: %v", err)
+				}
+				if len(records) != len(photos)+1 {
+					t.Fatalf("Manifest has %v records; want %v", len(records), len(photos)+1)
+				}
+				for _, r := range records[1:] {
+					if len(r[len(r)-1]) > 0 {
+						errors++
+					}
+				}
+			}
+			if errors != 1 {
+				t.Errorf("Manifest has %v errors; want 1 for the frozen photo", errors)
+			}
+		})
+	}
+}
+
+func TestWriteTakeout(t *testing.T) {
+	images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	newPhoto := func(name string, content string, tier image.Tier) photo.Photo {
+		p := photo.Photo{ID: uuid.New(), Tier: tier, Desc: descriptor.Descriptor{FileName: name, Format: "cr2", Rating: 4}}
+		if err := images.Store(p.ID.String(), image.RawVariant, []byte(content)); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		if err := images.Store(p.ID.String(), image.ThumbnailVariant, []byte(content+" thumbnail")); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		return p
+	}
+	photos := []photo.Photo{
+		newPhoto("IMG_1.CR2", "first", image.StandardTier),
+		newPhoto("IMG_1.CR2", "second", image.StandardTier),
+		newPhoto("IMG_2.CR2", "frozen", image.FrozenTier),
+	}
+	a := Account{
+		Profile:  user.Profile{Email: "test@test.com"},
+		Photos:   photos,
+		Albums:   []collection.Collection{{ID: uuid.New(), Name: "Album", Photos: photos[1:]}},
+		RuleSets: []ruleset.RuleSet{{ID: uuid.New(), Name: "Rules"}},
+	}
+
+	var buf bytes.Buffer
+	if err = NewService(images).WriteTakeout(&buf, a); err != nil {
+		t.Fatalf("WriteTakeout failed: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Reading archive failed: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, _ := f.Open()
+		b, _ := io.ReadAll(r)
+		files[f.Name] = string(b)
+	}
+	want := map[string]string{
+		"photos/IMG_1.CR2":             "first",
+		"photos/IMG_1 (2).CR2":         "second",
+		"thumbnails/IMG_1.CR2.jpg":     "first thumbnail",
+		"thumbnails/IMG_1 (2).CR2.jpg": "second thumbnail",
+		"thumbnails/IMG_2.CR2.jpg":     "frozen thumbnail",
+	}
+	for name, content := range want {
+		if files[name] != content {
+			t.Errorf("File %v = %v; want %v", name, files[name], content)
+		}
+	}
+	if len(files) != len(want)+1 {
+		t.Errorf("Archive has %v files; want %v", len(files), len(want)+1)
+	}
+
+	var m TakeoutManifest
+	if err = json.Unmarshal([]byte(files["manifest.json"]), &m); err != nil {
+		t.Fatalf("Parsing manifest failed: %v", err)
+	}
+	if m.Profile.Email != a.Profile.Email {
+		t.Errorf("Manifest profile = %v; want %v", m.Profile.Email, a.Profile.Email)
+	}
+	if len(m.Photos) != len(photos) {
+		t.Fatalf("Manifest has %v photos; want %v", len(m.Photos), len(photos))
+	}
+	if m.Photos[1].File != "photos/IMG_1 (2).CR2" || m.Photos[1].Thumbnail != "thumbnails/IMG_1 (2).CR2.jpg" || m.Photos[1].Rating != 4 {
+		t.Errorf("Manifest photo = %+v; want the second photo", m.Photos[1])
+	}
+	if len(m.Photos[2].File) > 0 || len(m.Photos[2].Error) == 0 {
+		t.Errorf("Manifest photo = %+v; want an error for the frozen photo", m.Photos[2])
+	}
+	if len(m.Albums) != 1 || len(m.Albums[0].Photos) != 2 || m.Albums[0].Photos[0] != photos[1].ID.String() {
+		t.Errorf("Manifest albums = %+v; want the album with its photos", m.Albums)
+	}
+	if len(m.RuleSets) != 1 || m.RuleSets[0].Name != "Rules" {
+		t.Errorf("Manifest rule sets = %+v; want the rule set", m.RuleSets)
+	}
+}
@@ -0,0 +1,117 @@
+package export
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/photo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TakeoutStorer is an interface for persisting `Takeout`s.
+type TakeoutStorer interface {
+	Store(t *Takeout) error
+	Update(t *Takeout) error
+	ByID(id uuid.UUID) (*Takeout, error)
+	Active(userID uuid.UUID) (*Takeout, error)
+	Claim(stale time.Time) (*Takeout, error)
+	Expired(now time.Time) ([]Takeout, error)
+	Delete(id uuid.UUID) error
+}
+
+// GORMTakeoutStorer is an implementation of `TakeoutStorer` interface based on GORM library.
+type GORMTakeoutStorer struct {
+	db *gorm.DB
+}
+
+// NewGORMTakeoutStorer creates a new `GORMTakeoutStorer` instance based on the GORM library.
+func NewGORMTakeoutStorer(db *gorm.DB) *GORMTakeoutStorer {
+	return &GORMTakeoutStorer{db: db}
+}
+
+// Store is a method of `GORMTakeoutStorer` for persisting a `Takeout`.
+func (s *GORMTakeoutStorer) Store(t *Takeout) error {
+	return s.db.Create(t).Error
+}
+
+// Update is a method of `GORMTakeoutStorer` for persisting the status, the error and the expiry of a `Takeout`.
+func (s *GORMTakeoutStorer) Update(t *Takeout) error {
+	return s.db.Model(t).Select("status", "error", "expires", "updated_at").Updates(t).Error
+}
+
+// ByID is a method of `GORMTakeoutStorer` for loading a `Takeout` by ID.
+func (s *GORMTakeoutStorer) ByID(id uuid.UUID) (*Takeout, error) {
+	var t Takeout
+	result := s.db.First(&t, "id = ?", id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &t, nil
+}
+
+// Active is a method of `GORMTakeoutStorer` for loading the pending or processing `Takeout` of a user. Returns nil
+// if the user has no takeout in progress.
+func (s *GORMTakeoutStorer) Active(userID uuid.UUID) (*Takeout, error) {
+	var t Takeout
+	result := s.db.Where("user_id = ? AND status IN ?", userID, []photo.JobStatus{photo.JobPending, photo.JobProcessing}).First(&t)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &t, nil
+}
+
+// Claim is a method of `GORMTakeoutStorer` for taking the pending `Takeout` updated the longest ago for processing,
+// so takeouts waiting for restores from frozen storage do not hold up the rest. Takeouts stuck in processing since
+// before the stale time, e.g. because the application stopped, are claimed again. Takeouts locked by other instances
+// of the application are skipped. Returns nil if there is no takeout to process.
+func (s *GORMTakeoutStorer) Claim(stale time.Time) (*Takeout, error) {
+	var t Takeout
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? OR (status = ? AND updated_at < ?)", photo.JobPending, photo.JobProcessing, stale).
+			Order("updated_at").
+			First(&t)
+		if result.Error != nil {
+			return result.Error
+		}
+		t.Status = photo.JobProcessing
+		return tx.Model(&t).Select("status", "updated_at").Updates(&t).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Expired is a method of `GORMTakeoutStorer` for loading the finished `Takeout`s expired before the time.
+func (s *GORMTakeoutStorer) Expired(now time.Time) ([]Takeout, error) {
+	var takeouts []Takeout
+	result := s.db.Where("status IN ? AND (expires < ? OR (expires IS NULL AND updated_at < ?))",
+		[]photo.JobStatus{photo.JobDone, photo.JobFailed}, now, now.Add(-takeoutTTL)).Find(&takeouts)
+	return takeouts, result.Error
+}
+
+// InFlight is a method of `GORMTakeoutStorer` for checking whether the ID is of a `Takeout`, so the scrub keeps its
+// archive, also while it is being written. Archives are deleted when the takeout expires.
+func (s *GORMTakeoutStorer) InFlight(id string) (bool, error) {
+	tid, err := uuid.Parse(id)
+	if err != nil {
+		return false, nil
+	}
+	var count int64
+	result := s.db.Model(&Takeout{}).Where("id = ?", tid).Count(&count)
+	return count > 0, result.Error
+}
+
+// Delete is a method of `GORMTakeoutStorer` for deleting a `Takeout` by ID.
+func (s *GORMTakeoutStorer) Delete(id uuid.UUID) error {
+	return s.db.Delete(&Takeout{}, "id = ?", id).Error
+}
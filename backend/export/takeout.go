@@ -0,0 +1,304 @@
+package export
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/collection"
+	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/mail"
+	"github.com/inokone/photostorage/onetime"
+	"github.com/inokone/photostorage/photo"
+	"github.com/inokone/photostorage/ruleset"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	takeoutTTL          = 7 * 24 * time.Hour
+	takeoutPollInterval = 5 * time.Minute
+	takeoutStaleAfter   = 2 * time.Hour
+)
+
+var (
+	// ErrTakeoutInProgress is an error for takeout requests of users with a takeout not yet finished
+	ErrTakeoutInProgress = errors.New("takeout is already in progress")
+	// ErrTakeoutNotFound is an error for status requests of takeouts that do not exist or belong to another user
+	ErrTakeoutNotFound = errors.New("takeout does not exist")
+
+	// errRestoring is an error for takeouts of photos still being restored from frozen storage
+	errRestoring = errors.New("photos are being restored from frozen storage")
+)
+
+// TakeoutService is a service exporting all data of users: the archives are written into the image store in the
+// background, and the users get a download link by e-mail through a one time access expiring after a week. RAWs in
+// frozen storage are restored for the archive and frozen again afterwards. Expired archives are deleted.
+type TakeoutService struct {
+	takeouts    TakeoutStorer
+	users       user.Loader
+	photos      photo.Loader
+	collections collection.Loader
+	ruleSets    ruleset.Loader
+	images      image.Storer
+	accesses    onetime.Writer
+	mailer      *mail.Service
+	service     *Service
+	downloadURL string
+	wake        chan struct{}
+}
+
+// NewTakeoutService creates a `TakeoutService` instance based on the storers, sending the download links with the
+// mail service. The download link is the ID of the one time access appended to the download URL.
+func NewTakeoutService(takeouts TakeoutStorer, users user.Loader, photos photo.Loader, collections collection.Loader,
+	ruleSets ruleset.Loader, images image.Storer, accesses onetime.Writer, mailer *mail.Service, downloadURL string) *TakeoutService {
+	return &TakeoutService{
+		takeouts:    takeouts,
+		users:       users,
+		photos:      photos,
+		collections: collections,
+		ruleSets:    ruleSets,
+		images:      images,
+		accesses:    accesses,
+		mailer:      mailer,
+		service:     NewService(images),
+		downloadURL: downloadURL,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Request is a method of `TakeoutService` creating a pending takeout for the user, processed in the background.
+// Returns `ErrTakeoutInProgress` if the user has a takeout not yet finished.
+func (s *TakeoutService) Request(usr *user.User) (*Takeout, error) {
+	active, err := s.takeouts.Active(usr.ID)
+	if err != nil {
+		return nil, err
+	}
+	if active != nil {
+		return active, ErrTakeoutInProgress
+	}
+	t := Takeout{
+		ID:     uuid.New(),
+		UserID: usr.ID,
+		Status: photo.JobPending,
+	}
+	if err = s.takeouts.Store(&t); err != nil {
+		return nil, err
+	}
+	s.notify()
+	return &t, nil
+}
+
+// Status is a method of `TakeoutService` returning a takeout of the user.
+func (s *TakeoutService) Status(usr *user.User, id uuid.UUID) (*Takeout, error) {
+	t, err := s.takeouts.ByID(id)
+	if err != nil || t.UserID != usr.ID {
+		return nil, ErrTakeoutNotFound
+	}
+	return t, nil
+}
+
+// Start runs the worker in the background, processing takeouts whenever they are requested, and periodically to
+// pick up the takeouts left by stopped instances. Expired takeouts are deleted with their archives hourly.
+func (s *TakeoutService) Start() {
+	go s.work()
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := s.Expire(); err != nil {
+				log.Err(err).Msg("Failed to delete expired takeouts")
+			}
+		}
+	}()
+}
+
+func (s *TakeoutService) work() {
+	ticker := time.NewTicker(takeoutPollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			processed, err := s.Process()
+			if err != nil {
+				log.Err(err).Msg("Failed to process takeouts")
+			}
+			if err != nil || !processed {
+				break
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// Process is a method of `TakeoutService` claiming and processing a single takeout, returns whether there was a
+// takeout to process. Failures are recorded on the takeout, the archive of a failed takeout is deleted. A takeout of
+// photos still being restored from frozen storage is kept pending and retried at the next poll.
+func (s *TakeoutService) Process() (bool, error) {
+	t, err := s.takeouts.Claim(time.Now().Add(-takeoutStaleAfter))
+	if err != nil || t == nil {
+		return false, err
+	}
+
+	start := time.Now()
+	err = s.process(t)
+	switch {
+	case errors.Is(err, errRestoring):
+		log.Debug().Str("id", t.ID.String()).Msg("takeout waiting for frozen photos")
+		t.Status = photo.JobPending
+		return false, s.takeouts.Update(t)
+	case err != nil:
+		log.Err(err).Str("id", t.ID.String()).Msg("Failed to process takeout!")
+		if derr := s.images.Delete(t.ID.String()); derr != nil {
+			log.Err(derr).Str("id", t.ID.String()).Msg("Failed to delete takeout archive!")
+		}
+		t.Status = photo.JobFailed
+		t.Error = "Takeout could not be created"
+	default:
+		t.Status = photo.JobDone
+		log.Debug().Str("id", t.ID.String()).Dur("elapsed", time.Since(start)).Msg("takeout processed")
+	}
+	return true, s.takeouts.Update(t)
+}
+
+func (s *TakeoutService) process(t *Takeout) error {
+	usr, err := s.users.ByID(t.UserID)
+	if err != nil {
+		return err
+	}
+	a, err := s.account(usr)
+	if err != nil {
+		return err
+	}
+	thawed, err := s.thaw(a.Photos)
+	if err != nil {
+		return err
+	}
+	defer s.freeze(thawed)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.service.WriteTakeout(pw, *a))
+	}()
+	err = s.images.StoreStream(t.ID.String(), image.ArchiveVariant, pr)
+	pr.CloseWithError(err) // stop the writer if the store failed
+	if err != nil {
+		return err
+	}
+
+	expires := time.Now().Add(takeoutTTL)
+	access := onetime.Access{
+		OriginalID: t.ID,
+		TTL:        expires,
+	}
+	if err = s.accesses.Store(&access); err != nil {
+		return err
+	}
+	t.Expires = &expires
+	return s.mailer.Takeout(usr.Email, s.downloadURL+access.ID.String(), int(takeoutTTL.Hours()/24))
+}
+
+// thaw moves the RAWs of the frozen photos to standard storage, so they can be archived, and records the tier on the
+// photos. Returns the IDs of the photos moved, or `errRestoring` while any of them is still being restored. Photos
+// already moved stay in standard storage until the rest are restored.
+func (s *TakeoutService) thaw(photos []photo.Photo) ([]string, error) {
+	var (
+		thawed    []string
+		restoring bool
+	)
+	for i := range photos {
+		p := &photos[i]
+		if p.Tier != image.FrozenTier {
+			continue
+		}
+		err := s.images.MoveTo(p.ID.String(), image.StandardTier)
+		if errors.Is(err, image.ErrRestoreInProgress) {
+			restoring = true
+			continue
+		}
+		if err != nil {
+			s.freeze(thawed)
+			return nil, err
+		}
+		p.Tier = image.StandardTier
+		thawed = append(thawed, p.ID.String())
+	}
+	if restoring {
+		return nil, errRestoring
+	}
+	return thawed, nil
+}
+
+// freeze moves the RAWs of the photos thawed for the archive back to frozen storage.
+func (s *TakeoutService) freeze(ids []string) {
+	for _, id := range ids {
+		if err := s.images.MoveTo(id, image.FrozenTier); err != nil {
+			log.Err(err).Str("id", id).Msg("Failed to move RAW back to frozen storage!")
+		}
+	}
+}
+
+// account loads all data of the user.
+func (s *TakeoutService) account(usr *user.User) (*Account, error) {
+	var (
+		a   = &Account{Profile: usr.AsProfile()}
+		err error
+	)
+	if a.Photos, err = s.photos.All(usr.ID.String()); err != nil {
+		return nil, err
+	}
+	if a.Albums, err = s.collectionsOf(usr, collection.Album); err != nil {
+		return nil, err
+	}
+	if a.Uploads, err = s.collectionsOf(usr, collection.Upload); err != nil {
+		return nil, err
+	}
+	if a.RuleSets, err = s.ruleSets.ByUser(usr.ID); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *TakeoutService) collectionsOf(usr *user.User, ct collection.Type) ([]collection.Collection, error) {
+	items, err := s.collections.ByUserAndType(usr, ct)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]collection.Collection, 0, len(items))
+	for _, item := range items {
+		c, err := s.collections.ByID(item.ID)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *c)
+	}
+	return res, nil
+}
+
+// Expire is a method of `TakeoutService` deleting the takeouts expired, with their archives.
+func (s *TakeoutService) Expire() error {
+	takeouts, err := s.takeouts.Expired(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, t := range takeouts {
+		if err = s.images.Delete(t.ID.String()); err != nil {
+			log.Err(err).Str("id", t.ID.String()).Msg("Failed to delete takeout archive!")
+			continue
+		}
+		if err = s.takeouts.Delete(t.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TakeoutService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
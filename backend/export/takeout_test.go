@@ -0,0 +1,77 @@
+package export
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/photo"
+	"github.com/inokone/photostorage/photo/descriptor"
+)
+
+// restoringStorer is an image store with RAWs being restored from frozen storage until they are restored.
+type restoringStorer struct {
+	image.Storer
+	restoring map[string]bool
+}
+
+func (s restoringStorer) MoveTo(id string, tier image.Tier) error {
+	if tier == image.StandardTier && s.restoring[id] {
+		return image.ErrRestoreInProgress
+	}
+	return s.Storer.MoveTo(id, tier)
+}
+
+func TestThaw(t *testing.T) {
+	local, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	newPhoto := func(name string, tier image.Tier) photo.Photo {
+		p := photo.Photo{ID: uuid.New(), Tier: tier, Desc: descriptor.Descriptor{FileName: name, Format: "cr2"}}
+		if err := local.Store(p.ID.String(), image.RawVariant, []byte(name)); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		if tier == image.FrozenTier {
+			if err := local.MoveTo(p.ID.String(), image.FrozenTier); err != nil {
+				t.Fatalf("MoveTo failed: %v", err)
+			}
+		}
+		return p
+	}
+	var (
+		standard  = newPhoto("standard.CR2", image.StandardTier)
+		restored  = newPhoto("restored.CR2", image.FrozenTier)
+		restoring = newPhoto("restoring.CR2", image.FrozenTier)
+		images    = restoringStorer{Storer: local, restoring: map[string]bool{restoring.ID.String(): true}}
+		s         = &TakeoutService{images: images}
+	)
+
+	// the photos of the account are loaded again for every attempt
+	if _, err = s.thaw([]photo.Photo{standard, restored, restoring}); !errors.Is(err, errRestoring) {
+		t.Fatalf("thaw() while restoring = %v; want %v", err, errRestoring)
+	}
+
+	delete(images.restoring, restoring.ID.String())
+	photos := []photo.Photo{standard, restored, restoring}
+	thawed, err := s.thaw(photos)
+	if err != nil {
+		t.Fatalf("thaw() failed: %v", err)
+	}
+	if len(thawed) != 2 {
+		t.Errorf("thaw() = %v photos; want 2", len(thawed))
+	}
+	for _, p := range photos {
+		if tier, err := local.Tier(p.ID.String()); err != nil || tier != image.StandardTier || p.Tier != image.StandardTier {
+			t.Errorf("thaw() tier of %v = %v, %v; want standard", p.Desc.FileName, tier, p.Tier)
+		}
+	}
+
+	s.freeze(thawed)
+	for _, p := range []photo.Photo{restored, restoring} {
+		if tier, err := local.Tier(p.ID.String()); err != nil || tier != image.FrozenTier {
+			t.Errorf("freeze() tier of %v = %v; want frozen", p.Desc.FileName, tier)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package geo
+
+import (
+	"bufio"
+	"embed"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultMaxDistance is the distance in kilometers within which a place is the location of a coordinate
+	DefaultMaxDistance = 50.0
+
+	earthRadius = 6371.0 // in kilometers
+	kmPerDegree = math.Pi * earthRadius / 180
+
+	citiesPattern = "cities*.txt"
+	admin1File    = "admin1CodesASCII.txt"
+	countriesFile = "countryInfo.txt"
+	boundsFile    = "countryBounds.txt"
+)
+
+var (
+	//go:embed data
+	embedded embed.FS
+
+	defaultMu sync.Mutex
+	def       *Gazetteer
+
+	// ErrMissingPlaces is an error for gazetteer directories without a cities dump
+	ErrMissingPlaces = errors.New("gazetteer has no cities dump")
+)
+
+// Place is a struct representing a populated place of the gazetteer, with the names of its country and first-level
+// administrative division, e.g. state or county.
+type Place struct {
+	Name        string
+	CountryCode string
+	Country     string
+	Region      string
+	Latitude    float64
+	Longitude   float64
+	Population  int
+}
+
+// Gazetteer is an offline reverse geocoder, resolving coordinates to the nearest populated place of a GeoNames dump,
+// or to the country of the coordinates if there is no place nearby.
+type Gazetteer struct {
+	// MaxDistance is the distance in kilometers within which the nearest place is returned
+	MaxDistance float64
+	places      []Place // ordered by latitude
+	bounds      []bounds
+}
+
+// bounds is the approximate bounding box of a country, or of a part of it.
+type bounds struct {
+	country                  Place
+	west, south, east, north float64
+}
+
+// contains tells whether the coordinates are within the box. Boxes spanning the antimeridian have their west bound
+// east of their east bound.
+func (b bounds) contains(latitude, longitude float64) bool {
+	if latitude < b.south || latitude > b.north {
+		return false
+	}
+	if b.west <= b.east {
+		return longitude >= b.west && longitude <= b.east
+	}
+	return longitude >= b.west || longitude <= b.east
+}
+
+// Open loads a gazetteer from a directory in the format of the GeoNames dumps: a cities dump, e.g.
+// `cities15000.txt`, `admin1CodesASCII.txt` and `countryInfo.txt`. The administrative divisions and countries are
+// optional, places are resolved without region and country names without them. The optional `countryBounds.txt` has
+// the tab separated ISO code and west, south, east and north bounds of the countries, used to resolve the country of
+// coordinates without a place nearby.
+func Open(fsys fs.FS) (*Gazetteer, error) {
+	matches, err := fs.Glob(fsys, citiesPattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, ErrMissingPlaces
+	}
+	regions, err := readNames(fsys, admin1File, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	countries, err := readNames(fsys, countriesFile, 0, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Gazetteer{MaxDistance: DefaultMaxDistance}
+	if err = g.readBounds(fsys, countries); err != nil {
+		return nil, err
+	}
+	for _, name := range matches {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		err = g.read(f, regions, countries)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(g.places, func(i, j int) bool {
+		return g.places[i].Latitude < g.places[j].Latitude
+	})
+	return g, nil
+}
+
+// Embedded returns the gazetteer embedded in the application, with the major cities of the world.
+func Embedded() (*Gazetteer, error) {
+	data, err := fs.Sub(embedded, "data")
+	if err != nil {
+		return nil, err
+	}
+	return Open(data)
+}
+
+// Default returns the gazetteer used by the application, the embedded one unless replaced with `SetDefault`.
+func Default() *Gazetteer {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if def == nil {
+		g, err := Embedded()
+		if err != nil {
+			log.Err(err).Msg("Failed to load embedded gazetteer, locations are not resolved.")
+			g = &Gazetteer{MaxDistance: DefaultMaxDistance}
+		}
+		def = g
+	}
+	return def
+}
+
+// SetDefault replaces the gazetteer used by the application, e.g. with a complete GeoNames dump.
+func SetDefault(g *Gazetteer) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	def = g
+}
+
+// Len returns the number of places in the gazetteer.
+func (g *Gazetteer) Len() int {
+	return len(g.places)
+}
+
+// Reverse resolves the coordinates to the nearest place of the gazetteer within `MaxDistance`. If there is no place
+// close enough, the returned place has the country of the coordinates only, without name and region. Returns false if
+// neither is known, e.g. at sea or close to a border.
+func (g *Gazetteer) Reverse(latitude, longitude float64) (Place, bool) {
+	if p, ok := g.nearest(latitude, longitude); ok {
+		return p, true
+	}
+	return g.country(latitude, longitude)
+}
+
+func (g *Gazetteer) nearest(latitude, longitude float64) (Place, bool) {
+	var (
+		res   Place
+		found bool
+		best  = g.MaxDistance
+		span  = g.MaxDistance / kmPerDegree
+		start = sort.Search(len(g.places), func(i int) bool {
+			return g.places[i].Latitude >= latitude-span
+		})
+	)
+	for _, p := range g.places[start:] {
+		if p.Latitude > latitude+span {
+			break
+		}
+		if d := Distance(latitude, longitude, p.Latitude, p.Longitude); d <= best {
+			res, best, found = p, d, true
+		}
+	}
+	return res, found
+}
+
+// country resolves the coordinates to the country with a bounding box containing them. Bounding boxes of neighbors
+// overlap along their borders, so the country is unknown if the boxes of more than one country contain the
+// coordinates.
+func (g *Gazetteer) country(latitude, longitude float64) (Place, bool) {
+	var (
+		res   Place
+		found bool
+	)
+	for _, b := range g.bounds {
+		if !b.contains(latitude, longitude) || (found && b.country.CountryCode == res.CountryCode) {
+			continue
+		}
+		if found {
+			return Place{}, false
+		}
+		res, found = b.country, true
+	}
+	return res, found
+}
+
+// Distance returns the great-circle distance of two coordinates in kilometers.
+func Distance(lat1, long1, lat2, long2 float64) float64 {
+	var (
+		phi1 = lat1 * math.Pi / 180
+		phi2 = lat2 * math.Pi / 180
+		dPhi = (lat2 - lat1) * math.Pi / 180
+		dLam = (long2 - long1) * math.Pi / 180
+		a    = math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLam/2)*math.Sin(dLam/2)
+	)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// read parses a GeoNames cities dump: tab separated geonameid, name, ASCII name, alternate names, latitude,
+// longitude, feature class, feature code, country code, alternate country codes, admin1 code, ... population.
+func (g *Gazetteer) read(r io.Reader, regions, countries map[string]string) error {
+	return readLines(r, func(fields []string) error {
+		if len(fields) < 15 {
+			return nil
+		}
+		lat, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return err
+		}
+		long, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return err
+		}
+		population, _ := strconv.Atoi(fields[14])
+		g.places = append(g.places, Place{
+			Name:        fields[1],
+			CountryCode: fields[8],
+			Country:     countries[fields[8]],
+			Region:      regions[fields[8]+"."+fields[10]],
+			Latitude:    lat,
+			Longitude:   long,
+			Population:  population,
+		})
+		return nil
+	})
+}
+
+// readBounds reads the bounding boxes of the countries, if the gazetteer has them.
+func (g *Gazetteer) readBounds(fsys fs.FS, countries map[string]string) error {
+	f, err := fsys.Open(boundsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readLines(f, func(fields []string) error {
+		if len(fields) < 5 {
+			return nil
+		}
+		b := bounds{country: Place{CountryCode: fields[0], Country: countries[fields[0]]}}
+		for i, v := range []*float64{&b.west, &b.south, &b.east, &b.north} {
+			var err error
+			if *v, err = strconv.ParseFloat(fields[i+1], 64); err != nil {
+				return err
+			}
+		}
+		g.bounds = append(g.bounds, b)
+		return nil
+	})
+}
+
+// readNames reads a tab separated GeoNames file into a map of the key and name columns. Returns an empty map if the
+// file does not exist.
+func readNames(fsys fs.FS, name string, key int, value int) (map[string]string, error) {
+	res := make(map[string]string)
+	f, err := fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	err = readLines(f, func(fields []string) error {
+		if len(fields) > max(key, value) {
+			res[fields[key]] = fields[value]
+		}
+		return nil
+	})
+	return res, err
+}
+
+// readLines calls `fn` with the tab separated fields of the lines of a GeoNames file, skipping comments.
+func readLines(r io.Reader, fn func(fields []string) error) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1<<20) // alternate names of large cities are long
+	for s.Scan() {
+		line := s.Text()
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(strings.Split(line, "\t")); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
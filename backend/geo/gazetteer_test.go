@@ -0,0 +1,98 @@
+package geo
+
+import (
+	"math"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReverse(t *testing.T) {
+	g, err := Embedded()
+	if err != nil {
+		t.Fatalf("Loading embedded gazetteer failed: %v", err)
+	}
+	tests := []struct {
+		name    string
+		lat     float64
+		long    float64
+		want    Place
+		wantHit bool
+	}{
+		{name: "city center", lat: 47.4979, long: 19.0402, want: Place{Name: "Budapest", CountryCode: "HU", Country: "Hungary", Region: "Budapest"}, wantHit: true},
+		{name: "suburb", lat: 48.8049, long: 2.1204, want: Place{Name: "Paris", CountryCode: "FR", Country: "France", Region: "Ile-de-France"}, wantHit: true},
+		{name: "nearest of two", lat: 22.40, long: 114.11, want: Place{Name: "Hong Kong", CountryCode: "HK", Country: "Hong Kong"}, wantHit: true},
+		{name: "southern hemisphere", lat: -33.8568, long: 151.2153, want: Place{Name: "Sydney", CountryCode: "AU", Country: "Australia", Region: "New South Wales"}, wantHit: true},
+		{name: "countryside", lat: 46.9, long: 19.3, want: Place{CountryCode: "HU", Country: "Hungary"}, wantHit: true},
+		{name: "outback", lat: -25.3, long: 131.0, want: Place{CountryCode: "AU", Country: "Australia"}, wantHit: true},
+		{name: "enclave", lat: -29.5, long: 28.3, wantHit: false},
+		{name: "border of France and Belgium", lat: 50.63, long: 3.06, wantHit: false},
+		{name: "border of France and Switzerland", lat: 47.75, long: 7.34, wantHit: false},
+		{name: "border of United States and Canada", lat: 42.33, long: -83.05, wantHit: false},
+		{name: "across the antimeridian", lat: 66.0, long: 175.0, want: Place{CountryCode: "RU", Country: "Russia"}, wantHit: true},
+		{name: "open sea", lat: 30, long: -40, wantHit: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := g.Reverse(tt.lat, tt.long)
+			if ok != tt.wantHit {
+				t.Fatalf("Reverse found %v; want %v", ok, tt.wantHit)
+			}
+			if p.Name != tt.want.Name || p.CountryCode != tt.want.CountryCode || p.Country != tt.want.Country || p.Region != tt.want.Region {
+				t.Errorf("Reverse = %+v; want %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	fsys := fstest.MapFS{
+		"cities500.txt": {Data: []byte("# comment\n" +
+			"3054643\tBudapest\tBudapest\tBudapeszt\t47.49835\t19.04045\tP\tPPLC\tHU\t\t05\t13\t\t\t1741041\t\t96\tEurope/Budapest\t2023-01-01\n" +
+			"3046526\tPecs\tPecs\t\t46.08333\t18.23333\tP\tPPLA\tHU\t\t02\t\t\t\t145347\t\t\tEurope/Budapest\t2023-01-01\n")},
+		"admin1CodesASCII.txt": {Data: []byte("HU.05\tBudapest\tBudapest\t3054638\n")},
+	}
+	g, err := Open(fsys)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if g.Len() != 2 {
+		t.Errorf("Len = %v; want 2", g.Len())
+	}
+	p, ok := g.Reverse(46.07, 18.22)
+	if !ok || p.Name != "Pecs" || p.Population != 145347 || len(p.Region) > 0 || len(p.Country) > 0 {
+		t.Errorf("Reverse = %+v, %v; want Pecs without region and country names", p, ok)
+	}
+
+	fsys["countryBounds.txt"] = &fstest.MapFile{Data: []byte("#ISO\twest\tsouth\teast\tnorth\nHU\t16.11\t45.74\t22.90\t48.59\n")}
+	if g, err = Open(fsys); err != nil {
+		t.Fatalf("Open with bounds failed: %v", err)
+	}
+	p, ok = g.Reverse(47.9, 21.9)
+	if !ok || p.CountryCode != "HU" || len(p.Name) > 0 {
+		t.Errorf("Reverse = %+v, %v; want HU without place name", p, ok)
+	}
+
+	if _, err = Open(fstest.MapFS{}); err != ErrMissingPlaces {
+		t.Errorf("Open without cities = %v; want %v", err, ErrMissingPlaces)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name                     string
+		lat1, long1, lat2, long2 float64
+		want                     float64
+	}{
+		{name: "same", lat1: 47.5, long1: 19, lat2: 47.5, long2: 19, want: 0},
+		{name: "degree of latitude", lat1: 0, long1: 0, lat2: 1, long2: 0, want: 111.19},
+		{name: "antimeridian", lat1: 0, long1: 179.5, lat2: 0, long2: -179.5, want: 111.19},
+		{name: "London to Paris", lat1: 51.50853, long1: -0.12574, lat2: 48.85341, long2: 2.3488, want: 343.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := Distance(tt.lat1, tt.long1, tt.lat2, tt.long2); math.Abs(actual-tt.want) > 0.5 {
+				t.Errorf("Distance = %v; want %v", actual, tt.want)
+			}
+		})
+	}
+}
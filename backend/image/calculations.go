@@ -2,40 +2,191 @@ package image
 
 import (
 	"bytes"
+	"errors"
 	"image"
 	"image/jpeg"
 	"math"
+	"sort"
 
+	"github.com/inokone/photostorage/common"
 	"golang.org/x/image/draw"
 )
 
 const (
 	thumbWidth  float64 = 1000
 	thumbHeight float64 = 1000
+	// thumbQuality is the default JPEG quality of the renditions, the same as of renders
+	thumbQuality = defaultQuality
+	// thumbKernel is the default resampling kernel of the renditions
+	thumbKernel = "catmullrom"
 )
 
-// Thumbnail is a function to generate a thumbnail image of max size [`thumbWidth`, `thumbHeight`] for the image provided as a parameter.
+// ErrInvalidResampling is an error for unknown resampling kernels, JPEG qualities out of [1, 100] and non-positive
+// rendition sizes
+var ErrInvalidResampling = errors.New("invalid resampling configuration")
+
+// kernels are the resampling kernels of the renditions by name, from the fastest to the sharpest
+var kernels = map[string]draw.Interpolator{
+	"nearest":        draw.NearestNeighbor,
+	"approxbilinear": draw.ApproxBiLinear,
+	"bilinear":       draw.BiLinear,
+	"catmullrom":     draw.CatmullRom,
+}
+
+// Resampling is the configuration of resizing images into renditions and encoding them as JPEG.
+type Resampling struct {
+	// Kernel is the name of the resampling kernel: nearest, approxbilinear, bilinear or catmullrom
+	Kernel  string
+	Quality int
+	// Upscale lets images smaller than a rendition be enlarged to its size, otherwise they are kept at their size
+	Upscale bool
+	// MaxWidth and MaxHeight bound the size of the thumbnail rendition
+	MaxWidth  float64
+	MaxHeight float64
+}
+
+// NewResampling creates the resampling of renditions from the image store configuration. Unset values fall back to
+// the defaults.
+func NewResampling(c *common.ImageStoreConfig) (Resampling, error) {
+	r := Resampling{
+		Kernel:    c.ThumbKernel,
+		Quality:   c.ThumbQuality,
+		Upscale:   c.ThumbUpscale,
+		MaxWidth:  float64(c.ThumbMaxWidth),
+		MaxHeight: float64(c.ThumbMaxHeight),
+	}
+	if len(r.Kernel) == 0 {
+		r.Kernel = thumbKernel
+	}
+	if r.Quality == 0 {
+		r.Quality = thumbQuality
+	}
+	if r.MaxWidth == 0 {
+		r.MaxWidth = thumbWidth
+	}
+	if r.MaxHeight == 0 {
+		r.MaxHeight = thumbHeight
+	}
+	if _, ok := kernels[r.Kernel]; !ok || r.Quality < 1 || r.Quality > 100 || r.MaxWidth < 0 || r.MaxHeight < 0 {
+		return Resampling{}, ErrInvalidResampling
+	}
+	return r, nil
+}
+
+// DefaultResampling returns the resampling of an empty configuration, used by `Thumbnail` and `ExportJpeg`.
+func DefaultResampling() Resampling {
+	return Resampling{
+		Kernel:    thumbKernel,
+		Quality:   thumbQuality,
+		MaxWidth:  thumbWidth,
+		MaxHeight: thumbHeight,
+	}
+}
+
+// Thumbnail is a function to generate a thumbnail image of the max size of the default resampling for the image provided as a parameter.
 func Thumbnail(original image.Image) (image.Image, error) {
-	result := canvas(original.Bounds().Size().X, original.Bounds().Size().Y)
-	draw.NearestNeighbor.Scale(result, result.Rect, original, original.Bounds(), draw.Over, nil)
+	r := DefaultResampling()
+	return r.Resize(original, r.MaxWidth, r.MaxHeight)
+}
+
+// ExportJpeg is a function to export the image provided as parameter as a byte array in JPEG format.
+func ExportJpeg(image image.Image) ([]byte, error) {
+	return DefaultResampling().ExportJpeg(image)
+}
+
+// Resize generates a resized image of max size [`maxWidth`, `maxHeight`] for the image provided as a parameter,
+// keeping the aspect ratio.
+func (r Resampling) Resize(original image.Image, maxWidth, maxHeight float64) (image.Image, error) {
+	result := r.canvasFor(original.Bounds().Size().X, original.Bounds().Size().Y, maxWidth, maxHeight)
+	r.interpolator().Scale(result, result.Rect, original, original.Bounds(), draw.Over, nil)
 	return result, nil
 }
 
+// CreateRenditions generates all `Renditions` as JPEG for the image provided as a parameter. Renditions are
+// resampled from the next larger one if it is large enough, which is a lot cheaper with kernels of wide support than
+// resampling the original every time.
+func (r Resampling) CreateRenditions(original image.Image) ([]ThumbnailImg, error) {
+	order := make([]int, len(Renditions))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		wi, hi := r.bounds(Renditions[order[i]])
+		wj, hj := r.bounds(Renditions[order[j]])
+		return wi*hi > wj*hj
+	})
+
+	var (
+		res  = make([]ThumbnailImg, len(Renditions))
+		size = original.Bounds().Size()
+		src  = original
+	)
+	for _, i := range order {
+		w, h := r.bounds(Renditions[i])
+		resized := r.canvasFor(size.X, size.Y, w, h)
+		if src.Bounds().Dx() < resized.Rect.Dx() || src.Bounds().Dy() < resized.Rect.Dy() {
+			src = original
+		}
+		r.interpolator().Scale(resized, resized.Rect, src, src.Bounds(), draw.Over, nil)
+		b, err := r.ExportJpeg(resized)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = ThumbnailImg{
+			Variant: Renditions[i].Variant,
+			Image:   b,
+			Width:   resized.Rect.Dx(),
+			Height:  resized.Rect.Dy(),
+		}
+		src = resized
+	}
+	return res, nil
+}
+
+// ExportJpeg exports the image provided as parameter as a byte array in JPEG format of the quality of the resampling.
+func (r Resampling) ExportJpeg(image image.Image) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := jpeg.Encode(buf, image, &jpeg.Options{Quality: r.Quality})
+	return buf.Bytes(), err
+}
+
+// bounds returns the max size of the rendition, the thumbnail is bounded by the resampling.
+func (r Resampling) bounds(rd Rendition) (float64, float64) {
+	if rd.Variant == ThumbnailVariant {
+		return r.MaxWidth, r.MaxHeight
+	}
+	return rd.MaxWidth, rd.MaxHeight
+}
+
+// canvasFor returns the canvas of an image of size [`width`, `height`] resized to max size [`maxWidth`, `maxHeight`],
+// not larger than the image itself unless upscaling is enabled.
+func (r Resampling) canvasFor(width int, height int, maxWidth, maxHeight float64) *image.RGBA {
+	if !r.Upscale {
+		maxWidth, maxHeight = math.Min(maxWidth, float64(width)), math.Min(maxHeight, float64(height))
+	}
+	return canvasFor(width, height, maxWidth, maxHeight)
+}
+
+// interpolator returns the resampling kernel, Catmull-Rom for unknown kernels.
+func (r Resampling) interpolator() draw.Interpolator {
+	if k, ok := kernels[r.Kernel]; ok {
+		return k
+	}
+	return draw.CatmullRom
+}
+
 func canvas(width int, height int) *image.RGBA {
-	ratio := math.Min(thumbWidth/float64(width), thumbHeight/float64(height))
+	return canvasFor(width, height, thumbWidth, thumbHeight)
+}
+
+func canvasFor(width int, height int, maxWidth, maxHeight float64) *image.RGBA {
+	ratio := math.Min(maxWidth/float64(width), maxHeight/float64(height))
 	newWidth := int(ratio * float64(width))
 	newHeight := int(ratio * float64(height))
 	result := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
 	return result
 }
 
-// ExportJpeg is a function to export the image provided as parameter as a byte array in JPEG format.
-func ExportJpeg(image image.Image) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := jpeg.Encode(buf, image, nil)
-	return buf.Bytes(), err
-}
-
 // ImportJpeg is a function to import a byte array in JPEG format into an Image object.
 func ImportJpeg(b []byte) (image.Image, error) {
 	return jpeg.Decode(bytes.NewReader(b))
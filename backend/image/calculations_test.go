@@ -1,9 +1,19 @@
 package image
 
 import (
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/inokone/photostorage/common"
 )
 
+var update = flag.Bool("update", false, "update the golden images in testdata")
+
 type CanvasTest struct {
 	inX  int
 	inY  int
@@ -26,3 +36,174 @@ func TestCanvas(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateRenditions(t *testing.T) {
+	original := canvasFor(4000, 3000, 4000, 3000)
+	renditions, err := DefaultResampling().CreateRenditions(original)
+	if err != nil {
+		t.Fatalf("CreateRenditions failed: %v", err)
+	}
+	if len(renditions) != len(Renditions) {
+		t.Fatalf("CreateRenditions returned %v renditions; want %v", len(renditions), len(Renditions))
+	}
+	for i, r := range Renditions {
+		actual := renditions[i]
+		if actual.Variant != r.Variant || float64(actual.Width) != r.MaxWidth || len(actual.Image) == 0 {
+			t.Errorf("CreateRenditions()[%v] = (%v, %v); want (%v, %v)", i, actual.Variant, actual.Width, r.Variant, r.MaxWidth)
+		}
+	}
+}
+
+// rings returns a deterministic test image of concentric rings, which are aliased visibly by poor resampling.
+func rings(width, height int) *image.RGBA {
+	im := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx, dy := x-width/2, y-height/2
+			v := uint8(0)
+			if (dx*dx+dy*dy)/97%2 == 0 {
+				v = 255
+			}
+			im.SetRGBA(x, y, color.RGBA{v, uint8(x * 255 / width), uint8(y * 255 / height), 255})
+		}
+	}
+	return im
+}
+
+func TestResizeGolden(t *testing.T) {
+	original := rings(384, 288)
+	for kernel := range kernels {
+		t.Run(kernel, func(t *testing.T) {
+			r := Resampling{Kernel: kernel, Quality: thumbQuality}
+			actual, err := r.Resize(original, 96, 96)
+			if err != nil {
+				t.Fatalf("Resize failed: %v", err)
+			}
+			golden := filepath.Join("testdata", "resize_"+kernel+".png")
+			if *update {
+				f, err := os.Create(golden)
+				if err != nil {
+					t.Fatalf("Failed to create golden image: %v", err)
+				}
+				defer f.Close()
+				if err = png.Encode(f, actual); err != nil {
+					t.Fatalf("Failed to write golden image: %v", err)
+				}
+				return
+			}
+			f, err := os.Open(golden)
+			if err != nil {
+				t.Fatalf("Failed to open golden image: %v", err)
+			}
+			defer f.Close()
+			expected, err := png.Decode(f)
+			if err != nil {
+				t.Fatalf("Failed to read golden image: %v", err)
+			}
+			if actual.Bounds() != expected.Bounds() {
+				t.Fatalf("Resize() = %v; want %v", actual.Bounds(), expected.Bounds())
+			}
+			// floating point results may differ slightly between architectures
+			const tolerance = 2
+			b := actual.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					a := color.RGBAModel.Convert(actual.At(x, y)).(color.RGBA)
+					e := color.RGBAModel.Convert(expected.At(x, y)).(color.RGBA)
+					if diff(a.R, e.R) > tolerance || diff(a.G, e.G) > tolerance || diff(a.B, e.B) > tolerance || diff(a.A, e.A) > tolerance {
+						t.Fatalf("Resize() at (%v, %v) = %v; want %v", x, y, a, e)
+					}
+				}
+			}
+		})
+	}
+}
+
+func diff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestResizeUpscale(t *testing.T) {
+	var upscaleTests = []struct {
+		upscale bool
+		inX     int
+		inY     int
+		outX    int
+		outY    int
+	}{
+		{false, 200, 200, 200, 200},
+		{false, 3000, 400, 1000, 133},
+		{false, 2344, 1540, 1000, 656},
+		{true, 200, 200, 1000, 1000},
+		{true, 100, 50, 1000, 500},
+	}
+	for _, test := range upscaleTests {
+		r := Resampling{Kernel: "nearest", Upscale: test.upscale}
+		actual, _ := r.Resize(image.NewRGBA(image.Rect(0, 0, test.inX, test.inY)), thumbWidth, thumbHeight)
+		if actual.Bounds().Dx() != test.outX || actual.Bounds().Dy() != test.outY {
+			t.Errorf("Resize(%v, %v) with upscale %v = (%v, %v); want (%v, %v)", test.inX, test.inY, test.upscale, actual.Bounds().Dx(), actual.Bounds().Dy(), test.outX, test.outY)
+		}
+	}
+}
+
+func TestCreateRenditionsBounds(t *testing.T) {
+	r := Resampling{Kernel: "approxbilinear", Quality: thumbQuality, MaxWidth: 640, MaxHeight: 480}
+	renditions, err := r.CreateRenditions(rings(1200, 300))
+	if err != nil {
+		t.Fatalf("CreateRenditions failed: %v", err)
+	}
+	var expected = map[Variant][2]int{
+		SmallVariant:     {256, 64},
+		ThumbnailVariant: {640, 160},
+		MediumVariant:    {1200, 300},
+	}
+	for _, actual := range renditions {
+		e := expected[actual.Variant]
+		if actual.Width != e[0] || actual.Height != e[1] {
+			t.Errorf("CreateRenditions()[%v] = (%v, %v); want (%v, %v)", actual.Variant, actual.Width, actual.Height, e[0], e[1])
+		}
+		im, err := ImportJpeg(actual.Image)
+		if err != nil || im.Bounds().Dx() != e[0] || im.Bounds().Dy() != e[1] {
+			t.Errorf("CreateRenditions()[%v] is not a JPEG of (%v, %v)", actual.Variant, e[0], e[1])
+		}
+	}
+}
+
+func TestExportJpegQuality(t *testing.T) {
+	original := rings(256, 256)
+	low, err := Resampling{Quality: 30}.ExportJpeg(original)
+	if err != nil {
+		t.Fatalf("ExportJpeg failed: %v", err)
+	}
+	high, err := Resampling{Quality: 95}.ExportJpeg(original)
+	if err != nil {
+		t.Fatalf("ExportJpeg failed: %v", err)
+	}
+	if len(high) <= len(low) {
+		t.Errorf("ExportJpeg() of quality 95 = %v bytes; want more than %v bytes of quality 30", len(high), len(low))
+	}
+}
+
+func TestNewResampling(t *testing.T) {
+	var resamplingTests = []struct {
+		config   common.ImageStoreConfig
+		expected Resampling
+		err      error
+	}{
+		{common.ImageStoreConfig{}, Resampling{Kernel: thumbKernel, Quality: thumbQuality, MaxWidth: thumbWidth, MaxHeight: thumbHeight}, nil},
+		{common.ImageStoreConfig{ThumbKernel: "bilinear", ThumbQuality: 70, ThumbUpscale: true, ThumbMaxWidth: 800, ThumbMaxHeight: 600},
+			Resampling{Kernel: "bilinear", Quality: 70, Upscale: true, MaxWidth: 800, MaxHeight: 600}, nil},
+		{common.ImageStoreConfig{ThumbKernel: "lanczos"}, Resampling{}, ErrInvalidResampling},
+		{common.ImageStoreConfig{ThumbQuality: 101}, Resampling{}, ErrInvalidResampling},
+		{common.ImageStoreConfig{ThumbMaxWidth: -1}, Resampling{}, ErrInvalidResampling},
+	}
+	for _, test := range resamplingTests {
+		actual, err := NewResampling(&test.config)
+		if actual != test.expected || err != test.err {
+			t.Errorf("NewResampling(%+v) = (%+v, %v); want (%+v, %v)", test.config, actual, err, test.expected, test.err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package image
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+var errNegativeOffset = errors.New("seek to negative offset")
+
+// Content is a seekable view of a stored binary (RAW or processed image). Only the byte ranges actually read are
+// collected from the store, so it can be used for partial downloads of large files.
+type Content struct {
+	Info   ObjectInfo
+	open   func(offset, length int64) (io.ReadCloser, error)
+	offset int64
+	body   io.ReadCloser
+}
+
+// NewContent creates a `Content` for a binary described by `info`, using `open` to read byte ranges of it.
+func NewContent(info ObjectInfo, open func(offset, length int64) (io.ReadCloser, error)) *Content {
+	return &Content{
+		Info: info,
+		open: open,
+	}
+}
+
+// OpenContent creates a `Content` for a variant of the image specified by the id.
+func OpenContent(l Loader, id string, variant Variant) (*Content, error) {
+	info, err := l.Stat(id, variant)
+	if err != nil {
+		return nil, err
+	}
+	return NewContent(*info, func(offset, length int64) (io.ReadCloser, error) {
+		return l.OpenRange(id, variant, offset, length)
+	}), nil
+}
+
+// Read is a method of `Content` implementing `io.Reader`. The underlying range is opened lazily from the current
+// offset to the end of the binary.
+func (c *Content) Read(p []byte) (int, error) {
+	var err error
+	if c.offset >= c.Info.Size {
+		return 0, io.EOF
+	}
+	if c.body == nil {
+		c.body, err = c.open(c.offset, c.Info.Size-c.offset)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.body.Read(p)
+	c.offset += int64(n)
+	return n, err
+}
+
+// Seek is a method of `Content` implementing `io.Seeker`. Seeking closes the range opened by previous reads.
+func (c *Content) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = c.offset + offset
+	case io.SeekEnd:
+		target = c.Info.Size + offset
+	}
+	if target < 0 {
+		return c.offset, errNegativeOffset
+	}
+	if target != c.offset {
+		if err := c.Close(); err != nil {
+			return c.offset, err
+		}
+		c.offset = target
+	}
+	return c.offset, nil
+}
+
+// Close is a method of `Content` closing the range opened by previous reads, if any.
+func (c *Content) Close() error {
+	if c.body == nil {
+		return nil
+	}
+	err := c.body.Close()
+	c.body = nil
+	return err
+}
+
+// Serve is a method of `Content` writing the binary to the HTTP response. `Range`, `If-None-Match` and
+// `If-Modified-Since` headers of the request are honored, content type and disposition headers are expected
+// to be set by the caller.
+func (c *Content) Serve(w http.ResponseWriter, r *http.Request) {
+	if len(c.Info.ETag) > 0 {
+		w.Header().Set("ETag", c.Info.ETag)
+	}
+	http.ServeContent(w, r, "", c.Info.Modified, c)
+}
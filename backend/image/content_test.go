@@ -0,0 +1,79 @@
+package image
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type ServeTest struct {
+	header string
+	value  string
+	status int
+	body   string
+}
+
+var (
+	payload    = []byte("0123456789abcdefghij")
+	modified   = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	serveTests = []ServeTest{
+		{"", "", http.StatusOK, string(payload)},
+		{"Range", "bytes=0-3", http.StatusPartialContent, "0123"},
+		{"Range", "bytes=10-", http.StatusPartialContent, "abcdefghij"},
+		{"Range", "bytes=-5", http.StatusPartialContent, "fghij"},
+		{"Range", "bytes=30-40", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"If-None-Match", `"tag"`, http.StatusNotModified, ""},
+		{"If-None-Match", `"other"`, http.StatusOK, string(payload)},
+		{"If-Modified-Since", modified.Format(http.TimeFormat), http.StatusNotModified, ""},
+		{"If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK, string(payload)},
+	}
+)
+
+func testContent() *Content {
+	return NewContent(ObjectInfo{Size: int64(len(payload)), Modified: modified, ETag: `"tag"`},
+		func(offset, length int64) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(payload[offset : offset+length])), nil
+		})
+}
+
+func TestContentSeek(t *testing.T) {
+	c := testContent()
+	if _, err := c.Seek(5, io.SeekStart); err != nil {
+		t.Fatalf("Seek(5, SeekStart) failed: %v", err)
+	}
+	b := make([]byte, 3)
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != "567" {
+		t.Errorf("Read after Seek(5, SeekStart) = %v, %v; want 567", string(b), err)
+	}
+	if _, err := c.Seek(-2, io.SeekEnd); err != nil {
+		t.Fatalf("Seek(-2, SeekEnd) failed: %v", err)
+	}
+	rest, err := io.ReadAll(c)
+	if err != nil || string(rest) != "ij" {
+		t.Errorf("Read after Seek(-2, SeekEnd) = %v, %v; want ij", string(rest), err)
+	}
+	if _, err := c.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("Seek(-1, SeekStart) should fail")
+	}
+}
+
+func TestContentServe(t *testing.T) {
+	for _, test := range serveTests {
+		r := httptest.NewRequest(http.MethodGet, "/raw", nil)
+		if len(test.header) > 0 {
+			r.Header.Set(test.header, test.value)
+		}
+		w := httptest.NewRecorder()
+		testContent().Serve(w, r)
+		if w.Code != test.status {
+			t.Errorf("Serve with %v: %v = %v; want %v", test.header, test.value, w.Code, test.status)
+			continue
+		}
+		if (test.status == http.StatusOK || test.status == http.StatusPartialContent) && w.Body.String() != test.body {
+			t.Errorf("Serve with %v: %v = %v; want %v", test.header, test.value, w.Body.String(), test.body)
+		}
+	}
+}
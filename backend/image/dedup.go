@@ -0,0 +1,395 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Blob is a content-addressed binary in the image store, shared by all photos with the same RAW content. The content
+// is complete once `Written`, as references are added before the content is written.
+type Blob struct {
+	Hash     string `gorm:"type:char(64);primary_key"`
+	RefCount int
+	Size     int64
+	// Written defaults to true for blobs stored before the written state was tracked
+	Written   bool `gorm:"not null;default:true"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// BlobRef is a struct mapping a photo to the `Blob` holding its content.
+type BlobRef struct {
+	PhotoID   string `gorm:"type:varchar(255);primary_key"`
+	Hash      string `gorm:"type:char(64);index;not null"`
+	CreatedAt time.Time
+}
+
+// RefStorer is an interface for persisting references of photos to content-addressed blobs.
+type RefStorer interface {
+	// Hash returns the hash of the blob referenced by the photo, `gorm.ErrRecordNotFound` if there is no reference.
+	Hash(id string) (string, error)
+
+	// Reference adds a reference of the photo to the blob, returns whether the content of the blob is written.
+	Reference(id string, hash string, size int64) (bool, error)
+
+	// Written marks the content of the blob as written.
+	Written(hash string) error
+
+	// Release removes the reference of the photo, returns the hash of the blob and the number of remaining references.
+	Release(id string) (string, int, error)
+
+	// Refs returns the IDs of the photos referencing the blob.
+	Refs(hash string) ([]string, error)
+}
+
+// GORMRefStorer is an implementation of `RefStorer` interface based on GORM library.
+type GORMRefStorer struct {
+	db *gorm.DB
+}
+
+// NewGORMRefStorer creates a new `GORMRefStorer` instance based on the GORM library.
+func NewGORMRefStorer(db *gorm.DB) *GORMRefStorer {
+	return &GORMRefStorer{db: db}
+}
+
+// Hash is a method of `GORMRefStorer` for loading the hash of the blob referenced by the photo.
+func (s *GORMRefStorer) Hash(id string) (string, error) {
+	var ref BlobRef
+	result := s.db.First(&ref, "photo_id = ?", id)
+	return ref.Hash, result.Error
+}
+
+// Reference is a method of `GORMRefStorer` for adding a reference of a photo to a blob and incrementing the
+// reference count of the blob.
+func (s *GORMRefStorer) Reference(id string, hash string, size int64) (bool, error) {
+	var written bool
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if res := tx.Create(&BlobRef{PhotoID: id, Hash: hash}); res.Error != nil {
+			return res.Error
+		}
+		res := tx.Raw(`INSERT INTO blobs (hash, ref_count, size, written, created_at, updated_at)
+			VALUES (?, 1, ?, false, now(), now())
+			ON CONFLICT (hash) DO UPDATE SET ref_count = blobs.ref_count + 1, updated_at = now()
+			RETURNING written`, hash, size).Scan(&written)
+		return res.Error
+	})
+	return written, err
+}
+
+// Written is a method of `GORMRefStorer` for marking the content of the blob as written.
+func (s *GORMRefStorer) Written(hash string) error {
+	return s.db.Model(&Blob{}).Where("hash = ?", hash).Update("written", true).Error
+}
+
+// Release is a method of `GORMRefStorer` for removing the reference of a photo and decrementing the reference count
+// of the blob. The blob is removed from persistence when its last reference is released.
+func (s *GORMRefStorer) Release(id string) (string, int, error) {
+	var (
+		ref   BlobRef
+		count int
+	)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if res := tx.First(&ref, "photo_id = ?", id); res.Error != nil {
+			return res.Error
+		}
+		if res := tx.Delete(&ref, "photo_id = ?", id); res.Error != nil {
+			return res.Error
+		}
+		res := tx.Raw("UPDATE blobs SET ref_count = ref_count - 1, updated_at = now() WHERE hash = ? RETURNING ref_count", ref.Hash).Scan(&count)
+		if res.Error != nil {
+			return res.Error
+		}
+		if count <= 0 {
+			return tx.Delete(&Blob{}, "hash = ?", ref.Hash).Error
+		}
+		return nil
+	})
+	return ref.Hash, count, err
+}
+
+// Refs is a method of `GORMRefStorer` for loading the IDs of the photos referencing the blob.
+func (s *GORMRefStorer) Refs(hash string) ([]string, error) {
+	var ids []string
+	result := s.db.Model(&BlobRef{}).Where("hash = ?", hash).Pluck("photo_id", &ids)
+	return ids, result.Error
+}
+
+// DedupStorer is an implementation of the `Storer` interface as pointer, storing images content-addressed on
+// an underlying `Storer`. RAWs are keyed by the SHA-256 hash of their content and reference counted, so uploading
+// the same RAW multiple times stores it only once. Processed variants depend on the rotation of the photo as well,
+// so they are keyed by the ID of the image.
+type DedupStorer struct {
+	base Storer
+	refs RefStorer
+}
+
+// NewDedupStorer creates a new `DedupStorer` storing blobs on the base `Storer` and references in `RefStorer`.
+func NewDedupStorer(base Storer, refs RefStorer) *DedupStorer {
+	return &DedupStorer{
+		base: base,
+		refs: refs,
+	}
+}
+
+// Store stores a variant of an image content-addressed, the RAW is only written if the content is not stored yet.
+func (s *DedupStorer) Store(id string, variant Variant, content []byte) error {
+	if variant != RawVariant {
+		return s.StoreStream(id, variant, bytes.NewReader(content))
+	}
+	sum := sha256.Sum256(content)
+	return s.storeBlob(id, hex.EncodeToString(sum[:]), int64(len(content)), bytes.NewReader(content))
+}
+
+// StoreStream stores a variant of an image content-addressed. As the key depends on the content, the RAW is
+// spooled to a temporary file while hashing, it is only written if the content is not stored yet. Processed
+// variants are stored under the ID of the image.
+func (s *DedupStorer) StoreStream(id string, variant Variant, content io.Reader) error {
+	if variant != RawVariant {
+		return s.base.StoreStream(id, variant, content)
+	}
+
+	f, err := os.CreateTemp("", "dedup_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(f, io.TeeReader(content, h))
+	if err != nil {
+		return err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return s.storeBlob(id, hex.EncodeToString(h.Sum(nil)), size, f)
+}
+
+// storeBlob references the blob and writes its content unless it is written already. Uploads of the same content
+// racing with the first write can not rely on it succeeding, so every uploader writes the content until the blob is
+// marked as written. The content is the same, so the writes are interchangeable.
+func (s *DedupStorer) storeBlob(id string, hash string, size int64, raw io.Reader) error {
+	written, err := s.refs.Reference(id, hash, size)
+	if err != nil {
+		log.Err(err).Str("id", id).Str("hash", hash).Msg("Failed to reference blob")
+		return err
+	}
+	if written {
+		log.Debug().Str("id", id).Str("hash", hash).Msg("Content already stored, skipping write")
+		return nil
+	}
+	if err = s.base.StoreStream(hash, RawVariant, raw); err != nil {
+		if _, _, rerr := s.refs.Release(id); rerr != nil {
+			log.Err(rerr).Str("id", id).Str("hash", hash).Msg("Failed to release blob reference")
+		}
+		return err
+	}
+	if err = s.refs.Written(hash); err != nil {
+		log.Err(err).Str("id", id).Str("hash", hash).Msg("Failed to mark blob as written")
+	}
+	return nil
+}
+
+// Delete releases the reference of the image and deletes its processed variants, the RAW is only deleted with the
+// last reference.
+func (s *DedupStorer) Delete(id string) error {
+	hash, remaining, err := s.refs.Release(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.base.Delete(id) // stored before deduplication was enabled
+	}
+	if err != nil {
+		return err
+	}
+	if err = s.base.Delete(id); err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return nil
+	}
+	return s.base.Delete(hash)
+}
+
+// DeleteVariants deletes the processed variants of the image with names starting with the prefix.
+func (s *DedupStorer) DeleteVariants(id string, prefix Variant) error {
+	return s.base.DeleteVariants(id, prefix)
+}
+
+// Rotate re-wraps the data key of a variant of the image with the active master key, if the underlying store is
+// encrypted. The RAW is shared, so its key is rotated for all images with the same content.
+func (s *DedupStorer) Rotate(id string, variant Variant) (bool, error) {
+	r, ok := s.base.(Rotator)
+	if !ok {
+		return false, ErrNotEncrypted
+	}
+	key, err := s.variantKey(id, variant)
+	if err != nil {
+		return false, err
+	}
+	return r.Rotate(key, variant)
+}
+
+// List calls `fn` with the ID of every image referencing a blob or having processed variants on the underlying
+// store. Blobs without references are listed with their hash, images stored before deduplication was enabled with
+// their ID.
+func (s *DedupStorer) List(fn func(id string) error) error {
+	seen := make(map[string]bool)
+	return s.base.List(func(key string) error {
+		ids, err := s.refs.Refs(key)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			ids = []string{key}
+		}
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if err = fn(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// key returns the key of the image on the underlying `Storer`. Images stored before deduplication was enabled
+// have no reference and are keyed by their ID.
+func (s *DedupStorer) key(id string) (string, error) {
+	hash, err := s.refs.Hash(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return id, nil
+	}
+	return hash, err
+}
+
+// variantKey returns the key of a variant of the image on the underlying `Storer`: the key of the RAW for the RAW, the
+// ID of the image for processed variants. Renditions stored under the key of the RAW before they were keyed by the
+// ID are still loaded from there, renders are a cache, so they are rendered again instead.
+func (s *DedupStorer) variantKey(id string, variant Variant) (string, error) {
+	if variant == RawVariant {
+		return s.key(id)
+	}
+	if strings.HasPrefix(string(variant), string(RenderPrefix)) {
+		return id, nil
+	}
+	if _, err := s.base.Stat(id, variant); err == nil {
+		return id, nil
+	}
+	return s.key(id)
+}
+
+// Load loads a variant of the image specified by the id.
+func (s *DedupStorer) Load(id string, variant Variant) ([]byte, error) {
+	key, err := s.variantKey(id, variant)
+	if err != nil {
+		return nil, err
+	}
+	return s.base.Load(key, variant)
+}
+
+// Open opens a variant of the image specified by the id for reading.
+func (s *DedupStorer) Open(id string, variant Variant) (io.ReadCloser, error) {
+	key, err := s.variantKey(id, variant)
+	if err != nil {
+		return nil, err
+	}
+	return s.base.Open(key, variant)
+}
+
+// Stat returns size, modification time and entity tag of a variant of the image specified by the id.
+func (s *DedupStorer) Stat(id string, variant Variant) (*ObjectInfo, error) {
+	key, err := s.variantKey(id, variant)
+	if err != nil {
+		return nil, err
+	}
+	return s.base.Stat(key, variant)
+}
+
+// OpenRange opens a byte range of a variant of the image specified by the id for reading.
+func (s *DedupStorer) OpenRange(id string, variant Variant, offset, length int64) (io.ReadCloser, error) {
+	key, err := s.variantKey(id, variant)
+	if err != nil {
+		return nil, err
+	}
+	return s.base.OpenRange(key, variant, offset, length)
+}
+
+// SupportsPresign indicates whether the underlying store supports presign
+func (s *DedupStorer) SupportsPresign() bool {
+	return s.base.SupportsPresign()
+}
+
+// Presign makes a presigned request that can be used to get a variant of an image.
+func (s *DedupStorer) Presign(id string, variant Variant) (*PresignedRequest, error) {
+	key, err := s.variantKey(id, variant)
+	if err != nil {
+		return nil, err
+	}
+	return s.base.Presign(key, variant)
+}
+
+// PresignUpload makes a presigned request that can be used to upload a variant of an image. The content is not
+// known before the upload, so directly uploaded RAWs are keyed by the ID of the image and not deduplicated.
+func (s *DedupStorer) PresignUpload(id string, variant Variant, size int64) (*PresignedRequest, error) {
+	if variant != RawVariant {
+		return s.base.PresignUpload(id, variant, size)
+	}
+	key, err := s.key(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.base.PresignUpload(key, variant, size)
+}
+
+// Commit notifies the underlying store about a variant of the image uploaded with a presigned request.
+func (s *DedupStorer) Commit(id string, variant Variant) error {
+	c, ok := s.base.(Committer)
+	if !ok {
+		return nil
+	}
+	key, err := s.variantKey(id, variant)
+	if err != nil {
+		return err
+	}
+	return c.Commit(key, variant)
+}
+
+// MoveTo moves the content of the image specified by the id between storage tiers. The content is shared, so
+// it is only frozen if no other image references it, `ErrSharedContent` is returned otherwise.
+func (s *DedupStorer) MoveTo(id string, tier Tier) error {
+	key, err := s.key(id)
+	if err != nil {
+		return err
+	}
+	if tier == FrozenTier && key != id {
+		refs, err := s.refs.Refs(key)
+		if err != nil {
+			return err
+		}
+		if len(refs) > 1 {
+			return ErrSharedContent
+		}
+	}
+	return s.base.MoveTo(key, tier)
+}
+
+// Tier returns the storage tier of the content of the image specified by the id.
+func (s *DedupStorer) Tier(id string) (Tier, error) {
+	key, err := s.key(id)
+	if err != nil {
+		return 0, err
+	}
+	return s.base.Tier(key)
+}
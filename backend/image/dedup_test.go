@@ -0,0 +1,201 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type memRefs struct {
+	refs  map[string]string
+	blobs map[string]*Blob
+}
+
+func newMemRefs() *memRefs {
+	return &memRefs{refs: make(map[string]string), blobs: make(map[string]*Blob)}
+}
+
+func (r *memRefs) Hash(id string) (string, error) {
+	hash, ok := r.refs[id]
+	if !ok {
+		return "", gorm.ErrRecordNotFound
+	}
+	return hash, nil
+}
+
+func (r *memRefs) Reference(id string, hash string, size int64) (bool, error) {
+	r.refs[id] = hash
+	b, ok := r.blobs[hash]
+	if !ok {
+		b = &Blob{Hash: hash, Size: size}
+		r.blobs[hash] = b
+	}
+	b.RefCount++
+	return b.Written, nil
+}
+
+func (r *memRefs) Written(hash string) error {
+	r.blobs[hash].Written = true
+	return nil
+}
+
+func (r *memRefs) Release(id string) (string, int, error) {
+	hash, ok := r.refs[id]
+	if !ok {
+		return "", 0, gorm.ErrRecordNotFound
+	}
+	delete(r.refs, id)
+	b := r.blobs[hash]
+	b.RefCount--
+	if b.RefCount <= 0 {
+		delete(r.blobs, hash)
+	}
+	return hash, b.RefCount, nil
+}
+
+func (r *memRefs) Refs(hash string) ([]string, error) {
+	var ids []string
+	for id, h := range r.refs {
+		if h == hash {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// failingStorer fails the first RAW write, after calling `during` to simulate uploads racing with the write.
+type failingStorer struct {
+	*LocalStorer
+	failed bool
+	during func()
+}
+
+var errWrite = errors.New("write failed")
+
+func (s *failingStorer) StoreStream(id string, variant Variant, content io.Reader) error {
+	if variant == RawVariant && !s.failed {
+		s.failed = true
+		s.during()
+		return errWrite
+	}
+	return s.LocalStorer.StoreStream(id, variant, content)
+}
+
+func TestDedupStorerFailedWrite(t *testing.T) {
+	local, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	var (
+		content = []byte("raw content")
+		refs    = newMemRefs()
+		base    = &failingStorer{LocalStorer: local}
+		s       = NewDedupStorer(base, refs)
+		second  error
+	)
+	base.during = func() {
+		second = s.Store("second", RawVariant, content)
+	}
+
+	if err = s.Store("first", RawVariant, content); err == nil {
+		t.Fatalf("Store() of the failing write succeeded; want error")
+	}
+	if second != nil {
+		t.Fatalf("Store() of the racing upload failed: %v", second)
+	}
+	if _, err = refs.Hash("first"); err == nil {
+		t.Errorf("Reference of the failed upload was kept")
+	}
+	actual, err := s.Load("second", RawVariant)
+	if err != nil || !bytes.Equal(actual, content) {
+		t.Fatalf("Load() of the racing upload = (%q, %v); want %q", actual, err, content)
+	}
+	for _, b := range refs.blobs {
+		if b.RefCount != 1 || !b.Written {
+			t.Errorf("Blob = (%v refs, written %v); want (1 refs, written true)", b.RefCount, b.Written)
+		}
+	}
+}
+
+func TestDedupStorerMoveTo(t *testing.T) {
+	local, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	s := NewDedupStorer(local, newMemRefs())
+	for _, id := range []string{"first", "second"} {
+		if err = s.Store(id, RawVariant, []byte("shared content")); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+	if err = s.Store("single", RawVariant, []byte("single content")); err != nil {
+		t.Fatalf("Store() failed: %v", err)
+	}
+
+	if err = s.MoveTo("first", FrozenTier); !errors.Is(err, ErrSharedContent) {
+		t.Errorf("MoveTo() of shared content = %v; want %v", err, ErrSharedContent)
+	}
+	if tier, _ := s.Tier("second"); tier != StandardTier {
+		t.Errorf("Tier() of the other image = %v; want %v", tier, StandardTier)
+	}
+	if err = s.MoveTo("single", FrozenTier); err != nil {
+		t.Errorf("MoveTo() of unshared content failed: %v", err)
+	}
+	if tier, _ := s.Tier("single"); tier != FrozenTier {
+		t.Errorf("Tier() of the moved image = %v; want %v", tier, FrozenTier)
+	}
+}
+
+func TestDedupStorerVariants(t *testing.T) {
+	local, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	var (
+		refs = newMemRefs()
+		s    = NewDedupStorer(local, refs)
+	)
+	for _, id := range []string{"first", "second", "legacy"} {
+		if err = s.Store(id, RawVariant, []byte("shared content")); err != nil {
+			t.Fatalf("Store() failed: %v", err)
+		}
+	}
+	hash, _ := refs.Hash("legacy")
+	if err = local.Store(hash, ThumbnailVariant, []byte("shared thumbnail")); err != nil {
+		t.Fatalf("Store() of the shared thumbnail failed: %v", err)
+	}
+	if err = s.Store("first", ThumbnailVariant, []byte("rotated thumbnail")); err != nil {
+		t.Fatalf("Store() of the rotated thumbnail failed: %v", err)
+	}
+	if err = s.Store("second", ThumbnailVariant, []byte("upright thumbnail")); err != nil {
+		t.Fatalf("Store() of the upright thumbnail failed: %v", err)
+	}
+
+	var loadTests = []struct {
+		id       string
+		expected string
+	}{
+		{"first", "rotated thumbnail"},
+		{"second", "upright thumbnail"},
+		{"legacy", "shared thumbnail"},
+	}
+	for _, test := range loadTests {
+		actual, err := s.Load(test.id, ThumbnailVariant)
+		if err != nil || string(actual) != test.expected {
+			t.Errorf("Load(%v) = (%q, %v); want %q", test.id, actual, err, test.expected)
+		}
+	}
+
+	if err = s.Delete("first"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if _, err = local.Load("first", ThumbnailVariant); err == nil {
+		t.Errorf("Thumbnail of the deleted image was kept")
+	}
+	if actual, err := s.Load("second", RawVariant); err != nil || string(actual) != "shared content" {
+		t.Errorf("Load() of the shared RAW = (%q, %v); want %q", actual, err, "shared content")
+	}
+}
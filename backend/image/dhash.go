@@ -0,0 +1,42 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	dHashWidth  = 9
+	dHashHeight = 8
+)
+
+// DHash is a function calculating the 64 bit difference hash of the image: the image is scaled down to 9x8 grayscale
+// pixels and each bit is set if a pixel is brighter than its right neighbour. Similar images have hashes with a small
+// `Distance`, regardless of the size, compression and small edits of the images.
+func DHash(original image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, dHashWidth, dHashHeight))
+	draw.BiLinear.Scale(small, small.Rect, original, original.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < dHashHeight; y++ {
+		for x := 0; x < dHashWidth-1; x++ {
+			hash <<= 1
+			if brightness(small, x, y) > brightness(small, x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Distance is a function returning the number of different bits of the hashes, 0 for identical images.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func brightness(img *image.Gray, x, y int) uint8 {
+	return img.At(x, y).(color.Gray).Y
+}
@@ -0,0 +1,48 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func pattern(width, height int, invert bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			v := uint8(127 + 127*math.Sin(2*math.Pi*3*fx)*math.Cos(2*math.Pi*2*fy))
+			if invert {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       image.Image
+		b       image.Image
+		maxDist int
+		minDist int
+	}{
+		{name: "identical", a: pattern(400, 300, false), b: pattern(400, 300, false), maxDist: 0},
+		{name: "resized", a: pattern(400, 300, false), b: pattern(1000, 750, false), maxDist: 4},
+		{name: "different", a: pattern(400, 300, false), b: pattern(400, 300, true), minDist: 32, maxDist: 64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if DHash(tt.a) == 0 {
+				t.Fatalf("DHash = 0; want bits set")
+			}
+			d := Distance(DHash(tt.a), DHash(tt.b))
+			if d < tt.minDist || d > tt.maxDist {
+				t.Errorf("Distance = %v; want between %v and %v", d, tt.minDist, tt.maxDist)
+			}
+		})
+	}
+}
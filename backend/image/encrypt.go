@@ -0,0 +1,546 @@
+package image
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Encrypted objects start with a fixed size header holding the ID of the master key and the data key of the object
+// wrapped with the master key, followed by the content sealed in segments, so byte ranges can be decrypted without
+// reading the whole object.
+const (
+	segmentSize    = 64 * 1024
+	dataKeySize    = 32
+	keyIDSize      = 32
+	nonceSize      = 12
+	tagSize        = 16
+	wrappedKeySize = nonceSize + dataKeySize + tagSize
+	headerSize     = len(encryptMagic) + 1 + keyIDSize + wrappedKeySize
+	sealedSize     = segmentSize + tagSize
+)
+
+const encryptMagic = "RNE1"
+
+var (
+	// ErrInvalidKeys is an error for malformed master key configuration
+	ErrInvalidKeys = errors.New("invalid encryption key configuration")
+	// ErrUnknownKey is an error for objects encrypted with a master key missing from the configuration
+	ErrUnknownKey = errors.New("unknown encryption key")
+	// ErrCorruptObject is an error for encrypted objects failing authentication
+	ErrCorruptObject = errors.New("encrypted object is corrupt")
+	// ErrNotEncrypted is an error for rotating keys on a store without encryption at rest
+	ErrNotEncrypted = errors.New("image store is not encrypted")
+	// errPlaintext is an error for objects stored before encryption at rest was enabled, they are read as is while
+	// migrating to encryption
+	errPlaintext = errors.New("object is not encrypted")
+)
+
+// KeyRing is a set of master keys used for wrapping data keys. New objects are encrypted with the active key, the
+// other keys are kept for decrypting objects written before a key rotation.
+type KeyRing struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// ParseKeyRing creates a `KeyRing` from a comma separated list of `id:base64-key` pairs. Keys are AES-128, AES-192 or
+// AES-256 keys, the active key is the one with the provided ID, or the first one if no ID is provided.
+func ParseKeyRing(spec string, active string) (*KeyRing, error) {
+	k := &KeyRing{
+		active: active,
+		keys:   make(map[string]cipher.AEAD),
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		id, encoded, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found || len(id) == 0 || len(id) > keyIDSize {
+			return nil, ErrInvalidKeys
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("%w: duplicate key %v", ErrInvalidKeys, id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %v is not base64 encoded", ErrInvalidKeys, id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %v: %v", ErrInvalidKeys, id, err)
+		}
+		k.keys[id] = aead
+		if len(k.active) == 0 {
+			k.active = id
+		}
+	}
+	if _, ok := k.keys[k.active]; !ok {
+		return nil, fmt.Errorf("%w: active key %v is missing", ErrInvalidKeys, k.active)
+	}
+	return k, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// header creates the header of an object, wrapping the data key with the active master key.
+func (k *KeyRing) header(dataKey []byte) ([]byte, error) {
+	h := make([]byte, 0, headerSize)
+	h = append(h, encryptMagic...)
+	h = append(h, byte(len(k.active)))
+	h = append(h, k.active...)
+	h = append(h, make([]byte, keyIDSize-len(k.active))...)
+	prefix := bytes.Clone(h)
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	h = append(h, nonce...)
+	return k.keys[k.active].Seal(h, nonce, dataKey, prefix), nil
+}
+
+// unwrap returns the data key and the ID of the master key from the header of an object.
+func (k *KeyRing) unwrap(h []byte) ([]byte, string, error) {
+	if len(h) != headerSize || string(h[:len(encryptMagic)]) != encryptMagic {
+		return nil, "", ErrCorruptObject
+	}
+	prefix := len(encryptMagic) + 1 + keyIDSize
+	idLen := int(h[len(encryptMagic)])
+	if idLen > keyIDSize {
+		return nil, "", ErrCorruptObject
+	}
+	id := string(h[len(encryptMagic)+1 : len(encryptMagic)+1+idLen])
+	master, ok := k.keys[id]
+	if !ok {
+		return nil, id, ErrUnknownKey
+	}
+	dataKey, err := master.Open(nil, h[prefix:prefix+nonceSize], h[prefix+nonceSize:], h[:prefix])
+	if err != nil {
+		return nil, id, ErrCorruptObject
+	}
+	return dataKey, id, nil
+}
+
+// Rotator is an interface for storers encrypting images, capable of re-wrapping the data key of stored images with
+// the active master key.
+type Rotator interface {
+	Rotate(id string, variant Variant) (bool, error)
+}
+
+// EncryptStorer is an implementation of the `Storer` interface as pointer, encrypting images at rest on an underlying
+// `Storer` with AES-GCM. Every object is encrypted with its own data key, wrapped by the master key from the
+// configuration. Objects without the header of encrypted objects are corrupt, unless plaintext objects are allowed
+// while migrating a store to encryption: they were stored before encryption was enabled, and are read as plaintext
+// until encrypted with `Rotate`. Presigned requests would expose the encrypted content, so presign is not supported.
+type EncryptStorer struct {
+	base      Storer
+	keys      *KeyRing
+	plaintext bool
+}
+
+// NewEncryptStorer creates a new `EncryptStorer` storing encrypted images on the base `Storer`, reading objects without
+// the header of encrypted objects as plaintext if allowed.
+func NewEncryptStorer(base Storer, keys *KeyRing, plaintext bool) *EncryptStorer {
+	return &EncryptStorer{
+		base:      base,
+		keys:      keys,
+		plaintext: plaintext,
+	}
+}
+
+// Store encrypts and stores a variant of an image.
+func (s *EncryptStorer) Store(id string, variant Variant, content []byte) error {
+	return s.StoreStream(id, variant, bytes.NewReader(content))
+}
+
+// StoreStream encrypts and stores a variant of an image, sealing the content segment by segment while streaming.
+func (s *EncryptStorer) StoreStream(id string, variant Variant, content io.Reader) error {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	h, err := s.keys.header(dataKey)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	return s.base.StoreStream(id, variant, io.MultiReader(bytes.NewReader(h), newSealer(content, aead)))
+}
+
+// Delete deletes an image from the underlying store.
+func (s *EncryptStorer) Delete(id string) error {
+	return s.base.Delete(id)
+}
+
+// DeleteVariants deletes the processed variants of the image with names starting with the prefix.
+func (s *EncryptStorer) DeleteVariants(id string, prefix Variant) error {
+	return s.base.DeleteVariants(id, prefix)
+}
+
+// List calls `fn` with the ID of every image in the underlying store.
+func (s *EncryptStorer) List(fn func(id string) error) error {
+	return s.base.List(fn)
+}
+
+// Load loads and decrypts a variant of the image specified by the id.
+func (s *EncryptStorer) Load(id string, variant Variant) ([]byte, error) {
+	r, err := s.Open(id, variant)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Open opens a variant of the image specified by the id for reading, decrypting the content while streaming.
+// The caller is responsible for closing it.
+func (s *EncryptStorer) Open(id string, variant Variant) (io.ReadCloser, error) {
+	info, err := s.Stat(id, variant)
+	if err != nil {
+		return nil, err
+	}
+	return s.OpenRange(id, variant, 0, info.Size)
+}
+
+// Stat returns size, modification time and entity tag of a variant of the image specified by the id. The size is
+// the size of the decrypted content.
+func (s *EncryptStorer) Stat(id string, variant Variant) (*ObjectInfo, error) {
+	info, err := s.base.Stat(id, variant)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err = s.header(id, variant); errors.Is(err, errPlaintext) {
+		return info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	plain, _, err := plainSize(info.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Size:     plain,
+		Modified: info.Modified,
+		ETag:     info.ETag,
+	}, nil
+}
+
+// OpenRange opens `length` bytes of a variant of the image specified by the id for reading, starting at `offset`.
+// Only the segments covering the range are collected from the underlying store and decrypted.
+// The caller is responsible for closing it.
+func (s *EncryptStorer) OpenRange(id string, variant Variant, offset, length int64) (io.ReadCloser, error) {
+	dataKey, _, err := s.header(id, variant)
+	if errors.Is(err, errPlaintext) {
+		return s.base.OpenRange(id, variant, offset, length)
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.base.Stat(id, variant)
+	if err != nil {
+		return nil, err
+	}
+	plain, segments, err := plainSize(info.Size)
+	if err != nil {
+		return nil, err
+	}
+	if length <= 0 || offset >= plain {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	first := offset / segmentSize
+	last := min((offset+length-1)/segmentSize, segments-1)
+	start := int64(headerSize) + first*sealedSize
+	end := min(int64(headerSize)+(last+1)*sealedSize, info.Size)
+	body, err := s.base.OpenRange(id, variant, start, end-start)
+	if err != nil {
+		return nil, err
+	}
+	o := &opener{
+		src:   body,
+		aead:  aead,
+		index: first,
+		stop:  last,
+		final: segments - 1,
+		last:  plain - (segments-1)*segmentSize,
+		skip:  offset - first*segmentSize,
+		buf:   make([]byte, sealedSize),
+	}
+	return limitedFile{Reader: io.LimitReader(o, length), Closer: body}, nil
+}
+
+// header returns the data key and the ID of the master key of an object. Objects without the header of encrypted
+// objects are corrupt, or `errPlaintext` if plaintext objects are allowed.
+func (s *EncryptStorer) header(id string, variant Variant) ([]byte, string, error) {
+	r, err := s.base.OpenRange(id, variant, 0, int64(headerSize))
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+	h := make([]byte, headerSize)
+	n, err := io.ReadFull(r, h)
+	if n < len(encryptMagic) || string(h[:len(encryptMagic)]) != encryptMagic {
+		if s.plaintext {
+			return nil, "", errPlaintext
+		}
+		return nil, "", ErrCorruptObject
+	}
+	if err != nil {
+		return nil, "", ErrCorruptObject
+	}
+	return s.keys.unwrap(h)
+}
+
+// plainSize returns the size of the decrypted content and the number of segments of an encrypted object.
+func plainSize(size int64) (int64, int64, error) {
+	body := size - int64(headerSize)
+	if body < tagSize {
+		return 0, 0, ErrCorruptObject
+	}
+	segments := (body + sealedSize - 1) / sealedSize
+	last := body - (segments-1)*sealedSize
+	if last < tagSize {
+		return 0, 0, ErrCorruptObject
+	}
+	return body - segments*tagSize, segments, nil
+}
+
+// Rotate re-wraps the data key of a variant of the image with the active master key, returns whether the object
+// was rewritten. The content is not re-encrypted, as the data key does not change. Plaintext objects, stored before
+// encryption was enabled, are encrypted if plaintext objects are allowed.
+func (s *EncryptStorer) Rotate(id string, variant Variant) (bool, error) {
+	dataKey, keyID, err := s.header(id, variant)
+	if errors.Is(err, errPlaintext) {
+		return true, s.encrypt(id, variant)
+	}
+	if err != nil {
+		return false, err
+	}
+	if keyID == s.keys.active {
+		return false, nil
+	}
+	h, err := s.keys.header(dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	// the object is spooled, as it is overwritten while being read otherwise
+	f, err := os.CreateTemp("", "rotate_*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	r, err := s.base.Open(id, variant)
+	if err != nil {
+		return false, err
+	}
+	_, err = io.Copy(f, r)
+	r.Close()
+	if err != nil {
+		return false, err
+	}
+	if _, err = f.Seek(int64(headerSize), io.SeekStart); err != nil {
+		return false, err
+	}
+	if err = s.base.StoreStream(id, variant, io.MultiReader(bytes.NewReader(h), f)); err != nil {
+		return false, err
+	}
+	log.Debug().Str("id", id).Str("variant", string(variant)).Str("from", keyID).Str("to", s.keys.active).Msg("Encryption key rotated")
+	return true, nil
+}
+
+// encrypt encrypts a plaintext variant of the image in place.
+func (s *EncryptStorer) encrypt(id string, variant Variant) error {
+	// the object is spooled, as it is overwritten while being read otherwise
+	f, err := os.CreateTemp("", "encrypt_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	r, err := s.base.Open(id, variant)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err = s.StoreStream(id, variant, f); err != nil {
+		return err
+	}
+	log.Debug().Str("id", id).Str("variant", string(variant)).Str("to", s.keys.active).Msg("Plaintext image encrypted")
+	return nil
+}
+
+// SupportsPresign indicates whether the store supports presign. Encrypted images can not be presigned.
+func (s *EncryptStorer) SupportsPresign() bool {
+	return false
+}
+
+// Presign makes a presigned request that can be used to get a variant of an image.
+func (s *EncryptStorer) Presign(id string, variant Variant) (*PresignedRequest, error) { // nolint:revive
+	panic("Unsupported operation!")
+}
+
+// MoveTo moves the image specified by the id between storage tiers.
+func (s *EncryptStorer) MoveTo(id string, tier Tier) error {
+	return s.base.MoveTo(id, tier)
+}
+
+// Tier returns the storage tier of the image specified by the id.
+func (s *EncryptStorer) Tier(id string) (Tier, error) {
+	return s.base.Tier(id)
+}
+
+// segmentNonce returns the nonce of a segment. Data keys are used for a single object only, so the index of the
+// segment is unique for the key.
+func segmentNonce(index int64) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce[nonceSize-8:], uint64(index))
+	return nonce
+}
+
+// segmentData returns the additional data of a segment, marking the final segment so truncation is detected.
+func segmentData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// sealer is a reader encrypting the content of the source reader segment by segment.
+type sealer struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	index   int64
+	buf     []byte
+	pending int
+	sealed  []byte
+	out     []byte
+	done    bool
+}
+
+func newSealer(src io.Reader, aead cipher.AEAD) *sealer {
+	return &sealer{
+		src:  src,
+		aead: aead,
+		buf:  make([]byte, segmentSize+1),
+	}
+}
+
+func (s *sealer) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.seal(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// seal encrypts the next segment. One byte is read ahead to find out whether the segment is the final one.
+func (s *sealer) seal() error {
+	n, err := io.ReadFull(s.src, s.buf[s.pending:])
+	n += s.pending
+	final := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !final {
+		return err
+	}
+	size := n
+	if !final {
+		size = segmentSize
+	}
+	s.sealed = s.aead.Seal(s.sealed[:0], segmentNonce(s.index), s.buf[:size], segmentData(final))
+	s.out = s.sealed
+	if !final {
+		s.buf[0] = s.buf[segmentSize]
+		s.pending = 1
+	}
+	s.index++
+	s.done = final
+	return nil
+}
+
+// opener is a reader decrypting the segments from `index` to `stop` of the source reader, skipping the first `skip`
+// bytes of the decrypted content.
+type opener struct {
+	src   io.Reader
+	aead  cipher.AEAD
+	index int64
+	stop  int64
+	final int64
+	last  int64
+	skip  int64
+	buf   []byte
+	out   []byte
+}
+
+func (o *opener) Read(p []byte) (int, error) {
+	for len(o.out) == 0 {
+		if o.index > o.stop {
+			return 0, io.EOF
+		}
+		if err := o.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	return n, nil
+}
+
+func (o *opener) open() error {
+	size := int64(sealedSize)
+	if o.index == o.final {
+		size = o.last + tagSize
+	}
+	if _, err := io.ReadFull(o.src, o.buf[:size]); err != nil {
+		return err
+	}
+	plain, err := o.aead.Open(o.buf[:0], segmentNonce(o.index), o.buf[:size], segmentData(o.index == o.final))
+	if err != nil {
+		return ErrCorruptObject
+	}
+	o.out = plain[o.skip:]
+	o.skip = 0
+	o.index++
+	return nil
+}
+
+// PresignUpload makes a presigned request that can be used to upload a variant of an image.
+func (s *EncryptStorer) PresignUpload(id string, variant Variant, size int64) (*PresignedRequest, error) { // nolint:revive
+	panic("Unsupported operation!")
+}
@@ -0,0 +1,164 @@
+package image
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"testing"
+)
+
+var (
+	oldKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	newKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+)
+
+func testEncryptStorer(t *testing.T, base Storer, spec, active string) *EncryptStorer {
+	keys, err := ParseKeyRing(spec, active)
+	if err != nil {
+		t.Fatalf("ParseKeyRing failed: %v", err)
+	}
+	return NewEncryptStorer(base, keys, false)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	base, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	s := testEncryptStorer(t, base, "old:"+oldKey, "")
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 5} {
+		content := make([]byte, size)
+		rand.Read(content)
+		if err = s.Store("id", RawVariant, content); err != nil {
+			t.Fatalf("Store(%v bytes) failed: %v", size, err)
+		}
+		stored, _ := base.Load("id", RawVariant)
+		if size >= 16 && bytes.Contains(stored, content) {
+			t.Errorf("Store(%v bytes) stored plaintext", size)
+		}
+		loaded, err := s.Load("id", RawVariant)
+		if err != nil || !bytes.Equal(loaded, content) {
+			t.Errorf("Load(%v bytes) = %v bytes, %v; want the stored content", size, len(loaded), err)
+		}
+		info, err := s.Stat("id", RawVariant)
+		if err != nil || info.Size != int64(size) {
+			t.Errorf("Stat(%v bytes) = %+v, %v; want size %v", size, info, err, size)
+		}
+		for _, r := range [][2]int64{{0, 1}, {1, 10}, {segmentSize - 2, 4}, {segmentSize, segmentSize}, {int64(size) / 2, int64(size) - int64(size)/2}} {
+			if r[0]+r[1] > int64(size) || r[1] == 0 {
+				continue
+			}
+			rc, err := s.OpenRange("id", RawVariant, r[0], r[1])
+			if err != nil {
+				t.Fatalf("OpenRange(%v, %v) failed: %v", r[0], r[1], err)
+			}
+			part, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil || !bytes.Equal(part, content[r[0]:r[0]+r[1]]) {
+				t.Errorf("OpenRange(%v, %v) of %v bytes = %v bytes, %v; want matching content", r[0], r[1], size, len(part), err)
+			}
+		}
+	}
+}
+
+func TestEncryptTampered(t *testing.T) {
+	base, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	s := testEncryptStorer(t, base, "old:"+oldKey, "")
+	if err = s.Store("id", RawVariant, []byte("secret content")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	stored, _ := base.Load("id", RawVariant)
+	stored[len(stored)-1] ^= 1
+	if err = base.Store("id", RawVariant, stored); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if _, err = s.Load("id", RawVariant); err != ErrCorruptObject {
+		t.Errorf("Load of tampered object = %v; want %v", err, ErrCorruptObject)
+	}
+}
+
+func TestEncryptRotate(t *testing.T) {
+	base, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	content := []byte("rotated content")
+	if err = testEncryptStorer(t, base, "old:"+oldKey, "").Store("id", RawVariant, content); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	s := testEncryptStorer(t, base, "old:"+oldKey+",new:"+newKey, "new")
+	rotated, err := s.Rotate("id", RawVariant)
+	if err != nil || !rotated {
+		t.Fatalf("Rotate = %v, %v; want true", rotated, err)
+	}
+	rotated, err = s.Rotate("id", RawVariant)
+	if err != nil || rotated {
+		t.Errorf("Rotate of rotated object = %v, %v; want false", rotated, err)
+	}
+
+	loaded, err := testEncryptStorer(t, base, "new:"+newKey, "").Load("id", RawVariant)
+	if err != nil || !bytes.Equal(loaded, content) {
+		t.Errorf("Load with new key = %v, %v; want %v", string(loaded), err, string(content))
+	}
+	if _, err = testEncryptStorer(t, base, "old:"+oldKey, "").Load("id", RawVariant); err != ErrUnknownKey {
+		t.Errorf("Load with old key = %v; want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestParseKeyRing(t *testing.T) {
+	for _, invalid := range [][2]string{{"", ""}, {"nokey", ""}, {"id:notbase64!", ""}, {"id:" + base64.StdEncoding.EncodeToString([]byte("short")), ""},
+		{"a:" + oldKey + ",a:" + newKey, ""}, {"a:" + oldKey, "b"}} {
+		if _, err := ParseKeyRing(invalid[0], invalid[1]); err == nil {
+			t.Errorf("ParseKeyRing(%v, %v) should fail", invalid[0], invalid[1])
+		}
+	}
+}
+
+func TestEncryptPlaintext(t *testing.T) {
+	base, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	content := make([]byte, 2*segmentSize+7)
+	rand.Read(content)
+	if err = base.Store("id", RawVariant, content); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	s := testEncryptStorer(t, base, "old:"+oldKey, "")
+	if _, err = s.Load("id", RawVariant); err != ErrCorruptObject {
+		t.Errorf("Load of plaintext without migration = %v; want %v", err, ErrCorruptObject)
+	}
+	if _, err = s.Rotate("id", RawVariant); err != ErrCorruptObject {
+		t.Errorf("Rotate of plaintext without migration = %v; want %v", err, ErrCorruptObject)
+	}
+
+	s.plaintext = true
+	loaded, err := s.Load("id", RawVariant)
+	if err != nil || !bytes.Equal(loaded, content) {
+		t.Errorf("Load of plaintext = %v bytes, %v; want the stored content", len(loaded), err)
+	}
+	info, err := s.Stat("id", RawVariant)
+	if err != nil || info.Size != int64(len(content)) {
+		t.Errorf("Stat of plaintext = %+v, %v; want size %v", info, err, len(content))
+	}
+
+	rotated, err := s.Rotate("id", RawVariant)
+	if err != nil || !rotated {
+		t.Fatalf("Rotate of plaintext = %v, %v; want true", rotated, err)
+	}
+	stored, _ := base.Load("id", RawVariant)
+	if !bytes.HasPrefix(stored, []byte(encryptMagic)) || bytes.Contains(stored, content[:64]) {
+		t.Errorf("Rotate of plaintext did not encrypt the object")
+	}
+	loaded, err = s.Load("id", RawVariant)
+	if err != nil || !bytes.Equal(loaded, content) {
+		t.Errorf("Load of encrypted plaintext = %v bytes, %v; want the stored content", len(loaded), err)
+	}
+}
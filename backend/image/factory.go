@@ -1,42 +1,97 @@
 package image
 
 import (
+	"path/filepath"
 	"strings"
 
 	"github.com/inokone/photostorage/common"
 	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 )
 
 const (
-	localType = "local"
-	s3Type    = "s3"
+	localType  = "local"
+	s3Type     = "s3"
+	sftpType   = "sftp"
+	webdavType = "webdav"
 )
 
-// NewStorer is a factory method of `Storer` based on configuration
-func NewStorer(config *common.ImageStoreConfig) Storer {
-	standard := strings.ToLower(strings.TrimSpace(config.Type))
-	if standard == localType {
-		result, err := NewLocalStorer(config.Path)
+// NewStorer is a factory method of `Storer` based on configuration, the backend is looked up by type in the
+// registry. When replicas are configured, writes are mirrored to the replicas and reads fail over to them. When
+// encryption keys are configured, images are encrypted at rest and presigned requests are disabled. When
+// deduplication is configured, the storer keeps images content-addressed with references persisted in the database.
+func NewStorer(config *common.ImageStoreConfig, db *gorm.DB) Storer {
+	var base Storer
+	if len(config.Replicas) > 0 {
+		replicated, err := NewReplicas(config, db)
 		if err != nil {
-			log.Err(err).Msg("Failed to set up local storer!")
-			panic("Failed to set up local storer!")
+			log.Err(err).Msg("Failed to set up image store replicas!")
+			panic("Failed to set up image store replicas!")
 		}
-		return result
-	}
-	if standard == s3Type {
-		result, err := NewS3Storer(
-			config.RawBucket,
-			config.ThumbBucket,
-			config.AwsKey,
-			config.AwsSecret,
-			config.PresignedTTL,
-		)
+		replicated.Start()
+		base = replicated
+	} else {
+		base = newBaseStorer(config)
+	}
+	if len(config.EncryptionKeys) > 0 {
+		keys, err := ParseKeyRing(config.EncryptionKeys, config.EncryptionKeyID)
+		if err != nil {
+			log.Err(err).Msg("Failed to set up encryption keys!")
+			panic("Failed to set up encryption keys!")
+		}
+		if config.UsePresigned {
+			log.Warn().Msg("Presigned requests are disabled, images are encrypted at rest.")
+		}
+		base = NewEncryptStorer(base, keys, config.EncryptionPlaintext)
+	}
+	if config.Deduplicate {
+		return NewDedupStorer(base, NewGORMRefStorer(db))
+	}
+	return base
+}
+
+func newBaseStorer(config *common.ImageStoreConfig) Storer {
+	factory, ok := lookup(config.Type)
+	if !ok {
+		panic("No store found for type " + config.Type)
+	}
+	result, err := factory(config)
+	if err != nil {
+		log.Err(err).Str("type", config.Type).Msg("Failed to set up image storer!")
+		panic("Failed to set up image storer!")
+	}
+	return result
+}
+
+// NewReplicas creates the replicated image store of the configuration, without processing the replication queue.
+// Replicas are configured in separate env files and named by the path of the file.
+func NewReplicas(config *common.ImageStoreConfig, db *gorm.DB) (*ReplicatedStorer, error) {
+	if len(config.Replicas) == 0 {
+		return nil, ErrNotReplicated
+	}
+	var async bool
+	switch strings.ToLower(strings.TrimSpace(config.Replication)) {
+	case "", syncReplication:
+	case asyncReplication:
+		async = true
+	default:
+		return nil, ErrUnknownReplication
+	}
+	var replicas []Replica
+	for _, file := range strings.Split(config.Replicas, ",") {
+		file = strings.TrimSpace(file)
+		rc, err := common.LoadImageStoreConfig(file)
 		if err != nil {
-			log.Err(err).Msg("Failed to set up S3 storer!")
-			panic("Failed to set up S3 storer!")
+			return nil, err
 		}
-		return result
+		replicas = append(replicas, Replica{Name: file, Storer: newBaseStorer(rc)})
 	}
+	return NewReplicatedStorer(newBaseStorer(config), replicas, NewGORMReplicationQueue(db), async), nil
+}
 
-	panic("No store found for type " + config.Type)
+func coldPath(config *common.ImageStoreConfig) string {
+	if len(config.ColdPath) > 0 {
+		return config.ColdPath
+	}
+	return filepath.Join(config.Path, "cold")
 }
@@ -0,0 +1,248 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// FileSystem is an interface for remote file systems the images can be stored on. Names are slash separated paths,
+// missing files are reported with errors matching `fs.ErrNotExist`.
+type FileSystem interface {
+	// Write creates or truncates the file and copies the content into it. Parent directories must exist.
+	Write(name string, content io.Reader) error
+
+	Open(name string) (io.ReadCloser, error)
+
+	// OpenRange opens `length` bytes of the file for reading, starting at `offset`.
+	OpenRange(name string, offset, length int64) (io.ReadCloser, error)
+
+	Stat(name string) (fs.FileInfo, error)
+
+	ReadDir(name string) ([]fs.FileInfo, error)
+
+	MkdirAll(name string) error
+
+	Remove(name string) error
+
+	RemoveAll(name string) error
+
+	// Rename moves the file, the target must not exist.
+	Rename(from, to string) error
+}
+
+// FSStorer is an implementation of the Storer interface as pointer, storing images on a `FileSystem`.
+// RAW files in frozen tier are moved to a separate "cold" directory.
+type FSStorer struct {
+	fs       FileSystem
+	path     string
+	coldPath string
+}
+
+// NewFSStorer creates a new `FSStorer` on the file system with the specified storage path and cold storage path.
+func NewFSStorer(fsys FileSystem, root string, coldRoot string) (*FSStorer, error) {
+	s := &FSStorer{
+		fs:       fsys,
+		path:     path.Join(root, imageFolder),
+		coldPath: path.Join(coldRoot, imageFolder),
+	}
+	if err := fsys.MkdirAll(s.path); err != nil {
+		return nil, err
+	}
+	if err := fsys.MkdirAll(s.coldPath); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Store stores a variant of an image on the file system.
+func (s *FSStorer) Store(id string, variant Variant, content []byte) error {
+	return s.StoreStream(id, variant, bytes.NewReader(content))
+}
+
+// StoreStream stores a variant of an image on the file system, copying the content from the reader.
+func (s *FSStorer) StoreStream(id string, variant Variant, content io.Reader) error {
+	name := path.Join(s.path, id, string(variant))
+	var err error
+	if err = s.fs.MkdirAll(path.Dir(name)); err != nil {
+		log.Error().Err(err).Str("path", name).Str("id", id).Msg("Failed to create path for image store.")
+		return err
+	}
+	if err = s.fs.Write(name, content); err != nil {
+		log.Error().Err(err).Str("path", name).Str("id", id).Str("variant", string(variant)).Msg("Failed to write image")
+		return err
+	}
+	return nil
+}
+
+// Delete deletes an image on the file system.
+func (s *FSStorer) Delete(id string) error {
+	if err := s.fs.RemoveAll(path.Join(s.coldPath, id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := s.fs.RemoveAll(path.Join(s.path, id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// DeleteVariants deletes the processed variants of an image with names starting with the prefix from the file system.
+func (s *FSStorer) DeleteVariants(id string, prefix Variant) error {
+	return s.walk(path.Join(s.path, id), "", func(variant Variant) error {
+		if variant == RawVariant || !strings.HasPrefix(string(variant), string(prefix)) {
+			return nil
+		}
+		return s.fs.Remove(path.Join(s.path, id, string(variant)))
+	})
+}
+
+// walk calls `fn` with the path relative to the image directory of every file below `dir`.
+func (s *FSStorer) walk(root string, dir string, fn func(variant Variant) error) error {
+	entries, err := s.fs.ReadDir(path.Join(root, dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		rel := path.Join(dir, e.Name())
+		if e.IsDir() {
+			err = s.walk(root, rel, fn)
+		} else {
+			err = fn(Variant(rel))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List calls `fn` with the ID of every image on the file system, in standard or in cold storage.
+func (s *FSStorer) List(fn func(id string) error) error {
+	seen := make(map[string]bool)
+	for _, dir := range []string{s.path, s.coldPath} {
+		entries, err := s.fs.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !e.IsDir() || seen[e.Name()] {
+				continue
+			}
+			seen[e.Name()] = true
+			if err = fn(e.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// file returns the path of a variant of the image. RAW files are either in standard or in cold storage.
+func (s *FSStorer) file(id string, variant Variant) string {
+	if variant == RawVariant {
+		cold := path.Join(s.coldPath, id, rawName)
+		if _, err := s.fs.Stat(cold); err == nil {
+			return cold
+		}
+	}
+	return path.Join(s.path, id, string(variant))
+}
+
+// Load loads a variant of the image specified by the id from the file system.
+func (s *FSStorer) Load(id string, variant Variant) ([]byte, error) {
+	r, err := s.Open(id, variant)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Open opens a variant of the image specified by the id on the file system for reading. The caller is responsible
+// for closing it.
+func (s *FSStorer) Open(id string, variant Variant) (io.ReadCloser, error) {
+	return s.fs.Open(s.file(id, variant))
+}
+
+// Stat returns size, modification time and entity tag of a variant of the image specified by the id on the file
+// system.
+func (s *FSStorer) Stat(id string, variant Variant) (*ObjectInfo, error) {
+	fi, err := s.fs.Stat(s.file(id, variant))
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Size:     fi.Size(),
+		Modified: fi.ModTime(),
+		ETag:     fmt.Sprintf("\"%x-%x\"", fi.ModTime().UnixNano(), fi.Size()),
+	}, nil
+}
+
+// OpenRange opens `length` bytes of a variant of the image specified by the id on the file system for reading,
+// starting at `offset`. The caller is responsible for closing it.
+func (s *FSStorer) OpenRange(id string, variant Variant, offset, length int64) (io.ReadCloser, error) {
+	return s.fs.OpenRange(s.file(id, variant), offset, length)
+}
+
+// MoveTo moves the RAW file of the image specified by the id between the standard and the cold directory.
+func (s *FSStorer) MoveTo(id string, tier Tier) error {
+	var (
+		standard = path.Join(s.path, id, rawName)
+		cold     = path.Join(s.coldPath, id, rawName)
+	)
+	switch tier {
+	case StandardTier:
+		return s.move(cold, standard)
+	case FrozenTier:
+		return s.move(standard, cold)
+	}
+	return ErrUnknownTier
+}
+
+func (s *FSStorer) move(from, to string) error {
+	if _, err := s.fs.Stat(to); err == nil {
+		return nil // already in the target tier
+	}
+	if err := s.fs.MkdirAll(path.Dir(to)); err != nil {
+		return err
+	}
+	return s.fs.Rename(from, to)
+}
+
+// Tier returns the storage tier of the RAW file of the image specified by the id.
+func (s *FSStorer) Tier(id string) (Tier, error) {
+	if _, err := s.fs.Stat(path.Join(s.coldPath, id, rawName)); err == nil {
+		return FrozenTier, nil
+	}
+	if _, err := s.fs.Stat(path.Join(s.path, id, rawName)); err != nil {
+		return 0, err
+	}
+	return StandardTier, nil
+}
+
+// SupportsPresign indicates whether the store supports presign
+func (s *FSStorer) SupportsPresign() bool {
+	return false
+}
+
+// Presign makes a presigned request that can be used to get a variant of an image.
+func (s *FSStorer) Presign(id string, variant Variant) (*PresignedRequest, error) { // nolint:revive
+	panic("Unsupported operation!")
+}
+
+// PresignUpload makes a presigned request that can be used to upload a variant of an image.
+func (s *FSStorer) PresignUpload(id string, variant Variant, size int64) (*PresignedRequest, error) { // nolint:revive
+	panic("Unsupported operation!")
+}
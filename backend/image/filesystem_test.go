@@ -0,0 +1,94 @@
+package image
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// testFSStorer exercises the `Storer` interface of a file system backed storer.
+func testFSStorer(t *testing.T, s Storer) {
+	raw := []byte("raw content of the image")
+	render := RenderPrefix + "100x0-contain-q85.jpg"
+	for v, content := range map[Variant][]byte{RawVariant: raw, ThumbnailVariant: []byte("thumbnail"), render: []byte("render")} {
+		if err := s.Store("id", v, content); err != nil {
+			t.Fatalf("Store(%v) failed: %v", v, err)
+		}
+	}
+	if err := s.StoreStream("other", RawVariant, io.LimitReader(bytes.NewReader(raw), 3)); err != nil {
+		t.Fatalf("StoreStream failed: %v", err)
+	}
+
+	loaded, err := s.Load("id", RawVariant)
+	if err != nil || !bytes.Equal(loaded, raw) {
+		t.Errorf("Load = %v, %v; want %v", string(loaded), err, string(raw))
+	}
+	info, err := s.Stat("id", RawVariant)
+	if err != nil || info.Size != int64(len(raw)) || len(info.ETag) == 0 {
+		t.Errorf("Stat = %+v, %v; want size %v", info, err, len(raw))
+	}
+	r, err := s.OpenRange("id", RawVariant, 4, 7)
+	if err != nil {
+		t.Fatalf("OpenRange failed: %v", err)
+	}
+	part, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(part) != "content" {
+		t.Errorf("OpenRange = %v, %v; want content", string(part), err)
+	}
+	if _, err = s.Stat("missing", RawVariant); err == nil {
+		t.Errorf("Stat of missing image should fail")
+	}
+
+	for _, tier := range []Tier{FrozenTier, FrozenTier, StandardTier} {
+		if err = s.MoveTo("id", tier); err != nil {
+			t.Fatalf("MoveTo(%v) failed: %v", tier, err)
+		}
+		actual, err := s.Tier("id")
+		if err != nil || actual != tier {
+			t.Errorf("Tier after MoveTo(%v) = %v, %v; want %v", tier, actual, err, tier)
+		}
+	}
+	if err = s.MoveTo("other", FrozenTier); err != nil {
+		t.Fatalf("MoveTo failed: %v", err)
+	}
+	r, err = s.Open("other", RawVariant)
+	if err != nil {
+		t.Fatalf("Open of frozen image failed: %v", err)
+	}
+	loaded, err = io.ReadAll(r)
+	r.Close()
+	if err != nil || string(loaded) != "raw" {
+		t.Errorf("Open of frozen image = %v, %v; want raw", string(loaded), err)
+	}
+
+	listed := make(map[string]int)
+	if err = s.List(func(id string) error {
+		listed[id]++
+		return nil
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(listed) != 2 || listed["id"] != 1 || listed["other"] != 1 {
+		t.Errorf("List = %v; want id and other once", listed)
+	}
+
+	if err = s.DeleteVariants("id", RenderPrefix); err != nil {
+		t.Fatalf("DeleteVariants failed: %v", err)
+	}
+	if _, err = s.Stat("id", render); err == nil {
+		t.Errorf("Render should be deleted")
+	}
+	if _, err = s.Stat("id", ThumbnailVariant); err != nil {
+		t.Errorf("Stat of thumbnail after DeleteVariants failed: %v", err)
+	}
+
+	for _, id := range []string{"id", "other", "missing"} {
+		if err = s.Delete(id); err != nil {
+			t.Errorf("Delete(%v) failed: %v", id, err)
+		}
+		if _, err = s.Stat(id, RawVariant); err == nil {
+			t.Errorf("Stat after Delete(%v) should fail", id)
+		}
+	}
+}
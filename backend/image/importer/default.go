@@ -7,6 +7,7 @@ import (
 	"image/jpeg"
 	"image/png"
 	"math"
+	"strings"
 
 	img "github.com/inokone/photostorage/image"
 	"github.com/rs/zerolog/log"
@@ -24,13 +25,36 @@ func NewDefaultImporter() DefaultImporter {
 	return DefaultImporter{}
 }
 
-// Image is a method of `DefaultImporter` for importing an image byte array into an `image.Image`
+// Image is a method of `DefaultImporter` for importing an image byte array into an `image.Image`, turned upright
+// according to the EXIF orientation of the image
 func (i DefaultImporter) Image(raw []byte) (*image.Image, error) {
+	im, err := i.decode(raw)
+	if err != nil {
+		return &im, err
+	}
+	im = img.Orient(im, orientation(raw))
+	return &im, nil
+}
+
+// decode imports an image byte array into an `image.Image` as stored, without applying the orientation.
+func (i DefaultImporter) decode(raw []byte) (image.Image, error) {
 	im, _, err := image.Decode(bytes.NewReader(raw))
-	return &im, err
+	return im, err
 }
 
-// Describe is a method of `DefaultImporter` for importing EXIF metadata from the image
+// orientation returns the EXIF orientation of an image or a TIFF based RAW, `Upright` without EXIF orientation.
+func orientation(raw []byte) img.Orientation {
+	x, err := exif.Decode(bytes.NewReader(raw))
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return img.Upright
+	}
+	if o := img.Orientation(asInt(x, exif.Orientation)); o > img.Upright {
+		return o
+	}
+	return img.Upright
+}
+
+// Describe is a method of `DefaultImporter` for importing EXIF, IPTC and XMP metadata from the image
 func (i DefaultImporter) Describe(raw []byte) (*img.Metadata, error) {
 	var (
 		err error
@@ -51,7 +75,7 @@ func (i DefaultImporter) Describe(raw []byte) (*img.Metadata, error) {
 	js = string(b)
 	log.Debug().Str("data", js).Msg("EXIF")
 
-	return &img.Metadata{
+	md := &img.Metadata{
 		Width:  asInt(m, exif.PixelXDimension),
 		Height: asInt(m, exif.PixelYDimension),
 		Camera: img.Camera{
@@ -68,7 +92,9 @@ func (i DefaultImporter) Describe(raw []byte) (*img.Metadata, error) {
 		ISO:       asInt(m, exif.ISOSpeedRatings),
 		DataSize:  int64(len(raw)),
 		Timestamp: asTime(m),
-	}, nil
+	}
+	describe(raw, m, md)
+	return md, nil
 }
 
 func (i DefaultImporter) noExif(raw []byte) (*img.Metadata, error) {
@@ -76,11 +102,13 @@ func (i DefaultImporter) noExif(raw []byte) (*img.Metadata, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &img.Metadata{
+	md := &img.Metadata{
 		Width:    im.Width,
 		Height:   im.Height,
 		DataSize: int64(len(raw)),
-	}, nil
+	}
+	describe(raw, nil, md)
+	return md, nil
 }
 
 func asApex(m *exif.Exif, f exif.FieldName) float64 {
@@ -132,8 +160,10 @@ func asString(m *exif.Exif, f exif.FieldName) string {
 	if err != nil {
 		return ""
 	}
-	i := t.String()
-	return i
+	if s, err := t.StringVal(); err == nil {
+		return strings.TrimSpace(s)
+	}
+	return t.String()
 }
 
 func asTime(m *exif.Exif) int64 {
@@ -144,32 +174,14 @@ func asTime(m *exif.Exif) int64 {
 	return time.Unix()
 }
 
-// Thumbnail is a method of `DefaultImporter` for generating a thumbnail image byte array for an image bye array.
-func (i DefaultImporter) Thumbnail(raw []byte) (*img.ThumbnailImg, error) {
-	var (
-		im  *image.Image
-		err error
-		f   string
-		tn  image.Image
-		res []byte
-	)
-	im, err = i.Image(raw)
+// Preview is a method of `DefaultImporter` for importing the image byte array as a base of the renditions.
+// Compressed images are previews themselves, so the image is imported as is, turned upright.
+func (i DefaultImporter) Preview(raw []byte) (*image.Image, error) {
+	im, err := i.Image(raw)
 	if err != nil {
-		f, _ = tempFile("forensics", raw)
+		f, _ := tempFile("forensics", raw)
 		log.Warn().Str("path", f).Msg("Image import failed, writing forensics file.")
 		return nil, err
 	}
-	tn, err = img.Thumbnail(*im)
-	if err != nil {
-		return nil, err
-	}
-	res, err = img.ExportJpeg(tn)
-	if err != nil {
-		return nil, err
-	}
-	return &img.ThumbnailImg{
-		Image:  res,
-		Width:  tn.Bounds().Dx(),
-		Height: tn.Bounds().Dy(),
-	}, nil
+	return im, nil
 }
@@ -0,0 +1,58 @@
+package importer
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	img "github.com/inokone/photostorage/image"
+)
+
+// exifOrientation returns an EXIF APP1 payload with the orientation tag only.
+func exifOrientation(o img.Orientation) []byte {
+	return []byte{'E', 'x', 'i', 'f', 0, 0,
+		'I', 'I', 42, 0, 8, 0, 0, 0, // little endian TIFF header, IFD at 8
+		1, 0, // one entry
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(o), 0, 0, 0, // Orientation, SHORT, count 1
+		0, 0, 0, 0} // no next IFD
+}
+
+func TestImageOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 4)), nil); err != nil {
+		t.Fatalf("Encoding image failed: %v", err)
+	}
+	tests := []struct {
+		name        string
+		orientation img.Orientation
+		wantWidth   int
+		wantHeight  int
+	}{
+		{name: "upright", orientation: img.Upright, wantWidth: 8, wantHeight: 4},
+		{name: "upside down", orientation: img.Rotated180, wantWidth: 8, wantHeight: 4},
+		{name: "portrait", orientation: img.Rotated90, wantWidth: 4, wantHeight: 8},
+		{name: "portrait mirrored", orientation: img.Transversed, wantWidth: 4, wantHeight: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := append([]byte{0xff, 0xd8}, jpegSegment(0xe1, exifOrientation(tt.orientation))...)
+			raw = append(raw, buf.Bytes()[2:]...)
+
+			im, err := NewDefaultImporter().Image(raw)
+			if err != nil {
+				t.Fatalf("Image failed: %v", err)
+			}
+			if (*im).Bounds().Dx() != tt.wantWidth || (*im).Bounds().Dy() != tt.wantHeight {
+				t.Errorf("Image size = %vx%v; want %vx%v", (*im).Bounds().Dx(), (*im).Bounds().Dy(), tt.wantWidth, tt.wantHeight)
+			}
+			m, err := NewDefaultImporter().Describe(raw)
+			if err != nil {
+				t.Fatalf("Describe failed: %v", err)
+			}
+			if m.Orientation != tt.orientation {
+				t.Errorf("Orientation = %v; want %v", m.Orientation, tt.orientation)
+			}
+		})
+	}
+}
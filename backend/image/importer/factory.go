@@ -17,3 +17,8 @@ func NewImporter(format string) Importer {
 	}
 	panic("No importer found for format " + format)
 }
+
+// Supports checks whether there is an `Importer` for the file format
+func Supports(format string) bool {
+	return slices.Contains(libraw, format) || slices.Contains(compressed, format)
+}
@@ -0,0 +1,115 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf8"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const (
+	iptcMarker = 0x1c
+	// iptcResource is the ID of the IPTC-NAA record in Photoshop image resources
+	iptcResource = 0x0404
+)
+
+var photoshopHeader = []byte("Photoshop 3.0\x00")
+
+// iptcDatasets are the names of the IPTC application record datasets extracted from images.
+var iptcDatasets = map[byte]string{
+	5:   "ObjectName",
+	15:  "Category",
+	20:  "SupplementalCategories",
+	25:  "Keywords",
+	40:  "SpecialInstructions",
+	55:  "DateCreated",
+	80:  "By-line",
+	85:  "By-lineTitle",
+	90:  "City",
+	92:  "Sub-location",
+	95:  "Province-State",
+	101: "Country-PrimaryLocationName",
+	105: "Headline",
+	110: "Credit",
+	115: "Source",
+	116: "CopyrightNotice",
+	120: "Caption-Abstract",
+	122: "Writer-Editor",
+}
+
+// iptcBlock returns the IPTC-NAA record of the image, either from the TIFF tag of TIFF based formats, or from the
+// Photoshop image resources of JPEG images. Returns nil if the image has no IPTC record.
+func iptcBlock(raw []byte, x *exif.Exif) []byte {
+	if x != nil {
+		if tag, err := x.Get(iptcField); err == nil {
+			return tag.Val
+		}
+	}
+	start := bytes.Index(raw, photoshopHeader)
+	if start < 0 {
+		return nil
+	}
+	return photoshopResource(raw[start+len(photoshopHeader):], iptcResource)
+}
+
+// photoshopResource returns the data of the Photoshop image resource with the ID, nil if there is none.
+func photoshopResource(b []byte, id uint16) []byte {
+	for len(b) >= 12 && bytes.Equal(b[:4], []byte("8BIM")) {
+		rid := binary.BigEndian.Uint16(b[4:6])
+		nameLen := int(b[6]) + 1 // Pascal string padded to even size
+		if nameLen%2 == 1 {
+			nameLen++
+		}
+		pos := 6 + nameLen
+		if len(b) < pos+4 {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(b[pos : pos+4]))
+		pos += 4
+		if size < 0 || len(b) < pos+size {
+			return nil
+		}
+		if rid == id {
+			return b[pos : pos+size]
+		}
+		if size%2 == 1 {
+			size++
+		}
+		if len(b) < pos+size {
+			return nil
+		}
+		b = b[pos+size:]
+	}
+	return nil
+}
+
+// parseIPTC parses the application record datasets of an IPTC-NAA record, the values of repeated datasets, e.g. the
+// keywords, are collected in order. Texts not encoded as UTF-8 are decoded as Latin-1.
+func parseIPTC(b []byte) map[string][]string {
+	res := make(map[string][]string)
+	for len(b) >= 5 && b[0] == iptcMarker {
+		record, dataset := b[1], b[2]
+		size := int(binary.BigEndian.Uint16(b[3:5]))
+		if size&0x8000 != 0 || len(b) < 5+size {
+			break // extended datasets are only used for binary data
+		}
+		if name, ok := iptcDatasets[dataset]; ok && record == 2 {
+			res[name] = append(res[name], iptcText(b[5:5+size]))
+		}
+		b = b[5+size:]
+	}
+	return res
+}
+
+func iptcText(b []byte) string {
+	b = bytes.TrimRight(b, "\x00")
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
@@ -1,6 +1,7 @@
 package importer
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"math"
@@ -9,6 +10,7 @@ import (
 	raw "github.com/inokone/golibraw"
 	pi "github.com/inokone/photostorage/image"
 	"github.com/rs/zerolog/log"
+	"github.com/rwcarlsen/goexif/exif"
 )
 
 // LibrawImporter is an implementation of `Importer` using LibRAW library.
@@ -25,7 +27,8 @@ func NewLibrawImporter() Importer {
 	}
 }
 
-// Image is a method of `LibrawImporter` for importing a RAW image byte array into an `image.Image`
+// Image is a method of `LibrawImporter` for importing a RAW image byte array into an `image.Image`. LibRAW applies
+// the orientation of the camera, the image is upright.
 func (p LibrawImporter) Image(rawBytes []byte) (*image.Image, error) {
 	path, err := tempFile("image", rawBytes)
 	defer removeTempFile(path)
@@ -40,7 +43,8 @@ func (p LibrawImporter) Image(rawBytes []byte) (*image.Image, error) {
 	return &result, nil
 }
 
-// Describe is a method of `LibrawImporter` for importing the description from the RAW image byte array.
+// Describe is a method of `LibrawImporter` for importing the description from the RAW image byte array, completed
+// with the EXIF, IPTC and XMP metadata embedded in the RAW.
 func (p LibrawImporter) Describe(rawBytes []byte) (*pi.Metadata, error) {
 	path, err := tempFile("desc", rawBytes)
 	defer removeTempFile(path)
@@ -58,7 +62,7 @@ func (p LibrawImporter) Describe(rawBytes []byte) (*pi.Metadata, error) {
 	if math.IsNaN(metadata.Shutter) {
 		metadata.Shutter = 0
 	}
-	return &pi.Metadata{
+	md := &pi.Metadata{
 		Height:    metadata.Height,
 		Width:     metadata.Width,
 		Timestamp: metadata.Timestamp,
@@ -69,22 +73,32 @@ func (p LibrawImporter) Describe(rawBytes []byte) (*pi.Metadata, error) {
 			Software: metadata.Camera.Software,
 		},
 		Lens: pi.Lens{
-			Make:  metadata.Lens.Make,
-			Model: metadata.Lens.Model,
+			Make:   metadata.Lens.Make,
+			Model:  metadata.Lens.Model,
+			Serial: metadata.Lens.Serial,
 		},
 		ISO:      metadata.ISO,
 		Aperture: metadata.Aperture,
 		Shutter:  metadata.Shutter,
-	}, nil
+	}
+	// most RAW formats are TIFF based, the EXIF tags libraw does not extract are read from the RAW itself
+	x, err := exif.Decode(bytes.NewReader(rawBytes))
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		x = nil
+	}
+	describe(rawBytes, x, md)
+	return md, nil
 }
 
-// Thumbnail is a methof of `LibrawImporter` for extracting existing thumbnail image from the RAW image byte array.
-// If the RAW image does not contain a thumbnail, this function generates one from the RAW image.
-func (p LibrawImporter) Thumbnail(rawBytes []byte) (*pi.ThumbnailImg, error) {
+// Preview is a method of `LibrawImporter` for extracting the embedded preview image from the RAW image byte array
+// as a base of the renditions. Embedded previews are stored as the sensor was oriented, they are turned upright
+// according to the EXIF orientation of the RAW. If the RAW image does not contain a preview, the RAW image is
+// imported instead.
+func (p LibrawImporter) Preview(rawBytes []byte) (*image.Image, error) {
 	path, err := tempFile("raw", rawBytes)
 	defer removeTempFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("thumbnail extract error [%v]", err)
+		return nil, fmt.Errorf("preview extract error [%v]", err)
 	}
 	exportPath := tempPath("thumb")
 	defer removeTempFile(exportPath)
@@ -92,35 +106,16 @@ func (p LibrawImporter) Thumbnail(rawBytes []byte) (*pi.ThumbnailImg, error) {
 	if err == nil {
 		rs, err := os.ReadFile(exportPath)
 		if err != nil {
-			return nil, fmt.Errorf("thumbnail extract error [%v]", err)
+			return nil, fmt.Errorf("preview extract error [%v]", err)
 		}
-		im, err := p.def.Image(rs)
+		im, err := p.def.decode(rs)
 		if err != nil {
-			return nil, fmt.Errorf("thumbnail extract error [%v]", err)
+			return nil, fmt.Errorf("preview extract error [%v]", err)
 		}
-		return &pi.ThumbnailImg{
-			Image:  rs,
-			Width:  (*im).Bounds().Dx(),
-			Height: (*im).Bounds().Dy(),
-		}, nil
-	}
-	log.Debug().AnErr("Thumbnail extraction", err).Msg("Failed to extract thumbnail")
-	// most likely we have no thumbnail embedded in the RAW image, let's create one
-	img, err := p.Image(rawBytes)
-	if err != nil {
-		return nil, err
-	}
-	*img, err = pi.Thumbnail(*img)
-	if err != nil {
-		return nil, err
-	}
-	res, err := pi.ExportJpeg(*img)
-	if err != nil {
-		return nil, err
+		im = pi.Orient(im, orientation(rawBytes))
+		return &im, nil
 	}
-	return &pi.ThumbnailImg{
-		Image:  res,
-		Width:  (*img).Bounds().Dx(),
-		Height: (*img).Bounds().Dy(),
-	}, nil
+	log.Debug().AnErr("Preview extraction", err).Msg("Failed to extract preview")
+	// most likely we have no preview embedded in the RAW image, let's use the RAW itself
+	return p.Image(rawBytes)
 }
@@ -0,0 +1,170 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+
+	img "github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/image/xmp"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+const (
+	// maxTagSize is the size limit of the EXIF tags dumped, larger tags are binary data
+	maxTagSize = 256
+
+	lensSerialField exif.FieldName = "LensSerialNumber"
+	xmpField        exif.FieldName = "XMLPacket"
+	iptcField       exif.FieldName = "IPTCNAA"
+)
+
+func init() {
+	exif.RegisterParsers(extraFields{})
+}
+
+// extraFields is an EXIF parser loading the tags Goexif does not know of.
+type extraFields struct{}
+
+// Parse is a method of `extraFields` loading the XMP and IPTC tags of the first IFD and the lens serial number of the
+// EXIF sub-IFD. Failures are ignored, the tags are optional.
+func (extraFields) Parse(x *exif.Exif) error {
+	if len(x.Tiff.Dirs) == 0 {
+		return nil
+	}
+	x.LoadTags(x.Tiff.Dirs[0], map[uint16]exif.FieldName{0x02bc: xmpField, 0x83bb: iptcField}, false)
+
+	tag, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err = r.Seek(offset, 0); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(dir, map[uint16]exif.FieldName{0xa435: lensSerialField}, false)
+	return nil
+}
+
+// describe adds the metadata to `m` that is not part of the basic description of the image: exposure details, GPS,
+// authorship, IPTC and XMP keywords and captions, and the dump of all tags. Fields already set are kept. The EXIF
+// data is nil for images without EXIF.
+func describe(raw []byte, x *exif.Exif, m *img.Metadata) {
+	tags := make(img.Tags)
+	if x != nil {
+		describeExif(x, m, tags)
+	}
+	if block := iptcBlock(raw, x); block != nil {
+		describeIPTC(parseIPTC(block), m, tags)
+	}
+	if packet := xmp.Extract(raw); packet != nil {
+		if p, err := xmp.Parse(packet); err == nil {
+			describeXMP(p, m, tags)
+		}
+	}
+	if len(tags) > 0 {
+		m.Tags = tags
+	}
+}
+
+func describeExif(x *exif.Exif, m *img.Metadata, tags img.Tags) {
+	m.FocalLength = asFloat(x, exif.FocalLength)
+	m.FocalLength35 = asInt(x, exif.FocalLengthIn35mmFilm)
+	m.ExposureBias = asFloat(x, exif.ExposureBiasValue)
+	m.Flash = asInt(x, exif.Flash)
+	m.WhiteBalance = asInt(x, exif.WhiteBalance)
+	m.MeteringMode = asInt(x, exif.MeteringMode)
+	m.Orientation = img.Orientation(asInt(x, exif.Orientation))
+	m.Artist = asString(x, exif.Artist)
+	m.Copyright = asString(x, exif.Copyright)
+	if len(m.Lens.Serial) == 0 {
+		m.Lens.Serial = asString(x, lensSerialField)
+	}
+	if lat, long, err := x.LatLong(); err == nil {
+		m.GPS.Latitude, m.GPS.Longitude = &lat, &long
+		if alt, err := x.Get(exif.GPSAltitude); err == nil {
+			if numer, denom, err := alt.Rat2(0); err == nil && denom != 0 {
+				a := float64(numer) / float64(denom)
+				if asInt(x, exif.GPSAltitudeRef) == 1 {
+					a = -a // below sea level
+				}
+				m.GPS.Altitude = &a
+			}
+		}
+	}
+
+	_ = x.Walk(walker(func(name exif.FieldName, tag *tiff.Tag) error {
+		if name == exif.MakerNote || name == xmpField || name == iptcField || len(tag.Val) > maxTagSize {
+			return nil
+		}
+		if s, err := tag.StringVal(); err == nil {
+			tags["EXIF:"+string(name)] = s
+			return nil
+		}
+		var v any
+		if err := json.Unmarshal([]byte(tag.String()), &v); err == nil {
+			tags["EXIF:"+string(name)] = v
+		}
+		return nil
+	}))
+}
+
+func describeIPTC(fields map[string][]string, m *img.Metadata, tags img.Tags) {
+	for name, values := range fields {
+		if len(values) == 1 && name != "Keywords" && name != "SupplementalCategories" {
+			tags["IPTC:"+name] = values[0]
+		} else {
+			tags["IPTC:"+name] = values
+		}
+	}
+	m.Keywords = merge(m.Keywords, fields["Keywords"])
+	m.Title = first(m.Title, fields["ObjectName"])
+	m.Caption = first(m.Caption, fields["Caption-Abstract"])
+	m.Artist = first(m.Artist, fields["By-line"])
+	m.Copyright = first(m.Copyright, fields["CopyrightNotice"])
+}
+
+func describeXMP(p *xmp.Packet, m *img.Metadata, tags img.Tags) {
+	for name, value := range p.Properties {
+		tags["XMP:"+name] = value
+	}
+	m.Keywords = merge(m.Keywords, p.Keywords)
+	m.Title = first(m.Title, []string{p.Title})
+	m.Caption = first(m.Caption, []string{p.Description})
+	m.Artist = first(m.Artist, []string{p.Creator})
+	m.Copyright = first(m.Copyright, []string{p.Rights})
+}
+
+// walker is an adapter to use functions as EXIF walkers.
+type walker func(name exif.FieldName, tag *tiff.Tag) error
+
+func (w walker) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	return w(name, tag)
+}
+
+// first returns the current value if set, otherwise the first value.
+func first(current string, values []string) string {
+	if len(current) > 0 || len(values) == 0 {
+		return current
+	}
+	return values[0]
+}
+
+// merge appends the values missing from the current values.
+func merge(current []string, values []string) []string {
+	for _, v := range values {
+		if len(v) > 0 && !slices.Contains(current, v) {
+			current = append(current, v)
+		}
+	}
+	return current
+}
@@ -0,0 +1,88 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"slices"
+	"testing"
+)
+
+func iptcDataset(dataset byte, value string) []byte {
+	b := []byte{iptcMarker, 2, dataset, 0, 0}
+	binary.BigEndian.PutUint16(b[3:], uint16(len(value)))
+	return append(b, value...)
+}
+
+func photoshopSegment(iptc []byte) []byte {
+	res := append([]byte{}, photoshopHeader...)
+	res = append(res, "8BIM\x04\x0c\x00\x00\x00\x00\x00\x01\x00\x00"...) // thumbnail resource, padded
+	res = append(res, "8BIM\x04\x04\x00\x00"...)
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(iptc)))
+	res = append(res, size...)
+	return append(res, iptc...)
+}
+
+func jpegSegment(marker byte, data []byte) []byte {
+	b := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(data)+2))
+	return append(b, data...)
+}
+
+func TestParseIPTC(t *testing.T) {
+	var block []byte
+	block = append(block, iptcDataset(25, "beach")...)
+	block = append(block, iptcDataset(25, "sea")...)
+	block = append(block, iptcDataset(120, "Caf\xe9")...) // Latin-1
+	block = append(block, iptcDataset(200, "unknown")...)
+
+	fields := parseIPTC(photoshopResource(photoshopSegment(block)[len(photoshopHeader):], iptcResource))
+	if !slices.Equal(fields["Keywords"], []string{"beach", "sea"}) {
+		t.Errorf("Keywords = %v; want [beach sea]", fields["Keywords"])
+	}
+	if !slices.Equal(fields["Caption-Abstract"], []string{"Café"}) {
+		t.Errorf("Caption = %v; want [Café]", fields["Caption-Abstract"])
+	}
+	if len(fields) != 2 {
+		t.Errorf("parseIPTC returned %v datasets; want 2", len(fields))
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 4)), nil); err != nil {
+		t.Fatalf("Encoding image failed: %v", err)
+	}
+	xmp := `http://ns.adobe.com/xap/1.0/` + "\x00" + `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="3">
+   <dc:subject><rdf:Bag><rdf:li>sea</rdf:li><rdf:li>sunset</rdf:li></rdf:Bag></dc:subject>
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">XMP title</rdf:li></rdf:Alt></dc:title>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+	iptc := append(iptcDataset(25, "beach"), iptcDataset(25, "sea")...)
+	iptc = append(iptc, iptcDataset(5, "IPTC title")...)
+	raw := append([]byte{0xff, 0xd8}, jpegSegment(0xe1, []byte(xmp))...)
+	raw = append(raw, jpegSegment(0xed, photoshopSegment(iptc))...)
+	raw = append(raw, buf.Bytes()[2:]...)
+
+	m, err := NewDefaultImporter().Describe(raw)
+	if err != nil {
+		t.Fatalf("Describe failed: %v", err)
+	}
+	if m.Width != 8 || m.Height != 4 {
+		t.Errorf("Size = %vx%v; want 8x4", m.Width, m.Height)
+	}
+	if !slices.Equal(m.Keywords, []string{"beach", "sea", "sunset"}) {
+		t.Errorf("Keywords = %v; want [beach sea sunset]", m.Keywords)
+	}
+	if m.Title != "IPTC title" {
+		t.Errorf("Title = %v; want IPTC title", m.Title)
+	}
+	if m.Tags["XMP:xmp:Rating"] != "3" || m.Tags["IPTC:ObjectName"] != "IPTC title" {
+		t.Errorf("Tags = %v; want the XMP and IPTC tags", m.Tags)
+	}
+}
@@ -1,75 +1,243 @@
 package image
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 )
 
 const (
-	imageFolder   = "photos"
-	rawName       = "raw"
-	thumbnailName = "thumbnail.jpg"
+	imageFolder = "photos"
+	rawName     = string(RawVariant)
 )
 
 // LocalStorer is an implementation of the Storer interface as pointer.
-// that stores images on the local disk.
+// that stores images on the local disk. RAW files in frozen tier are moved to a separate "cold" directory.
 type LocalStorer struct {
-	path string
+	path     string
+	coldPath string
 }
 
-// NewLocalStorer creates a new LocalStorer with the specified storage path.
-func NewLocalStorer(path string) (*LocalStorer, error) {
+// NewLocalStorer creates a new LocalStorer with the specified storage path and cold storage path.
+func NewLocalStorer(path string, coldPath string) (*LocalStorer, error) {
 	ip := filepath.Join(path, imageFolder)
+	cp := filepath.Join(coldPath, imageFolder)
 	fs := LocalStorer{
-		path: ip,
+		path:     ip,
+		coldPath: cp,
 	}
 	if err := os.MkdirAll(ip, os.ModePerm); err != nil {
 		return &LocalStorer{}, err
 	}
+	if err := os.MkdirAll(cp, os.ModePerm); err != nil {
+		return &LocalStorer{}, err
+	}
 	return &fs, nil
 }
 
-// Store stores an image on the local disk.
-func (s *LocalStorer) Store(id string, raw []byte, thumbnail []byte) error {
-	path := filepath.Join(s.path, imageFolder, id)
+// Store stores a variant of an image on the local disk.
+func (s *LocalStorer) Store(id string, variant Variant, content []byte) error {
+	return s.StoreStream(id, variant, bytes.NewReader(content))
+}
+
+// StoreStream stores a variant of an image on the local disk, copying the content from the reader without
+// buffering it.
+func (s *LocalStorer) StoreStream(id string, variant Variant, content io.Reader) error {
+	path := filepath.Join(s.path, imageFolder, id, string(variant))
 	var err error
-	if err = os.MkdirAll(path, os.ModePerm); err != nil {
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
 		log.Error().Err(err).Str("path", path).Str("id", id).Msg("Failed to create path for image store.")
 		return err
 	}
-	if err = write(filepath.Join(path, rawName), raw); err != nil {
-		log.Error().Err(err).Str("path", path).Str("id", id).Msg("Failed to write raw")
-		return err
-	}
-	if err = write(filepath.Join(path, thumbnailName), thumbnail); err != nil {
-		log.Error().Err(err).Str("path", path).Str("id", id).Msg("Failed to write thumbnail")
+	if err = writeStream(path, content); err != nil {
+		log.Error().Err(err).Str("path", path).Str("id", id).Str("variant", string(variant)).Msg("Failed to write image")
 		return err
 	}
 	return nil
 }
 
-func write(path string, content []byte) error {
-	return os.WriteFile(path, content, 0o755)
+func writeStream(path string, content io.Reader) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 // Delete deletes a image on the local disk.
 func (s *LocalStorer) Delete(id string) error {
+	if err := os.RemoveAll(filepath.Join(s.coldPath, imageFolder, id)); err != nil {
+		return err
+	}
 	path := filepath.Join(s.path, imageFolder, id)
 	return os.RemoveAll(path)
 }
 
-// LoadImage loads a image specified by the id from the local disk.
-func (s *LocalStorer) LoadImage(id string) ([]byte, error) {
-	path := filepath.Join(s.path, imageFolder, id, rawName)
-	return os.ReadFile(path)
+// DeleteVariants deletes the processed variants of an image with names starting with the prefix from the local disk.
+func (s *LocalStorer) DeleteVariants(id string, prefix Variant) error {
+	dir := filepath.Join(s.path, imageFolder, id)
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		variant := Variant(filepath.ToSlash(rel))
+		if variant == RawVariant || !strings.HasPrefix(string(variant), string(prefix)) {
+			return nil
+		}
+		return os.Remove(path)
+	})
+}
+
+// List calls `fn` with the ID of every image on the local disk, in standard or in cold storage.
+func (s *LocalStorer) List(fn func(id string) error) error {
+	seen := make(map[string]bool)
+	for _, dir := range []string{filepath.Join(s.path, imageFolder), filepath.Join(s.coldPath, imageFolder)} {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !e.IsDir() || seen[e.Name()] {
+				continue
+			}
+			seen[e.Name()] = true
+			if err = fn(e.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// file returns the path of a variant of the image. RAW files are either in standard or in cold storage.
+func (s *LocalStorer) file(id string, variant Variant) string {
+	if variant == RawVariant {
+		cold := filepath.Join(s.coldPath, imageFolder, id, rawName)
+		if _, err := os.Stat(cold); err == nil {
+			return cold
+		}
+	}
+	return filepath.Join(s.path, imageFolder, id, string(variant))
+}
+
+// Load loads a variant of the image specified by the id from the local disk.
+func (s *LocalStorer) Load(id string, variant Variant) ([]byte, error) {
+	return os.ReadFile(s.file(id, variant))
+}
+
+// Open opens a variant of the image specified by the id on the local disk for reading. The caller is responsible
+// for closing it.
+func (s *LocalStorer) Open(id string, variant Variant) (io.ReadCloser, error) {
+	return os.Open(s.file(id, variant))
+}
+
+// Stat returns size, modification time and entity tag of a variant of the image specified by the id on the local disk.
+func (s *LocalStorer) Stat(id string, variant Variant) (*ObjectInfo, error) {
+	return stat(s.file(id, variant))
 }
 
-// LoadThumbnail loads the thumbnail of the image specified by the id from the local disk.
-func (s *LocalStorer) LoadThumbnail(id string) ([]byte, error) {
-	path := filepath.Join(s.path, imageFolder, id, thumbnailName)
-	return os.ReadFile(path)
+func stat(path string) (*ObjectInfo, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Size:     fi.Size(),
+		Modified: fi.ModTime(),
+		ETag:     fmt.Sprintf("\"%x-%x\"", fi.ModTime().UnixNano(), fi.Size()),
+	}, nil
+}
+
+// OpenRange opens `length` bytes of a variant of the image specified by the id on the local disk for reading,
+// starting at `offset`. The caller is responsible for closing it.
+func (s *LocalStorer) OpenRange(id string, variant Variant, offset, length int64) (io.ReadCloser, error) {
+	return openRange(s.file(id, variant), offset, length)
+}
+
+func openRange(path string, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return limitedFile{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+// MoveTo moves the RAW file of the image specified by the id between the standard and the cold directory.
+func (s *LocalStorer) MoveTo(id string, tier Tier) error {
+	var (
+		standard = filepath.Join(s.path, imageFolder, id, rawName)
+		cold     = filepath.Join(s.coldPath, imageFolder, id, rawName)
+	)
+	switch tier {
+	case StandardTier:
+		return move(cold, standard)
+	case FrozenTier:
+		return move(standard, cold)
+	}
+	return ErrUnknownTier
+}
+
+func move(from, to string) error {
+	if _, err := os.Stat(to); err == nil {
+		return nil // already in the target tier
+	}
+	if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	// cold directory might be on a different device, fall back to copy
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err = writeStream(to, src); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+// Tier returns the storage tier of the RAW file of the image specified by the id.
+func (s *LocalStorer) Tier(id string) (Tier, error) {
+	if _, err := os.Stat(filepath.Join(s.coldPath, imageFolder, id, rawName)); err == nil {
+		return FrozenTier, nil
+	}
+	if _, err := os.Stat(filepath.Join(s.path, imageFolder, id, rawName)); err != nil {
+		return 0, err
+	}
+	return StandardTier, nil
 }
 
 // SupportsPresign indicates whether the store supports presign
@@ -77,12 +245,12 @@ func (s *LocalStorer) SupportsPresign() bool {
 	return false
 }
 
-// PresignThumbnail makes a presigned request that can be used to get a thumbnail.
-func (s *LocalStorer) PresignThumbnail(id string) (*PresignedRequest, error) { // nolint:revive
+// Presign makes a presigned request that can be used to get a variant of an image.
+func (s *LocalStorer) Presign(id string, variant Variant) (*PresignedRequest, error) { // nolint:revive
 	panic("Unsupported operation!")
 }
 
-// PresignImage makes a presigned request that can be used to get a raw image.
-func (s *LocalStorer) PresignImage(id string) (*PresignedRequest, error) { // nolint:revive
+// PresignUpload makes a presigned request that can be used to upload a variant of an image.
+func (s *LocalStorer) PresignUpload(id string, variant Variant, size int64) (*PresignedRequest, error) { // nolint:revive
 	panic("Unsupported operation!")
 }
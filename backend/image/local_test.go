@@ -0,0 +1,88 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLocalMoveTo(t *testing.T) {
+	s, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	raw := []byte("raw content")
+	if err = s.Store("id", RawVariant, raw); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	for _, tier := range []Tier{FrozenTier, FrozenTier, StandardTier, StandardTier} {
+		if err = s.MoveTo("id", tier); err != nil {
+			t.Fatalf("MoveTo(%v) failed: %v", tier, err)
+		}
+		actual, err := s.Tier("id")
+		if err != nil || actual != tier {
+			t.Errorf("Tier after MoveTo(%v) = %v, %v; want %v", tier, actual, err, tier)
+		}
+		loaded, err := s.Load("id", RawVariant)
+		if err != nil || !bytes.Equal(loaded, raw) {
+			t.Errorf("Load after MoveTo(%v) = %v, %v; want %v", tier, string(loaded), err, string(raw))
+		}
+	}
+
+	if err = s.MoveTo("id", Tier(42)); err != ErrUnknownTier {
+		t.Errorf("MoveTo(42) = %v; want %v", err, ErrUnknownTier)
+	}
+}
+
+func TestLocalDeleteVariants(t *testing.T) {
+	s, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	for _, v := range []Variant{RawVariant, ThumbnailVariant, RenderPrefix + "100x0-contain-q85.jpg"} {
+		if err = s.Store("id", v, []byte(v)); err != nil {
+			t.Fatalf("Store(%v) failed: %v", v, err)
+		}
+	}
+
+	if err = s.DeleteVariants("id", RenderPrefix); err != nil {
+		t.Fatalf("DeleteVariants failed: %v", err)
+	}
+	if _, err = s.Stat("id", RenderPrefix+"100x0-contain-q85.jpg"); err == nil {
+		t.Errorf("Render should be deleted")
+	}
+	for _, v := range []Variant{RawVariant, ThumbnailVariant} {
+		if _, err = s.Stat("id", v); err != nil {
+			t.Errorf("Stat(%v) after DeleteVariants failed: %v", v, err)
+		}
+	}
+	if err = s.DeleteVariants("missing", RenderPrefix); err != nil {
+		t.Errorf("DeleteVariants of missing image = %v; want nil", err)
+	}
+}
+
+func TestLocalList(t *testing.T) {
+	s, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err = s.Store(id, RawVariant, []byte(id)); err != nil {
+			t.Fatalf("Store(%v) failed: %v", id, err)
+		}
+	}
+	if err = s.MoveTo("b", FrozenTier); err != nil {
+		t.Fatalf("MoveTo failed: %v", err)
+	}
+
+	listed := make(map[string]int)
+	if err = s.List(func(id string) error {
+		listed[id]++
+		return nil
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(listed) != 3 || listed["a"] != 1 || listed["b"] != 1 || listed["c"] != 1 {
+		t.Errorf("List = %v; want a, b and c once", listed)
+	}
+}
@@ -1,10 +1,14 @@
 package image
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"gorm.io/gorm"
 )
 
@@ -23,6 +27,50 @@ type Lens struct {
 	Serial string
 }
 
+// GPS is a struct representing the location where the image was created. The coordinates are nil if the image is
+// not geotagged.
+type GPS struct {
+	Latitude  *float64 `gorm:"index:idx_metadata_gps"`
+	Longitude *float64 `gorm:"index:idx_metadata_gps"`
+	Altitude  *float64
+}
+
+// Location is a struct representing the place where the image was created, resolved from the GPS coordinates with a
+// gazetteer. Empty if the image is not geotagged or there is no known place nearby.
+type Location struct {
+	CountryCode string `gorm:"type:varchar(2);index"`
+	Country     string
+	Region      string
+	City        string
+}
+
+// Tags is a dump of all metadata tags of the image, keyed by the group and the name of the tag, e.g. `EXIF:Model`,
+// `IPTC:Keywords` or `XMP:dc:subject`. Stored as JSONB to be queried later.
+type Tags map[string]any
+
+// Scan is a function to return `Tags` for a JSONB value
+func (t *Tags) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	}
+	return errors.New("tags must be JSON")
+}
+
+// Value is a function to return the JSONB value for `Tags`
+func (t Tags) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(t)
+	return string(b), err
+}
+
 // Metadata is a struct representing generic metadata on the image.
 type Metadata struct {
 	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
@@ -35,9 +83,27 @@ type Metadata struct {
 	ISO       int
 	Aperture  float64
 	Shutter   float64
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt
+	// FocalLength is in millimeters, FocalLength35 is the 35 mm film equivalent
+	FocalLength   float64
+	FocalLength35 int
+	// ExposureBias is the exposure compensation in EV
+	ExposureBias float64
+	// Flash, WhiteBalance, MeteringMode and Orientation are the values of the EXIF tags with the same names
+	Flash        int
+	WhiteBalance int
+	MeteringMode int
+	Orientation  Orientation
+	GPS          GPS      `gorm:"embedded;embeddedPrefix:gps_"`
+	Location     Location `gorm:"embedded;embeddedPrefix:location_"`
+	Artist       string
+	Copyright    string
+	Title        string
+	Caption      string
+	Keywords     pq.StringArray `gorm:"type:text[]"`
+	Tags         Tags           `gorm:"type:jsonb"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DeletedAt    gorm.DeletedAt
 }
 
 // Response is the JSON representation of `Metadata` when retrieving from the application.
@@ -55,6 +121,29 @@ type Response struct {
 	Colors      uint      `json:"colors"`
 	LensMake    string    `json:"lens_make"`
 	LensModel   string    `json:"lens_model"`
+	LensSerial  string    `json:"lens_serial"`
+	// FocalLength is in millimeters, FocalLength35 is the 35 mm film equivalent
+	FocalLength   float64  `json:"focal_length"`
+	FocalLength35 int      `json:"focal_length_35mm"`
+	ExposureBias  float64  `json:"exposure_bias"`
+	Flash         int      `json:"flash"`
+	FlashFired    bool     `json:"flash_fired"`
+	WhiteBalance  int      `json:"white_balance"`
+	MeteringMode  int      `json:"metering_mode"`
+	Orientation   int      `json:"orientation"`
+	Latitude      *float64 `json:"latitude,omitempty"`
+	Longitude     *float64 `json:"longitude,omitempty"`
+	Altitude      *float64 `json:"altitude,omitempty"`
+	CountryCode   string   `json:"country_code"`
+	Country       string   `json:"country"`
+	Region        string   `json:"region"`
+	City          string   `json:"city"`
+	Artist        string   `json:"artist"`
+	Copyright     string   `json:"copyright"`
+	Title         string   `json:"title"`
+	Caption       string   `json:"caption"`
+	Keywords      []string `json:"keywords"`
+	Tags          Tags     `json:"raw_tags,omitempty"`
 }
 
 // AsResp is a method of the `Metadata` struct. It converts a `Metadata` object into a `Response` object.
@@ -73,6 +162,29 @@ func (m Metadata) AsResp() Response {
 		Colors:      m.Camera.Colors,
 		LensMake:    m.Lens.Make,
 		LensModel:   m.Lens.Model,
+		LensSerial:  m.Lens.Serial,
+
+		FocalLength:   m.FocalLength,
+		FocalLength35: m.FocalLength35,
+		ExposureBias:  m.ExposureBias,
+		Flash:         m.Flash,
+		FlashFired:    m.Flash&1 == 1,
+		WhiteBalance:  m.WhiteBalance,
+		MeteringMode:  m.MeteringMode,
+		Orientation:   int(m.Orientation),
+		Latitude:      m.GPS.Latitude,
+		Longitude:     m.GPS.Longitude,
+		Altitude:      m.GPS.Altitude,
+		CountryCode:   m.Location.CountryCode,
+		Country:       m.Location.Country,
+		Region:        m.Location.Region,
+		City:          m.Location.City,
+		Artist:        m.Artist,
+		Copyright:     m.Copyright,
+		Title:         m.Title,
+		Caption:       m.Caption,
+		Keywords:      m.Keywords,
+		Tags:          m.Tags,
 	}
 }
 
@@ -85,9 +197,18 @@ type PresignedRequest struct {
 	Mode   string      `json:"mode"`
 }
 
-// ThumbnailImg is a struct storing a generated thumbnail image
+// ObjectInfo is a struct describing a stored binary (RAW or processed image). Used for conditional and partial
+// downloads of the binary.
+type ObjectInfo struct {
+	Size     int64
+	Modified time.Time
+	ETag     string
+}
+
+// ThumbnailImg is a struct storing a generated thumbnail image or other rendition of the image
 type ThumbnailImg struct {
-	Image  []byte
-	Width  int
-	Height int
+	Variant Variant
+	Image   []byte
+	Width   int
+	Height  int
 }
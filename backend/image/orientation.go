@@ -0,0 +1,108 @@
+package image
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+)
+
+// Orientation is the value of the EXIF Orientation tag, telling how the stored image is transformed from the upright
+// position of the scene.
+type Orientation int
+
+const (
+	// Upright is the orientation of images stored upright, as are images without orientation
+	Upright Orientation = 1
+	// MirroredHorizontal is the orientation of images stored mirrored horizontally
+	MirroredHorizontal Orientation = 2
+	// Rotated180 is the orientation of images stored upside down
+	Rotated180 Orientation = 3
+	// MirroredVertical is the orientation of images stored mirrored vertically
+	MirroredVertical Orientation = 4
+	// Transposed is the orientation of images stored mirrored over the main diagonal
+	Transposed Orientation = 5
+	// Rotated90 is the orientation of images to be rotated 90 degrees clockwise, e.g. portraits with the grip up
+	Rotated90 Orientation = 6
+	// Transversed is the orientation of images stored mirrored over the anti-diagonal
+	Transversed Orientation = 7
+	// Rotated270 is the orientation of images to be rotated 270 degrees clockwise, e.g. portraits with the grip down
+	Rotated270 Orientation = 8
+)
+
+// ErrInvalidRotation is an error for rotations that are not a multiple of 90 degrees
+var ErrInvalidRotation = errors.New("rotation is not a multiple of 90 degrees")
+
+// NormalizeRotation returns the rotation in degrees clockwise between 0 and 270, fails for rotations that are not a
+// multiple of 90 degrees.
+func NormalizeRotation(degrees int) (int, error) {
+	if degrees%90 != 0 {
+		return 0, ErrInvalidRotation
+	}
+	return (degrees%360 + 360) % 360, nil
+}
+
+// Orient is a function returning the image provided as a parameter transformed into the upright position from the
+// orientation. Images with unknown orientation are returned as is.
+func Orient(original image.Image, orientation Orientation) image.Image {
+	if orientation <= Upright || orientation > Rotated270 {
+		return original
+	}
+	var (
+		src    = rgba(original)
+		w, h   = src.Rect.Dx(), src.Rect.Dy()
+		dw, dh = w, h
+	)
+	if orientation >= Transposed {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case MirroredHorizontal:
+				sx, sy = w-1-x, y
+			case Rotated180:
+				sx, sy = w-1-x, h-1-y
+			case MirroredVertical:
+				sx, sy = x, h-1-y
+			case Transposed:
+				sx, sy = y, x
+			case Rotated90:
+				sx, sy = y, h-1-x
+			case Transversed:
+				sx, sy = w-1-y, h-1-x
+			case Rotated270:
+				sx, sy = w-1-y, x
+			}
+			s, d := sy*src.Stride+sx*4, y*dst.Stride+x*4
+			copy(dst.Pix[d:d+4], src.Pix[s:s+4])
+		}
+	}
+	return dst
+}
+
+// Rotate is a function returning the image provided as a parameter rotated clockwise by the degrees, a multiple of
+// 90. Images are returned as is for other rotations.
+func Rotate(original image.Image, degrees int) image.Image {
+	switch degrees, _ = NormalizeRotation(degrees); degrees {
+	case 90:
+		return Orient(original, Rotated90)
+	case 180:
+		return Orient(original, Rotated180)
+	case 270:
+		return Orient(original, Rotated270)
+	}
+	return original
+}
+
+// rgba returns the image as RGBA with the origin in the top left corner, for direct access to its pixels.
+func rgba(im image.Image) *image.RGBA {
+	if res, ok := im.(*image.RGBA); ok && res.Rect.Min == (image.Point{}) {
+		return res
+	}
+	b := im.Bounds()
+	res := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(res, res.Rect, im, b.Min, draw.Src)
+	return res
+}
@@ -0,0 +1,98 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// marked returns a 3x2 image with a distinct gray level at every pixel: 10 20 30 / 40 50 60.
+func marked() *image.Gray {
+	im := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range im.Pix {
+		im.Pix[i] = uint8(10 * (i + 1))
+	}
+	return im
+}
+
+func levels(im image.Image) [][]uint8 {
+	b := im.Bounds()
+	res := make([][]uint8, b.Dy())
+	for y := range res {
+		for x := 0; x < b.Dx(); x++ {
+			res[y] = append(res[y], color.GrayModel.Convert(im.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y)
+		}
+	}
+	return res
+}
+
+func TestOrient(t *testing.T) {
+	tests := []struct {
+		orientation Orientation
+		want        [][]uint8
+	}{
+		{0, [][]uint8{{10, 20, 30}, {40, 50, 60}}},
+		{Upright, [][]uint8{{10, 20, 30}, {40, 50, 60}}},
+		{MirroredHorizontal, [][]uint8{{30, 20, 10}, {60, 50, 40}}},
+		{Rotated180, [][]uint8{{60, 50, 40}, {30, 20, 10}}},
+		{MirroredVertical, [][]uint8{{40, 50, 60}, {10, 20, 30}}},
+		{Transposed, [][]uint8{{10, 40}, {20, 50}, {30, 60}}},
+		{Rotated90, [][]uint8{{40, 10}, {50, 20}, {60, 30}}},
+		{Transversed, [][]uint8{{60, 30}, {50, 20}, {40, 10}}},
+		{Rotated270, [][]uint8{{30, 60}, {20, 50}, {10, 40}}},
+		{9, [][]uint8{{10, 20, 30}, {40, 50, 60}}},
+	}
+	for _, tt := range tests {
+		actual := levels(Orient(marked(), tt.orientation))
+		if len(actual) != len(tt.want) {
+			t.Errorf("Orient(%v) = %v; want %v", tt.orientation, actual, tt.want)
+			continue
+		}
+		for y := range actual {
+			if string(actual[y]) != string(tt.want[y]) {
+				t.Errorf("Orient(%v) = %v; want %v", tt.orientation, actual, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	tests := []struct {
+		degrees int
+		want    Orientation
+	}{
+		{0, Upright},
+		{90, Rotated90},
+		{-90, Rotated270},
+		{180, Rotated180},
+		{450, Rotated90},
+		{45, Upright},
+	}
+	for _, tt := range tests {
+		actual, want := levels(Rotate(marked(), tt.degrees)), levels(Orient(marked(), tt.want))
+		if len(actual) != len(want) || string(actual[0]) != string(want[0]) {
+			t.Errorf("Rotate(%v) = %v; want %v", tt.degrees, actual, want)
+		}
+	}
+}
+
+func TestNormalizeRotation(t *testing.T) {
+	tests := []struct {
+		in      int
+		want    int
+		wantErr error
+	}{
+		{in: 0, want: 0},
+		{in: 90, want: 90},
+		{in: -90, want: 270},
+		{in: 720, want: 0},
+		{in: -450, want: 270},
+		{in: 45, wantErr: ErrInvalidRotation},
+	}
+	for _, tt := range tests {
+		if actual, err := NormalizeRotation(tt.in); actual != tt.want || err != tt.wantErr {
+			t.Errorf("NormalizeRotation(%v) = %v, %v; want %v, %v", tt.in, actual, err, tt.want, tt.wantErr)
+		}
+	}
+}
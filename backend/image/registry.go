@@ -0,0 +1,50 @@
+package image
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/inokone/photostorage/common"
+)
+
+// Factory is a function creating a `Storer` based on configuration.
+type Factory func(config *common.ImageStoreConfig) (Storer, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+func init() {
+	Register(localType, func(config *common.ImageStoreConfig) (Storer, error) {
+		return NewLocalStorer(config.Path, coldPath(config))
+	})
+	Register(s3Type, func(config *common.ImageStoreConfig) (Storer, error) {
+		return NewS3Storer(config)
+	})
+	Register(sftpType, func(config *common.ImageStoreConfig) (Storer, error) {
+		return NewSFTPStorer(config)
+	})
+	Register(webdavType, func(config *common.ImageStoreConfig) (Storer, error) {
+		return NewWebDAVStorer(config)
+	})
+}
+
+// Register makes an image store backend available by the type name used in `IMG_STORE_TYPE`. Type names are case
+// insensitive. It panics if a backend is already registered with the name.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	name = strings.ToLower(strings.TrimSpace(name))
+	if _, ok := registry[name]; ok {
+		panic("Image store already registered for type " + name)
+	}
+	registry[name] = factory
+}
+
+func lookup(name string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	f, ok := registry[strings.ToLower(strings.TrimSpace(name))]
+	return f, ok
+}
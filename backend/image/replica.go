@@ -0,0 +1,567 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// ReplicationOp is a write operation of the image store to be replayed on a replica.
+type ReplicationOp string
+
+const (
+	// StoreOp copies a variant of an image from the primary to the replica
+	StoreOp ReplicationOp = "store"
+	// DeleteOp deletes an image from the replica
+	DeleteOp ReplicationOp = "delete"
+	// DeleteVariantsOp deletes the variants of an image with names starting with the variant of the task
+	DeleteVariantsOp ReplicationOp = "delete_variants"
+	// MoveOp moves an image on the replica to the tier of the task
+	MoveOp ReplicationOp = "move"
+
+	syncReplication        = "sync"
+	asyncReplication       = "async"
+	replicationBatch       = 100
+	replicationInterval    = 30 * time.Second
+	maxReplicationAttempts = 10
+	maxReplicationBackoff  = time.Hour
+)
+
+var (
+	// ErrNotReplicated is an error for replica operations on an image store without replicas
+	ErrNotReplicated = errors.New("image store has no replicas")
+	// ErrUnknownReplica is an error for replica names not in the configuration
+	ErrUnknownReplica = errors.New("unknown replica")
+	// ErrUnknownReplication is an error for replication modes other than sync and async
+	ErrUnknownReplication = errors.New("unknown replication mode")
+)
+
+// ReplicationTask is a write operation of the image store waiting to be replayed on a replica.
+type ReplicationTask struct {
+	ID          uint          `gorm:"primaryKey"`
+	Replica     string        `gorm:"type:varchar(255);index;not null"`
+	Op          ReplicationOp `gorm:"type:varchar(32);not null"`
+	ImageID     string        `gorm:"type:varchar(255);not null"`
+	Variant     Variant       `gorm:"type:varchar(255)"`
+	Tier        Tier
+	Attempts    int
+	LastError   string
+	NextAttempt time.Time `gorm:"index"`
+	CreatedAt   time.Time
+}
+
+// ReplicationQueue is an interface for persisting the replication tasks of replicas that fell behind.
+type ReplicationQueue interface {
+	// Push adds a new task to the queue.
+	Push(task *ReplicationTask) error
+
+	// Due returns the tasks with the next attempt in the past, in the order they were pushed.
+	Due(limit int) ([]ReplicationTask, error)
+
+	// Done removes the task from the queue.
+	Done(task *ReplicationTask) error
+
+	// Retry persists the attempts and the next attempt of the task.
+	Retry(task *ReplicationTask) error
+
+	// Drop removes the tasks of an image on the replica, e.g. when the image is deleted.
+	Drop(replica string, id string) error
+
+	// Clear removes all tasks of the replica, e.g. when the replica is resynced.
+	Clear(replica string) error
+}
+
+// GORMReplicationQueue is an implementation of `ReplicationQueue` interface based on GORM library.
+type GORMReplicationQueue struct {
+	db *gorm.DB
+}
+
+// NewGORMReplicationQueue creates a new `GORMReplicationQueue` instance based on the GORM library.
+func NewGORMReplicationQueue(db *gorm.DB) *GORMReplicationQueue {
+	return &GORMReplicationQueue{db: db}
+}
+
+// Push is a method of `GORMReplicationQueue` for adding a task to the queue.
+func (q *GORMReplicationQueue) Push(task *ReplicationTask) error {
+	return q.db.Create(task).Error
+}
+
+// Due is a method of `GORMReplicationQueue` for loading the tasks due, in the order they were pushed.
+func (q *GORMReplicationQueue) Due(limit int) ([]ReplicationTask, error) {
+	var tasks []ReplicationTask
+	result := q.db.Where("next_attempt <= ?", time.Now()).Order("id").Limit(limit).Find(&tasks)
+	return tasks, result.Error
+}
+
+// Done is a method of `GORMReplicationQueue` for removing a task from the queue.
+func (q *GORMReplicationQueue) Done(task *ReplicationTask) error {
+	return q.db.Delete(&ReplicationTask{}, task.ID).Error
+}
+
+// Retry is a method of `GORMReplicationQueue` for persisting the attempts and the next attempt of a task.
+func (q *GORMReplicationQueue) Retry(task *ReplicationTask) error {
+	return q.db.Save(task).Error
+}
+
+// Drop is a method of `GORMReplicationQueue` for removing the tasks of an image on a replica.
+func (q *GORMReplicationQueue) Drop(replica string, id string) error {
+	return q.db.Where("replica = ? AND image_id = ?", replica, id).Delete(&ReplicationTask{}).Error
+}
+
+// Clear is a method of `GORMReplicationQueue` for removing all tasks of a replica.
+func (q *GORMReplicationQueue) Clear(replica string) error {
+	return q.db.Where("replica = ?", replica).Delete(&ReplicationTask{}).Error
+}
+
+// Replica is an image store mirroring the primary image store.
+type Replica struct {
+	Name   string
+	Storer Storer
+}
+
+// ResyncReport is a summary of a replica resync.
+type ResyncReport struct {
+	Images  int
+	Copied  int
+	Moved   int
+	Deleted int
+	Failed  int
+}
+
+// ReplicatedStorer is an implementation of the `Storer` interface as pointer, writing images to a primary and
+// mirroring the writes to replicas. In sync mode replicas are written with the primary, in async mode from the
+// replication queue. Failed replica writes are retried from the queue in both modes, a write only fails if the
+// primary fails. Reads fall back to the replicas when the primary fails.
+type ReplicatedStorer struct {
+	primary  Storer
+	replicas []Replica
+	queue    ReplicationQueue
+	async    bool
+	wake     chan struct{}
+}
+
+// NewReplicatedStorer creates a new `ReplicatedStorer` with the primary `Storer`, the replicas and the queue for
+// replication tasks.
+func NewReplicatedStorer(primary Storer, replicas []Replica, queue ReplicationQueue, async bool) *ReplicatedStorer {
+	return &ReplicatedStorer{
+		primary:  primary,
+		replicas: replicas,
+		queue:    queue,
+		async:    async,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Start processes the replication queue in the background, periodically and whenever a task is pushed.
+func (s *ReplicatedStorer) Start() {
+	go func() {
+		ticker := time.NewTicker(replicationInterval)
+		defer ticker.Stop()
+		for {
+			for {
+				done, err := s.Process()
+				if err != nil {
+					log.Err(err).Msg("Failed to process replication queue")
+				}
+				if err != nil || done < replicationBatch {
+					break
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-s.wake:
+			}
+		}
+	}()
+}
+
+// Process replays the due tasks of the replication queue on the replicas, returns the number of tasks processed.
+// Tasks of an image are replayed in order, so the remaining tasks of an image are postponed when one fails.
+func (s *ReplicatedStorer) Process() (int, error) {
+	tasks, err := s.queue.Due(replicationBatch)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		processed int
+		failed    = make(map[string]bool)
+	)
+	for i := range tasks {
+		t := &tasks[i]
+		key := t.Replica + "/" + t.ImageID
+		if failed[key] {
+			continue
+		}
+		r, err := s.replica(t.Replica)
+		if err != nil {
+			log.Warn().Str("replica", t.Replica).Msg("Replication task of unknown replica dropped")
+		} else if err = s.apply(t, r.Storer); err != nil {
+			failed[key] = true
+			s.fail(t, err)
+			processed++
+			continue
+		}
+		if err = s.queue.Done(t); err != nil {
+			return processed, err
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+// Resync makes the replica with the name identical to the primary: copies the processed variants and RAWs missing
+// or different on the replica, moves them to the tier on the primary, and deletes images missing on the primary.
+// Cached renders are not copied. Pending replication tasks of the replica are dropped. The application can keep
+// running during the resync: images modified on the replica after the resync started are not deleted, as they might
+// have been written to the primary after it was listed.
+func (s *ReplicatedStorer) Resync(name string) (*ResyncReport, error) {
+	start := time.Now()
+	r, err := s.replica(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.queue.Clear(name); err != nil {
+		return nil, err
+	}
+
+	variants := []Variant{RawVariant}
+	for _, rd := range Renditions {
+		variants = append(variants, rd.Variant)
+	}
+
+	var (
+		report = &ResyncReport{}
+		known  = make(map[string]bool)
+	)
+	err = s.primary.List(func(id string) error {
+		known[id] = true
+		report.Images++
+		for _, v := range variants {
+			expected, err := s.primary.Stat(id, v)
+			if err != nil {
+				continue // not stored on the primary
+			}
+			if s.identical(r.Storer, id, v, expected) {
+				continue
+			}
+			if err = s.apply(&ReplicationTask{Op: StoreOp, ImageID: id, Variant: v}, r.Storer); err != nil {
+				log.Warn().Err(err).Str("id", id).Str("variant", string(v)).Str("replica", name).Msg("Failed to copy image to replica")
+				report.Failed++
+				continue
+			}
+			report.Copied++
+		}
+		tier, err := s.primary.Tier(id)
+		if err != nil {
+			return nil
+		}
+		if actual, err := r.Storer.Tier(id); err == nil && actual == tier {
+			return nil
+		}
+		if err = r.Storer.MoveTo(id, tier); err != nil {
+			log.Warn().Err(err).Str("id", id).Str("replica", name).Msg("Failed to move image on replica")
+			report.Failed++
+			return nil
+		}
+		report.Moved++
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	err = r.Storer.List(func(id string) error {
+		if known[id] || !lastModified(r.Storer, id, variants).Before(start) {
+			return nil
+		}
+		if err := r.Storer.Delete(id); err != nil {
+			log.Warn().Err(err).Str("id", id).Str("replica", name).Msg("Failed to delete image from replica")
+			report.Failed++
+			return nil
+		}
+		report.Deleted++
+		return nil
+	})
+	return report, err
+}
+
+// identical tells whether the variant of the image on the replica is the same as the one on the primary: of the same
+// size, and of the same ETag or content. ETags of file based stores depend on the modification time, so copies with
+// different ETags are compared by content. If the primary can not be read, e.g. the RAW is frozen, the size decides.
+func (s *ReplicatedStorer) identical(replica Storer, id string, v Variant, expected *ObjectInfo) bool {
+	actual, err := replica.Stat(id, v)
+	if err != nil || actual.Size != expected.Size {
+		return false
+	}
+	if actual.ETag == expected.ETag {
+		return true
+	}
+	want, err := digest(s.primary, id, v)
+	if err != nil {
+		return true
+	}
+	got, err := digest(replica, id, v)
+	return err == nil && got == want
+}
+
+// digest returns the SHA-256 hash of the variant of the image.
+func digest(store Storer, id string, v Variant) (string, error) {
+	r, err := store.Open(id, v)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lastModified returns the last modification of the variants of the image on the store, zero if none of them is stored.
+func lastModified(store Storer, id string, variants []Variant) time.Time {
+	var res time.Time
+	for _, v := range variants {
+		if info, err := store.Stat(id, v); err == nil && info.Modified.After(res) {
+			res = info.Modified
+		}
+	}
+	return res
+}
+
+func (s *ReplicatedStorer) replica(name string) (Replica, error) {
+	for _, r := range s.replicas {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return Replica{}, ErrUnknownReplica
+}
+
+// apply replays the task on the replica. Variants are copied from the primary.
+func (s *ReplicatedStorer) apply(t *ReplicationTask, replica Storer) error {
+	switch t.Op {
+	case StoreOp:
+		r, err := s.primary.Open(t.ImageID, t.Variant)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return replica.StoreStream(t.ImageID, t.Variant, r)
+	case DeleteOp:
+		return replica.Delete(t.ImageID)
+	case DeleteVariantsOp:
+		return replica.DeleteVariants(t.ImageID, t.Variant)
+	case MoveOp:
+		return replica.MoveTo(t.ImageID, t.Tier)
+	}
+	return errors.New("unknown replication operation " + string(t.Op))
+}
+
+// fail records the failed attempt of the task, it is retried with exponential backoff until it is given up.
+func (s *ReplicatedStorer) fail(t *ReplicationTask, err error) {
+	t.Attempts++
+	t.LastError = err.Error()
+	if t.Attempts >= maxReplicationAttempts {
+		log.Error().Err(err).Str("id", t.ImageID).Str("replica", t.Replica).Str("op", string(t.Op)).Msg("Replication given up, replica needs resync")
+		if t.ID != 0 {
+			err = s.queue.Done(t)
+		}
+	} else {
+		log.Warn().Err(err).Str("id", t.ImageID).Str("replica", t.Replica).Str("op", string(t.Op)).Msg("Replication failed, retrying later")
+		backoff := time.Minute << t.Attempts
+		if backoff > maxReplicationBackoff {
+			backoff = maxReplicationBackoff
+		}
+		t.NextAttempt = time.Now().Add(backoff)
+		if t.ID == 0 {
+			err = s.queue.Push(t)
+		} else {
+			err = s.queue.Retry(t)
+		}
+	}
+	if err != nil {
+		log.Err(err).Str("id", t.ImageID).Str("replica", t.Replica).Msg("Failed to persist replication task")
+	}
+}
+
+// replicate replays the operation on all replicas, or pushes it to the queue in async mode. Write of a variant is
+// replayed by `write` in sync mode, when provided, or by copying the variant from the primary.
+func (s *ReplicatedStorer) replicate(op ReplicationTask, write func(replica Storer) error) {
+	for _, r := range s.replicas {
+		t := op
+		t.Replica = r.Name
+		if t.Op == DeleteOp {
+			if err := s.queue.Drop(r.Name, t.ImageID); err != nil {
+				log.Err(err).Str("id", t.ImageID).Str("replica", r.Name).Msg("Failed to drop replication tasks")
+			}
+		}
+		if s.async {
+			t.NextAttempt = time.Now()
+			if err := s.queue.Push(&t); err != nil {
+				log.Err(err).Str("id", t.ImageID).Str("replica", r.Name).Msg("Failed to persist replication task")
+			}
+			continue
+		}
+		var err error
+		if write != nil {
+			err = write(r.Storer)
+		} else {
+			err = s.apply(&t, r.Storer)
+		}
+		if err != nil {
+			s.fail(&t, err)
+		}
+	}
+	if s.async {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Store stores a variant of an image on the primary and the replicas.
+func (s *ReplicatedStorer) Store(id string, variant Variant, content []byte) error {
+	if err := s.primary.Store(id, variant, content); err != nil {
+		return err
+	}
+	s.replicate(ReplicationTask{Op: StoreOp, ImageID: id, Variant: variant}, func(replica Storer) error {
+		return replica.StoreStream(id, variant, bytes.NewReader(content))
+	})
+	return nil
+}
+
+// StoreStream stores a variant of an image on the primary from the reader, the replicas are written from the
+// primary.
+func (s *ReplicatedStorer) StoreStream(id string, variant Variant, content io.Reader) error {
+	if err := s.primary.StoreStream(id, variant, content); err != nil {
+		return err
+	}
+	s.replicate(ReplicationTask{Op: StoreOp, ImageID: id, Variant: variant}, nil)
+	return nil
+}
+
+// Delete deletes an image from the primary and the replicas.
+func (s *ReplicatedStorer) Delete(id string) error {
+	if err := s.primary.Delete(id); err != nil {
+		return err
+	}
+	s.replicate(ReplicationTask{Op: DeleteOp, ImageID: id}, nil)
+	return nil
+}
+
+// DeleteVariants deletes the processed variants of an image with names starting with the prefix from the primary
+// and the replicas.
+func (s *ReplicatedStorer) DeleteVariants(id string, prefix Variant) error {
+	if err := s.primary.DeleteVariants(id, prefix); err != nil {
+		return err
+	}
+	s.replicate(ReplicationTask{Op: DeleteVariantsOp, ImageID: id, Variant: prefix}, nil)
+	return nil
+}
+
+// MoveTo moves an image between storage tiers on the primary and the replicas.
+func (s *ReplicatedStorer) MoveTo(id string, tier Tier) error {
+	if err := s.primary.MoveTo(id, tier); err != nil {
+		return err
+	}
+	s.replicate(ReplicationTask{Op: MoveOp, ImageID: id, Tier: tier}, nil)
+	return nil
+}
+
+// Tier returns the storage tier of the image on the primary.
+func (s *ReplicatedStorer) Tier(id string) (Tier, error) {
+	return s.primary.Tier(id)
+}
+
+// List calls `fn` with the ID of every image on the primary.
+func (s *ReplicatedStorer) List(fn func(id string) error) error {
+	return s.primary.List(fn)
+}
+
+// failover calls `read` with the primary, and with the replicas in order while it fails. The error of the primary
+// is returned if all fail.
+func (s *ReplicatedStorer) failover(id string, variant Variant, read func(st Storer) error) error {
+	err := read(s.primary)
+	if err == nil {
+		return nil
+	}
+	for _, r := range s.replicas {
+		if read(r.Storer) == nil {
+			log.Warn().Err(err).Str("id", id).Str("variant", string(variant)).Str("replica", r.Name).Msg("Read from primary failed, served from replica")
+			return nil
+		}
+	}
+	return err
+}
+
+// Load loads a variant of the image specified by the id, from a replica if the primary fails.
+func (s *ReplicatedStorer) Load(id string, variant Variant) ([]byte, error) {
+	var content []byte
+	err := s.failover(id, variant, func(st Storer) (err error) {
+		content, err = st.Load(id, variant)
+		return err
+	})
+	return content, err
+}
+
+// Open opens a variant of the image specified by the id for reading, from a replica if the primary fails.
+func (s *ReplicatedStorer) Open(id string, variant Variant) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	err := s.failover(id, variant, func(st Storer) (err error) {
+		r, err = st.Open(id, variant)
+		return err
+	})
+	return r, err
+}
+
+// Stat returns size, modification time and entity tag of a variant of the image specified by the id, from a replica
+// if the primary fails.
+func (s *ReplicatedStorer) Stat(id string, variant Variant) (*ObjectInfo, error) {
+	var info *ObjectInfo
+	err := s.failover(id, variant, func(st Storer) (err error) {
+		info, err = st.Stat(id, variant)
+		return err
+	})
+	return info, err
+}
+
+// OpenRange opens a byte range of a variant of the image specified by the id for reading, from a replica if the
+// primary fails.
+func (s *ReplicatedStorer) OpenRange(id string, variant Variant, offset, length int64) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	err := s.failover(id, variant, func(st Storer) (err error) {
+		r, err = st.OpenRange(id, variant, offset, length)
+		return err
+	})
+	return r, err
+}
+
+// SupportsPresign indicates whether the primary store supports presign
+func (s *ReplicatedStorer) SupportsPresign() bool {
+	return s.primary.SupportsPresign()
+}
+
+// Presign makes a presigned request on the primary store that can be used to get a variant of an image.
+func (s *ReplicatedStorer) Presign(id string, variant Variant) (*PresignedRequest, error) {
+	return s.primary.Presign(id, variant)
+}
+
+// PresignUpload makes a presigned request on the primary store that can be used to upload a variant of an image.
+// The upload has to be committed to replicate it.
+func (s *ReplicatedStorer) PresignUpload(id string, variant Variant, size int64) (*PresignedRequest, error) {
+	return s.primary.PresignUpload(id, variant, size)
+}
+
+// Commit replicates a variant of the image uploaded to the primary with a presigned request.
+func (s *ReplicatedStorer) Commit(id string, variant Variant) error {
+	s.replicate(ReplicationTask{Op: StoreOp, ImageID: id, Variant: variant}, nil)
+	return nil
+}
@@ -0,0 +1,240 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type memQueue struct {
+	tasks []ReplicationTask
+	next  uint
+}
+
+func (q *memQueue) Push(task *ReplicationTask) error {
+	q.next++
+	task.ID = q.next
+	q.tasks = append(q.tasks, *task)
+	return nil
+}
+
+func (q *memQueue) Due(limit int) ([]ReplicationTask, error) {
+	var due []ReplicationTask
+	for _, t := range q.tasks {
+		if !t.NextAttempt.After(time.Now()) && len(due) < limit {
+			due = append(due, t)
+		}
+	}
+	return due, nil
+}
+
+func (q *memQueue) Done(task *ReplicationTask) error {
+	return q.remove(func(t ReplicationTask) bool { return t.ID == task.ID })
+}
+
+func (q *memQueue) Retry(task *ReplicationTask) error {
+	for i := range q.tasks {
+		if q.tasks[i].ID == task.ID {
+			q.tasks[i] = *task
+		}
+	}
+	return nil
+}
+
+func (q *memQueue) Drop(replica string, id string) error {
+	return q.remove(func(t ReplicationTask) bool { return t.Replica == replica && t.ImageID == id })
+}
+
+func (q *memQueue) Clear(replica string) error {
+	return q.remove(func(t ReplicationTask) bool { return t.Replica == replica })
+}
+
+func (q *memQueue) remove(match func(t ReplicationTask) bool) error {
+	var kept []ReplicationTask
+	for _, t := range q.tasks {
+		if !match(t) {
+			kept = append(kept, t)
+		}
+	}
+	q.tasks = kept
+	return nil
+}
+
+func newTestReplicatedStorer(t *testing.T, async bool) (*ReplicatedStorer, *LocalStorer, *LocalStorer, *memQueue) {
+	primary, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	replica, err := NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	queue := &memQueue{}
+	return NewReplicatedStorer(primary, []Replica{{Name: "replica", Storer: replica}}, queue, async), primary, replica, queue
+}
+
+func TestReplicatedStorer(t *testing.T) {
+	tests := []struct {
+		name  string
+		async bool
+	}{
+		{name: "sync", async: false},
+		{name: "async", async: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, primary, replica, queue := newTestReplicatedStorer(t, tt.async)
+			raw := []byte("raw content")
+			if err := s.Store("id", RawVariant, raw); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			if err := s.StoreStream("id", ThumbnailVariant, bytes.NewReader([]byte("thumbnail"))); err != nil {
+				t.Fatalf("StoreStream failed: %v", err)
+			}
+			if tt.async {
+				if len(queue.tasks) != 2 {
+					t.Fatalf("Queue = %v; want 2 tasks", queue.tasks)
+				}
+				if n, err := s.Process(); err != nil || n != 2 {
+					t.Fatalf("Process = %v, %v; want 2", n, err)
+				}
+			}
+			if len(queue.tasks) != 0 {
+				t.Errorf("Queue = %v; want empty", queue.tasks)
+			}
+			for _, v := range []Variant{RawVariant, ThumbnailVariant} {
+				if _, err := replica.Stat("id", v); err != nil {
+					t.Errorf("Stat(%v) on replica failed: %v", v, err)
+				}
+			}
+
+			if err := primary.Delete("id"); err != nil {
+				t.Fatalf("Delete on primary failed: %v", err)
+			}
+			loaded, err := s.Load("id", RawVariant)
+			if err != nil || !bytes.Equal(loaded, raw) {
+				t.Errorf("Load with failed primary = %v, %v; want %v", string(loaded), err, string(raw))
+			}
+		})
+	}
+}
+
+func TestReplicatedStorerRetry(t *testing.T) {
+	s, _, replica, queue := newTestReplicatedStorer(t, false)
+	if err := s.MoveTo("missing", FrozenTier); err == nil {
+		t.Fatalf("MoveTo of missing image should fail")
+	}
+	if err := s.Store("id", RawVariant, []byte("raw")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if err := replica.Delete("id"); err != nil {
+		t.Fatalf("Delete on replica failed: %v", err)
+	}
+
+	s.fail(&ReplicationTask{Replica: "replica", Op: StoreOp, ImageID: "id", Variant: RawVariant}, ErrUnknownTier)
+	if len(queue.tasks) != 1 || queue.tasks[0].Attempts != 1 || !queue.tasks[0].NextAttempt.After(time.Now()) {
+		t.Fatalf("Queue = %v; want 1 postponed task", queue.tasks)
+	}
+	if n, _ := s.Process(); n != 0 {
+		t.Errorf("Process = %v; want 0 before next attempt", n)
+	}
+	queue.tasks[0].NextAttempt = time.Now()
+	if n, err := s.Process(); err != nil || n != 1 {
+		t.Errorf("Process = %v, %v; want 1", n, err)
+	}
+	if _, err := replica.Stat("id", RawVariant); err != nil {
+		t.Errorf("Stat on replica after retry failed: %v", err)
+	}
+
+	if err := s.Delete("id"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := replica.Stat("id", RawVariant); err == nil {
+		t.Errorf("Stat on replica after Delete should fail")
+	}
+}
+
+func TestReplicatedStorerResync(t *testing.T) {
+	s, primary, replica, _ := newTestReplicatedStorer(t, false)
+	for _, id := range []string{"a", "b"} {
+		if err := primary.Store(id, RawVariant, []byte(id)); err != nil {
+			t.Fatalf("Store(%v) failed: %v", id, err)
+		}
+	}
+	if err := primary.Store("a", ThumbnailVariant, []byte("thumbnail")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if err := primary.MoveTo("b", FrozenTier); err != nil {
+		t.Fatalf("MoveTo failed: %v", err)
+	}
+	if err := replica.Store("orphan", RawVariant, []byte("orphan")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	report, err := s.Resync("replica")
+	if err != nil {
+		t.Fatalf("Resync failed: %v", err)
+	}
+	want := ResyncReport{Images: 2, Copied: 3, Moved: 1, Deleted: 1}
+	if *report != want {
+		t.Errorf("Resync = %+v; want %+v", *report, want)
+	}
+	if tier, err := replica.Tier("b"); err != nil || tier != FrozenTier {
+		t.Errorf("Tier on replica = %v, %v; want %v", tier, err, FrozenTier)
+	}
+	if _, err = s.Resync("unknown"); err != ErrUnknownReplica {
+		t.Errorf("Resync of unknown replica = %v; want %v", err, ErrUnknownReplica)
+	}
+}
+
+// listHook is a `Storer` calling the hook after listing the images, e.g. to write images during a resync.
+type listHook struct {
+	*LocalStorer
+	hook func()
+}
+
+func (s listHook) List(fn func(id string) error) error {
+	if err := s.LocalStorer.List(fn); err != nil {
+		return err
+	}
+	s.hook()
+	return nil
+}
+
+func TestReplicatedStorerResyncChanges(t *testing.T) {
+	s, primary, replica, _ := newTestReplicatedStorer(t, false)
+	for id, content := range map[string]string{"same": "same", "changed": "primary"} {
+		if err := primary.Store(id, RawVariant, []byte(content)); err != nil {
+			t.Fatalf("Store(%v) failed: %v", id, err)
+		}
+	}
+	// copies of the same content differ in ETag on local stores, copies of the same size differ in content
+	for id, content := range map[string]string{"same": "same", "changed": "replica", "orphan": "orphan"} {
+		if err := replica.Store(id, RawVariant, []byte(content)); err != nil {
+			t.Fatalf("Store(%v) failed: %v", id, err)
+		}
+	}
+	// an image uploaded while the resync is running is written to the primary after it was listed
+	s.primary = listHook{LocalStorer: primary, hook: func() {
+		if err := s.Store("late", RawVariant, []byte("late")); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}}
+
+	report, err := s.Resync("replica")
+	if err != nil {
+		t.Fatalf("Resync failed: %v", err)
+	}
+	want := ResyncReport{Images: 2, Copied: 1, Deleted: 1}
+	if *report != want {
+		t.Errorf("Resync = %+v; want %+v", *report, want)
+	}
+	for id, want := range map[string]string{"same": "same", "changed": "primary", "late": "late"} {
+		if content, err := replica.Load(id, RawVariant); err != nil || string(content) != want {
+			t.Errorf("Load(%v) on replica = %q, %v; want %q", id, content, err, want)
+		}
+	}
+	if _, err = replica.Stat("orphan", RawVariant); err == nil {
+		t.Errorf("Resync kept an image missing on the primary")
+	}
+}
@@ -3,137 +3,284 @@ package image
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/inokone/photostorage/common"
 	"github.com/rs/zerolog/log"
 )
 
 const (
-	prefix    = "photos"
-	rawFile   = "raw"
-	thumbFile = "thumbnail.jpg"
-	rawCT     = "application/octet-stream"
-	thumbCT   = "image/jpeg"
+	prefix = "photos"
+
+	frozenClass   = types.StorageClassGlacier
+	standardClass = types.StorageClassStandardIa
+	restoreDays   = 7
+	restoring     = `ongoing-request="true"`
 )
 
-// S3Storer is an implementation of the Storer interface as pointer that stores images on Amazon S3 buckets.
+// ErrUnknownEncryption is an error for server-side encryption modes not supported by Amazon S3
+var ErrUnknownEncryption = errors.New("unknown server-side encryption")
+
+// S3Storer is an implementation of the Storer interface as pointer that stores images on Amazon S3 buckets, or on
+// any S3 compatible storage (e.g. MinIO, Ceph) with a custom endpoint.
 type S3Storer struct {
 	rawBucket    string
 	thumbBucket  string
 	client       *s3.Client
+	uploader     *manager.Uploader
 	presign      *s3.PresignClient
 	presignedTTL int64
+	sse          types.ServerSideEncryption
+	kmsKeyID     string
 }
 
-// NewS3Storer creates a new LocalStorer with the specified storage path.
-func NewS3Storer(rawBucket, thumbBucket, awsAPIKey, awsAPISecret string, presignedTTL int64) (*S3Storer, error) {
+// NewS3Storer creates a new S3Storer based on the image store configuration. When no AWS key is configured, the
+// default AWS credential chain (environment, shared configuration, instance role) is used.
+func NewS3Storer(conf *common.ImageStoreConfig) (*S3Storer, error) {
 	var (
-		c   aws.Config
-		err error
-		cl  *s3.Client
+		c    aws.Config
+		err  error
+		cl   *s3.Client
+		sse  = types.ServerSideEncryption(conf.SSE)
+		opts = []func(*config.LoadOptions) error{config.WithRegion(conf.AwsRegion)}
 	)
 
-	c, err = config.LoadDefaultConfig(context.TODO(),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			awsAPIKey,
-			awsAPISecret, "")),
-		config.WithRegion("eu-central-1"),
-	)
+	if len(sse) > 0 && sse != types.ServerSideEncryptionAes256 && sse != types.ServerSideEncryptionAwsKms {
+		return nil, ErrUnknownEncryption
+	}
+	if len(conf.AwsKey) > 0 {
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			conf.AwsKey,
+			conf.AwsSecret, "")))
+	}
+	c, err = config.LoadDefaultConfig(context.TODO(), opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	cl = s3.NewFromConfig(c)
+	cl = s3.NewFromConfig(c, func(o *s3.Options) {
+		if len(conf.Endpoint) > 0 {
+			o.BaseEndpoint = aws.String(conf.Endpoint)
+		}
+		o.UsePathStyle = conf.PathStyle
+	})
 
 	return &S3Storer{
-		rawBucket:    rawBucket,
-		thumbBucket:  thumbBucket,
+		rawBucket:    conf.RawBucket,
+		thumbBucket:  conf.ThumbBucket,
 		client:       cl,
+		uploader:     manager.NewUploader(cl),
 		presign:      s3.NewPresignClient(cl),
-		presignedTTL: presignedTTL,
+		presignedTTL: conf.PresignedTTL,
+		sse:          sse,
+		kmsKeyID:     conf.KMSKeyID,
 	}, nil
 }
 
-// Store stores an image on the local disk.
-func (s *S3Storer) Store(id string, raw []byte, thumbnail []byte) error {
-	path := filepath.Join(prefix, id)
-	var err error
-	if err = s.writeS3(s.rawBucket, filepath.Join(path, rawName), rawCT, raw); err != nil {
-		log.Error().Err(err).Str("path", path).Str("id", id).Msg("Failed to write raw")
-		return err
+// kmsKey returns the KMS key for SSE-KMS encryption, nil if the bucket key or no KMS encryption is used.
+func (s *S3Storer) kmsKey() *string {
+	if s.sse != types.ServerSideEncryptionAwsKms || len(s.kmsKeyID) == 0 {
+		return nil
 	}
-	if err = s.writeS3(s.thumbBucket, filepath.Join(path, thumbnailName), thumbCT, thumbnail); err != nil {
-		log.Error().Err(err).Str("path", path).Str("id", id).Msg("Failed to write thumbnail")
+	return aws.String(s.kmsKeyID)
+}
+
+// Store stores a variant of an image on Amazon S3.
+func (s *S3Storer) Store(id string, variant Variant, content []byte) error {
+	return s.StoreStream(id, variant, bytes.NewReader(content))
+}
+
+// StoreStream stores a variant of an image on Amazon S3. The content is streamed from the reader with a multipart
+// upload, so it is never buffered whole in memory.
+func (s *S3Storer) StoreStream(id string, variant Variant, content io.Reader) error {
+	bucket, key := s.location(id, variant)
+	if err := s.writeS3(bucket, key, variant.ContentType(), content); err != nil {
+		log.Error().Err(err).Str("key", key).Str("id", id).Str("variant", string(variant)).Msg("Failed to write image")
 		return err
 	}
 	return nil
 }
 
-func (s *S3Storer) writeS3(bucket string, path string, contentType string, content []byte) error {
-	_, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:      aws.String(bucket),
-		Key:         aws.String(path),
-		Body:        bytes.NewReader(content),
-		ContentType: aws.String(contentType),
+// location returns the bucket and the key of a variant of the image. RAW files are stored in the RAW bucket,
+// processed images in the thumbnail bucket.
+func (s *S3Storer) location(id string, variant Variant) (string, string) {
+	key := filepath.Join(prefix, id, string(variant))
+	if variant == RawVariant {
+		return s.rawBucket, key
+	}
+	return s.thumbBucket, key
+}
+
+func (s *S3Storer) writeS3(bucket string, path string, contentType string, content io.Reader) error {
+	_, err := s.uploader.Upload(context.TODO(), &s3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(path),
+		Body:                 content,
+		ContentType:          aws.String(contentType),
+		ServerSideEncryption: s.sse,
+		SSEKMSKeyId:          s.kmsKey(),
 	})
 	return err
 }
 
-// Delete deletes an image from Amazon S3.
+// Delete deletes an image with all of its variants from Amazon S3.
 func (s *S3Storer) Delete(id string) error {
-	var (
-		path, rawFile, thumbFile string
-		err                      error
-	)
-
-	path = filepath.Join(prefix, imageFolder, id)
-	rawFile = filepath.Join(path, rawName)
-	thumbFile = filepath.Join(path, thumbnailName)
-
-	_, err = s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(s.rawBucket),
-		Key:    aws.String(rawFile),
+	bucket, key := s.location(id, RawVariant)
+	_, err := s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
 	})
 	if err != nil {
 		return err
 	}
 
-	_, err = s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+	return s.deletePrefix(filepath.Join(prefix, id) + "/")
+}
+
+// DeleteVariants deletes the processed variants of an image with names starting with the prefix from Amazon S3.
+func (s *S3Storer) DeleteVariants(id string, variantPrefix Variant) error {
+	return s.deletePrefix(filepath.Join(prefix, id) + "/" + string(variantPrefix))
+}
+
+func (s *S3Storer) deletePrefix(keyPrefix string) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.thumbBucket),
-		Key:    aws.String(thumbFile),
+		Prefix: aws.String(keyPrefix),
 	})
-	return err
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+		for _, object := range page.Contents {
+			_, err = s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+				Bucket: aws.String(s.thumbBucket),
+				Key:    object.Key,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-// LoadImage loads a image specified by the id from from Amazon S3.
-func (s *S3Storer) LoadImage(id string) ([]byte, error) {
-	log.Debug().Str("id", id).Msg("Collecting image")
-	path := filepath.Join(prefix, id, rawName)
-	return s.loadS3(s.rawBucket, path)
+// List calls `fn` with the ID of every image on Amazon S3, having a RAW or a processed variant.
+func (s *S3Storer) List(fn func(id string) error) error {
+	seen := make(map[string]bool)
+	for _, bucket := range []string{s.rawBucket, s.thumbBucket} {
+		paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+			Bucket:    aws.String(bucket),
+			Prefix:    aws.String(prefix + "/"),
+			Delimiter: aws.String("/"),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return err
+			}
+			for _, p := range page.CommonPrefixes {
+				id := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(p.Prefix), prefix+"/"), "/")
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				if err = fn(id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
 }
 
-// LoadThumbnail loads the thumbnail of the image specified by the id from Amazon S3.
-func (s *S3Storer) LoadThumbnail(id string) ([]byte, error) {
-	log.Debug().Str("id", id).Msg("Collecting thumbnail")
-	path := filepath.Join(prefix, id, thumbnailName)
-	return s.loadS3(s.rawBucket, path)
+// Load loads a variant of the image specified by the id from Amazon S3.
+func (s *S3Storer) Load(id string, variant Variant) ([]byte, error) {
+	log.Debug().Str("id", id).Str("variant", string(variant)).Msg("Collecting image")
+	bucket, key := s.location(id, variant)
+	return s.loadS3(bucket, key)
+}
+
+// Open opens a variant of the image specified by the id on Amazon S3 for reading. The body of the object is passed
+// through without buffering, the caller is responsible for closing it.
+func (s *S3Storer) Open(id string, variant Variant) (io.ReadCloser, error) {
+	bucket, key := s.location(id, variant)
+	return s.openS3(bucket, key)
+}
+
+// Stat returns size, modification time and entity tag of a variant of the image specified by the id on Amazon S3.
+func (s *S3Storer) Stat(id string, variant Variant) (*ObjectInfo, error) {
+	bucket, key := s.location(id, variant)
+	return s.statS3(bucket, key)
+}
+
+// OpenRange opens `length` bytes of a variant of the image specified by the id on Amazon S3 for reading, starting
+// at `offset`. The caller is responsible for closing it.
+func (s *S3Storer) OpenRange(id string, variant Variant, offset, length int64) (io.ReadCloser, error) {
+	bucket, key := s.location(id, variant)
+	return s.openRangeS3(bucket, key, offset, length)
+}
+
+func (s *S3Storer) statS3(bucket string, key string) (*ObjectInfo, error) {
+	result, err := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		log.Err(err).Str("bucket", bucket).Str("key", key).Msg("Failed to collect file info")
+		return nil, err
+	}
+	return &ObjectInfo{
+		Size:     aws.ToInt64(result.ContentLength),
+		Modified: aws.ToTime(result.LastModified),
+		ETag:     aws.ToString(result.ETag),
+	}, nil
+}
+
+func (s *S3Storer) openRangeS3(bucket string, key string, offset, length int64) (io.ReadCloser, error) {
+	log.Debug().Str("bucket", bucket).Str("key", key).Int64("offset", offset).Int64("length", length).Msg("Collecting file range")
+
+	result, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		log.Err(err).Msg("Failed to collect file range")
+		return nil, err
+	}
+	return result.Body, nil
 }
 
 func (s *S3Storer) loadS3(bucket string, key string) ([]byte, error) {
-	var (
-		result *s3.GetObjectOutput
-		err    error
-	)
+	body, err := s.openS3(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	res, err := io.ReadAll(body)
+	if err != nil {
+		log.Err(err).Msg("Failed to read collected file")
+	}
+	return res, err
+}
+
+func (s *S3Storer) openS3(bucket string, key string) (io.ReadCloser, error) {
 	log.Debug().Str("bucket", bucket).Str("key", key).Msg("Collecting file")
 
-	result, err = s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+	result, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
@@ -141,13 +288,111 @@ func (s *S3Storer) loadS3(bucket string, key string) ([]byte, error) {
 		log.Err(err).Msg("Failed to collect file")
 		return nil, err
 	}
-	defer result.Body.Close()
+	return result.Body, nil
+}
 
-	res, err := io.ReadAll(result.Body)
+// MoveTo moves the RAW file of the image specified by the id to the storage class of the tier. Moving out of frozen
+// storage requires a restore first: the restore is requested and `ErrRestoreInProgress` is returned until finished.
+func (s *S3Storer) MoveTo(id string, tier Tier) error {
+	var (
+		path = filepath.Join(prefix, id, string(RawVariant))
+		head *s3.HeadObjectOutput
+		err  error
+	)
+	head, err = s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.rawBucket),
+		Key:    aws.String(path),
+	})
 	if err != nil {
-		log.Err(err).Msg("Failed to read collected file")
+		return err
 	}
-	return res, err
+
+	switch tier {
+	case FrozenTier:
+		if head.StorageClass == frozenClass {
+			return nil
+		}
+		return s.changeClass(path, frozenClass)
+	case StandardTier:
+		if head.StorageClass != frozenClass {
+			return nil
+		}
+		if head.Restore == nil {
+			log.Debug().Str("id", id).Msg("Requesting restore of frozen image")
+			_, err = s.client.RestoreObject(context.TODO(), &s3.RestoreObjectInput{
+				Bucket: aws.String(s.rawBucket),
+				Key:    aws.String(path),
+				RestoreRequest: &types.RestoreRequest{
+					Days: aws.Int32(restoreDays),
+					GlacierJobParameters: &types.GlacierJobParameters{
+						Tier: types.TierStandard,
+					},
+				},
+			})
+			if err != nil {
+				return err
+			}
+			return ErrRestoreInProgress
+		}
+		if strings.Contains(*head.Restore, restoring) {
+			return ErrRestoreInProgress
+		}
+		return s.changeClass(path, standardClass)
+	}
+	return ErrUnknownTier
+}
+
+func (s *S3Storer) changeClass(key string, class types.StorageClass) error {
+	_, err := s.client.CopyObject(context.TODO(), &s3.CopyObjectInput{
+		Bucket:               aws.String(s.rawBucket),
+		Key:                  aws.String(key),
+		CopySource:           aws.String(s.rawBucket + "/" + key),
+		StorageClass:         class,
+		MetadataDirective:    types.MetadataDirectiveCopy,
+		ServerSideEncryption: s.sse,
+		SSEKMSKeyId:          s.kmsKey(),
+	})
+	return err
+}
+
+// Tier returns the storage tier of the RAW file of the image specified by the id, based on its storage class.
+func (s *S3Storer) Tier(id string) (Tier, error) {
+	head, err := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.rawBucket),
+		Key:    aws.String(filepath.Join(prefix, id, string(RawVariant))),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if head.StorageClass == frozenClass {
+		return FrozenTier, nil
+	}
+	return StandardTier, nil
+}
+
+// PresignUpload makes a presigned request that can be used to upload a variant of an image to Amazon S3 directly.
+// The request is valid for the configured time, server side encryption headers and the content length are part of
+// the signature, so only a file of the size provided can be uploaded.
+func (s *S3Storer) PresignUpload(id string, variant Variant, size int64) (*PresignedRequest, error) {
+	bucket, key := s.location(id, variant)
+	request, err := s.presign.PresignPutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		ContentLength:        aws.Int64(size),
+		ServerSideEncryption: s.sse,
+		SSEKMSKeyId:          s.kmsKey(),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = time.Duration(s.presignedTTL * int64(time.Second))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get a presigned request to put %v:%v. cause: %v", bucket, key, err)
+	}
+	return &PresignedRequest{
+		Method: request.Method,
+		URL:    request.URL,
+		Header: request.SignedHeader,
+		Mode:   "cors",
+	}, nil
 }
 
 // SupportsPresign indicates whether the store supports presign
@@ -175,14 +420,12 @@ func (s *S3Storer) getURL(bucket string, key string, ttlSec int64) (*PresignedRe
 	}, nil
 }
 
-// PresignThumbnail makes a presigned request that can be used to get a thumbnail.
-func (s *S3Storer) PresignThumbnail(id string) (*PresignedRequest, error) {
-	path := filepath.Join(prefix, id, thumbnailName)
-	return s.getURL(s.thumbBucket, path, 300)
-}
-
-// PresignImage makes a presigned request that can be used to get a raw image.
-func (s *S3Storer) PresignImage(id string) (*PresignedRequest, error) {
-	path := filepath.Join(prefix, id, rawName)
-	return s.getURL(s.rawBucket, path, s.presignedTTL)
+// Presign makes a presigned request that can be used to get a variant of an image. Requests for RAW files are
+// valid for the configured time, requests for processed images for 5 minutes.
+func (s *S3Storer) Presign(id string, variant Variant) (*PresignedRequest, error) {
+	bucket, key := s.location(id, variant)
+	if variant == RawVariant {
+		return s.getURL(bucket, key, s.presignedTTL)
+	}
+	return s.getURL(bucket, key, 300)
 }
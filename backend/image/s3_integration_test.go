@@ -0,0 +1,174 @@
+//go:build integration
+
+package image
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/inokone/photostorage/common"
+)
+
+// Integration tests run against a local S3 stand-in, e.g. the MinIO service of the docker compose file:
+//
+//	docker compose up -d minio
+//	go test -tags integration ./image/...
+//
+// Endpoint and credentials can be overridden with S3_TEST_ENDPOINT, S3_TEST_ACCESS_KEY and S3_TEST_SECRET_KEY.
+func testS3Storer(t *testing.T) *S3Storer {
+	conf := &common.ImageStoreConfig{
+		RawBucket:    "raw-integration",
+		ThumbBucket:  "thumb-integration",
+		AwsKey:       env("S3_TEST_ACCESS_KEY", "minioadmin"),
+		AwsSecret:    env("S3_TEST_SECRET_KEY", "minioadmin"),
+		AwsRegion:    "us-east-1",
+		Endpoint:     env("S3_TEST_ENDPOINT", "http://localhost:9000"),
+		PathStyle:    true,
+		PresignedTTL: 60,
+	}
+	s, err := NewS3Storer(conf)
+	if err != nil {
+		t.Fatalf("NewS3Storer failed: %v", err)
+	}
+	for _, bucket := range []string{conf.RawBucket, conf.ThumbBucket} {
+		_, err = s.client.HeadBucket(context.TODO(), &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		if err == nil {
+			continue
+		}
+		if _, err = s.client.CreateBucket(context.TODO(), &s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+			t.Fatalf("CreateBucket(%v) failed: %v", bucket, err)
+		}
+	}
+	return s
+}
+
+func env(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+func TestS3StoreLoad(t *testing.T) {
+	s := testS3Storer(t)
+	id := "integration-store"
+	defer s.Delete(id)
+
+	raw := []byte("0123456789abcdefghij")
+	if err := s.Store(id, RawVariant, raw); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if err := s.Store(id, ThumbnailVariant, []byte("thumbnail")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	loaded, err := s.Load(id, RawVariant)
+	if err != nil || !bytes.Equal(loaded, raw) {
+		t.Errorf("Load = %v, %v; want %v", string(loaded), err, string(raw))
+	}
+	info, err := s.Stat(id, RawVariant)
+	if err != nil || info.Size != int64(len(raw)) || len(info.ETag) == 0 {
+		t.Errorf("Stat = %+v, %v; want size %v with entity tag", info, err, len(raw))
+	}
+	r, err := s.OpenRange(id, RawVariant, 10, 5)
+	if err != nil {
+		t.Fatalf("OpenRange failed: %v", err)
+	}
+	part, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(part) != "abcde" {
+		t.Errorf("OpenRange(10, 5) = %v, %v; want abcde", string(part), err)
+	}
+}
+
+func TestS3Presign(t *testing.T) {
+	s := testS3Storer(t)
+	id := "integration-presign"
+	defer s.Delete(id)
+
+	if err := s.Store(id, ThumbnailVariant, []byte("thumbnail")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	req, err := s.Presign(id, ThumbnailVariant)
+	if err != nil {
+		t.Fatalf("Presign failed: %v", err)
+	}
+	resp, err := http.Get(req.URL)
+	if err != nil {
+		t.Fatalf("GET presigned URL failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "thumbnail" {
+		t.Errorf("GET presigned URL = %v %v; want 200 thumbnail", resp.StatusCode, string(body))
+	}
+}
+
+func TestS3PresignUpload(t *testing.T) {
+	s := testS3Storer(t)
+	id := "integration-presign-upload"
+	defer s.Delete(id)
+
+	req, err := s.PresignUpload(id, RawVariant, 3)
+	if err != nil {
+		t.Fatalf("PresignUpload failed: %v", err)
+	}
+	put, err := http.NewRequest(req.Method, req.URL, strings.NewReader("raw"))
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	for k, v := range req.Header {
+		if k != "Host" {
+			put.Header[k] = v
+		}
+	}
+	resp, err := http.DefaultClient.Do(put)
+	if err != nil {
+		t.Fatalf("PUT presigned URL failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT presigned URL = %v; want 200", resp.StatusCode)
+	}
+	loaded, err := s.Load(id, RawVariant)
+	if err != nil || string(loaded) != "raw" {
+		t.Errorf("Load after upload = %v, %v; want raw", string(loaded), err)
+	}
+}
+
+func TestS3Delete(t *testing.T) {
+	s := testS3Storer(t)
+	id := "integration-delete"
+	render := RenderPrefix + "100x0-contain-q85.jpg"
+	for _, v := range []Variant{RawVariant, ThumbnailVariant, render} {
+		if err := s.Store(id, v, []byte(v)); err != nil {
+			t.Fatalf("Store(%v) failed: %v", v, err)
+		}
+	}
+
+	if err := s.DeleteVariants(id, RenderPrefix); err != nil {
+		t.Fatalf("DeleteVariants failed: %v", err)
+	}
+	if _, err := s.Stat(id, render); err == nil {
+		t.Errorf("Render should be deleted")
+	}
+	if _, err := s.Stat(id, ThumbnailVariant); err != nil {
+		t.Errorf("Stat(thumbnail) after DeleteVariants failed: %v", err)
+	}
+
+	if err := s.Delete(id); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	for _, v := range []Variant{RawVariant, ThumbnailVariant} {
+		if _, err := s.Stat(id, v); err == nil {
+			t.Errorf("Stat(%v) after Delete should fail", v)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package image
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/inokone/photostorage/common"
+	"github.com/pkg/sftp"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrMissingHostKey is an error for SFTP stores configured without the public key of the server
+var ErrMissingHostKey = errors.New("SFTP host key is not configured")
+
+// sftpDialTimeout is the timeout of connecting to the SFTP server
+const sftpDialTimeout = 30 * time.Second
+
+// NewSFTPStorer creates a new `FSStorer` storing images on an SFTP server, under `Path` of the configuration.
+// The user is authenticated with password and/or private key, the server is verified against the configured
+// host key.
+func NewSFTPStorer(conf *common.ImageStoreConfig) (*FSStorer, error) {
+	if len(conf.SFTPHostKey) == 0 {
+		return nil, ErrMissingHostKey
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(conf.SFTPHostKey))
+	if err != nil {
+		return nil, err
+	}
+	var auth []ssh.AuthMethod
+	if len(conf.SFTPKeyPath) > 0 {
+		pem, err := os.ReadFile(conf.SFTPKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if len(conf.SFTPPassword) > 0 {
+		auth = append(auth, ssh.Password(conf.SFTPPassword))
+	}
+
+	fsys := NewSFTPFileSystem(func() (*sftp.Client, error) {
+		conn, err := ssh.Dial("tcp", conf.SFTPAddress, &ssh.ClientConfig{
+			User:            conf.SFTPUser,
+			Auth:            auth,
+			HostKeyCallback: ssh.FixedHostKey(hostKey),
+			Timeout:         sftpDialTimeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return client, nil
+	})
+	if _, err = fsys.connect(); err != nil {
+		return nil, err
+	}
+	return NewFSStorer(fsys, conf.Path, coldPath(conf))
+}
+
+// SFTPFileSystem is an implementation of the `FileSystem` interface on an SFTP connection. The connection is dialled
+// lazily, and dialled again when it is lost, e.g. when the server restarts.
+type SFTPFileSystem struct {
+	dial func() (*sftp.Client, error)
+	mu   sync.Mutex
+	conn *sftpConn
+}
+
+// sftpConn is an SFTP client with a channel closed when its connection is closed.
+type sftpConn struct {
+	client *sftp.Client
+	done   chan struct{}
+}
+
+func (c *sftpConn) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewSFTPFileSystem creates a new `SFTPFileSystem` connecting to the server with the dial function.
+func NewSFTPFileSystem(dial func() (*sftp.Client, error)) *SFTPFileSystem {
+	return &SFTPFileSystem{dial: dial}
+}
+
+// connect returns the connection to the server, dialling it if not connected.
+func (f *SFTPFileSystem) connect() (*sftpConn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn != nil && !f.conn.closed() {
+		return f.conn, nil
+	}
+	client, err := f.dial()
+	if err != nil {
+		return nil, err
+	}
+	c := &sftpConn{client: client, done: make(chan struct{})}
+	go func() {
+		_ = client.Wait()
+		close(c.done)
+	}()
+	f.conn = c
+	return c, nil
+}
+
+// drop closes the connection, so the next operation dials the server again.
+func (f *SFTPFileSystem) drop(c *sftpConn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn == c {
+		f.conn = nil
+	}
+	c.client.Close()
+}
+
+// do calls `fn` with the SFTP client, and once more with a new connection if the connection was lost.
+func (f *SFTPFileSystem) do(fn func(client *sftp.Client) error) error {
+	c, err := f.connect()
+	if err != nil {
+		return err
+	}
+	if err = fn(c.client); err == nil || !(errors.Is(err, sftp.ErrSSHFxConnectionLost) || c.closed()) {
+		return err
+	}
+	log.Warn().Err(err).Msg("SFTP connection lost, reconnecting")
+	f.drop(c)
+	if c, err = f.connect(); err != nil {
+		return err
+	}
+	return fn(c.client)
+}
+
+// Write creates or truncates the file on the SFTP server and copies the content into it. Only creating the file is
+// retried on a new connection, the content may be consumed partially when the connection is lost while copying.
+func (f *SFTPFileSystem) Write(name string, content io.Reader) error {
+	var file *sftp.File
+	err := f.do(func(client *sftp.Client) (err error) {
+		file, err = client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Open opens the file on the SFTP server for reading.
+func (f *SFTPFileSystem) Open(name string) (io.ReadCloser, error) {
+	var file *sftp.File
+	err := f.do(func(client *sftp.Client) (err error) {
+		file, err = client.Open(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// OpenRange opens `length` bytes of the file on the SFTP server for reading, starting at `offset`.
+func (f *SFTPFileSystem) OpenRange(name string, offset, length int64) (io.ReadCloser, error) {
+	var file *sftp.File
+	err := f.do(func(client *sftp.Client) (err error) {
+		file, err = client.Open(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return limitedFile{Reader: io.LimitReader(file, length), Closer: file}, nil
+}
+
+// Stat returns the file info of the file on the SFTP server.
+func (f *SFTPFileSystem) Stat(name string) (fs.FileInfo, error) {
+	var info fs.FileInfo
+	err := f.do(func(client *sftp.Client) (err error) {
+		info, err = client.Stat(name)
+		return err
+	})
+	return info, err
+}
+
+// ReadDir returns the entries of the directory on the SFTP server.
+func (f *SFTPFileSystem) ReadDir(name string) ([]fs.FileInfo, error) {
+	var entries []fs.FileInfo
+	err := f.do(func(client *sftp.Client) (err error) {
+		entries, err = client.ReadDir(name)
+		return err
+	})
+	return entries, err
+}
+
+// MkdirAll creates the directory on the SFTP server with all missing parents.
+func (f *SFTPFileSystem) MkdirAll(name string) error {
+	return f.do(func(client *sftp.Client) error {
+		return client.MkdirAll(name)
+	})
+}
+
+// Remove removes the file on the SFTP server.
+func (f *SFTPFileSystem) Remove(name string) error {
+	return f.do(func(client *sftp.Client) error {
+		return client.Remove(name)
+	})
+}
+
+// RemoveAll removes the directory on the SFTP server with all its content.
+func (f *SFTPFileSystem) RemoveAll(name string) error {
+	return f.do(func(client *sftp.Client) error {
+		return client.RemoveAll(name)
+	})
+}
+
+// Rename moves the file on the SFTP server.
+func (f *SFTPFileSystem) Rename(from, to string) error {
+	return f.do(func(client *sftp.Client) error {
+		return client.Rename(from, to)
+	})
+}
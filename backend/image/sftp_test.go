@@ -0,0 +1,173 @@
+package image
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/inokone/photostorage/common"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSFTPStorer(t *testing.T) {
+	addr, hostKey, _ := serveSFTP(t, "user", "secret")
+
+	s, err := NewSFTPStorer(&common.ImageStoreConfig{
+		Path:         "/store",
+		SFTPAddress:  addr,
+		SFTPUser:     "user",
+		SFTPPassword: "secret",
+		SFTPHostKey:  string(ssh.MarshalAuthorizedKey(hostKey)),
+	})
+	if err != nil {
+		t.Fatalf("NewSFTPStorer failed: %v", err)
+	}
+	testFSStorer(t, s)
+}
+
+func TestSFTPStorerReconnect(t *testing.T) {
+	addr, hostKey, drop := serveSFTP(t, "user", "secret")
+
+	s, err := NewSFTPStorer(&common.ImageStoreConfig{
+		Path:         "/store",
+		SFTPAddress:  addr,
+		SFTPUser:     "user",
+		SFTPPassword: "secret",
+		SFTPHostKey:  string(ssh.MarshalAuthorizedKey(hostKey)),
+	})
+	if err != nil {
+		t.Fatalf("NewSFTPStorer failed: %v", err)
+	}
+	if err = s.Store("id", RawVariant, []byte("content")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	drop()
+	loaded, err := s.Load("id", RawVariant)
+	if err != nil || string(loaded) != "content" {
+		t.Fatalf("Load after the connection was lost = %q, %v; want %q", loaded, err, "content")
+	}
+	drop()
+	if err = s.Store("other", RawVariant, []byte("other content")); err != nil {
+		t.Errorf("Store after the connection was lost failed: %v", err)
+	}
+}
+
+func TestSFTPStorerHostKey(t *testing.T) {
+	addr, _, _ := serveSFTP(t, "user", "secret")
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	otherKey, err := ssh.NewPublicKey(other)
+	if err != nil {
+		t.Fatalf("NewPublicKey failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		hostKey string
+	}{
+		{name: "missing host key", hostKey: ""},
+		{name: "unknown host key", hostKey: string(ssh.MarshalAuthorizedKey(otherKey))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSFTPStorer(&common.ImageStoreConfig{
+				Path:         "/store",
+				SFTPAddress:  addr,
+				SFTPUser:     "user",
+				SFTPPassword: "secret",
+				SFTPHostKey:  tt.hostKey,
+			})
+			if err == nil {
+				t.Errorf("NewSFTPStorer should fail")
+			}
+		})
+	}
+}
+
+// serveSFTP starts an in-memory SFTP server accepting the user with the password, returns its address, host key and a
+// function dropping all open connections.
+func serveSFTP(t *testing.T, user, password string) (string, ssh.PublicKey, func()) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey failed: %v", err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == user && string(pass) == password {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var (
+		handlers = sftp.InMemHandler()
+		mu       sync.Mutex
+		conns    []net.Conn
+	)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go serveSSH(conn, config, handlers)
+		}
+	}()
+	drop := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+		conns = nil
+	}
+	return listener.Addr().String(), signer.PublicKey(), drop
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig, handlers sftp.Handlers) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if ok {
+					go sftp.NewRequestServer(ch, handlers).Serve()
+				}
+			}
+		}()
+	}
+}
@@ -1,28 +1,51 @@
 package image
 
+import "io"
+
 // Writer is an interface for changing images (RAW or processed).
 type Writer interface {
-	Store(id string, raw []byte, thumbnail []byte) error
+	Store(id string, variant Variant, content []byte) error
+
+	StoreStream(id string, variant Variant, content io.Reader) error
 
 	Delete(id string) error
+
+	DeleteVariants(id string, prefix Variant) error
 }
 
 // Loader is an interface for loading images (RAW or processed).
 type Loader interface {
-	LoadThumbnail(id string) ([]byte, error)
+	Load(id string, variant Variant) ([]byte, error)
 
-	LoadImage(id string) ([]byte, error)
+	Open(id string, variant Variant) (io.ReadCloser, error)
+
+	Stat(id string, variant Variant) (*ObjectInfo, error)
+
+	OpenRange(id string, variant Variant, offset, length int64) (io.ReadCloser, error)
 }
 
 // Presigner is an interface for providing presigned requests for images (RAW or processed).
 type Presigner interface {
-	PresignThumbnail(id string) (*PresignedRequest, error)
+	Presign(id string, variant Variant) (*PresignedRequest, error)
 
-	PresignImage(id string) (*PresignedRequest, error)
+	// PresignUpload makes a presigned request that can be used to upload a variant of an image of the size in bytes
+	// directly to the store.
+	PresignUpload(id string, variant Variant, size int64) (*PresignedRequest, error)
 
 	SupportsPresign() bool
 }
 
+// Committer is an interface for storers that have to be notified about variants uploaded with presigned requests,
+// e.g. to replicate them.
+type Committer interface {
+	Commit(id string, variant Variant) error
+}
+
+// Lister is an interface for enumerating the IDs of all images in a store.
+type Lister interface {
+	List(fn func(id string) error) error
+}
+
 // Storer is an interface for types that can store images (RAW or processed).
 type Storer interface {
 	Writer
@@ -30,4 +53,8 @@ type Storer interface {
 	Loader
 
 	Presigner
+
+	Tierer
+
+	Lister
 }
@@ -0,0 +1,32 @@
+package image
+
+import "errors"
+
+// Tier is a storage tier of an image. Frozen storage is cheaper, but the RAW is not available for download
+// until it is moved back to standard storage.
+type Tier int
+
+const (
+	// StandardTier is the default tier for images, accessible any time
+	StandardTier Tier = 1
+	// FrozenTier is the tier for archived images, counting 0.5x from the quota
+	FrozenTier Tier = 2
+)
+
+var (
+	// ErrRestoreInProgress is an error for moving an image out of frozen storage while it is still being restored.
+	// The move has to be retried when the restore is finished.
+	ErrRestoreInProgress = errors.New("image restore from frozen storage is in progress")
+	// ErrUnknownTier is an error for tiers not supported by the storer
+	ErrUnknownTier = errors.New("unknown storage tier")
+	// ErrSharedContent is an error for freezing an image with content shared by other images, which would freeze
+	// them as well
+	ErrSharedContent = errors.New("image content is shared with other images")
+)
+
+// Tierer is an interface for moving images (RAW only, thumbnails always stay available) between storage tiers.
+type Tierer interface {
+	MoveTo(id string, tier Tier) error
+
+	Tier(id string) (Tier, error)
+}
@@ -0,0 +1,198 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+
+	"github.com/chai2010/webp"
+	"golang.org/x/image/draw"
+)
+
+// Fit is the strategy of fitting an image into the requested size of a transform.
+type Fit string
+
+// Format is the output format of a transform.
+type Format string
+
+const (
+	// ContainFit scales the image to fit into the requested size, keeping the aspect ratio
+	ContainFit Fit = "contain"
+	// CoverFit scales the image to cover the requested size, keeping the aspect ratio and cropping the center
+	CoverFit Fit = "cover"
+	// FillFit stretches the image to the requested size
+	FillFit Fit = "fill"
+
+	// JPEGFormat is the output format for JPEG images
+	JPEGFormat Format = "jpeg"
+	// WebPFormat is the output format for WebP images
+	WebPFormat Format = "webp"
+
+	// RenderPrefix is the prefix of the variants caching renders of transforms
+	RenderPrefix Variant = "render/"
+
+	maxRenderSize  = 4096
+	defaultQuality = 85
+)
+
+var (
+	// renderSizes are the sizes the width and height of transforms are rounded up to, bounding the number of renders
+	// cached for a photo
+	renderSizes = []int{64, 128, 256, 512, 1024, 2048, maxRenderSize}
+	// renderQualities are the qualities of renders, the quality of transforms is rounded to the nearest one
+	renderQualities = []int{60, 75, defaultQuality, 95}
+)
+
+// ErrInvalidTransform is an error for transforms with sizes, fit, format or quality out of the supported range
+var ErrInvalidTransform = errors.New("invalid transform")
+
+// Transform is a struct describing a render of an image: resized to `Width` x `Height` with the `Fit` strategy,
+// encoded in `Format` with `Quality`. Either width or height can be 0, it is calculated from the aspect ratio then.
+type Transform struct {
+	Width   int
+	Height  int
+	Fit     Fit
+	Format  Format
+	Quality int
+}
+
+// Normalize is a method of `Transform` setting defaults for the missing fit, format and quality, and validating
+// the transform. Sizes are rounded up to `renderSizes` and the quality to the nearest of `renderQualities`.
+func (t Transform) Normalize() (Transform, error) {
+	if len(t.Fit) == 0 {
+		t.Fit = ContainFit
+	}
+	if len(t.Format) == 0 {
+		t.Format = JPEGFormat
+	}
+	if t.Quality == 0 {
+		t.Quality = defaultQuality
+	}
+	if t.Width < 0 || t.Height < 0 || t.Width > maxRenderSize || t.Height > maxRenderSize || t.Width+t.Height == 0 {
+		return t, ErrInvalidTransform
+	}
+	if t.Fit != ContainFit && t.Fit != CoverFit && t.Fit != FillFit {
+		return t, ErrInvalidTransform
+	}
+	if t.Format != JPEGFormat && t.Format != WebPFormat {
+		return t, ErrInvalidTransform
+	}
+	if t.Quality < 1 || t.Quality > 100 {
+		return t, ErrInvalidTransform
+	}
+	t.Width, t.Height = renderSize(t.Width), renderSize(t.Height)
+	t.Quality = renderQuality(t.Quality)
+	return t, nil
+}
+
+// Within is a method of `Transform` capping the width and height at the render size of the longest edge of an image
+// of size [`width`, `height`]. Renders are not upscaled, so larger transforms would produce the same render. The
+// transform is returned as is for unknown sizes.
+func (t Transform) Within(width, height int) Transform {
+	edge := renderSize(max(width, height))
+	if edge == 0 {
+		return t
+	}
+	t.Width, t.Height = min(t.Width, edge), min(t.Height, edge)
+	return t
+}
+
+// renderSize returns the smallest of `renderSizes` not smaller than the size, 0 for 0.
+func renderSize(size int) int {
+	if size <= 0 {
+		return 0
+	}
+	for _, s := range renderSizes {
+		if s >= size {
+			return s
+		}
+	}
+	return maxRenderSize
+}
+
+// renderQuality returns the nearest of `renderQualities` to the quality.
+func renderQuality(quality int) int {
+	res := renderQualities[0]
+	for _, q := range renderQualities {
+		if abs(q-quality) < abs(res-quality) {
+			res = q
+		}
+	}
+	return res
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Variant is a method of `Transform` returning the variant the render of the transform is cached under, for an image
+// rotated clockwise by the degrees.
+func (t Transform) Variant(rotation int) Variant {
+	ext := "jpg"
+	if t.Format == WebPFormat {
+		ext = "webp"
+	}
+	var rotated string
+	if rotation, _ = NormalizeRotation(rotation); rotation != 0 {
+		rotated = fmt.Sprintf("-r%v", rotation)
+	}
+	return Variant(fmt.Sprintf("%v%vx%v-%v-q%v%v.%v", RenderPrefix, t.Width, t.Height, t.Fit, t.Quality, rotated, ext))
+}
+
+// Apply is a function to resize and crop the image provided as a parameter according to the transform. Images are
+// never upscaled, renders larger than the image are bounded by its size.
+func Apply(original image.Image, t Transform) image.Image {
+	var (
+		bounds = original.Bounds()
+		ow     = float64(bounds.Dx())
+		oh     = float64(bounds.Dy())
+		w      = float64(t.Width)
+		h      = float64(t.Height)
+		src    = bounds
+	)
+	switch {
+	case w == 0:
+		w = math.Round(h * ow / oh)
+	case h == 0:
+		h = math.Round(w * oh / ow)
+	}
+
+	switch t.Fit {
+	case ContainFit:
+		ratio := math.Min(1, math.Min(w/ow, h/oh))
+		w, h = math.Max(1, math.Round(ow*ratio)), math.Max(1, math.Round(oh*ratio))
+	case CoverFit:
+		ratio := math.Max(w/ow, h/oh)
+		if ratio > 1 {
+			w, h, ratio = math.Max(1, math.Round(w/ratio)), math.Max(1, math.Round(h/ratio)), 1
+		}
+		cw, ch := int(math.Round(w/ratio)), int(math.Round(h/ratio))
+		x, y := bounds.Min.X+(bounds.Dx()-cw)/2, bounds.Min.Y+(bounds.Dy()-ch)/2
+		src = image.Rect(x, y, x+cw, y+ch)
+	case FillFit:
+		w, h = math.Min(w, ow), math.Min(h, oh)
+	}
+
+	result := image.NewRGBA(image.Rect(0, 0, int(w), int(h)))
+	draw.ApproxBiLinear.Scale(result, result.Rect, original, src, draw.Over, nil)
+	return result
+}
+
+// Encode is a function to export the image provided as parameter as a byte array in the format and quality of the
+// transform.
+func Encode(im image.Image, t Transform) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	var err error
+	if t.Format == WebPFormat {
+		err = webp.Encode(buf, im, &webp.Options{Quality: float32(t.Quality)})
+	} else {
+		err = jpeg.Encode(buf, im, &jpeg.Options{Quality: t.Quality})
+	}
+	return buf.Bytes(), err
+}
@@ -0,0 +1,103 @@
+package image
+
+import (
+	"image"
+	"testing"
+)
+
+type ApplyTest struct {
+	transform Transform
+	outX      int
+	outY      int
+}
+
+var applyTests = []ApplyTest{
+	{Transform{Width: 200, Fit: ContainFit}, 200, 150},
+	{Transform{Height: 300, Fit: ContainFit}, 400, 300},
+	{Transform{Width: 200, Height: 200, Fit: ContainFit}, 200, 150},
+	{Transform{Width: 200, Height: 200, Fit: CoverFit}, 200, 200},
+	{Transform{Width: 200, Height: 200, Fit: FillFit}, 200, 200},
+	{Transform{Width: 100, Height: 300, Fit: CoverFit}, 100, 300},
+	{Transform{Width: 1600, Fit: ContainFit}, 800, 600},
+	{Transform{Width: 1000, Height: 1000, Fit: CoverFit}, 600, 600},
+	{Transform{Width: 1000, Height: 500, Fit: FillFit}, 800, 500},
+}
+
+func TestApply(t *testing.T) {
+	original := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	for _, test := range applyTests {
+		actual := Apply(original, test.transform)
+		if actual.Bounds().Dx() != test.outX || actual.Bounds().Dy() != test.outY {
+			t.Errorf("Apply(%+v) = (%v, %v); want (%v, %v)", test.transform, actual.Bounds().Dx(), actual.Bounds().Dy(), test.outX, test.outY)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tr, err := Transform{Width: 100}.Normalize()
+	if err != nil || tr.Fit != ContainFit || tr.Format != JPEGFormat || tr.Quality != defaultQuality {
+		t.Errorf("Normalize() = %+v, %v; want defaults", tr, err)
+	}
+	for _, invalid := range []Transform{{}, {Width: -1}, {Width: 5000}, {Width: 1, Fit: "zoom"}, {Width: 1, Format: "gif"}, {Width: 1, Quality: 101}} {
+		if _, err = invalid.Normalize(); err != ErrInvalidTransform {
+			t.Errorf("Normalize(%+v) = %v; want %v", invalid, err, ErrInvalidTransform)
+		}
+	}
+}
+
+func TestNormalizeBuckets(t *testing.T) {
+	var bucketTests = []struct {
+		in       Transform
+		expected Transform
+	}{
+		{Transform{Width: 100}, Transform{Width: 128, Quality: defaultQuality}},
+		{Transform{Width: 300, Height: 1}, Transform{Width: 512, Height: 64, Quality: defaultQuality}},
+		{Transform{Height: 4000, Quality: 100}, Transform{Height: maxRenderSize, Quality: 95}},
+		{Transform{Width: 2048, Quality: 1}, Transform{Width: 2048, Quality: 60}},
+		{Transform{Width: 64, Quality: 70}, Transform{Width: 64, Quality: 75}},
+	}
+	for _, test := range bucketTests {
+		actual, err := test.in.Normalize()
+		if err != nil || actual.Width != test.expected.Width || actual.Height != test.expected.Height || actual.Quality != test.expected.Quality {
+			t.Errorf("Normalize(%+v) = %+v, %v; want %+v", test.in, actual, err, test.expected)
+		}
+	}
+}
+
+func TestWithin(t *testing.T) {
+	var withinTests = []struct {
+		in       Transform
+		width    int
+		height   int
+		expected Transform
+	}{
+		{Transform{Width: 4096, Height: 4096}, 800, 600, Transform{Width: 1024, Height: 1024}},
+		{Transform{Width: 2048}, 600, 1500, Transform{Width: 2048}},
+		{Transform{Width: 512, Height: 256}, 6000, 4000, Transform{Width: 512, Height: 256}},
+		{Transform{Width: 4096}, 0, 0, Transform{Width: 4096}},
+	}
+	for _, test := range withinTests {
+		actual := test.in.Within(test.width, test.height)
+		if actual != test.expected {
+			t.Errorf("Within(%+v, %v, %v) = %+v; want %+v", test.in, test.width, test.height, actual, test.expected)
+		}
+	}
+}
+
+func TestVariant(t *testing.T) {
+	var variantTests = []struct {
+		transform Transform
+		rotation  int
+		expected  Variant
+	}{
+		{Transform{Width: 128, Fit: ContainFit, Format: JPEGFormat, Quality: 85}, 0, "render/128x0-contain-q85.jpg"},
+		{Transform{Width: 128, Fit: ContainFit, Format: JPEGFormat, Quality: 85}, 360, "render/128x0-contain-q85.jpg"},
+		{Transform{Width: 128, Height: 64, Fit: CoverFit, Format: WebPFormat, Quality: 75}, 90, "render/128x64-cover-q75-r90.webp"},
+		{Transform{Width: 128, Fit: ContainFit, Format: JPEGFormat, Quality: 85}, -90, "render/128x0-contain-q85-r270.jpg"},
+	}
+	for _, test := range variantTests {
+		if actual := test.transform.Variant(test.rotation); actual != test.expected {
+			t.Errorf("Variant(%v) = %v; want %v", test.rotation, actual, test.expected)
+		}
+	}
+}
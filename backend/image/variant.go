@@ -0,0 +1,60 @@
+package image
+
+import (
+	"errors"
+	"mime"
+	"path/filepath"
+)
+
+// Variant is the name of a stored rendition of an image, e.g. the original RAW or a resized preview.
+type Variant string
+
+const (
+	// RawVariant is the original uploaded file
+	RawVariant Variant = "raw"
+	// ThumbnailVariant is the default preview of the image for the gallery
+	ThumbnailVariant Variant = "thumbnail.jpg"
+	// SmallVariant is a small preview of the image for grids
+	SmallVariant Variant = "small.jpg"
+	// MediumVariant is a large preview of the image for fullscreen viewing
+	MediumVariant Variant = "medium.jpg"
+	// ArchiveVariant is a ZIP archive generated for download, e.g. the takeout of an account
+	ArchiveVariant Variant = "archive.zip"
+)
+
+// ErrUnknownSize is an error for preview sizes without a rendition
+var ErrUnknownSize = errors.New("unknown preview size")
+
+// Rendition is a resized version of images generated on import, bounded by the maximum width and height.
+type Rendition struct {
+	Variant   Variant
+	Size      string
+	MaxWidth  float64
+	MaxHeight float64
+}
+
+// Renditions are the previews generated for every imported image.
+var Renditions = []Rendition{
+	{SmallVariant, "small", 256, 256},
+	{ThumbnailVariant, "thumbnail", thumbWidth, thumbHeight},
+	{MediumVariant, "medium", 2048, 2048},
+}
+
+// VariantFor returns the variant of the rendition with the preview size name provided as parameter.
+func VariantFor(size string) (Variant, error) {
+	for _, r := range Renditions {
+		if r.Size == size {
+			return r.Variant, nil
+		}
+	}
+	return "", ErrUnknownSize
+}
+
+// ContentType returns the MIME type of the variant, based on the extension of its name.
+func (v Variant) ContentType() string {
+	ct := mime.TypeByExtension(filepath.Ext(string(v)))
+	if len(ct) == 0 {
+		return "application/octet-stream"
+	}
+	return ct
+}
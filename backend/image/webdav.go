@@ -0,0 +1,109 @@
+package image
+
+import (
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/inokone/photostorage/common"
+	"github.com/studio-b12/gowebdav"
+)
+
+// NewWebDAVStorer creates a new `FSStorer` storing images on a WebDAV server, under `Path` of the configuration
+// relative to the server URL.
+func NewWebDAVStorer(conf *common.ImageStoreConfig) (*FSStorer, error) {
+	client := gowebdav.NewClient(conf.WebDAVURL, conf.WebDAVUser, conf.WebDAVPassword)
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	return NewFSStorer(NewWebDAVFileSystem(client), conf.Path, coldPath(conf))
+}
+
+// WebDAVFileSystem is an implementation of the `FileSystem` interface on a WebDAV server.
+type WebDAVFileSystem struct {
+	client *gowebdav.Client
+}
+
+// NewWebDAVFileSystem creates a new `WebDAVFileSystem` using the WebDAV client.
+func NewWebDAVFileSystem(client *gowebdav.Client) *WebDAVFileSystem {
+	return &WebDAVFileSystem{client: client}
+}
+
+// Write creates or replaces the file on the WebDAV server with the content. The upload needs the content length,
+// so content not seekable is spooled to a temporary file first.
+func (f *WebDAVFileSystem) Write(name string, content io.Reader) error {
+	seeker, ok := content.(io.ReadSeeker)
+	if !ok {
+		tmp, err := os.CreateTemp("", "webdav_*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err = io.Copy(tmp, content); err != nil {
+			return err
+		}
+		seeker = tmp
+	}
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return notExist(f.client.WriteStreamWithLength(name, seeker, size, 0o600))
+}
+
+// Open opens the file on the WebDAV server for reading.
+func (f *WebDAVFileSystem) Open(name string) (io.ReadCloser, error) {
+	r, err := f.client.ReadStream(name)
+	return r, notExist(err)
+}
+
+// OpenRange opens `length` bytes of the file on the WebDAV server for reading, starting at `offset`.
+func (f *WebDAVFileSystem) OpenRange(name string, offset, length int64) (io.ReadCloser, error) {
+	r, err := f.client.ReadStreamRange(name, offset, length)
+	return r, notExist(err)
+}
+
+// Stat returns the file info of the file on the WebDAV server.
+func (f *WebDAVFileSystem) Stat(name string) (fs.FileInfo, error) {
+	fi, err := f.client.Stat(name)
+	return fi, notExist(err)
+}
+
+// ReadDir returns the entries of the collection on the WebDAV server.
+func (f *WebDAVFileSystem) ReadDir(name string) ([]fs.FileInfo, error) {
+	entries, err := f.client.ReadDir(name)
+	return entries, notExist(err)
+}
+
+// MkdirAll creates the collection on the WebDAV server with all missing parents.
+func (f *WebDAVFileSystem) MkdirAll(name string) error {
+	return notExist(f.client.MkdirAll(name, 0o700))
+}
+
+// Remove removes the file on the WebDAV server.
+func (f *WebDAVFileSystem) Remove(name string) error {
+	return notExist(f.client.Remove(name))
+}
+
+// RemoveAll removes the collection on the WebDAV server with all its content.
+func (f *WebDAVFileSystem) RemoveAll(name string) error {
+	return notExist(f.client.RemoveAll(name))
+}
+
+// Rename moves the file on the WebDAV server.
+func (f *WebDAVFileSystem) Rename(from, to string) error {
+	return notExist(f.client.Rename(from, to, false))
+}
+
+// notExist translates the 404 responses of the WebDAV server to errors matching `fs.ErrNotExist`.
+func notExist(err error) error {
+	if !gowebdav.IsErrNotFound(err) {
+		return err
+	}
+	pe := err.(*fs.PathError)
+	return &fs.PathError{Op: pe.Op, Path: pe.Path, Err: fs.ErrNotExist}
+}
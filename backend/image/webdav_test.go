@@ -0,0 +1,26 @@
+package image
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/inokone/photostorage/common"
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVStorer(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer server.Close()
+
+	s, err := NewWebDAVStorer(&common.ImageStoreConfig{
+		Path:      "/store",
+		WebDAVURL: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewWebDAVStorer failed: %v", err)
+	}
+	testFSStorer(t, s)
+}
@@ -0,0 +1,226 @@
+package xmp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+const (
+	rdfNS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmlNS = "http://www.w3.org/XML/1998/namespace"
+	// appNS is the namespace of the properties of the application without a standard XMP property, e.g. favorites
+	appNS = "https://raw.ninja/ns/1.0/"
+)
+
+var (
+	packetStart = []byte("<x:xmpmeta")
+	packetEnd   = []byte("</x:xmpmeta>")
+)
+
+// Packet is a struct representing the properties of an XMP packet, embedded in an image or in a sidecar file. The
+// properties most editors use are parsed into the fields, all simple and array properties are in `Properties` keyed
+// by namespace prefix and name, e.g. `dc:subject`. Rating and favorite are nil if the packet does not have them.
+type Packet struct {
+	Rating      *int
+	Favorite    *bool
+	Label       string
+	Title       string
+	Description string
+	Keywords    []string
+	Creator     string
+	Rights      string
+	Properties  map[string]any
+}
+
+// node is a generic XML element of an XMP packet.
+type node struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Nodes   []node     `xml:",any"`
+	Text    string     `xml:",chardata"`
+}
+
+// Extract returns the XMP packet embedded in the binary of an image, nil if there is none.
+func Extract(b []byte) []byte {
+	start := bytes.Index(b, packetStart)
+	if start < 0 {
+		return nil
+	}
+	end := bytes.Index(b[start:], packetEnd)
+	if end < 0 {
+		return nil
+	}
+	return b[start : start+end+len(packetEnd)]
+}
+
+// Parse parses an XMP packet or sidecar file.
+func Parse(b []byte) (*Packet, error) {
+	var root node
+	if err := xml.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+	prefixes := make(map[string]string)
+	root.prefixes(prefixes)
+
+	p := &Packet{Properties: make(map[string]any)}
+	root.walk(func(n *node) {
+		if n.XMLName.Space == rdfNS && n.XMLName.Local == "Description" {
+			n.properties(prefixes, p.Properties)
+		}
+	})
+
+	if rating, err := strconv.ParseFloat(p.text("xmp:Rating"), 64); err == nil {
+		r := int(rating) // some editors write decimal ratings
+		p.Rating = &r
+	}
+	if favorite, err := strconv.ParseBool(p.text(prefixes[appNS] + ":Favorite")); err == nil {
+		p.Favorite = &favorite
+	}
+	p.Label = p.text("xmp:Label")
+	p.Title = p.text("dc:title")
+	p.Description = p.text("dc:description")
+	p.Keywords = p.list("dc:subject")
+	p.Creator = strings.Join(p.list("dc:creator"), ", ")
+	p.Rights = p.text("dc:rights")
+	return p, nil
+}
+
+// text returns a simple property, or the first item of an array property, e.g. the default language of a title.
+func (p *Packet) text(name string) string {
+	switch v := p.Properties[name].(type) {
+	case string:
+		return v
+	case []string:
+		if len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// list returns an array property, or a simple property as a single item array.
+func (p *Packet) list(name string) []string {
+	switch v := p.Properties[name].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	}
+	return nil
+}
+
+func (n *node) walk(fn func(n *node)) {
+	fn(n)
+	for i := range n.Nodes {
+		n.Nodes[i].walk(fn)
+	}
+}
+
+// prefixes collects the namespace prefixes declared in the packet.
+func (n *node) prefixes(res map[string]string) {
+	n.walk(func(n *node) {
+		for _, a := range n.Attrs {
+			if a.Name.Space == "xmlns" {
+				res[a.Value] = a.Name.Local
+			}
+		}
+	})
+}
+
+// properties collects the properties of a `rdf:Description`, both the ones in attributes and the ones in elements.
+// Structures are left out, only simple and array properties are collected.
+func (n *node) properties(prefixes map[string]string, res map[string]any) {
+	for _, a := range n.Attrs {
+		if a.Name.Space == "xmlns" || a.Name.Space == rdfNS || a.Name.Space == xmlNS || len(a.Name.Space) == 0 {
+			continue
+		}
+		res[name(prefixes, a.Name)] = a.Value
+	}
+	for _, c := range n.Nodes {
+		if len(c.Nodes) == 0 {
+			res[name(prefixes, c.XMLName)] = strings.TrimSpace(c.Text)
+			continue
+		}
+		if array := c.Nodes[0]; array.XMLName.Space == rdfNS && isArray(array.XMLName.Local) {
+			items := make([]string, 0, len(array.Nodes))
+			for _, li := range array.Nodes {
+				if len(li.Nodes) == 0 {
+					items = append(items, strings.TrimSpace(li.Text))
+				}
+			}
+			res[name(prefixes, c.XMLName)] = items
+		}
+	}
+}
+
+func isArray(local string) bool {
+	return local == "Bag" || local == "Seq" || local == "Alt"
+}
+
+func name(prefixes map[string]string, n xml.Name) string {
+	if prefix, ok := prefixes[n.Space]; ok {
+		return prefix + ":" + n.Local
+	}
+	return n.Space + n.Local
+}
+
+// Marshal returns the packet as an XMP sidecar file, with the rating, favorite, label, title, description, keywords,
+// creator and rights of the packet. Other properties are not written.
+func Marshal(p *Packet) []byte {
+	var b bytes.Buffer
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"" + rdfNS + "\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"\n")
+	b.WriteString("    xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	b.WriteString("    xmlns:rawninja=\"" + appNS + "\"")
+	if p.Rating != nil {
+		b.WriteString("\n    xmp:Rating=\"" + strconv.Itoa(*p.Rating) + "\"")
+	}
+	if p.Favorite != nil {
+		b.WriteString("\n    rawninja:Favorite=\"" + strconv.FormatBool(*p.Favorite) + "\"")
+	}
+	if len(p.Label) > 0 {
+		b.WriteString("\n    xmp:Label=\"" + escape(p.Label) + "\"")
+	}
+	b.WriteString(">\n")
+	writeArray(&b, "dc:title", "Alt", p.Title)
+	writeArray(&b, "dc:description", "Alt", p.Description)
+	writeArray(&b, "dc:subject", "Bag", p.Keywords...)
+	writeArray(&b, "dc:creator", "Seq", p.Creator)
+	writeArray(&b, "dc:rights", "Alt", p.Rights)
+	b.WriteString("  </rdf:Description>\n")
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>\n")
+	return b.Bytes()
+}
+
+// writeArray writes an array property, language alternatives in the default language. Empty items are left out, the
+// property is left out without items.
+func writeArray(b *bytes.Buffer, name string, kind string, items ...string) {
+	var lis []string
+	for _, item := range items {
+		if len(item) == 0 {
+			continue
+		}
+		if kind == "Alt" {
+			lis = append(lis, "<rdf:li xml:lang=\"x-default\">"+escape(item)+"</rdf:li>")
+		} else {
+			lis = append(lis, "<rdf:li>"+escape(item)+"</rdf:li>")
+		}
+	}
+	if len(lis) == 0 {
+		return
+	}
+	b.WriteString("   <" + name + "><rdf:" + kind + ">" + strings.Join(lis, "") + "</rdf:" + kind + "></" + name + ">\n")
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package xmp
+
+import (
+	"slices"
+	"testing"
+)
+
+const sidecar = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:crs="http://ns.adobe.com/camera-raw-settings/1.0/"
+    xmp:Rating="4"
+    xmp:Label="Red"
+    crs:Exposure2012="+0.50">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Sunset</rdf:li></rdf:Alt></dc:title>
+   <dc:subject><rdf:Bag><rdf:li>beach</rdf:li><rdf:li>sea</rdf:li></rdf:Bag></dc:subject>
+   <dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>
+   <dc:description>
+    <rdf:Alt><rdf:li xml:lang="x-default">Sunset at the beach</rdf:li></rdf:Alt>
+   </dc:description>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]byte(sidecar))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if p.Rating == nil || *p.Rating != 4 || p.Favorite != nil || p.Label != "Red" || p.Title != "Sunset" || p.Description != "Sunset at the beach" || p.Creator != "Jane Doe" {
+		t.Errorf("Parse = %+v; want the properties of the sidecar", p)
+	}
+	if !slices.Equal(p.Keywords, []string{"beach", "sea"}) {
+		t.Errorf("Keywords = %v; want [beach sea]", p.Keywords)
+	}
+	if p.Properties["crs:Exposure2012"] != "+0.50" {
+		t.Errorf("Property crs:Exposure2012 = %v; want +0.50", p.Properties["crs:Exposure2012"])
+	}
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "embedded", in: "\xff\xd8binary" + sidecar + "binary", want: sidecar[len(`<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>`)+1 : len(sidecar)-len(`<?xpacket end="w"?>`)-1]},
+		{name: "missing", in: "\xff\xd8binary", want: ""},
+		{name: "truncated", in: "binary<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := string(Extract([]byte(tt.in))); actual != tt.want {
+				t.Errorf("Extract = %v; want %v", actual, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	var (
+		rating   = 3
+		favorite = true
+		in       = &Packet{Rating: &rating, Favorite: &favorite, Label: "Green", Title: "Rock & roll", Keywords: []string{"music", "<live>"}}
+	)
+	out, err := Parse(Marshal(in))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if out.Rating == nil || *out.Rating != rating || out.Favorite == nil || !*out.Favorite {
+		t.Errorf("Rating and favorite = %v, %v; want %v, %v", out.Rating, out.Favorite, rating, favorite)
+	}
+	if out.Label != in.Label || out.Title != in.Title || len(out.Description) > 0 {
+		t.Errorf("Marshal = %+v; want %+v", out, in)
+	}
+	if !slices.Equal(out.Keywords, in.Keywords) {
+		t.Errorf("Keywords = %v; want %v", out.Keywords, in.Keywords)
+	}
+}
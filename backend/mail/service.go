@@ -16,12 +16,16 @@ var ct string
 //go:embed "passwordreset.html"
 var pt string
 
+//go:embed "takeout.html"
+var tt string
+
 // Service is a struct for a service sending mails for our users.
 type Service struct {
 	config       *common.MailConfig
 	dialer       *mail.Dialer
 	confirmation *template.Template
 	pwdReset     *template.Template
+	takeout      *template.Template
 }
 
 // NewService create a new `Service` entity based on the configuration.
@@ -35,6 +39,7 @@ func NewService(config *common.MailConfig) *Service {
 		dialer:       mail.NewDialer(config.SMTPAddress, config.SMTPPort, config.SMTPUser, config.SMTPPassword),
 		confirmation: mustLoadTemplate(ct),
 		pwdReset:     mustLoadTemplate(pt),
+		takeout:      mustLoadTemplate(tt),
 	}
 }
 
@@ -48,6 +53,7 @@ func mustLoadTemplate(tpl string) *template.Template {
 
 type templateData struct {
 	Link string
+	Days int
 }
 
 // Send is a method of `Service` sends an e-mail to the recipient email address with the subject and body provided as parameters
@@ -85,3 +91,13 @@ func (s *Service) PasswordReset(recipient string, resetURL string) error {
 	}
 	return s.send(recipient, "Password Reset", c.String())
 }
+
+// Takeout is a method of `Service` sends the download link of an account takeout, expiring after the days provided,
+// to the recipient email address
+func (s *Service) Takeout(recipient string, downloadURL string, days int) error {
+	var c bytes.Buffer
+	if err := s.takeout.Execute(&c, templateData{Link: downloadURL, Days: days}); err != nil {
+		return err
+	}
+	return s.send(recipient, "Your Data Export", c.String())
+}
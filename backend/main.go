@@ -12,6 +12,30 @@ The flags are:
 	    --migrate [=true/false]
 	        When provided the application initiates a database migration
 			before starting the application. Default value is false.
+	    --reencrypt [=true/false]
+	        When provided the application re-wraps the data keys of all
+			stored images with the active master key after a key rotation,
+			and encrypts images stored before encryption was enabled while
+			IMG_STORE_ENCRYPTION_PLAINTEXT is set, before starting the
+			application. Default value is false.
+	    --scrub [=true/false]
+	        When provided the application verifies the image store against
+			the database and repairs the findings, before starting the
+			application. Default value is false.
+	    --dry-run [=true/false]
+	        When provided with --scrub, findings are reported only, nothing
+			is repaired. Default value is false.
+	    --resync [replica]
+	        When provided the application copies the images missing or
+			different on the replica of the image store, named by the path
+			of its config file, and deletes the images missing on the
+			primary, before starting the application. Other instances of
+			the application can keep running, images written to the
+			replica during the resync are kept. Default value is "".
+	    --migrate-storage [config file]
+	        When provided the application copies the images of all photos
+			to the image store configured in the env file, before starting
+			the application. Default value is "".
 	    --application [=true/false]
 	        Starts the web application for the photostorage. Default value
 			is true.
@@ -36,6 +60,11 @@ import (
 func main() {
 	var (
 		isMigration = flag.Bool("migrate", false, "Start migration of the database. Default: [false]")
+		reencrypt   = flag.Bool("reencrypt", false, "Re-wrap data keys of stored images with the active master key, encrypting plaintext images while IMG_STORE_ENCRYPTION_PLAINTEXT is set. Default: [false]")
+		scrub       = flag.Bool("scrub", false, "Verify and repair the image store against the database. Default: [false]")
+		dryRun      = flag.Bool("dry-run", false, "Report the findings of the scrub without repairing. Default: [false]")
+		migrateTo   = flag.String("migrate-storage", "", "Copy all images to the image store with the config file. Default: []")
+		resync      = flag.String("resync", "", "Resync the replica of the image store with the config file. Default: []")
 		application = flag.Bool("application", true, "Start the web application on the provided port. Default: [true].")
 		config      = flag.String("config", ".", "Path of the configuration folder where the app.env file is. Default: [.]")
 	)
@@ -44,6 +73,18 @@ func main() {
 	if *isMigration {
 		app.Migrate(*config)
 	}
+	if *reencrypt {
+		app.Reencrypt(*config)
+	}
+	if *scrub {
+		app.Scrub(*config, *dryRun)
+	}
+	if len(*migrateTo) > 0 {
+		app.MigrateStorage(*config, *migrateTo)
+	}
+	if len(*resync) > 0 {
+		app.Resync(*config, *resync)
+	}
 	if *application {
 		app.App(*config)
 	}
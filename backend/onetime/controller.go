@@ -85,6 +85,7 @@ func (c Controller) Create(g *gin.Context) {
 // @Produce json
 // @Param id path int true "one time access ID of the RAW photo to download"
 // @Success 200 {array} byte
+// @Success 206 {array} byte
 // @Failure 404 {object} common.StatusMessage
 // @Failure 500 {object} common.StatusMessage
 // @Router /onetime/raw/:id [get]
@@ -107,13 +108,56 @@ func (c Controller) Raw(g *gin.Context) {
 		return
 	}
 
-	raw, err := c.images.LoadImage(access.OriginalID.String())
+	raw, err := image.OpenContent(c.images, access.OriginalID.String(), image.RawVariant)
 	if err != nil {
 		g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Resource not found or expired!"})
 		return
 	}
+	defer raw.Close()
 
 	g.Header("Content-Description", "File Transfer")
 	g.Header("Content-Disposition", "attachment; filename=edited")
-	g.Data(http.StatusOK, "application/octet-stream", raw)
+	g.Header("Content-Type", "application/octet-stream")
+	g.Header("Cache-Control", "private, no-cache")
+	raw.Serve(g.Writer, g.Request)
+}
+
+// Archive is a method of `Controller`. Handles requests for downloading a generated archive, e.g. the takeout of an
+// account, via one time access. The link is valid until the TTL of the access.
+// @Summary Download archive endpoint via one time access
+// @Schemes
+// @Tags account
+// @Description Returns the ZIP archive for the provided one time access ID
+// @Produce application/zip
+// @Param id path string true "one time access ID of the archive to download"
+// @Success 200 {array} byte
+// @Success 206 {array} byte
+// @Failure 400 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Router /onetime/archive/:id [get]
+func (c Controller) Archive(g *gin.Context) {
+	id, err := uuid.Parse(g.Param("id"))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid identifier!"})
+		return
+	}
+
+	access, err := c.accesses.ByID(id)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Resource not found or expired!"})
+		return
+	}
+
+	archive, err := image.OpenContent(c.images, access.OriginalID.String(), image.ArchiveVariant)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Resource not found or expired!"})
+		return
+	}
+	defer archive.Close()
+
+	g.Header("Content-Description", "File Transfer")
+	g.Header("Content-Disposition", "attachment; filename=rawninja-takeout.zip")
+	g.Header("Content-Type", "application/zip")
+	g.Header("Cache-Control", "private, no-cache")
+	archive.Serve(g.Writer, g.Request)
 }
@@ -2,17 +2,25 @@ package photo
 
 import (
 	"errors"
+	"mime"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/inokone/photostorage/auth/user"
 	"github.com/inokone/photostorage/common"
 	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/ruleset/rule"
 
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	// thumbnailCacheControl lets browsers cache thumbnails for a day, revalidating with the entity tag afterwards
+	thumbnailCacheControl = "private, max-age=86400"
+)
+
 var (
 	statusNotFound       = common.StatusMessage{Code: 404, Message: "Photo does not exist!"}
 	statusMalformedPhoto = common.StatusMessage{Code: 400, Message: "Malformed photo data!"}
@@ -27,16 +35,21 @@ type Controller struct {
 	cfg    *common.ImageStoreConfig
 	s      UploadService
 	l      LoadService
+	t      TierService
+	r      RenderService
 }
 
-// NewController creates a new `Controller` instance based on the photo persistence provided in the parameter.
-func NewController(photos Storer, images image.Storer, cfg *common.ImageStoreConfig) Controller {
+// NewController creates a new `Controller` instance based on the photo persistence provided in the parameter,
+// creating renditions with the resampling.
+func NewController(photos Storer, images image.Storer, cfg *common.ImageStoreConfig, resampling image.Resampling) Controller {
 	return Controller{
 		photos: photos,
 		images: images,
 		cfg:    cfg,
-		s:      *NewUploadService(photos, images, cfg),
+		s:      *NewUploadService(photos, images, nil, cfg, resampling),
 		l:      *NewLoadService(photos, images, cfg),
+		t:      *NewTierService(photos, images),
+		r:      *NewRenderService(photos, images, resampling),
 	}
 }
 
@@ -85,6 +98,173 @@ func (c Controller) List(g *gin.Context) {
 	g.JSON(http.StatusOK, imgs)
 }
 
+// Similar is a method of `Controller`. Handles requests for groups of near-duplicate photos of the authenticated
+// user, e.g. bursts and brackets, so the best photo of each group can be kept and the rest deleted.
+// @Summary Similar photos endpoint
+// @Schemes
+// @Tags photos
+// @Description Returns groups of photos with similar thumbnails captured close to each other
+// @Produce json
+// @Param distance query int false "Maximal distance of the perceptual hashes of the thumbnails, 0-64, default 10"
+// @Param window query int false "Maximal time between the capture of the photos in seconds, default 10"
+// @Success 200 {array} photo.SimilarGroup
+// @Failure 400 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /photos/similar [get]
+func (c Controller) Similar(g *gin.Context) {
+	var (
+		req      SimilarRequest
+		distance = defaultSimilarDistance
+		window   = defaultSimilarWindow
+		protocol string
+	)
+	if err := g.ShouldBindQuery(&req); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Malformed similarity parameters!"})
+		return
+	}
+	if req.Distance != nil {
+		distance = *req.Distance
+	}
+	if req.Window != nil {
+		window = time.Duration(*req.Window) * time.Second
+	}
+
+	usr, err := currentUser(g)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Error with the session. Please log in again!"})
+		return
+	}
+	photos, err := c.photos.All(usr.ID.String())
+	if err != nil {
+		log.Err(err).Msg("Failed to load photos!")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Failed to collect images!"})
+		return
+	}
+
+	protocol = "http"
+	if g.Request.TLS != nil {
+		protocol = "https"
+	}
+	baseURL := protocol + "://" + g.Request.Host + "/api/v1/photos/"
+	groups := Cluster(photos, distance, window)
+	res := make([]SimilarGroup, len(groups))
+	for i, group := range groups {
+		res[i].Best = best(group).ID.String()
+		if res[i].Photos, err = c.l.AsResponse(group, baseURL); err != nil {
+			g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Failed to collect images!"})
+			return
+		}
+	}
+	g.JSON(http.StatusOK, res)
+}
+
+// Map is a method of `Controller`. Handles requests for the geotagged photos of the authenticated user within a
+// bounding box on the map, latest captured first.
+// @Summary Photos on map endpoint
+// @Schemes
+// @Tags photos
+// @Description Returns the geotagged photo descriptors within the bounding box
+// @Produce json
+// @Param north query number true "North of the bounding box, latitude"
+// @Param south query number true "South of the bounding box, latitude"
+// @Param east query number true "East of the bounding box, longitude"
+// @Param west query number true "West of the bounding box, longitude, greater than east if the box spans the antimeridian"
+// @Param limit query int false "Maximal number of photos, 1-1000, default 500"
+// @Success 200 {array} photo.Response
+// @Failure 400 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /photos/map [get]
+func (c Controller) Map(g *gin.Context) {
+	var (
+		req    MapRequest
+		bounds Bounds
+		limit  = defaultMapLimit
+		err    error
+	)
+	if err = g.ShouldBindQuery(&req); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.ValidationMessage(err))
+		return
+	}
+	if bounds, err = req.AsBounds(); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid bounding box!"})
+		return
+	}
+	if req.Limit > 0 {
+		limit = req.Limit
+	}
+
+	usr, err := currentUser(g)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Error with the session. Please log in again!"})
+		return
+	}
+	photos, err := c.photos.InBounds(usr.ID.String(), bounds, limit)
+	if err != nil {
+		log.Err(err).Msg("Failed to load photos on map!")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Failed to collect images!"})
+		return
+	}
+
+	protocol := "http"
+	if g.Request.TLS != nil {
+		protocol = "https"
+	}
+	imgs, err := c.l.AsResponse(photos, protocol+"://"+g.Request.Host+"/api/v1/photos/")
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Failed to collect images!"})
+		return
+	}
+	g.JSON(http.StatusOK, imgs)
+}
+
+// Clusters is a method of `Controller`. Handles requests for the map markers of the geotagged photos of the
+// authenticated user within a bounding box, the photos are clustered by the zoom level of the map.
+// @Summary Photo markers on map endpoint
+// @Schemes
+// @Tags photos
+// @Description Returns the clustered map markers of the geotagged photos within the bounding box
+// @Produce json
+// @Param north query number true "North of the bounding box, latitude"
+// @Param south query number true "South of the bounding box, latitude"
+// @Param east query number true "East of the bounding box, longitude"
+// @Param west query number true "West of the bounding box, longitude, greater than east if the box spans the antimeridian"
+// @Param zoom query int false "Zoom level of the map, 0-22, default 0"
+// @Success 200 {array} photo.MapCluster
+// @Failure 400 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /photos/map/clusters [get]
+func (c Controller) Clusters(g *gin.Context) {
+	var (
+		req    ClusterRequest
+		bounds Bounds
+		err    error
+	)
+	if err = g.ShouldBindQuery(&req); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.ValidationMessage(err))
+		return
+	}
+	if bounds, err = req.AsBounds(); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid bounding box!"})
+		return
+	}
+
+	usr, err := currentUser(g)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Error with the session. Please log in again!"})
+		return
+	}
+	clusters, err := c.photos.Clusters(usr.ID.String(), bounds, cellSize(req.Zoom))
+	if err != nil {
+		log.Err(err).Msg("Failed to cluster photos on map!")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Failed to collect map markers!"})
+		return
+	}
+	if clusters == nil {
+		clusters = []MapCluster{}
+	}
+	g.JSON(http.StatusOK, clusters)
+}
+
 // Get is a method of `Controller`. Handles requests for retrieving metadata for a single photo or RAW file
 // of the authenticated user. The target photo is specified by the photo ID in the URL parameter.
 // @Summary Get photo endpoint
@@ -207,9 +387,213 @@ func (c Controller) Delete(g *gin.Context) {
 		g.AbortWithStatusJSON(http.StatusInternalServerError, statusNotFound)
 		return
 	}
+	if err = c.r.Invalidate(id); err != nil {
+		log.Err(err).Str("id", id).Msg("Failed to delete cached renders of photo")
+	}
 	g.JSON(http.StatusOK, common.StatusMessage{Code: 200, Message: "Photo deleted!"})
 }
 
+// Move is a method of `Controller`. Handles requests for moving a single photo or RAW file of the authenticated user
+// to another storage. The target photo specified by the photo ID in the URL parameter.
+// @Summary Move photo to storage endpoint
+// @Schemes
+// @Tags photos
+// @Description Moves the RAW file with the provided ID to standard or frozen storage
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the photo to move"
+// @Param data body photo.MoveRequest true "The target storage of the photo"
+// @Success 200 {object} common.StatusMessage
+// @Success 202 {object} common.StatusMessage
+// @Failure 400 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Failure 409 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /photos/:id/tier [put]
+func (c Controller) Move(g *gin.Context) {
+	var (
+		id     = g.Param("id")
+		req    MoveRequest
+		target rule.Target
+	)
+	persisted, err := c.photos.Load(id)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	if err = authorize(g, persisted.UserID); err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	if err = g.ShouldBindJSON(&req); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.ValidationMessage(err))
+		return
+	}
+
+	target, err = rule.TargetFor(req.TargetID)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Unknown target storage!"})
+		return
+	}
+
+	err = c.t.MoveTo(persisted, target)
+	if errors.Is(err, ErrUnsupportedTarget) {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Photos can only be moved to standard or frozen storage!"})
+		return
+	}
+	if errors.Is(err, image.ErrSharedContent) {
+		g.AbortWithStatusJSON(http.StatusConflict, common.StatusMessage{Code: 409, Message: "Photo shares its RAW with other photos, it can not be frozen!"})
+		return
+	}
+	if errors.Is(err, image.ErrRestoreInProgress) {
+		g.JSON(http.StatusAccepted, common.StatusMessage{Code: 202, Message: "Photo is being restored from frozen storage, please check back later!"})
+		return
+	}
+	if err != nil {
+		log.Err(err).Str("id", id).Msg("Failed to move photo")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Failed to move photo!"})
+		return
+	}
+	g.JSON(http.StatusOK, common.StatusMessage{Code: 200, Message: "Photo moved!"})
+}
+
+// SetLocation is a method of `Controller`. Handles requests for setting the location of a single photo of the
+// authenticated user manually, the coordinates are resolved to country, region and city. The target photo specified
+// by the photo ID in the URL parameter.
+// @Summary Set photo location endpoint
+// @Schemes
+// @Tags photos
+// @Description Sets the coordinates of the photo with the provided ID
+// @Accept json
+// @Produce json
+// @Param id path string true "ID of the photo"
+// @Param data body photo.LocationRequest true "The coordinates of the photo"
+// @Success 200 {object} image.Response
+// @Failure 400 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /photos/:id/location [put]
+func (c Controller) SetLocation(g *gin.Context) {
+	var (
+		id  = g.Param("id")
+		req LocationRequest
+	)
+	persisted, err := c.photos.Load(id)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	if err = authorize(g, persisted.UserID); err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	if err = g.ShouldBindJSON(&req); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.ValidationMessage(err))
+		return
+	}
+
+	SetLocation(persisted, req)
+	if err = c.photos.UpdateLocation(persisted); err != nil {
+		log.Err(err).Str("id", id).Msg("Failed to update location of photo")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Failed to update location!"})
+		return
+	}
+	g.JSON(http.StatusOK, persisted.Desc.Metadata.AsResp())
+}
+
+// ClearLocation is a method of `Controller`. Handles requests for removing the coordinates and the location of a
+// single photo of the authenticated user. The target photo specified by the photo ID in the URL parameter.
+// @Summary Clear photo location endpoint
+// @Schemes
+// @Tags photos
+// @Description Removes the coordinates of the photo with the provided ID
+// @Produce json
+// @Param id path string true "ID of the photo"
+// @Success 200 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /photos/:id/location [delete]
+func (c Controller) ClearLocation(g *gin.Context) {
+	id := g.Param("id")
+	persisted, err := c.photos.Load(id)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	if err = authorize(g, persisted.UserID); err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	ClearLocation(persisted)
+	if err = c.photos.UpdateLocation(persisted); err != nil {
+		log.Err(err).Str("id", id).Msg("Failed to clear location of photo")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Failed to clear location!"})
+		return
+	}
+	g.JSON(http.StatusOK, common.StatusMessage{Code: 200, Message: "Location cleared!"})
+}
+
+// Rotate is a method of `Controller`. Handles requests for rotating a single photo of the authenticated user by a
+// multiple of 90 degrees. The thumbnail and previews are regenerated, the RAW is kept as uploaded. The target photo
+// specified by the photo ID in the URL parameter.
+// @Summary Rotate photo endpoint
+// @Schemes
+// @Tags photos
+// @Description Rotates the previews of the photo with the provided ID clockwise
+// @Accept json
+// @Produce json
+// @Param id path string true "ID of the photo to rotate"
+// @Param data body photo.RotateRequest true "The rotation in degrees clockwise, negative for counterclockwise"
+// @Success 200 {object} common.StatusMessage
+// @Failure 400 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Failure 409 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /photos/:id/rotate [put]
+func (c Controller) Rotate(g *gin.Context) {
+	var (
+		id  = g.Param("id")
+		req RotateRequest
+	)
+	persisted, err := c.photos.Load(id)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	if err = authorize(g, persisted.UserID); err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	if err = g.ShouldBindJSON(&req); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.ValidationMessage(err))
+		return
+	}
+
+	err = c.r.Rotate(persisted, req.Degrees)
+	if errors.Is(err, image.ErrInvalidRotation) {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Photos can only be rotated by a multiple of 90 degrees!"})
+		return
+	}
+	if errors.Is(err, ErrFrozenPhoto) {
+		g.AbortWithStatusJSON(http.StatusConflict, common.StatusMessage{Code: 409, Message: "Photo is in frozen storage, move it to standard storage first!"})
+		return
+	}
+	if err != nil {
+		log.Err(err).Str("id", id).Msg("Failed to rotate photo")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Photo could not be rotated!"})
+		return
+	}
+	g.JSON(http.StatusOK, common.StatusMessage{Code: 200, Message: "Photo rotated!"})
+}
+
 func applyChange(persisted *Photo, newVersion Response) error {
 	if persisted.ID.String() != newVersion.ID {
 		return ErrMalformedRequest
@@ -217,6 +601,8 @@ func applyChange(persisted *Photo, newVersion Response) error {
 	persisted.Desc.Tags = newVersion.Desc.Tags
 	persisted.Desc.Favorite = newVersion.Desc.Favorite
 	persisted.Desc.Rating = newVersion.Desc.Rating
+	persisted.Desc.Label = newVersion.Desc.Label
+	persisted.Desc.Title = newVersion.Desc.Title
 	return nil
 }
 
@@ -230,7 +616,9 @@ func applyChange(persisted *Photo, newVersion Response) error {
 // @Produce json
 // @Param id path int true "ID of the RAW photo to download"
 // @Success 200 {array} byte
+// @Success 206 {array} byte
 // @Failure 404 {object} common.StatusMessage
+// @Failure 409 {object} common.StatusMessage
 // @Failure 500 {object} common.StatusMessage
 // @Router /photos/:id/raw [get]
 func (c Controller) Raw(g *gin.Context) {
@@ -246,16 +634,54 @@ func (c Controller) Raw(g *gin.Context) {
 		return
 	}
 
+	if img.Tier == image.FrozenTier {
+		g.AbortWithStatusJSON(http.StatusConflict, common.StatusMessage{Code: 409, Message: "Photo is in frozen storage, move it to standard storage first!"})
+		return
+	}
+
 	fileName := img.Desc.FileName
-	raw, err := c.images.LoadImage(id)
+	raw, err := image.OpenContent(c.images, id, image.RawVariant)
 	if err != nil {
 		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
 		return
 	}
+	defer raw.Close()
 
 	g.Header("Content-Description", "File Transfer")
 	g.Header("Content-Disposition", "attachment; filename="+fileName)
-	g.Data(http.StatusOK, "application/octet-stream", raw)
+	g.Header("Content-Type", "application/octet-stream")
+	g.Header("Cache-Control", "private, no-cache")
+	raw.Serve(g.Writer, g.Request)
+}
+
+// Xmp is a method of `Controller`. Handles requests for the XMP sidecar of a single photo of the authenticated user,
+// reflecting the current tags, rating, favorite, color label and title, to be placed next to the RAW for desktop
+// editors. The target photo specified by the photo ID in the URL parameter.
+// @Summary Download XMP sidecar endpoint
+// @Schemes
+// @Tags photos
+// @Description Returns the XMP sidecar for the provided ID
+// @Produce application/rdf+xml
+// @Param id path string true "ID of the photo"
+// @Success 200 {array} byte
+// @Failure 404 {object} common.StatusMessage
+// @Router /photos/:id/xmp [get]
+func (c Controller) Xmp(g *gin.Context) {
+	p, err := c.photos.Load(g.Param("id"))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	if err = authorize(g, p.UserID); err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	g.Header("Content-Description", "File Transfer")
+	g.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": SidecarName(p)}))
+	g.Header("Cache-Control", "private, no-cache")
+	g.Data(http.StatusOK, "application/rdf+xml", Sidecar(p))
 }
 
 // Thumbnail is a method of `Controller`. Handles requests for downloding thumbnail binary for a single photo or RAW file of the
@@ -285,15 +711,136 @@ func (c Controller) Thumbnail(g *gin.Context) {
 	}
 
 	fileName := img.Desc.FileName
-	thumbnail, err := c.images.LoadThumbnail(id)
+	thumbnail, err := image.OpenContent(c.images, id, image.ThumbnailVariant)
 	if err != nil {
 		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
 		return
 	}
+	defer thumbnail.Close()
 
 	g.Header("Content-Description", "File Transfer")
 	g.Header("Content-Disposition", "attachment; filename="+fileName)
-	g.Data(http.StatusOK, "application/octet-stream", thumbnail)
+	g.Header("Content-Type", "application/octet-stream")
+	g.Header("Cache-Control", thumbnailCacheControl)
+	thumbnail.Serve(g.Writer, g.Request)
+}
+
+// Preview is a method of `Controller`. Handles requests for downloding a preview of a given size for a single photo
+// of the authenticated user. The target photo specified by the photo ID, the size by the size name in the URL parameters.
+// Photos uploaded before the size was introduced have no such preview, the thumbnail is returned for them.
+// @Summary Preview image endpoint
+// @Schemes
+// @Tags photos
+// @Description Returns the preview of the given size (small, thumbnail, medium) for the provided ID
+// @Accept json
+// @Produce jpeg
+// @Param id path int true "ID of the photo"
+// @Param size path string true "Size of the preview"
+// @Success 200 {array} byte
+// @Success 206 {array} byte
+// @Failure 400 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /photos/:id/preview/:size [get]
+func (c Controller) Preview(g *gin.Context) {
+	id := g.Param("id")
+	variant, err := image.VariantFor(g.Param("size"))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Unknown preview size!"})
+		return
+	}
+
+	img, err := c.photos.Load(id)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	if err = authorize(g, img.UserID); err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	preview, err := image.OpenContent(c.images, id, variant)
+	if err != nil {
+		variant = image.ThumbnailVariant
+		preview, err = image.OpenContent(c.images, id, variant)
+	}
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+	defer preview.Close()
+
+	g.Header("Content-Type", variant.ContentType())
+	g.Header("Cache-Control", thumbnailCacheControl)
+	preview.Serve(g.Writer, g.Request)
+}
+
+// Render is a method of `Controller`. Handles requests for rendering a single photo of the authenticated user resized
+// and cropped, in JPEG or WebP format. The target photo specified by the photo ID in the URL parameter, the transform by
+// the query parameters. Renders are cached, so repeated requests are served from the image store.
+// @Summary Render photo endpoint
+// @Schemes
+// @Tags photos
+// @Description Returns the photo with the provided ID resized to the requested width and height
+// @Accept json
+// @Produce jpeg
+// @Param id path int true "ID of the photo to render"
+// @Param w query int false "Width of the render rounded up to 64, 128, 256, 512, 1024, 2048 or 4096, calculated from the aspect ratio if missing"
+// @Param h query int false "Height of the render rounded up to 64, 128, 256, 512, 1024, 2048 or 4096, calculated from the aspect ratio if missing"
+// @Param fit query string false "Fit of the render: contain (default), cover or fill"
+// @Param format query string false "Format of the render: jpeg (default) or webp"
+// @Param quality query int false "Quality of the render between 1 and 100 rounded to 60, 75, 85 or 95, 85 by default"
+// @Success 200 {array} byte
+// @Success 206 {array} byte
+// @Failure 400 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Failure 409 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /photos/:id/render [get]
+func (c Controller) Render(g *gin.Context) {
+	var (
+		id  = g.Param("id")
+		req RenderRequest
+		t   image.Transform
+		err error
+	)
+	if err = g.ShouldBindQuery(&req); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Malformed render parameters!"})
+		return
+	}
+	if t, err = req.AsTransform().Normalize(); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid render parameters!"})
+		return
+	}
+
+	img, err := c.photos.Load(id)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	if err = authorize(g, img.UserID); err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, statusNotFound)
+		return
+	}
+
+	render, variant, err := c.r.Render(img, t)
+	if errors.Is(err, ErrFrozenPhoto) {
+		g.AbortWithStatusJSON(http.StatusConflict, common.StatusMessage{Code: 409, Message: "Photo is in frozen storage, move it to standard storage first!"})
+		return
+	}
+	if err != nil {
+		log.Err(err).Str("id", id).Msg("Failed to render photo")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Photo could not be rendered!"})
+		return
+	}
+	defer render.Close()
+
+	g.Header("Content-Type", variant.ContentType())
+	g.Header("Cache-Control", thumbnailCacheControl)
+	render.Serve(g.Writer, g.Request)
 }
 
 func authorize(g *gin.Context, userID uuid.UUID) error {
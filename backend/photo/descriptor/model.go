@@ -27,10 +27,14 @@ type Descriptor struct {
 	Tags        pq.StringArray `gorm:"type:text[]"`
 	Favorite    bool           `gorm:"index"`
 	Rating      int8           `gorm:"index"`
+	Label       string         `gorm:"type:varchar(32);index"` // color label, e.g. `Red`
+	Title       string         `gorm:"type:varchar(255)"`
 	Metadata    img.Metadata   `gorm:"foreignKey:MetadataID"`
 	MetadataID  uuid.UUID
 	ThumbWidth  int
 	ThumbHeight int
+	Rotation    int    // rotation by the user in degrees clockwise on top of the orientation of the camera
+	DHash       *int64 `gorm:"index"` // perceptual hash of the thumbnail, see `image.DHash`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 	DeletedAt   gorm.DeletedAt
@@ -48,7 +52,10 @@ func (p Descriptor) AsResp() Response {
 		Favorite:    p.Favorite,
 		ThumbWidth:  p.ThumbWidth,
 		ThumbHeight: p.ThumbHeight,
+		Rotation:    p.Rotation,
 		Rating:      p.Rating,
+		Label:       p.Label,
+		Title:       p.Title,
 	}
 }
 
@@ -61,8 +68,11 @@ type Response struct {
 	Thumbnail   string       `json:"thumbnail"`
 	ThumbWidth  int          `json:"thumbnail_width"`
 	ThumbHeight int          `json:"thumbnail_height"`
+	Rotation    int          `json:"rotation"`
 	Metadata    img.Response `json:"metadata"`
 	Tags        []string     `json:"tags"`
 	Favorite    bool         `json:"favorite"`
 	Rating      int8         `json:"rating"`
+	Label       string       `json:"label" binding:"max=32"`
+	Title       string       `json:"title" binding:"max=255"`
 }
@@ -0,0 +1,86 @@
+package photo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobStorer is an interface for persisting `UploadJob`s of asynchronous uploads.
+type JobStorer interface {
+	Store(job *UploadJob) error
+	Load(id uuid.UUID) (*UploadJob, error)
+	Update(job *UploadJob) error
+	Batch(id uuid.UUID) ([]UploadJob, error)
+	Claim(stale time.Time) (*UploadJob, error)
+	Purge(before time.Time) (int64, error)
+}
+
+// GORMJobStorer is an implementation of `JobStorer` interface based on GORM library.
+type GORMJobStorer struct {
+	db *gorm.DB
+}
+
+// NewGORMJobStorer creates a new `GORMJobStorer` instance based on the GORM library.
+func NewGORMJobStorer(db *gorm.DB) *GORMJobStorer {
+	return &GORMJobStorer{db: db}
+}
+
+// Store is a method of `GORMJobStorer` for persisting an `UploadJob`.
+func (s *GORMJobStorer) Store(job *UploadJob) error {
+	return s.db.Create(job).Error
+}
+
+// Load is a method of `GORMJobStorer` for loading an `UploadJob` by ID.
+func (s *GORMJobStorer) Load(id uuid.UUID) (*UploadJob, error) {
+	var job UploadJob
+	result := s.db.First(&job, "id = ?", id)
+	return &job, result.Error
+}
+
+// Update is a method of `GORMJobStorer` for persisting the status and the error of an `UploadJob`.
+func (s *GORMJobStorer) Update(job *UploadJob) error {
+	return s.db.Model(job).Select("status", "error", "duplicate_of", "skipped", "updated_at").Updates(job).Error
+}
+
+// Batch is a method of `GORMJobStorer` for loading the `UploadJob`s of a batch, in the order they were stored.
+func (s *GORMJobStorer) Batch(id uuid.UUID) ([]UploadJob, error) {
+	var jobs []UploadJob
+	result := s.db.Where("batch_id = ?", id).Order("created_at").Find(&jobs)
+	return jobs, result.Error
+}
+
+// Claim is a method of `GORMJobStorer` for taking the oldest pending `UploadJob` for processing. Jobs stuck in
+// processing since before the stale time, e.g. because the application stopped, are claimed again. Jobs locked by
+// other instances of the application are skipped. Returns nil if there is no job to process.
+func (s *GORMJobStorer) Claim(stale time.Time) (*UploadJob, error) {
+	var job UploadJob
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? OR (status = ? AND updated_at < ?)", JobPending, JobProcessing, stale).
+			Order("created_at").
+			First(&job)
+		if result.Error != nil {
+			return result.Error
+		}
+		job.Status = JobProcessing
+		return tx.Model(&job).Select("status", "updated_at").Updates(&job).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Purge is a method of `GORMJobStorer` for deleting the finished `UploadJob`s updated before the time. Returns the
+// number of jobs deleted.
+func (s *GORMJobStorer) Purge(before time.Time) (int64, error) {
+	result := s.db.Where("status IN ? AND updated_at < ?", []JobStatus{JobDone, JobFailed}, before).Delete(&UploadJob{})
+	return result.RowsAffected, result.Error
+}
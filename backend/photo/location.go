@@ -0,0 +1,56 @@
+package photo
+
+import (
+	"errors"
+
+	"github.com/inokone/photostorage/geo"
+	"github.com/inokone/photostorage/image"
+)
+
+const (
+	// defaultMapLimit is the default number of photos returned for a bounding box
+	defaultMapLimit = 500
+	// markersPerTile is the number of map markers along the side of a 256 pixel map tile, photos are clustered in
+	// cells of the map grid of this density at the zoom level of the map
+	markersPerTile = 4
+)
+
+// ErrInvalidBounds is an error for bounding boxes with the south of the box north of its north
+var ErrInvalidBounds = errors.New("south of bounding box is north of its north")
+
+// locate resolves the GPS coordinates of the metadata to the country, region and city with the gazetteer of the
+// application. The location is cleared if the image is not geotagged or there is no known place nearby.
+func locate(m *image.Metadata) {
+	m.Location = image.Location{}
+	if m.GPS.Latitude == nil || m.GPS.Longitude == nil {
+		return
+	}
+	if p, ok := geo.Default().Reverse(*m.GPS.Latitude, *m.GPS.Longitude); ok {
+		m.Location = image.Location{
+			CountryCode: p.CountryCode,
+			Country:     p.Country,
+			Region:      p.Region,
+			City:        p.Name,
+		}
+	}
+}
+
+// SetLocation sets the coordinates of the photo manually, e.g. for photos of cameras without GPS, and resolves them
+// to the country, region and city. The photo is not persisted.
+func SetLocation(p *Photo, req LocationRequest) {
+	p.Desc.Metadata.GPS = image.GPS{Latitude: req.Latitude, Longitude: req.Longitude, Altitude: req.Altitude}
+	locate(&p.Desc.Metadata)
+}
+
+// ClearLocation removes the coordinates and the location of the photo, e.g. to hide where it was taken. The photo
+// is not persisted.
+func ClearLocation(p *Photo) {
+	p.Desc.Metadata.GPS = image.GPS{}
+	p.Desc.Metadata.Location = image.Location{}
+}
+
+// cellSize returns the size of the cells of the map grid in degrees at the zoom level. Cells at all zoom levels
+// divide the globe evenly, so no cell spans the antimeridian.
+func cellSize(zoom int) float64 {
+	return 360 / float64(int(1)<<zoom) / markersPerTile
+}
@@ -0,0 +1,67 @@
+package photo
+
+import (
+	"testing"
+
+	"github.com/inokone/photostorage/image"
+)
+
+func TestSetLocation(t *testing.T) {
+	var (
+		lat, long = 47.4979, 19.0402
+		p         = &Photo{}
+	)
+	SetLocation(p, LocationRequest{Latitude: &lat, Longitude: &long})
+	want := image.Location{CountryCode: "HU", Country: "Hungary", Region: "Budapest", City: "Budapest"}
+	if p.Desc.Metadata.Location != want || *p.Desc.Metadata.GPS.Latitude != lat || *p.Desc.Metadata.GPS.Longitude != long {
+		t.Errorf("SetLocation = %+v, %+v; want %+v", p.Desc.Metadata.GPS, p.Desc.Metadata.Location, want)
+	}
+
+	lat, long = 30, -40 // open sea
+	SetLocation(p, LocationRequest{Latitude: &lat, Longitude: &long})
+	if p.Desc.Metadata.Location != (image.Location{}) {
+		t.Errorf("SetLocation = %+v; want empty location", p.Desc.Metadata.Location)
+	}
+
+	ClearLocation(p)
+	if p.Desc.Metadata.GPS != (image.GPS{}) || p.Desc.Metadata.Location != (image.Location{}) {
+		t.Errorf("ClearLocation = %+v, %+v; want empty coordinates and location", p.Desc.Metadata.GPS, p.Desc.Metadata.Location)
+	}
+}
+
+func TestAsBounds(t *testing.T) {
+	north, south, east, west := 10.0, -10.0, -170.0, 170.0
+	tests := []struct {
+		name    string
+		in      BoundsQuery
+		want    Bounds
+		wantErr error
+	}{
+		{name: "antimeridian", in: BoundsQuery{North: &north, South: &south, East: &east, West: &west}, want: Bounds{North: 10, South: -10, East: -170, West: 170}},
+		{name: "upside down", in: BoundsQuery{North: &south, South: &north, East: &east, West: &west}, wantErr: ErrInvalidBounds},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := tt.in.AsBounds()
+			if err != tt.wantErr || actual != tt.want {
+				t.Errorf("AsBounds = %+v, %v; want %+v, %v", actual, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCellSize(t *testing.T) {
+	tests := []struct {
+		zoom int
+		want float64
+	}{
+		{zoom: 0, want: 90},
+		{zoom: 3, want: 11.25},
+		{zoom: 22, want: 360.0 / (1 << 22) / 4},
+	}
+	for _, tt := range tests {
+		if actual := cellSize(tt.zoom); actual != tt.want {
+			t.Errorf("cellSize(%v) = %v; want %v", tt.zoom, actual, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package photo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/inokone/photostorage/image"
+	"github.com/rs/zerolog/log"
+)
+
+const migrationProgressInterval = 10 * time.Second
+
+// ErrChecksumMismatch is an error for images with content different from the checksum recorded or from the source
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// MigrationReport is a summary of a storage migration, updated while the migration is in progress.
+type MigrationReport struct {
+	Total   int64
+	Photos  int64
+	Copied  int64
+	Skipped int64
+	// Frozen is the number of RAWs in frozen storage not copied, as they can not be read until restored
+	Frozen int64
+	Bytes  int64
+	Failed int64
+}
+
+// MigrationService is a service copying the RAWs and renditions of all photos from one image store to another.
+type MigrationService struct {
+	photos  Storer
+	source  image.Storer
+	target  image.Storer
+	workers int
+}
+
+// NewMigrationService creates a `MigrationService` instance copying images from the source to the target storer
+// with the number of concurrent workers.
+func NewMigrationService(photos Storer, source, target image.Storer, workers int) *MigrationService {
+	return &MigrationService{
+		photos:  photos,
+		source:  source,
+		target:  target,
+		workers: workers,
+	}
+}
+
+// Migrate is a method of `MigrationService` copying the RAW and the renditions of every photo to the target. Every
+// copy is read back and verified against the checksum of the source, RAWs also against the checksum recorded at
+// upload. Variants already on the target with the right checksum are skipped, so an interrupted migration can be
+// resumed, and photos uploaded while the migration was running can be copied by running it again before switching
+// to the target. The tier of the photo is applied on the target. RAWs in frozen storage can not be read, they are
+// skipped unless already on the target, and have to be restored before migrating them. Progress is logged
+// periodically.
+func (s MigrationService) Migrate() (*MigrationReport, error) {
+	report := &MigrationReport{}
+	stats, err := s.photos.Stats()
+	if err != nil {
+		return report, err
+	}
+	report.Total = int64(stats.Photos)
+
+	var (
+		jobs = make(chan Photo)
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				s.migrate(&p, report)
+				atomic.AddInt64(&report.Photos, 1)
+			}
+		}()
+	}
+	go s.progress(report, done)
+
+	err = s.photos.Walk(func(p *Photo) error {
+		if p.DeletedAt.Valid {
+			atomic.AddInt64(&report.Photos, 1)
+			return nil
+		}
+		jobs <- *p
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+	close(done)
+	return report, err
+}
+
+func (s MigrationService) progress(report *MigrationReport, done chan struct{}) {
+	ticker := time.NewTicker(migrationProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			log.Info().
+				Int64("photos", atomic.LoadInt64(&report.Photos)).
+				Int64("total", report.Total).
+				Int64("copied", atomic.LoadInt64(&report.Copied)).
+				Int64("skipped", atomic.LoadInt64(&report.Skipped)).
+				Int64("frozen", atomic.LoadInt64(&report.Frozen)).
+				Int64("bytes", atomic.LoadInt64(&report.Bytes)).
+				Int64("failed", atomic.LoadInt64(&report.Failed)).
+				Msg("Storage migration in progress")
+		}
+	}
+}
+
+func (s MigrationService) migrate(p *Photo, report *MigrationReport) {
+	id := p.ID.String()
+	variants := []image.Variant{image.RawVariant}
+	for _, r := range image.Renditions {
+		variants = append(variants, r.Variant)
+	}
+
+	for _, v := range variants {
+		if v == image.RawVariant && p.Tier == image.FrozenTier {
+			s.migrateFrozen(id, report)
+			continue
+		}
+		var expected string
+		if v == image.RawVariant {
+			expected = p.Checksum
+		}
+		copied, size, err := s.copy(id, v, expected)
+		if err != nil {
+			atomic.AddInt64(&report.Failed, 1)
+			log.Err(err).Str("id", id).Str("variant", string(v)).Msg("Failed to migrate image")
+			continue
+		}
+		if !copied {
+			atomic.AddInt64(&report.Skipped, 1)
+			continue
+		}
+		atomic.AddInt64(&report.Copied, 1)
+		atomic.AddInt64(&report.Bytes, size)
+	}
+
+}
+
+// migrateFrozen applies the frozen tier to the RAW of the photo on the target if it was copied before it was frozen,
+// the RAW can not be read from frozen storage otherwise.
+func (s MigrationService) migrateFrozen(id string, report *MigrationReport) {
+	if _, err := s.target.Stat(id, image.RawVariant); err != nil {
+		atomic.AddInt64(&report.Frozen, 1)
+		log.Warn().Str("id", id).Msg("RAW of photo is in frozen storage, restore it and migrate again")
+		return
+	}
+	atomic.AddInt64(&report.Skipped, 1)
+	if err := s.target.MoveTo(id, image.FrozenTier); err != nil {
+		atomic.AddInt64(&report.Failed, 1)
+		log.Err(err).Str("id", id).Msg("Failed to move image to tier on target")
+	}
+}
+
+// copy copies a variant of the image to the target unless it is already there with the expected checksum, returns
+// whether it was copied and the number of bytes copied. An empty expected checksum is calculated from the source.
+func (s MigrationService) copy(id string, variant image.Variant, expected string) (bool, int64, error) {
+	var err error
+	if _, err = s.target.Stat(id, variant); err == nil {
+		if len(expected) == 0 {
+			if expected, err = hash(s.source, id, variant); err != nil {
+				return false, 0, err
+			}
+		}
+		if actual, err := hash(s.target, id, variant); err == nil && actual == expected {
+			return false, 0, nil
+		}
+	}
+
+	r, err := s.source.Open(id, variant)
+	if err != nil {
+		return false, 0, err
+	}
+	defer r.Close()
+	var (
+		h       = sha256.New()
+		counter = &countingReader{r: io.TeeReader(r, h)}
+	)
+	if err = s.target.StoreStream(id, variant, counter); err != nil {
+		return false, 0, err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if len(expected) > 0 && sum != expected {
+		return false, 0, ErrChecksumMismatch // source is corrupt, the scrubber reports it
+	}
+	actual, err := hash(s.target, id, variant)
+	if err != nil {
+		return false, 0, err
+	}
+	if actual != sum {
+		return false, 0, ErrChecksumMismatch
+	}
+	return true, counter.n, nil
+}
+
+func hash(images image.Loader, id string, variant image.Variant) (string, error) {
+	r, err := images.Open(id, variant)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,62 @@
+package photo
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/image"
+)
+
+// migratePhotos is a `Storer` walking the photos provided.
+type migratePhotos struct {
+	Storer
+	photos []Photo
+}
+
+func (s migratePhotos) Stats() (Stats, error) {
+	return Stats{Photos: len(s.photos)}, nil
+}
+
+func (s migratePhotos) Walk(fn func(photo *Photo) error) error {
+	for i := range s.photos {
+		if err := fn(&s.photos[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestMigrateFrozen(t *testing.T) {
+	source, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	target, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	p := Photo{ID: uuid.New(), Tier: image.FrozenTier}
+	id := p.ID.String()
+	if err = source.Store(id, image.RawVariant, []byte("raw")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	for _, r := range image.Renditions {
+		if err = source.Store(id, r.Variant, []byte(r.Size)); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+	if err = source.MoveTo(id, image.FrozenTier); err != nil {
+		t.Fatalf("MoveTo failed: %v", err)
+	}
+
+	report, err := NewMigrationService(migratePhotos{photos: []Photo{p}}, source, target, 1).Migrate()
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if report.Failed != 0 || report.Frozen != 1 || report.Copied != int64(len(image.Renditions)) {
+		t.Errorf("Migrate() = %+v; want 1 frozen, %v copied and no failures", report, len(image.Renditions))
+	}
+	if _, err = target.Stat(id, image.RawVariant); err == nil {
+		t.Errorf("Migrate() copied the frozen RAW")
+	}
+}
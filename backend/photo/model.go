@@ -1,6 +1,7 @@
 package photo
 
 import (
+	"strings"
 	"time"
 
 	"github.com/inokone/photostorage/auth/user"
@@ -11,19 +12,21 @@ import (
 	"github.com/google/uuid"
 )
 
-// Photo is a struct representing a photo object including image, thumbnail and metadata.
+// Photo is a struct representing a photo object including image, renditions and metadata.
 type Photo struct {
-	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
-	Raw       []byte    `gorm:"-"`
-	Thumbnail []byte    `gorm:"-"`
-	UserID    uuid.UUID `gorm:"index"`
-	User      user.User `gorm:"foreignKey:UserID"`
-	DescID    uuid.UUID
-	Desc      descriptor.Descriptor `gorm:"foreignKey:DescID"`
-	UsedSpace int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt
+	ID         uuid.UUID            `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	Raw        []byte               `gorm:"-"`
+	Renditions []image.ThumbnailImg `gorm:"-"`
+	UserID     uuid.UUID            `gorm:"index"`
+	User       user.User            `gorm:"foreignKey:UserID"`
+	DescID     uuid.UUID
+	Desc       descriptor.Descriptor `gorm:"foreignKey:DescID"`
+	UsedSpace  int
+	Checksum   string     `gorm:"type:varchar(64)"`
+	Tier       image.Tier `gorm:"default:1"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	DeletedAt  gorm.DeletedAt
 }
 
 // AsResp is a method of the `Photo` struct. It converts a `Photo` object into a `Response` object.
@@ -32,15 +35,297 @@ func (p Photo) AsResp() Response {
 	return Response{
 		ID:   p.ID.String(),
 		Desc: desc,
+		Tier: p.Tier,
 	}
 }
 
 // Response is the JSON representation of `Photo` when retrieving from the application
 type Response struct {
-	ID        string                  `json:"id"`
-	Desc      descriptor.Response     `json:"descriptor"`
-	Raw       *image.PresignedRequest `json:"raw"`
-	Thumbnail *image.PresignedRequest `json:"thumbnail"`
+	ID        string                             `json:"id"`
+	Desc      descriptor.Response                `json:"descriptor"`
+	Raw       *image.PresignedRequest            `json:"raw"`
+	Thumbnail *image.PresignedRequest            `json:"thumbnail"`
+	Previews  map[string]*image.PresignedRequest `json:"previews"`
+	Tier      image.Tier                         `json:"tier"`
+}
+
+// SimilarRequest is the query of a request for groups of similar photos
+type SimilarRequest struct {
+	Distance *int `form:"distance" binding:"omitempty,min=0,max=64"`
+	Window   *int `form:"window" binding:"omitempty,min=0,max=86400"`
+}
+
+// SimilarGroup is the JSON representation of a group of similar photos, with the ID of the photo suggested to keep
+type SimilarGroup struct {
+	Best   string     `json:"best"`
+	Photos []Response `json:"photos"`
+}
+
+// Bounds is a bounding box on the map. The box spans the antimeridian if west is greater than east.
+type Bounds struct {
+	North float64
+	South float64
+	East  float64
+	West  float64
+}
+
+// BoundsQuery is the query of a bounding box on the map, the box spans the antimeridian if west is greater than east
+type BoundsQuery struct {
+	North *float64 `form:"north" binding:"required,min=-90,max=90"`
+	South *float64 `form:"south" binding:"required,min=-90,max=90"`
+	East  *float64 `form:"east" binding:"required,min=-180,max=180"`
+	West  *float64 `form:"west" binding:"required,min=-180,max=180"`
+}
+
+// AsBounds is a method of the `BoundsQuery` struct. It converts the query into `Bounds`, fails if the south of the
+// box is north of its north.
+func (q BoundsQuery) AsBounds() (Bounds, error) {
+	if *q.South > *q.North {
+		return Bounds{}, ErrInvalidBounds
+	}
+	return Bounds{North: *q.North, South: *q.South, East: *q.East, West: *q.West}, nil
+}
+
+// MapRequest is the query of a request for the geotagged photos in a bounding box
+type MapRequest struct {
+	BoundsQuery
+	Limit int `form:"limit" binding:"omitempty,min=1,max=1000"`
+}
+
+// ClusterRequest is the query of a request for the map markers of the geotagged photos in a bounding box, with the
+// zoom level of the map
+type ClusterRequest struct {
+	BoundsQuery
+	Zoom int `form:"zoom" binding:"min=0,max=22"`
+}
+
+// MapCluster is the JSON representation of a map marker for the geotagged photos of a cell of the map grid, with the
+// ID of the best rated, latest photo of the cell as cover
+type MapCluster struct {
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Count     int       `json:"count"`
+	PhotoID   uuid.UUID `json:"photo_id"`
+}
+
+// LocationRequest is the JSON representation of a request to set the location of a photo manually
+type LocationRequest struct {
+	Latitude  *float64 `json:"latitude" binding:"required,min=-90,max=90"`
+	Longitude *float64 `json:"longitude" binding:"required,min=-180,max=180"`
+	Altitude  *float64 `json:"altitude"`
+}
+
+// RotateRequest is the JSON representation of a request to rotate a photo clockwise, on top of its current rotation
+type RotateRequest struct {
+	Degrees int `json:"degrees" binding:"required"`
+}
+
+// MoveRequest is the JSON representation of a request to move a photo to another storage
+type MoveRequest struct {
+	TargetID int `json:"target_id"`
+}
+
+// RenderRequest is the query of a request to render a photo with a transform
+type RenderRequest struct {
+	Width   int    `form:"w"`
+	Height  int    `form:"h"`
+	Fit     string `form:"fit"`
+	Format  string `form:"format"`
+	Quality int    `form:"quality"`
+}
+
+// AsTransform is a method of the `RenderRequest` struct. It converts the request into an `image.Transform`.
+func (r RenderRequest) AsTransform() image.Transform {
+	return image.Transform{
+		Width:   r.Width,
+		Height:  r.Height,
+		Fit:     image.Fit(r.Fit),
+		Format:  image.Format(r.Format),
+		Quality: r.Quality,
+	}
+}
+
+// UploadTicket is a struct representing a RAW file uploaded to the image store with a presigned request, or to the
+// staging area with a resumable upload, waiting to be finalized into a `Photo` with the same ID. The size is the
+// size of the file declared when the upload was created, duplicates are handled on finalize according to the policy.
+type UploadTicket struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;index"`
+	FileName  string    `gorm:"type:varchar(255);not null"`
+	Size      int64
+	Policy    DuplicatePolicy `gorm:"type:varchar(16)"`
+	ExpiresAt time.Time       `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// PresignUploadRequest is the JSON representation of a request for presigned uploads of RAW files
+type PresignUploadRequest struct {
+	Files []PresignUploadFile `json:"files" binding:"required,min=1,dive"`
+}
+
+// PresignUploadFile is the JSON representation of a file to be uploaded with a presigned request
+type PresignUploadFile struct {
+	Name string `json:"name" binding:"required"`
+	Size int64  `json:"size" binding:"required,min=1"`
+}
+
+// PresignUploadResponse is the JSON representation of a presigned upload of a RAW file
+type PresignUploadResponse struct {
+	ID      string                  `json:"id"`
+	Name    string                  `json:"name"`
+	Request *image.PresignedRequest `json:"request"`
+}
+
+// FinalizeRequest is the JSON representation of a request to finalize presigned uploads
+type FinalizeRequest struct {
+	IDs []uuid.UUID `json:"ids" binding:"required,min=1"`
+}
+
+// DuplicatePolicy is the handling of uploads of photos the user has already uploaded
+type DuplicatePolicy string
+
+const (
+	// RejectDuplicates is the policy failing the upload of duplicates
+	RejectDuplicates DuplicatePolicy = "reject"
+	// SkipDuplicates is the policy skipping duplicates, they are not added to the upload
+	SkipDuplicates DuplicatePolicy = "skip"
+	// LinkDuplicates is the policy adding the photo already uploaded to the upload instead of the duplicate
+	LinkDuplicates DuplicatePolicy = "link"
+)
+
+// ParseDuplicatePolicy parses the name of a duplicate policy, the default is `RejectDuplicates`.
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return RejectDuplicates, nil
+	case RejectDuplicates, SkipDuplicates, LinkDuplicates:
+		return p, nil
+	}
+	return "", ErrUnknownDuplicatePolicy
+}
+
+// Fingerprint is the format, data size and perceptual hash of a `Photo`, compared to find duplicates of the same
+// capture
+type Fingerprint struct {
+	ID       uuid.UUID
+	Format   descriptor.Format
+	DataSize int64
+	DHash    *int64
+}
+
+// UploadError is the reason of a failed upload of a file
+type UploadError string
+
+const (
+	// UploadUnsupportedFormat is the reason of failed uploads with a file format that can not be imported
+	UploadUnsupportedFormat UploadError = "unsupported_format"
+	// UploadQuotaExceeded is the reason of failed uploads over the quota of the user or the application
+	UploadQuotaExceeded UploadError = "quota_exceeded"
+	// UploadDecodeFailed is the reason of failed uploads of files that could not be decoded
+	UploadDecodeFailed UploadError = "decode_failed"
+	// UploadDuplicate is the reason of failed uploads of files already uploaded by the user
+	UploadDuplicate UploadError = "duplicate"
+	// UploadStorageFailed is the reason of failed uploads of files that could not be stored
+	UploadStorageFailed UploadError = "storage_failed"
+	// UploadInvalidSidecar is the reason of failed uploads of files with an XMP sidecar that could not be parsed
+	UploadInvalidSidecar UploadError = "invalid_sidecar"
+	// UploadUnmatchedSidecar is the reason of failed uploads of XMP sidecars without a RAW file uploaded along
+	UploadUnmatchedSidecar UploadError = "unmatched_sidecar"
+	// UploadNotFound is the reason of failed finalizing of uploads that do not exist or belong to another user
+	UploadNotFound UploadError = "not_found"
+	// UploadExpired is the reason of failed finalizing of uploads after the ticket expired
+	UploadExpired UploadError = "expired"
+	// UploadMissing is the reason of failed finalizing of uploads before the file was uploaded, or with a file of a
+	// size other than declared
+	UploadMissing UploadError = "missing"
+	// UploadFailed is the reason of failed uploads for any other reason
+	UploadFailed UploadError = "failed"
+)
+
+// UploadFileResponse is the JSON representation of the result of a single file's upload
+type UploadFileResponse struct {
+	FileName    string      `json:"filename"`
+	Success     bool        `json:"success"`
+	ID          string      `json:"id,omitempty"`
+	Skipped     bool        `json:"skipped,omitempty"`
+	DuplicateOf string      `json:"duplicate_of,omitempty"`
+	Sidecar     string      `json:"sidecar,omitempty"`
+	Error       UploadError `json:"error,omitempty"`
+	Message     string      `json:"message,omitempty"`
+}
+
+// UploadResponse is the JSON representation of the result of a batch upload. The upload collection is created from
+// the files uploaded successfully, the ID is empty if none of the files were uploaded.
+type UploadResponse struct {
+	ID    string               `json:"id,omitempty"`
+	Files []UploadFileResponse `json:"files"`
+}
+
+// JobStatus is the processing status of an asynchronous upload
+type JobStatus string
+
+const (
+	// JobPending is the status of uploads waiting to be processed
+	JobPending JobStatus = "pending"
+	// JobProcessing is the status of uploads being processed by a worker
+	JobProcessing JobStatus = "processing"
+	// JobDone is the status of uploads processed into a `Photo`
+	JobDone JobStatus = "done"
+	// JobFailed is the status of uploads that could not be processed, the error of the job tells why
+	JobFailed JobStatus = "failed"
+)
+
+// UploadJob is a struct representing a RAW file stored in the image store, waiting to be processed asynchronously
+// into a `Photo` with the same ID. Jobs of the files uploaded in the same request share the batch ID. The content
+// of the XMP sidecar uploaded along with the file is kept to be merged into the photo. Duplicates are handled
+// according to the policy, jobs of duplicates skipped or linked refer to the photo already uploaded.
+type UploadJob struct {
+	ID          uuid.UUID       `gorm:"type:uuid;primary_key"`
+	BatchID     uuid.UUID       `gorm:"type:uuid;index"`
+	UserID      uuid.UUID       `gorm:"type:uuid;index"`
+	FileName    string          `gorm:"type:varchar(255);not null"`
+	Sidecar     string          `gorm:"type:text"`
+	Policy      DuplicatePolicy `gorm:"type:varchar(16)"`
+	Status      JobStatus       `gorm:"type:varchar(16);index"`
+	Error       string
+	DuplicateOf *uuid.UUID `gorm:"type:uuid"`
+	Skipped     bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time `gorm:"index"`
+}
+
+// AsResp returns the JSON representation of the upload job
+func (j UploadJob) AsResp() JobResponse {
+	res := JobResponse{
+		ID:       j.ID.String(),
+		FileName: j.FileName,
+		Status:   j.Status,
+		Error:    j.Error,
+		Skipped:  j.Skipped,
+	}
+	if j.DuplicateOf != nil {
+		res.DuplicateOf = j.DuplicateOf.String()
+	}
+	return res
+}
+
+// JobResponse is the JSON representation of an asynchronous upload of a RAW file. Duplicates linked to the photo
+// already uploaded have the ID of that photo, skipped ones are not part of the upload.
+type JobResponse struct {
+	ID          string    `json:"id"`
+	FileName    string    `json:"filename"`
+	Status      JobStatus `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	DuplicateOf string    `json:"duplicate_of,omitempty"`
+	Skipped     bool      `json:"skipped,omitempty"`
+}
+
+// BatchResponse is the JSON representation of the asynchronous uploads of a request. When the batch is done the IDs
+// of the jobs processed, or of the photos the linked duplicates refer to, can be finalized into an upload.
+type BatchResponse struct {
+	ID   string        `json:"id"`
+	Done bool          `json:"done"`
+	Jobs []JobResponse `json:"jobs"`
 }
 
 // UserStats is aggregated data on the photos of a user.
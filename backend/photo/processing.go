@@ -0,0 +1,228 @@
+package photo
+
+import (
+	"errors"
+	"mime/multipart"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/image"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	jobPollInterval = time.Minute
+	jobStaleAfter   = 30 * time.Minute
+	jobRetention    = 7 * 24 * time.Hour
+)
+
+// ErrBatchNotFound is an error for status requests of asynchronous uploads that do not exist or belong to another user
+var ErrBatchNotFound = errors.New("upload batch does not exist")
+
+// JobService is a service processing uploads asynchronously: the RAW files are stored when they are uploaded, the
+// renditions and the metadata are created in the background by a pool of workers.
+type JobService struct {
+	uploads *UploadService
+	jobs    JobStorer
+	users   user.Loader
+	workers int
+	wake    chan struct{}
+}
+
+// NewJobService creates a `JobService` instance importing the files with the upload service, with the number of
+// concurrent workers.
+func NewJobService(uploads *UploadService, jobs JobStorer, users user.Loader, workers int) *JobService {
+	return &JobService{
+		uploads: uploads,
+		jobs:    jobs,
+		users:   users,
+		workers: workers,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Submit is a method of `JobService` storing the uploaded RAW files and creating a pending job for each of them, in
+// a batch. The jobs are processed in the background, the IDs of the jobs are the IDs of the photos created. The XMP
+// sidecars of the files are validated and kept with the jobs, duplicates are handled according to the policy.
+func (s *JobService) Submit(usr *user.User, files []UploadFile, policy DuplicatePolicy) (uuid.UUID, []UploadJob, error) {
+	sidecars := make([][]byte, len(files))
+	for i, f := range files {
+		if !supported(f.Raw.Filename) {
+			return uuid.UUID{}, nil, ErrUnsupportedFormat
+		}
+		content, err := readSidecar(f.Sidecar)
+		if err == nil {
+			_, err = parseSidecar(content)
+		}
+		if err != nil {
+			return uuid.UUID{}, nil, ErrInvalidSidecar
+		}
+		sidecars[i] = content
+	}
+
+	var (
+		batch = uuid.New()
+		jobs  = make([]UploadJob, 0, len(files))
+	)
+	for i, f := range files {
+		job := UploadJob{
+			ID:       uuid.New(),
+			BatchID:  batch,
+			UserID:   usr.ID,
+			FileName: filepath.Base(f.Raw.Filename),
+			Sidecar:  string(sidecars[i]),
+			Policy:   policy,
+			Status:   JobPending,
+		}
+		if err := s.store(job.ID, f.Raw); err != nil {
+			log.Err(err).Str("file", f.Raw.Filename).Msg("Failed to store uploaded file!")
+			return batch, jobs, ErrStorageFailed
+		}
+		if err := s.jobs.Store(&job); err != nil {
+			return batch, jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+	s.notify()
+	return batch, jobs, nil
+}
+
+// Status is a method of `JobService` returning the jobs of a batch of asynchronous uploads of the user.
+func (s *JobService) Status(usr *user.User, batch uuid.UUID) ([]UploadJob, error) {
+	jobs, err := s.jobs.Batch(batch)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 || jobs[0].UserID != usr.ID {
+		return nil, ErrBatchNotFound
+	}
+	return jobs, nil
+}
+
+// Start runs the workers in the background, processing jobs whenever files are submitted, and periodically to pick
+// up the jobs left by stopped instances. Finished jobs are purged after a week.
+func (s *JobService) Start() {
+	for i := 0; i < s.workers; i++ {
+		go s.work()
+	}
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := s.jobs.Purge(time.Now().Add(-jobRetention)); err != nil {
+				log.Err(err).Msg("Failed to purge upload jobs")
+			}
+		}
+	}()
+}
+
+func (s *JobService) work() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			processed, err := s.Process()
+			if err != nil {
+				log.Err(err).Msg("Failed to process upload jobs")
+			}
+			if err != nil || !processed {
+				break
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// Process is a method of `JobService` claiming and processing a single job, returns whether there was a job to
+// process. Failures of the job are recorded on the job, the RAW file of a failed job is deleted, so is the RAW file
+// of a duplicate skipped or linked to the photo already uploaded.
+func (s *JobService) Process() (bool, error) {
+	job, err := s.jobs.Claim(time.Now().Add(-jobStaleAfter))
+	if err != nil || job == nil {
+		return false, err
+	}
+	s.notify() // wake another worker for the rest of the jobs
+
+	start := time.Now()
+	res := resolve(UploadResult{FileName: job.FileName, ID: job.ID, Err: s.process(job)}, job.Policy)
+	if res.Err != nil || res.Duplicate {
+		if derr := s.uploads.images.Delete(job.ID.String()); derr != nil {
+			log.Err(derr).Str("id", job.ID.String()).Msg("Failed to delete uploaded file!")
+		}
+	}
+	switch {
+	case res.Err != nil:
+		log.Err(res.Err).Str("id", job.ID.String()).Msg("Failed to process upload job!")
+		job.Status = JobFailed
+		job.Error = res.AsResp().Message
+	case res.Duplicate:
+		job.Status, job.DuplicateOf, job.Skipped = JobDone, &res.ID, res.Skipped
+	default:
+		job.Status = JobDone
+		log.Debug().Str("file", job.FileName).Dur("elapsed", time.Since(start)).Msg("photo processed")
+	}
+	return true, s.jobs.Update(job)
+}
+
+func (s *JobService) process(job *UploadJob) error {
+	if p, err := s.uploads.photos.Load(job.ID.String()); err == nil && p.UserID == job.UserID {
+		return nil // processed before the application stopped
+	}
+	usr, err := s.users.ByID(job.UserID)
+	if err != nil {
+		return err
+	}
+	raw, err := s.uploads.loadStored(job.ID)
+	if err != nil {
+		return err
+	}
+	target, err := s.uploads.importBinary(usr, raw, job.FileName)
+	if err != nil {
+		return err
+	}
+	sidecar, err := parseSidecar([]byte(job.Sidecar))
+	if err != nil {
+		return err
+	}
+	applySidecar(&target.Desc, sidecar)
+	return s.uploads.storeImported(target, job.ID)
+}
+
+// InFlight is a method of `JobService` checking whether the ID is of a job not processed yet, so the scrub keeps its
+// RAW file until the photo is created. The RAW file of a failed job is deleted by the worker.
+func (s *JobService) InFlight(id string) (bool, error) {
+	jid, err := uuid.Parse(id)
+	if err != nil {
+		return false, nil
+	}
+	job, err := s.jobs.Load(jid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return job.Status == JobPending || job.Status == JobProcessing, nil
+}
+
+func (s *JobService) store(id uuid.UUID, file *multipart.FileHeader) error {
+	mp, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer closeRequestFile(mp)
+	return s.uploads.images.StoreStream(id.String(), image.RawVariant, mp)
+}
+
+func (s *JobService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
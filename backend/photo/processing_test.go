@@ -0,0 +1,254 @@
+package photo
+
+import (
+	"bytes"
+	"errors"
+	goimage "image"
+	"image/png"
+	"mime/multipart"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/common"
+	"github.com/inokone/photostorage/image"
+	"gorm.io/gorm"
+)
+
+// memPhotos is a `Storer` keeping the photos in memory, without duplicates.
+type memPhotos struct {
+	Storer
+	photos map[string]Photo
+}
+
+func (s memPhotos) Store(photo *Photo) (uuid.UUID, error) {
+	if photo.ID == uuid.Nil {
+		photo.ID = uuid.New()
+	}
+	if photo.Tier == 0 {
+		photo.Tier = image.StandardTier // default of the column
+	}
+	s.photos[photo.ID.String()] = *photo
+	return photo.ID, nil
+}
+
+func (s memPhotos) Update(photo *Photo) error {
+	s.photos[photo.ID.String()] = *photo
+	return nil
+}
+
+func (s memPhotos) Load(id string) (*Photo, error) {
+	p, ok := s.photos[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &p, nil
+}
+
+func (s memPhotos) Delete(id string) error {
+	delete(s.photos, id)
+	return nil
+}
+
+func (s memPhotos) ByChecksum(userID string, checksum string) (*Photo, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s memPhotos) ByCapture(userID string, metadata image.Metadata) ([]Fingerprint, error) {
+	return nil, nil
+}
+
+// memUsers is a `user.Loader` with a single user.
+type memUsers struct {
+	user.Loader
+	usr *user.User
+}
+
+func (u memUsers) ByID(id uuid.UUID) (*user.User, error) {
+	if id != u.usr.ID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return u.usr, nil
+}
+
+// renditionFailing is an image store failing to store anything but RAW files.
+type renditionFailing struct {
+	*image.LocalStorer
+}
+
+var errRendition = errors.New("rendition write failed")
+
+func (s renditionFailing) Store(id string, variant image.Variant, content []byte) error {
+	if variant != image.RawVariant {
+		return errRendition
+	}
+	return s.LocalStorer.Store(id, variant, content)
+}
+
+// formFile returns the header of a file uploaded in a multipart form with the name and content.
+func formFile(t *testing.T, name string, content []byte) *multipart.FileHeader {
+	var (
+		buf = new(bytes.Buffer)
+		w   = multipart.NewWriter(buf)
+	)
+	part, err := w.CreateFormFile("files", name)
+	if err == nil {
+		_, err = part.Write(content)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatalf("Creating form failed: %v", err)
+	}
+	form, err := multipart.NewReader(buf, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("Reading form failed: %v", err)
+	}
+	return form.File["files"][0]
+}
+
+func pngContent(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, goimage.NewRGBA(goimage.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newTestJobService(t *testing.T, images image.Storer, photos map[string]Photo, usr *user.User) (*JobService, memJobs) {
+	var (
+		jobs    = memJobs{}
+		uploads = NewUploadService(memPhotos{photos: photos}, images, memTickets{}, &common.ImageStoreConfig{}, image.DefaultResampling())
+	)
+	return NewJobService(uploads, jobs, memUsers{usr: usr}, 0), jobs
+}
+
+func TestSubmit(t *testing.T) {
+	images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	var (
+		usr     = &user.User{ID: uuid.New()}
+		s, jobs = newTestJobService(t, images, map[string]Photo{}, usr)
+		content = pngContent(t)
+	)
+
+	if _, _, err = s.Submit(usr, []UploadFile{{Raw: formFile(t, "notes.txt", content)}}, SkipDuplicates); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Submit() of an unsupported file = %v; want %v", err, ErrUnsupportedFormat)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("Submit() of an unsupported file created %v jobs; want none", len(jobs))
+	}
+
+	batch, submitted, err := s.Submit(usr, []UploadFile{{Raw: formFile(t, "photo.png", content)}}, SkipDuplicates)
+	if err != nil || len(submitted) != 1 {
+		t.Fatalf("Submit() = %v jobs, %v; want 1 job", len(submitted), err)
+	}
+	job := jobs[submitted[0].ID]
+	if job.BatchID != batch || job.Status != JobPending || job.Policy != SkipDuplicates || job.FileName != "photo.png" {
+		t.Errorf("Submit() stored %+v; want a pending job of the batch", job)
+	}
+	if raw, err := images.Load(job.ID.String(), image.RawVariant); err != nil || !bytes.Equal(raw, content) {
+		t.Errorf("Submit() stored RAW = %v bytes, %v; want the uploaded file", len(raw), err)
+	}
+
+	if status, err := s.Status(usr, batch); err != nil || len(status) != 1 || status[0].ID != job.ID {
+		t.Errorf("Status() = %v, %v; want the submitted job", status, err)
+	}
+	if _, err = s.Status(&user.User{ID: uuid.New()}, batch); !errors.Is(err, ErrBatchNotFound) {
+		t.Errorf("Status() of another user = %v; want %v", err, ErrBatchNotFound)
+	}
+	if _, err = s.Status(usr, uuid.New()); !errors.Is(err, ErrBatchNotFound) {
+		t.Errorf("Status() of an unknown batch = %v; want %v", err, ErrBatchNotFound)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    []byte
+		failing    bool
+		wantStatus JobStatus
+	}{
+		{name: "imported", content: pngContent(t), wantStatus: JobDone},
+		{name: "undecodable", content: []byte("not a photo"), wantStatus: JobFailed},
+		{name: "renditions failing", content: pngContent(t), failing: true, wantStatus: JobFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+			if err != nil {
+				t.Fatalf("NewLocalStorer failed: %v", err)
+			}
+			var (
+				images image.Storer = local
+				usr                 = &user.User{ID: uuid.New()}
+				photos              = map[string]Photo{}
+			)
+			if tt.failing {
+				images = renditionFailing{LocalStorer: local}
+			}
+			s, jobs := newTestJobService(t, images, photos, usr)
+			job := UploadJob{ID: uuid.New(), UserID: usr.ID, FileName: "photo.png", Status: JobPending}
+			if err = jobs.Store(&job); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			if err = local.Store(job.ID.String(), image.RawVariant, tt.content); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+
+			processed, err := s.Process()
+			if err != nil || !processed {
+				t.Fatalf("Process() = %v, %v; want true", processed, err)
+			}
+			if status := jobs[job.ID].Status; status != tt.wantStatus {
+				t.Errorf("Process() status = %v; want %v", status, tt.wantStatus)
+			}
+			done := tt.wantStatus == JobDone
+			if _, ok := photos[job.ID.String()]; ok != done {
+				t.Errorf("Process() stored photo = %v; want %v", ok, done)
+			}
+			if _, err = local.Stat(job.ID.String(), image.RawVariant); (err == nil) != done {
+				t.Errorf("Process() kept RAW = %v; want %v", err == nil, done)
+			}
+
+			if processed, err = s.Process(); err != nil || processed {
+				t.Errorf("Process() without jobs = %v, %v; want false", processed, err)
+			}
+		})
+	}
+}
+
+func TestProcessStale(t *testing.T) {
+	images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	var (
+		usr     = &user.User{ID: uuid.New()}
+		photos  = map[string]Photo{}
+		s, jobs = newTestJobService(t, images, photos, usr)
+		active  = UploadJob{ID: uuid.New(), UserID: usr.ID, FileName: "active.png", Status: JobProcessing, UpdatedAt: time.Now()}
+		stale   = UploadJob{ID: uuid.New(), UserID: usr.ID, FileName: "stale.png", Status: JobProcessing, UpdatedAt: time.Now().Add(-2 * jobStaleAfter)}
+	)
+	jobs[active.ID], jobs[stale.ID] = active, stale
+	// the photo of the stale job was stored before the application stopped
+	photos[stale.ID.String()] = Photo{ID: stale.ID, UserID: usr.ID}
+
+	processed, err := s.Process()
+	if err != nil || !processed {
+		t.Fatalf("Process() = %v, %v; want true", processed, err)
+	}
+	if status := jobs[stale.ID].Status; status != JobDone {
+		t.Errorf("Process() status of the stale job = %v; want %v", status, JobDone)
+	}
+	if status := jobs[active.ID].Status; status != JobProcessing {
+		t.Errorf("Process() status of the active job = %v; want %v", status, JobProcessing)
+	}
+	if processed, err = s.Process(); err != nil || processed {
+		t.Errorf("Process() with an active job only = %v, %v; want false", processed, err)
+	}
+}
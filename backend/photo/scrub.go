@@ -0,0 +1,259 @@
+package photo
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/image"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// orphanGracePeriod is the age below which images without a photo are not deleted, as they may still be written
+const orphanGracePeriod = 24 * time.Hour
+
+// InFlight is an interface for checking whether images stored under an ID without a photo are in use, e.g. RAWs of
+// uploads waiting to be finalized. The scrub does not delete them as orphans.
+type InFlight interface {
+	InFlight(id string) (bool, error)
+}
+
+// ScrubReport is a summary of the findings of a scrub, and of the repairs made unless it was a dry run.
+type ScrubReport struct {
+	Photos             int
+	MissingRaws        int
+	MissingRenditions  int
+	ChecksumMismatches int
+	ChecksumsRecorded  int
+	HashesRecorded     int
+	Purged             int
+	Orphans            int
+	Repaired           int
+	Failed             int
+}
+
+// ScrubService is a service verifying the integrity of the image store against the photos in persistence.
+type ScrubService struct {
+	photos     Storer
+	images     image.Storer
+	resampling image.Resampling
+	inFlight   []InFlight
+	grace      time.Duration
+	dryRun     bool
+}
+
+// NewScrubService creates a `ScrubService` instance based on the storers, regenerating missing renditions with the
+// resampling. In dry run mode findings are only reported, nothing is repaired. Images stored under the IDs in flight
+// are kept, even without a photo.
+func NewScrubService(photos Storer, images image.Storer, resampling image.Resampling, dryRun bool,
+	inFlight ...InFlight,
+) *ScrubService {
+	return &ScrubService{
+		photos:     photos,
+		images:     images,
+		resampling: resampling,
+		inFlight:   inFlight,
+		grace:      orphanGracePeriod,
+		dryRun:     dryRun,
+	}
+}
+
+// Scrub is a method of `ScrubService` walking all photos and all stored images. Findings are repaired unless dry run:
+//   - missing renditions are regenerated from the RAW,
+//   - checksums are recorded for photos uploaded before checksums were introduced,
+//   - perceptual hashes are recorded for photos uploaded before similar photos were introduced,
+//   - images of deleted photos are purged,
+//   - orphan images, without a photo, are deleted, except for generated archives, images in flight and images
+//     written within the grace period.
+//
+// Missing RAWs and checksum mismatches can not be repaired, they are reported only.
+func (s ScrubService) Scrub() (*ScrubReport, error) {
+	var (
+		report = &ScrubReport{}
+		known  = make(map[string]bool)
+	)
+
+	err := s.photos.Walk(func(p *Photo) error {
+		id := p.ID.String()
+		known[id] = true
+		if p.DeletedAt.Valid {
+			s.purge(p, report)
+		} else {
+			report.Photos++
+			s.verify(p, report)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	err = s.images.List(func(id string) error {
+		if known[id] {
+			return nil
+		}
+		if _, err := s.images.Stat(id, image.ArchiveVariant); err == nil {
+			return nil // archives expire on their own
+		}
+		used, err := s.inUse(id)
+		if err != nil {
+			report.Failed++
+			log.Err(err).Str("id", id).Msg("Failed to check whether image is in use")
+			return nil
+		}
+		if used {
+			return nil
+		}
+		report.Orphans++
+		log.Warn().Str("id", id).Msg("Orphan image found")
+		s.repair(report, func() error {
+			return s.images.Delete(id)
+		})
+		return nil
+	})
+	return report, err
+}
+
+// inUse returns whether the images without a photo at the time of the walk are in use: the photo was uploaded since
+// the walk, the ID is in flight, or the images were written within the grace period.
+func (s ScrubService) inUse(id string) (bool, error) {
+	if _, err := uuid.Parse(id); err == nil {
+		_, err = s.photos.Load(id)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, err
+		}
+		for _, f := range s.inFlight {
+			used, err := f.InFlight(id)
+			if err != nil || used {
+				return used, err
+			}
+		}
+	}
+	for _, v := range []image.Variant{image.RawVariant, image.ThumbnailVariant, image.ArchiveVariant} {
+		if info, err := s.images.Stat(id, v); err == nil && time.Since(info.Modified) < s.grace {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s ScrubService) purge(p *Photo, report *ScrubReport) {
+	id := p.ID.String()
+	if _, err := s.images.Stat(id, image.RawVariant); err != nil {
+		return // already purged
+	}
+	report.Purged++
+	log.Info().Str("id", id).Msg("Image of deleted photo found")
+	s.repair(report, func() error {
+		return s.images.Delete(id)
+	})
+}
+
+func (s ScrubService) verify(p *Photo, report *ScrubReport) {
+	id := p.ID.String()
+	if _, err := s.images.Stat(id, image.RawVariant); err != nil {
+		report.MissingRaws++
+		log.Error().Err(err).Str("id", id).Msg("RAW of photo is missing")
+		return
+	}
+
+	var missing []image.Variant
+	for _, r := range image.Renditions {
+		if _, err := s.images.Stat(id, r.Variant); err != nil {
+			missing = append(missing, r.Variant)
+		}
+	}
+	if len(missing) > 0 {
+		report.MissingRenditions += len(missing)
+		log.Warn().Str("id", id).Int("missing", len(missing)).Msg("Renditions of photo are missing")
+	}
+
+	if p.Desc.DHash == nil && !slices.Contains(missing, image.ThumbnailVariant) {
+		report.HashesRecorded++
+		s.repair(report, func() error {
+			return s.recordHash(p)
+		})
+	}
+
+	if p.Tier == image.FrozenTier {
+		return // the RAW can not be read in frozen storage
+	}
+	if len(missing) > 0 {
+		s.repair(report, func() error {
+			return s.regenerate(p, missing)
+		})
+	}
+
+	sum, err := hash(s.images, id, image.RawVariant)
+	if err != nil {
+		report.Failed++
+		log.Err(err).Str("id", id).Msg("Failed to calculate checksum of photo")
+		return
+	}
+	if len(p.Checksum) == 0 {
+		report.ChecksumsRecorded++
+		s.repair(report, func() error {
+			p.Checksum = sum
+			return s.photos.Update(p)
+		})
+		return
+	}
+	if p.Checksum != sum {
+		report.ChecksumMismatches++
+		log.Error().Str("id", id).Str("expected", p.Checksum).Str("actual", sum).Msg("Checksum mismatch of photo")
+	}
+}
+
+func (s ScrubService) recordHash(p *Photo) error {
+	thumbnail, err := s.images.Load(p.ID.String(), image.ThumbnailVariant)
+	if err != nil {
+		return err
+	}
+	dHash, err := thumbnailHash(image.ThumbnailImg{Image: thumbnail})
+	if err != nil {
+		return err
+	}
+	p.Desc.DHash = &dHash
+	return s.photos.Update(p)
+}
+
+func (s ScrubService) regenerate(p *Photo, missing []image.Variant) error {
+	id := p.ID.String()
+	raw, err := s.images.Load(id, image.RawVariant)
+	if err != nil {
+		return err
+	}
+	rs, err := renditions(s.resampling, p.Desc.Format, raw, p.Desc.Rotation)
+	if err != nil {
+		return err
+	}
+	for _, r := range rs {
+		for _, v := range missing {
+			if r.Variant != v {
+				continue
+			}
+			if err = s.images.Store(id, r.Variant, r.Image); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// repair executes the repair unless dry run, recording the outcome in the report.
+func (s ScrubService) repair(report *ScrubReport, fn func() error) {
+	if s.dryRun {
+		return
+	}
+	if err := fn(); err != nil {
+		report.Failed++
+		log.Err(err).Msg("Repair failed")
+		return
+	}
+	report.Repaired++
+}
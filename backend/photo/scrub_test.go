@@ -0,0 +1,269 @@
+package photo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/common"
+	"github.com/inokone/photostorage/image"
+	"gorm.io/gorm"
+)
+
+// scrubPhotos is a `Storer` without photos at the time of the walk, and with the photos uploaded since.
+type scrubPhotos struct {
+	Storer
+	uploaded map[string]bool
+}
+
+func (s scrubPhotos) Walk(fn func(photo *Photo) error) error {
+	return nil
+}
+
+func (s scrubPhotos) Load(id string) (*Photo, error) {
+	if s.uploaded[id] {
+		return &Photo{}, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+type inFlightIDs map[string]bool
+
+// memTickets is a `TicketStorer` keeping the tickets in memory.
+type memTickets map[uuid.UUID]UploadTicket
+
+func (m memTickets) Store(ticket *UploadTicket) error {
+	m[ticket.ID] = *ticket
+	return nil
+}
+
+func (m memTickets) Load(id uuid.UUID) (*UploadTicket, error) {
+	ticket, ok := m[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &ticket, nil
+}
+
+func (m memTickets) Delete(id uuid.UUID) error {
+	delete(m, id)
+	return nil
+}
+
+func (m memTickets) Expired(before time.Time) ([]UploadTicket, error) {
+	var res []UploadTicket
+	for _, ticket := range m {
+		if ticket.ExpiresAt.Before(before) {
+			res = append(res, ticket)
+		}
+	}
+	return res, nil
+}
+
+func (f inFlightIDs) InFlight(id string) (bool, error) {
+	return f[id], nil
+}
+
+func TestScrubOrphans(t *testing.T) {
+	images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	var (
+		orphan   = uuid.NewString()
+		uploaded = uuid.NewString()
+		inFlight = uuid.NewString()
+		photos   = scrubPhotos{uploaded: map[string]bool{uploaded: true}}
+	)
+	for _, id := range []string{orphan, uploaded, inFlight} {
+		if err = images.Store(id, image.RawVariant, []byte("raw")); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+
+	s := NewScrubService(photos, images, image.DefaultResampling(), false, inFlightIDs{inFlight: true})
+	report, err := s.Scrub()
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if report.Orphans != 0 {
+		t.Errorf("Scrub() within the grace period = %v orphans; want 0", report.Orphans)
+	}
+
+	s.grace = 0
+	report, err = s.Scrub()
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if report.Orphans != 1 {
+		t.Errorf("Scrub() = %v orphans; want 1", report.Orphans)
+	}
+	for id, kept := range map[string]bool{orphan: false, uploaded: true, inFlight: true} {
+		if _, err = images.Stat(id, image.RawVariant); (err == nil) != kept {
+			t.Errorf("Scrub() kept %v = %v; want %v", id, err == nil, kept)
+		}
+	}
+}
+
+func TestScrubPendingUploads(t *testing.T) {
+	images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	var (
+		orphan  = uuid.New()
+		pending = uuid.New()
+		tickets = memTickets{pending: {ID: pending, FileName: "pending.dng", ExpiresAt: time.Now().Add(uploadTicketTTL)}}
+		uploads = NewUploadService(scrubPhotos{}, images, tickets, nil, image.DefaultResampling())
+	)
+	for _, id := range []uuid.UUID{orphan, pending} {
+		if err = images.Store(id.String(), image.RawVariant, []byte("raw")); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+
+	s := NewScrubService(scrubPhotos{}, images, image.DefaultResampling(), false, uploads)
+	s.grace = 0
+	report, err := s.Scrub()
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if report.Orphans != 1 {
+		t.Errorf("Scrub() = %v orphans; want 1", report.Orphans)
+	}
+	for id, kept := range map[uuid.UUID]bool{orphan: false, pending: true} {
+		if _, err = images.Stat(id.String(), image.RawVariant); (err == nil) != kept {
+			t.Errorf("Scrub() kept %v = %v; want %v", id, err == nil, kept)
+		}
+	}
+}
+
+func TestScrubResumableUploads(t *testing.T) {
+	images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	var (
+		usr     = &user.User{ID: uuid.New()}
+		tickets = memTickets{}
+		uploads = NewUploadService(scrubPhotos{}, images, tickets, &common.ImageStoreConfig{StagingPath: t.TempDir()}, image.DefaultResampling())
+	)
+	ticket, err := uploads.Stage(usr, "resumable.dng", 3, RejectDuplicates)
+	if err != nil {
+		t.Fatalf("Stage failed: %v", err)
+	}
+	// the last chunk arrived, the file is moved to the image store and the upload is being finalized
+	if err = images.Store(ticket.ID.String(), image.RawVariant, []byte("raw")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	s := NewScrubService(scrubPhotos{}, images, image.DefaultResampling(), false, uploads)
+	s.grace = 0
+	if _, err = s.Scrub(); err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if _, err = images.Stat(ticket.ID.String(), image.RawVariant); err != nil {
+		t.Errorf("Scrub() deleted the file of a resumable upload being finalized: %v", err)
+	}
+
+	if err = uploads.Terminate(usr, ticket.ID); err != nil {
+		t.Fatalf("Terminate failed: %v", err)
+	}
+	if inFlight, _ := uploads.InFlight(ticket.ID.String()); inFlight {
+		t.Errorf("InFlight() of a terminated upload = true; want false")
+	}
+}
+
+// memJobs is a `JobStorer` keeping the jobs in memory.
+type memJobs map[uuid.UUID]UploadJob
+
+func (m memJobs) Store(job *UploadJob) error {
+	job.CreatedAt, job.UpdatedAt = time.Now(), time.Now()
+	m[job.ID] = *job
+	return nil
+}
+
+func (m memJobs) Load(id uuid.UUID) (*UploadJob, error) {
+	job, ok := m[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &job, nil
+}
+
+func (m memJobs) Update(job *UploadJob) error {
+	job.UpdatedAt = time.Now()
+	m[job.ID] = *job
+	return nil
+}
+
+func (m memJobs) Batch(id uuid.UUID) ([]UploadJob, error) {
+	var res []UploadJob
+	for _, job := range m {
+		if job.BatchID == id {
+			res = append(res, job)
+		}
+	}
+	return res, nil
+}
+
+// Claim claims the oldest pending job, or the oldest job processing since before `stale`, as `GORMJobStorer` does.
+func (m memJobs) Claim(stale time.Time) (*UploadJob, error) {
+	var claimed *UploadJob
+	for _, job := range m {
+		if job.Status != JobPending && (job.Status != JobProcessing || !job.UpdatedAt.Before(stale)) {
+			continue
+		}
+		if claimed == nil || job.CreatedAt.Before(claimed.CreatedAt) {
+			claimed = &job
+		}
+	}
+	if claimed == nil {
+		return nil, nil
+	}
+	claimed.Status = JobProcessing
+	return claimed, m.Update(claimed)
+}
+
+func (m memJobs) Purge(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestScrubPendingJobs(t *testing.T) {
+	images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	var (
+		pending    = uuid.New()
+		processing = uuid.New()
+		failed     = uuid.New()
+		jobs       = memJobs{
+			pending:    {ID: pending, Status: JobPending},
+			processing: {ID: processing, Status: JobProcessing},
+			failed:     {ID: failed, Status: JobFailed},
+		}
+		uploads = NewUploadService(scrubPhotos{}, images, memTickets{}, nil, image.DefaultResampling())
+	)
+	for _, id := range []uuid.UUID{pending, processing, failed} {
+		if err = images.Store(id.String(), image.RawVariant, []byte("raw")); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+	}
+
+	s := NewScrubService(scrubPhotos{}, images, image.DefaultResampling(), false, NewJobService(uploads, jobs, nil, 0))
+	s.grace = 0
+	report, err := s.Scrub()
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if report.Orphans != 1 {
+		t.Errorf("Scrub() = %v orphans; want 1", report.Orphans)
+	}
+	for id, kept := range map[uuid.UUID]bool{pending: true, processing: true, failed: false} {
+		if _, err = images.Stat(id.String(), image.RawVariant); (err == nil) != kept {
+			t.Errorf("Scrub() kept %v = %v; want %v", jobs[id].Status, err == nil, kept)
+		}
+	}
+}
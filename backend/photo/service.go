@@ -1,11 +1,16 @@
 package photo
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	goimage "image"
 	"io"
+	"io/fs"
 	"mime/multipart"
 	"net/http"
+	"os"
 	"path/filepath"
 	"sync"
 	"time"
@@ -15,65 +20,254 @@ import (
 	"github.com/inokone/photostorage/common"
 	"github.com/inokone/photostorage/image"
 	"github.com/inokone/photostorage/image/importer"
+	"github.com/inokone/photostorage/image/xmp"
 	"github.com/inokone/photostorage/photo/descriptor"
+	"github.com/inokone/photostorage/ruleset/rule"
 	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	uploadTicketTTL = 24 * time.Hour
+	// duplicateDistance is the maximal distance of the perceptual hashes of duplicates of the same capture
+	duplicateDistance = 2
+)
+
+var (
+	// ErrPresignUnsupported is an error for presigned uploads to an image store not supporting presigned requests
+	ErrPresignUnsupported = errors.New("image store does not support presigned uploads")
+	// ErrUnsupportedFormat is an error for uploads of files with a format the application can not import
+	ErrUnsupportedFormat = errors.New("file format is not supported")
+	// ErrQuotaExceeded is an error for uploads over the quota of the user
+	ErrQuotaExceeded = errors.New("you can not upload files, you have reached your quota")
+	// ErrGlobalQuotaExceeded is an error for uploads over the quota of the application
+	ErrGlobalQuotaExceeded = errors.New("you can not upload files, please contact an administrator")
+	// ErrDecodeFailed is an error for uploaded files that could not be decoded, e.g. corrupt files
+	ErrDecodeFailed = errors.New("uploaded file could not be decoded")
+	// ErrDuplicate is an error for uploads of files the user has already uploaded
+	ErrDuplicate = errors.New("photo has already been uploaded")
+	// ErrUnknownDuplicatePolicy is an error for uploads with a duplicate policy that does not exist
+	ErrUnknownDuplicatePolicy = errors.New("unknown duplicate policy")
+	// ErrInvalidSidecar is an error for uploads with an XMP sidecar that could not be parsed
+	ErrInvalidSidecar = errors.New("sidecar could not be parsed")
+	// ErrUnmatchedSidecar is an error for XMP sidecars uploaded without the RAW file they belong to
+	ErrUnmatchedSidecar = errors.New("sidecar does not match any uploaded file")
+	// ErrStorageFailed is an error for uploaded files that could not be stored
+	ErrStorageFailed = errors.New("uploaded file could not be stored")
+	// ErrTicketNotFound is an error for finalizing presigned uploads that do not exist or belong to another user
+	ErrTicketNotFound = errors.New("upload does not exist")
+	// ErrTicketExpired is an error for finalizing presigned uploads after the ticket expired
+	ErrTicketExpired = errors.New("upload expired")
+	// ErrUploadMissing is an error for finalizing presigned uploads before the file was uploaded
+	ErrUploadMissing = errors.New("uploaded file is missing")
+	// ErrUploadSizeMismatch is an error for finalizing uploads of a file of a size other than declared
+	ErrUploadSizeMismatch = errors.New("uploaded file does not match the declared size")
 )
 
 // UploadService is a service entity handling photo uploads
 type UploadService struct {
-	photos Storer
-	images image.Storer
-	config *common.ImageStoreConfig
+	photos     Storer
+	images     image.Storer
+	tickets    TicketStorer
+	config     *common.ImageStoreConfig
+	resampling image.Resampling
+	appending  *uploadLocks
 }
 
-// NewUploadService creates an `UploadService` instance based on storers and configuration
-func NewUploadService(photos Storer, images image.Storer, config *common.ImageStoreConfig) *UploadService {
+// NewUploadService creates an `UploadService` instance based on storers and configuration, creating the renditions
+// with the resampling.
+func NewUploadService(photos Storer, images image.Storer, tickets TicketStorer, config *common.ImageStoreConfig,
+	resampling image.Resampling,
+) *UploadService {
 	return &UploadService{
-		photos: photos,
-		images: images,
-		config: config,
+		photos:     photos,
+		images:     images,
+		tickets:    tickets,
+		config:     config,
+		resampling: resampling,
+		appending:  newUploadLocks(),
 	}
 }
 
-// UploadResult is a struct to store result of a single photo's upload
+// DuplicateError is an error for uploads of a photo the user has already uploaded, with the ID of the photo already
+// uploaded. It matches `ErrDuplicate`.
+type DuplicateError struct {
+	ID uuid.UUID
+}
+
+func (e DuplicateError) Error() string {
+	return ErrDuplicate.Error()
+}
+
+// Is is a method of `DuplicateError` matching `ErrDuplicate`.
+func (e DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+// UploadResult is a struct to store result of a single photo's upload. Duplicates skipped or linked have the ID of
+// the photo already uploaded. The sidecar is the name of the XMP sidecar merged into the photo.
 type UploadResult struct {
-	ID  uuid.UUID
-	Err error
+	FileName  string
+	Sidecar   string
+	ID        uuid.UUID
+	Skipped   bool
+	Duplicate bool
+	Err       error
+}
+
+// AsResp returns the JSON representation of the upload result, with the reason of the failure if the upload failed.
+func (r UploadResult) AsResp() UploadFileResponse {
+	if r.Err == nil {
+		res := UploadFileResponse{FileName: r.FileName, Success: true, ID: r.ID.String(), Skipped: r.Skipped, Sidecar: r.Sidecar}
+		if r.Duplicate {
+			res.DuplicateOf = r.ID.String()
+		}
+		if r.Skipped {
+			res.ID = ""
+		}
+		return res
+	}
+	res := UploadFileResponse{FileName: r.FileName, Error: UploadFailed, Message: "Uploaded file could not be processed!"}
+	var dup DuplicateError
+	if errors.As(r.Err, &dup) {
+		res.DuplicateOf = dup.ID.String()
+	}
+	switch {
+	case errors.Is(r.Err, ErrUnsupportedFormat):
+		res.Error, res.Message = UploadUnsupportedFormat, "Uploaded file format is not supported!"
+	case errors.Is(r.Err, ErrQuotaExceeded):
+		res.Error, res.Message = UploadQuotaExceeded, "You can not upload files, you have reached your quota!"
+	case errors.Is(r.Err, ErrGlobalQuotaExceeded):
+		res.Error, res.Message = UploadQuotaExceeded, "You can not upload files, please contact an administrator!"
+	case errors.Is(r.Err, ErrDecodeFailed):
+		res.Error, res.Message = UploadDecodeFailed, "Uploaded file is corrupt!"
+	case errors.Is(r.Err, ErrDuplicate):
+		res.Error, res.Message = UploadDuplicate, "Photo has already been uploaded!"
+	case errors.Is(r.Err, ErrStorageFailed):
+		res.Error, res.Message = UploadStorageFailed, "Uploaded file could not be stored, please try again!"
+	case errors.Is(r.Err, ErrInvalidSidecar):
+		res.Error, res.Message = UploadInvalidSidecar, "Uploaded XMP sidecar is invalid!"
+	case errors.Is(r.Err, ErrUnmatchedSidecar):
+		res.Error, res.Message = UploadUnmatchedSidecar, "Uploaded XMP sidecar does not match any uploaded file!"
+	case errors.Is(r.Err, ErrTicketNotFound):
+		res.Error, res.Message = UploadNotFound, "Upload does not exist!"
+	case errors.Is(r.Err, ErrTicketExpired):
+		res.Error, res.Message = UploadExpired, "Upload expired, please upload the file again!"
+	case errors.Is(r.Err, ErrUploadMissing):
+		res.Error, res.Message = UploadMissing, "File has not been uploaded yet!"
+	case errors.Is(r.Err, ErrUploadSizeMismatch):
+		res.Error, res.Message = UploadMissing, "Uploaded file does not match the declared size, please upload the file again!"
+	}
+	return res
 }
 
 // Upload is a method og `UploadService`, capable of uploading a single file. The method is concurrency safe, target
 // is parallelization when multiple files are uploaded. Results of the upload is added to the channel uploadResult.
-func (s UploadService) Upload(usr *user.User, file *multipart.FileHeader, ch chan UploadResult, wg *sync.WaitGroup) {
+// Photos the user has already uploaded are handled according to the duplicate policy. The XMP sidecar of the file
+// is merged into new photos.
+func (s UploadService) Upload(usr *user.User, file UploadFile, policy DuplicatePolicy, ch chan UploadResult, wg *sync.WaitGroup) {
 	var (
-		err error
-		mp  multipart.File
-		raw []byte
-		id  uuid.UUID
+		err     error
+		mp      multipart.File
+		raw     []byte
+		content []byte
+		sidecar *xmp.Packet
 	)
 
 	defer wg.Done()
-	mp, err = file.Open()
+	content, err = readSidecar(file.Sidecar)
+	if err == nil {
+		sidecar, err = parseSidecar(content)
+	}
 	if err != nil {
-		ch <- UploadResult{uuid.UUID{}, err}
+		ch <- UploadResult{FileName: file.Raw.Filename, Err: ErrInvalidSidecar}
+		return
+	}
+	mp, err = file.Raw.Open()
+	if err != nil {
+		ch <- UploadResult{FileName: file.Raw.Filename, Err: err}
+		return
 	}
 	defer closeRequestFile(mp)
 	raw, err = io.ReadAll(mp)
 	if err != nil {
-		ch <- UploadResult{uuid.UUID{}, err}
+		ch <- UploadResult{FileName: file.Raw.Filename, Err: err}
+		return
+	}
+	res := s.uploadBinary(usr, raw, file.Raw.Filename, sidecar, policy)
+	if res.Err == nil && !res.Duplicate && file.Sidecar != nil {
+		res.Sidecar = file.Sidecar.Filename
 	}
-	id, err = s.uploadBinary(usr, raw, file.Filename)
-	ch <- UploadResult{id, err}
+	ch <- res
 }
 
-func (s UploadService) uploadBinary(usr *user.User, raw []byte, filename string) (uuid.UUID, error) {
+func (s UploadService) uploadBinary(usr *user.User, raw []byte, filename string, sidecar *xmp.Packet, policy DuplicatePolicy) UploadResult {
+	res := UploadResult{FileName: filename}
+	res.ID, res.Err = s.storeBinary(usr, raw, filename, sidecar)
+	return resolve(res, policy)
+}
+
+// resolve handles the upload of a photo the user has already uploaded according to the duplicate policy: the upload
+// is skipped, or the photo already uploaded is linked instead. Duplicates are rejected by default.
+func resolve(res UploadResult, policy DuplicatePolicy) UploadResult {
+	var dup DuplicateError
+	if !errors.As(res.Err, &dup) || (policy != SkipDuplicates && policy != LinkDuplicates) {
+		return res
+	}
+	log.Debug().Str("file", res.FileName).Str("duplicate_of", dup.ID.String()).Msg("duplicate photo " + string(policy))
+	res.ID, res.Err, res.Duplicate = dup.ID, nil, true
+	res.Skipped = policy == SkipDuplicates
+	return res
+}
+
+func (s UploadService) storeBinary(usr *user.User, raw []byte, filename string, sidecar *xmp.Packet) (uuid.UUID, error) {
 	start := time.Now()
+	var (
+		target *Photo
+		id     uuid.UUID
+		err    error
+	)
+	target, err = s.importBinary(usr, raw, filename)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	applySidecar(&target.Desc, sidecar)
+	id, err = s.photos.Store(target)
+	if err != nil {
+		log.Err(err).Msg("Failed to store photo!")
+		return uuid.UUID{}, ErrStorageFailed
+	}
+	err = s.images.Store(target.ID.String(), image.RawVariant, target.Raw)
+	if err != nil {
+		log.Err(err).Msg("Failed to store photo!")
+		return uuid.UUID{}, ErrStorageFailed
+	}
+	if target.Tier = s.rawTier(id); target.Tier == image.FrozenTier {
+		if err = s.photos.Update(target); err != nil {
+			log.Err(err).Msg("Failed to store photo!")
+			return uuid.UUID{}, ErrStorageFailed
+		}
+	}
+	if err = s.storeRenditions(target); err != nil {
+		return uuid.UUID{}, err
+	}
+	log.Debug().Str("file", filename).Dur("elapsed", time.Since(start)).Msg("photo stored")
+	return id, err
+}
+
+// importBinary creates the photo entity with renditions from the RAW file, checks the quotas and whether the user
+// has already uploaded the same file.
+func (s UploadService) importBinary(usr *user.User, raw []byte, filename string) (*Photo, error) {
 	var (
 		target        *Photo
-		id            uuid.UUID
 		quotaExceeded bool
 		err           error
 	)
+	if !supported(filename) {
+		return nil, ErrUnsupportedFormat
+	}
 	target, err = createPhoto(
+		s.resampling,
 		*usr,
 		filepath.Base(filename),
 		filepath.Ext(filename)[1:],
@@ -81,29 +275,239 @@ func (s UploadService) uploadBinary(usr *user.User, raw []byte, filename string)
 	)
 	if err != nil {
 		log.Err(err).Msg("Failed to create photo entity!")
-		return uuid.UUID{}, fmt.Errorf("Uploaded file format is not supported! Cause: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrDecodeFailed, err)
+	}
+	if id, found := s.duplicate(usr, target); found {
+		return nil, DuplicateError{ID: id}
 	}
 	quotaExceeded, err = s.exceededUserQuota(usr, target.Desc.Metadata.DataSize)
 	if quotaExceeded || err != nil {
-		return uuid.UUID{}, errors.New("you can not upload files, you have reached your quota")
+		return nil, ErrQuotaExceeded
 	}
 	quotaExceeded, err = s.exceededGlobalQuota(target.Desc.Metadata.DataSize)
 	if quotaExceeded || err != nil {
 		log.Error().Msg("Global quota exceeded!")
-		return uuid.UUID{}, errors.New("you can not upload files, please contact an administrator")
+		return nil, ErrGlobalQuotaExceeded
 	}
-	id, err = s.photos.Store(target)
+	return target, nil
+}
+
+// rawTier returns the storage tier of the RAW file of the photo in the image store. With deduplication the RAW of a
+// new photo can be the content of another photo, already moved to frozen storage.
+func (s UploadService) rawTier(id uuid.UUID) image.Tier {
+	tier, err := s.images.Tier(id.String())
 	if err != nil {
-		log.Err(err).Msg("Failed to store photo!")
-		return uuid.UUID{}, errors.New("uploaded file could not be stored")
+		log.Err(err).Str("id", id.String()).Msg("Failed to read storage tier of uploaded file!")
+		return image.StandardTier
+	}
+	return tier
+}
+
+func (s UploadService) storeRenditions(target *Photo) error {
+	for _, r := range target.Renditions {
+		if err := s.images.Store(target.ID.String(), r.Variant, r.Image); err != nil {
+			log.Err(err).Str("variant", string(r.Variant)).Msg("Failed to store rendition!")
+			return ErrStorageFailed
+		}
+	}
+	return nil
+}
+
+// Presign is a method of `UploadService` creating presigned requests to upload RAW files directly to the image store.
+// Each file gets an upload ticket, valid for a day, that has to be finalized after the upload with `Finalize`. The
+// requests are only valid for files of the declared sizes. Duplicates are handled on finalize according to the
+// duplicate policy. The quota is checked against the declared sizes of the files, and again on finalize.
+func (s UploadService) Presign(usr *user.User, files []PresignUploadFile, policy DuplicatePolicy) ([]PresignUploadResponse, error) {
+	if !s.images.SupportsPresign() {
+		return nil, ErrPresignUnsupported
+	}
+	var total int64
+	for _, f := range files {
+		if !supported(f.Name) {
+			return nil, ErrUnsupportedFormat
+		}
+		total += f.Size
+	}
+	if exceeded, err := s.exceededUserQuota(usr, total); exceeded || err != nil {
+		return nil, ErrQuotaExceeded
+	}
+
+	result := make([]PresignUploadResponse, len(files))
+	for i, f := range files {
+		ticket := &UploadTicket{
+			ID:        uuid.New(),
+			UserID:    usr.ID,
+			FileName:  filepath.Base(f.Name),
+			Size:      f.Size,
+			Policy:    policy,
+			ExpiresAt: time.Now().Add(uploadTicketTTL),
+		}
+		if err := s.tickets.Store(ticket); err != nil {
+			return nil, err
+		}
+		request, err := s.images.PresignUpload(ticket.ID.String(), image.RawVariant, ticket.Size)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = PresignUploadResponse{
+			ID:      ticket.ID.String(),
+			Name:    ticket.FileName,
+			Request: request,
+		}
+	}
+	return result, nil
+}
+
+// Finalize is a method of `UploadService` importing RAW files uploaded with presigned requests and creating the
+// photos with the IDs of the tickets. Files failing the import or the quota are deleted from the image store, so are
+// duplicates, handled according to the duplicate policy of the ticket. Finalizing an upload again returns the photo
+// already created. Returns the result of each upload, a failing upload does not stop finalizing the rest.
+func (s UploadService) Finalize(usr *user.User, ids []uuid.UUID) []UploadResult {
+	result := make([]UploadResult, len(ids))
+	for i, id := range ids {
+		result[i] = s.finalize(usr, id)
 	}
-	err = s.images.Store(target.ID.String(), target.Raw, target.Thumbnail)
+	return result
+}
+
+// InFlight is a method of `UploadService` checking whether the ID is of a presigned or resumable upload not finalized
+// yet, so the scrub keeps its RAW file, also while a resumable upload is moved from the staging area. Files of
+// abandoned uploads are deleted by `Cleanup` when the ticket expires.
+func (s UploadService) InFlight(id string) (bool, error) {
+	tid, err := uuid.Parse(id)
+	if err != nil {
+		return false, nil
+	}
+	_, err = s.tickets.Load(tid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s UploadService) finalize(usr *user.User, id uuid.UUID) UploadResult {
+	res := UploadResult{FileName: id.String(), ID: id}
+	ticket, err := s.tickets.Load(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if p, perr := s.photos.Load(id.String()); perr == nil && p.UserID == usr.ID {
+			return res // already finalized
+		}
+		res.Err = ErrTicketNotFound
+		return res
+	}
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if ticket.UserID != usr.ID {
+		res.Err = ErrTicketNotFound
+		return res
+	}
+	res.FileName = ticket.FileName
+	res.Err = s.importTicket(usr, ticket)
+	return resolve(res, ticket.Policy)
+}
+
+// importTicket imports the RAW file uploaded with the ticket into a photo with the ID of the ticket. The file and
+// the ticket are discarded if the import fails.
+func (s UploadService) importTicket(usr *user.User, ticket *UploadTicket) error {
+	if ticket.ExpiresAt.Before(time.Now()) {
+		s.discard(ticket)
+		return ErrTicketExpired
+	}
+
+	raw, err := s.loadStored(ticket.ID)
 	if err != nil {
+		return err
+	}
+	if ticket.Size > 0 && int64(len(raw)) != ticket.Size {
+		s.discard(ticket)
+		return ErrUploadSizeMismatch
+	}
+	target, err := s.importBinary(usr, raw, ticket.FileName)
+	if err != nil {
+		s.discard(ticket)
+		return err
+	}
+	if err = s.storeImported(target, ticket.ID); err != nil {
+		return err
+	}
+	if c, ok := s.images.(image.Committer); ok {
+		if err = c.Commit(ticket.ID.String(), image.RawVariant); err != nil {
+			log.Err(err).Str("id", ticket.ID.String()).Msg("Failed to commit uploaded file!")
+		}
+	}
+	return s.tickets.Delete(ticket.ID)
+}
+
+// duplicate finds the photo of the user with the same RAW file, or a photo of the same capture time and dimensions
+// that is either of the same format and data size, or has a thumbnail with a nearly identical perceptual hash, e.g.
+// a re-saved or re-exported copy. Photos of bursts and brackets differ in capture time or in their thumbnails, so
+// they are not duplicates, nor photos with unknown capture time.
+func (s UploadService) duplicate(usr *user.User, target *Photo) (uuid.UUID, bool) {
+	if p, err := s.photos.ByChecksum(usr.ID.String(), target.Checksum); err == nil {
+		return p.ID, true
+	}
+	if target.Desc.Metadata.Timestamp == 0 {
+		return uuid.UUID{}, false
+	}
+	candidates, err := s.photos.ByCapture(usr.ID.String(), target.Desc.Metadata)
+	if err != nil {
+		log.Err(err).Msg("Failed to find photos of the same capture!")
+		return uuid.UUID{}, false
+	}
+	for _, f := range candidates {
+		if f.Format == target.Desc.Format && f.DataSize == target.Desc.Metadata.DataSize {
+			return f.ID, true
+		}
+		if f.DHash != nil && target.Desc.DHash != nil &&
+			image.Distance(uint64(*f.DHash), uint64(*target.Desc.DHash)) <= duplicateDistance {
+			return f.ID, true
+		}
+	}
+	return uuid.UUID{}, false
+}
+
+// loadStored loads the RAW file uploaded to the image store.
+func (s UploadService) loadStored(id uuid.UUID) ([]byte, error) {
+	raw, err := s.images.Load(id.String(), image.RawVariant)
+	if err != nil {
+		log.Err(err).Str("id", id.String()).Msg("Failed to load uploaded file!")
+		return nil, ErrUploadMissing
+	}
+	return raw, nil
+}
+
+// storeImported stores the photo imported from a RAW file already in the image store, with the ID of the upload.
+// The photo is deleted if its renditions can not be stored, so a failed import does not leave a photo behind.
+func (s UploadService) storeImported(target *Photo, id uuid.UUID) error {
+	target.ID = id
+	target.Tier = s.rawTier(id)
+	if _, err := s.photos.Store(target); err != nil {
 		log.Err(err).Msg("Failed to store photo!")
-		return uuid.UUID{}, errors.New("uploaded file could not be stored")
+		return ErrStorageFailed
+	}
+	err := s.storeRenditions(target)
+	if err == nil {
+		return nil
+	}
+	if derr := s.photos.Delete(id.String()); derr != nil {
+		log.Err(derr).Str("id", id.String()).Msg("Failed to delete photo of a failed import!")
+	}
+	return err
+}
+
+// discard deletes the ticket and the file uploaded or staged with it.
+func (s UploadService) discard(ticket *UploadTicket) {
+	if err := os.Remove(s.staged(ticket.ID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Err(err).Str("id", ticket.ID.String()).Msg("Failed to remove staged upload!")
+	}
+	if err := s.images.Delete(ticket.ID.String()); err != nil {
+		log.Err(err).Str("id", ticket.ID.String()).Msg("Failed to delete uploaded file!")
+	}
+	if err := s.tickets.Delete(ticket.ID); err != nil {
+		log.Err(err).Str("id", ticket.ID.String()).Msg("Failed to delete upload ticket!")
 	}
-	log.Debug().Str("file", filename).Dur("elapsed", time.Since(start)).Msg("photo stored")
-	return id, err
 }
 
 func (s UploadService) exceededGlobalQuota(fileSize int64) (bool, error) {
@@ -125,6 +529,8 @@ func (s UploadService) exceededGlobalQuota(fileSize int64) (bool, error) {
 	return stats.UsedSpace+fileSize > quota, nil
 }
 
+// exceededUserQuota checks the quota of the user against the logical usage - the used space of all photos of the user -
+// even if the image store deduplicates the content, so each user is charged for the files they uploaded.
 func (s UploadService) exceededUserQuota(usr *user.User, fileSize int64) (bool, error) {
 	var (
 		stats UserStats
@@ -142,14 +548,24 @@ func (s UploadService) exceededUserQuota(usr *user.User, fileSize int64) (bool,
 	return stats.UsedSpace+fileSize > usr.Role.Quota, nil
 }
 
+// supported checks whether the file can be imported based on its extension.
+func supported(filename string) bool {
+	ext := filepath.Ext(filename)
+	return len(ext) > 1 && importer.Supports(string(descriptor.ParseFormat(ext[1:])))
+}
+
 func closeRequestFile(mp multipart.File) {
 	mp.Close()
 }
 
-func createPhoto(user user.User, filename, extension string, raw []byte) (*Photo, error) {
+func createPhoto(resampling image.Resampling, user user.User, filename, extension string, raw []byte) (*Photo, error) {
 	i := importer.NewImporter(string(descriptor.ParseFormat(extension)))
 	start := time.Now()
-	thumbnail, err := i.Thumbnail(raw)
+	preview, err := i.Preview(raw)
+	if err != nil {
+		return nil, err
+	}
+	renditions, err := resampling.CreateRenditions(*preview)
 	log.Debug().Dur("Elapsed", time.Since(start)).Str("File", filename).Msg("Image import monitored.")
 	if err != nil {
 		return nil, err
@@ -158,6 +574,20 @@ func createPhoto(user user.User, filename, extension string, raw []byte) (*Photo
 	if err != nil {
 		return nil, err
 	}
+	locate(metadata)
+	usedSpace := len(raw)
+	thumbnail := renditions[0]
+	for _, r := range renditions {
+		usedSpace += len(r.Image)
+		if r.Variant == image.ThumbnailVariant {
+			thumbnail = r
+		}
+	}
+	sum := sha256.Sum256(raw)
+	dHash, err := thumbnailHash(thumbnail)
+	if err != nil {
+		return nil, err
+	}
 	res := &Photo{
 		Desc: descriptor.Descriptor{
 			FileName:    filename,
@@ -166,15 +596,186 @@ func createPhoto(user user.User, filename, extension string, raw []byte) (*Photo
 			Metadata:    *metadata,
 			ThumbWidth:  thumbnail.Width,
 			ThumbHeight: thumbnail.Height,
+			DHash:       &dHash,
 		},
-		User:      user,
-		Raw:       raw,
-		Thumbnail: thumbnail.Image,
-		UsedSpace: len(raw) + len(thumbnail.Image),
+		User:       user,
+		Raw:        raw,
+		Renditions: renditions,
+		UsedSpace:  usedSpace,
+		Checksum:   hex.EncodeToString(sum[:]),
 	}
 	return res, nil
 }
 
+// renditions creates the renditions of a photo from its RAW with the resampling, rotated clockwise by the degrees.
+func renditions(resampling image.Resampling, format descriptor.Format, raw []byte, rotation int) ([]image.ThumbnailImg, error) {
+	preview, err := importer.NewImporter(string(format)).Preview(raw)
+	if err != nil {
+		return nil, err
+	}
+	return resampling.CreateRenditions(image.Rotate(*preview, rotation))
+}
+
+// thumbnailHash calculates the perceptual hash of the thumbnail rendition.
+func thumbnailHash(thumbnail image.ThumbnailImg) (int64, error) {
+	img, err := image.ImportJpeg(thumbnail.Image)
+	if err != nil {
+		return 0, err
+	}
+	return int64(image.DHash(img)), nil
+}
+
+// ErrUnsupportedTarget is an error for lifecycle targets that are not storage tiers
+var ErrUnsupportedTarget = errors.New("target is not a supported storage")
+
+// TierService is a service moving photos between storage tiers, implementing the `MoveTo` lifecycle action.
+type TierService struct {
+	photos Storer
+	images image.Storer
+}
+
+// NewTierService creates a `TierService` instance based on the storers.
+func NewTierService(photos Storer, images image.Storer) *TierService {
+	return &TierService{
+		photos: photos,
+		images: images,
+	}
+}
+
+// MoveTo is a method of `TierService` moving the RAW of a photo to the storage tier of the lifecycle target, and
+// recording the tier on the photo. When the photo is being restored from frozen storage `image.ErrRestoreInProgress`
+// is returned and the tier of the photo is unchanged.
+func (s TierService) MoveTo(p *Photo, target rule.Target) error {
+	var (
+		tier image.Tier
+		err  error
+	)
+	tier, err = tierFor(target)
+	if err != nil {
+		return err
+	}
+	if err = s.images.MoveTo(p.ID.String(), tier); err != nil {
+		return err
+	}
+	p.Tier = tier
+	return s.photos.Update(p)
+}
+
+func tierFor(target rule.Target) (image.Tier, error) {
+	switch target {
+	case rule.StandardStorage:
+		return image.StandardTier, nil
+	case rule.FrozenStorage:
+		return image.FrozenTier, nil
+	}
+	return 0, ErrUnsupportedTarget
+}
+
+// ErrFrozenPhoto is an error for operations requiring the RAW of a photo in frozen storage
+var ErrFrozenPhoto = errors.New("photo is in frozen storage")
+
+// RenderService is a service rendering photos with transforms, and rotating their renditions. Renders are cached in
+// the image store as variants of the photo.
+type RenderService struct {
+	photos     Storer
+	images     image.Storer
+	resampling image.Resampling
+}
+
+// NewRenderService creates a `RenderService` instance based on the storers, regenerating renditions with the
+// resampling.
+func NewRenderService(photos Storer, images image.Storer, resampling image.Resampling) *RenderService {
+	return &RenderService{
+		photos:     photos,
+		images:     images,
+		resampling: resampling,
+	}
+}
+
+// Render is a method of `RenderService` returning the render of the photo with the transform, capped at the size of
+// the photo. The render is served from the cache if available, otherwise the RAW is decoded, transformed and the
+// result is cached.
+func (s RenderService) Render(p *Photo, t image.Transform) (*image.Content, image.Variant, error) {
+	t = t.Within(p.Desc.Metadata.Width, p.Desc.Metadata.Height)
+	var (
+		id      = p.ID.String()
+		variant = t.Variant(p.Desc.Rotation)
+		raw     []byte
+		im      *goimage.Image
+		res     []byte
+	)
+	content, err := image.OpenContent(s.images, id, variant)
+	if err == nil {
+		return content, variant, nil
+	}
+	if p.Tier == image.FrozenTier {
+		return nil, variant, ErrFrozenPhoto
+	}
+
+	start := time.Now()
+	raw, err = s.images.Load(id, image.RawVariant)
+	if err != nil {
+		return nil, variant, err
+	}
+	im, err = importer.NewImporter(string(p.Desc.Format)).Image(raw)
+	if err != nil {
+		return nil, variant, err
+	}
+	res, err = image.Encode(image.Apply(image.Rotate(*im, p.Desc.Rotation), t), t)
+	if err != nil {
+		return nil, variant, err
+	}
+	if err = s.images.Store(id, variant, res); err != nil {
+		return nil, variant, err
+	}
+	log.Debug().Str("id", id).Str("variant", string(variant)).Dur("elapsed", time.Since(start)).Msg("photo rendered")
+	content, err = image.OpenContent(s.images, id, variant)
+	return content, variant, err
+}
+
+// Rotate is a method of `RenderService` rotating the photo clockwise by the degrees, a multiple of 90, on top of its
+// current rotation. The renditions are regenerated from the RAW and the cached renders are deleted, the RAW is kept
+// as uploaded.
+func (s RenderService) Rotate(p *Photo, degrees int) error {
+	var (
+		id       = p.ID.String()
+		rotation int
+		raw      []byte
+		rs       []image.ThumbnailImg
+		err      error
+	)
+	if rotation, err = image.NormalizeRotation(p.Desc.Rotation + degrees); err != nil {
+		return err
+	}
+	if p.Tier == image.FrozenTier {
+		return ErrFrozenPhoto
+	}
+	if raw, err = s.images.Load(id, image.RawVariant); err != nil {
+		return err
+	}
+	if rs, err = renditions(s.resampling, p.Desc.Format, raw, rotation); err != nil {
+		return err
+	}
+	for _, r := range rs {
+		if err = s.images.Store(id, r.Variant, r.Image); err != nil {
+			return err
+		}
+		if r.Variant == image.ThumbnailVariant {
+			p.Desc.ThumbWidth, p.Desc.ThumbHeight = r.Width, r.Height
+		}
+	}
+	p.Desc.Rotation = rotation
+	if err = s.photos.UpdateRotation(p); err != nil {
+		return err
+	}
+	return s.Invalidate(id)
+}
+
+// Invalidate is a method of `RenderService` deleting all cached renders of the photo.
+func (s RenderService) Invalidate(id string) error {
+	return s.images.DeleteVariants(id, image.RenderPrefix)
+}
+
 // LoadService is a service for retrieving raw and thumbnail files and links
 type LoadService struct {
 	photos Storer
@@ -210,8 +811,8 @@ func (s LoadService) AsResponse(result []Photo, baseURL string) ([]Response, err
 
 // ThumbnailURL generates presigned URL for a thumbnail
 func (s LoadService) ThumbnailURL(photoID uuid.UUID, baseURL string) (*image.PresignedRequest, error) {
-	if s.cfg.UsePresigned {
-		return s.images.PresignThumbnail(photoID.String())
+	if s.presigned() {
+		return s.images.Presign(photoID.String(), image.ThumbnailVariant)
 	}
 	return presign(baseURL + photoID.String() + "/thumbnail"), nil
 }
@@ -221,22 +822,38 @@ func (s LoadService) decorateWithRequest(photo *Response, baseURL string) error
 		id  = photo.ID
 		err error
 	)
-	if s.cfg.UsePresigned {
-		photo.Raw, err = s.images.PresignImage(id)
+	photo.Previews = make(map[string]*image.PresignedRequest, len(image.Renditions))
+	if s.presigned() {
+		photo.Raw, err = s.images.Presign(id, image.RawVariant)
 		if err != nil {
 			return err
 		}
-		photo.Thumbnail, err = s.images.PresignThumbnail(id)
+		photo.Thumbnail, err = s.images.Presign(id, image.ThumbnailVariant)
 		if err != nil {
 			return err
 		}
+		for _, r := range image.Renditions {
+			photo.Previews[r.Size], err = s.images.Presign(id, r.Variant)
+			if err != nil {
+				return err
+			}
+		}
 	} else {
 		photo.Raw = presign(baseURL + "/raw")
 		photo.Thumbnail = presign(baseURL + "/thumbnail")
+		for _, r := range image.Renditions {
+			photo.Previews[r.Size] = presign(baseURL + "/preview/" + r.Size)
+		}
 	}
 	return nil
 }
 
+// presigned returns whether presigned requests are configured and supported by the image store, e.g. encrypted
+// stores can not presign.
+func (s LoadService) presigned() bool {
+	return s.cfg.UsePresigned && s.images.SupportsPresign()
+}
+
 func presign(URL string) *image.PresignedRequest {
 	return &image.PresignedRequest{
 		URL:    URL,
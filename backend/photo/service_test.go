@@ -0,0 +1,322 @@
+package photo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	goimage "image"
+	"image/png"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/common"
+	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/photo/descriptor"
+	"gorm.io/gorm"
+)
+
+func TestFinalize(t *testing.T) {
+	images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	var (
+		usr      = &user.User{ID: uuid.New()}
+		unknown  = uuid.New()
+		missing  = uuid.New()
+		mismatch = uuid.New()
+		expires  = time.Now().Add(uploadTicketTTL)
+		tickets  = memTickets{
+			missing:  {ID: missing, UserID: usr.ID, FileName: "missing.dng", Size: 3, ExpiresAt: expires},
+			mismatch: {ID: mismatch, UserID: usr.ID, FileName: "mismatch.dng", Size: 3, ExpiresAt: expires},
+		}
+		s = NewUploadService(scrubPhotos{}, images, tickets, &common.ImageStoreConfig{StagingPath: t.TempDir()}, image.DefaultResampling())
+	)
+	if err = images.Store(mismatch.String(), image.RawVariant, []byte("larger")); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	tests := []struct {
+		id   uuid.UUID
+		name string
+		err  error
+	}{
+		{unknown, unknown.String(), ErrTicketNotFound},
+		{missing, "missing.dng", ErrUploadMissing},
+		{mismatch, "mismatch.dng", ErrUploadSizeMismatch},
+	}
+	results := s.Finalize(usr, []uuid.UUID{unknown, missing, mismatch})
+	if len(results) != len(tests) {
+		t.Fatalf("Finalize() = %v results; want %v", len(results), len(tests))
+	}
+	for i, tt := range tests {
+		if results[i].ID != tt.id || results[i].FileName != tt.name || !errors.Is(results[i].Err, tt.err) {
+			t.Errorf("Finalize()[%v] = %v %v %v; want %v %v %v", i, results[i].ID, results[i].FileName, results[i].Err, tt.id, tt.name, tt.err)
+		}
+	}
+	if _, ok := tickets[mismatch]; ok {
+		t.Errorf("Finalize() kept the ticket of an upload of another size")
+	}
+	if _, err = images.Stat(mismatch.String(), image.RawVariant); err == nil {
+		t.Errorf("Finalize() kept the file of an upload of another size")
+	}
+}
+
+// capturePhotos is a `Storer` with a single photo, found by checksum or by capture.
+type capturePhotos struct {
+	Storer
+	photo Photo
+}
+
+func (s capturePhotos) ByChecksum(userID string, checksum string) (*Photo, error) {
+	if checksum != s.photo.Checksum {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &s.photo, nil
+}
+
+func (s capturePhotos) ByCapture(userID string, metadata image.Metadata) ([]Fingerprint, error) {
+	m := s.photo.Desc.Metadata
+	if metadata.Timestamp != m.Timestamp || metadata.Width != m.Width || metadata.Height != m.Height {
+		return nil, nil
+	}
+	return []Fingerprint{{ID: s.photo.ID, Format: s.photo.Desc.Format, DataSize: m.DataSize, DHash: s.photo.Desc.DHash}}, nil
+}
+
+func TestDuplicate(t *testing.T) {
+	capture := func(checksum string, format string, timestamp int64, width int, size int64, hash int64) *Photo {
+		return &Photo{
+			Checksum: checksum,
+			Desc: descriptor.Descriptor{
+				Format:   descriptor.Format(format),
+				Metadata: image.Metadata{Timestamp: timestamp, Width: width, Height: 4000, DataSize: size},
+				DHash:    &hash,
+			},
+		}
+	}
+	stored := capture("stored", "dng", 1000, 6000, 25000000, 0x0f0f)
+	stored.ID = uuid.New()
+	unknown := capture("unknown", "dng", 0, 6000, 25000000, 0x0f0f)
+	unknown.ID = uuid.New()
+
+	tests := []struct {
+		name   string
+		stored *Photo
+		target *Photo
+		want   bool
+	}{
+		{name: "same file", stored: stored, target: capture("stored", "dng", 2000, 3000, 1, 0), want: true},
+		{name: "same capture", stored: stored, target: capture("edited", "dng", 1000, 6000, 25000000, 0xf0f0), want: true},
+		{name: "re-export", stored: stored, target: capture("export", "jpg", 1000, 6000, 9000000, 0x0f0f), want: true},
+		{name: "re-save", stored: stored, target: capture("resave", "dng", 1000, 6000, 24000000, 0x0f0c), want: true},
+		{name: "bracket", stored: stored, target: capture("bracket", "dng", 1000, 6000, 24000000, 0x0f00), want: false},
+		{name: "burst", stored: stored, target: capture("burst", "dng", 1001, 6000, 25000000, 0x0f0f), want: false},
+		{name: "crop", stored: stored, target: capture("crop", "dng", 1000, 4000, 25000000, 0x0f0f), want: false},
+		{name: "unknown capture time", stored: unknown, target: capture("other", "dng", 0, 6000, 25000000, 0x0f0f), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewUploadService(capturePhotos{photo: *tt.stored}, nil, nil, nil, image.DefaultResampling())
+			id, found := s.duplicate(&user.User{ID: uuid.New()}, tt.target)
+			if found != tt.want || (found && id != tt.stored.ID) {
+				t.Errorf("duplicate() = %v %v; want %v %v", id, found, tt.stored.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestFinalizeDuplicates(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, goimage.NewRGBA(goimage.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	var (
+		raw    = buf.Bytes()
+		sum    = sha256.Sum256(raw)
+		usr    = &user.User{ID: uuid.New()}
+		stored = Photo{ID: uuid.New(), Checksum: hex.EncodeToString(sum[:])}
+	)
+
+	tests := []struct {
+		policy  DuplicatePolicy
+		err     error
+		skipped bool
+	}{
+		{policy: RejectDuplicates, err: ErrDuplicate},
+		{policy: "", err: ErrDuplicate},
+		{policy: SkipDuplicates, skipped: true},
+		{policy: LinkDuplicates},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+			if err != nil {
+				t.Fatalf("NewLocalStorer failed: %v", err)
+			}
+			var (
+				id      = uuid.New()
+				tickets = memTickets{id: {ID: id, UserID: usr.ID, FileName: "copy.png", Size: int64(len(raw)), Policy: tt.policy, ExpiresAt: time.Now().Add(uploadTicketTTL)}}
+				s       = NewUploadService(capturePhotos{photo: stored}, images, tickets, &common.ImageStoreConfig{StagingPath: t.TempDir()}, image.DefaultResampling())
+			)
+			if err = images.Store(id.String(), image.RawVariant, raw); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+
+			res := s.Finalize(usr, []uuid.UUID{id})[0]
+			if !errors.Is(res.Err, tt.err) || (tt.err == nil && (res.ID != stored.ID || !res.Duplicate || res.Skipped != tt.skipped)) {
+				t.Errorf("Finalize() = %+v; want duplicate of %v, skipped %v, error %v", res, stored.ID, tt.skipped, tt.err)
+			}
+			if _, ok := tickets[id]; ok {
+				t.Errorf("Finalize() kept the ticket of a duplicate")
+			}
+			if _, err = images.Stat(id.String(), image.RawVariant); err == nil {
+				t.Errorf("Finalize() kept the file of a duplicate")
+			}
+		})
+	}
+}
+
+func TestRenditions(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, goimage.NewRGBA(goimage.Rect(0, 0, 64, 32))); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	resampling := image.Resampling{Kernel: "nearest", Quality: 75, MaxWidth: 16, MaxHeight: 16}
+
+	tests := []struct {
+		rotation      int
+		width, height int
+	}{
+		{rotation: 0, width: 16, height: 8},
+		{rotation: 90, width: 8, height: 16},
+	}
+	for _, tt := range tests {
+		rs, err := renditions(resampling, descriptor.ParseFormat("png"), buf.Bytes(), tt.rotation)
+		if err != nil {
+			t.Fatalf("renditions(%v) failed: %v", tt.rotation, err)
+		}
+		for _, r := range rs {
+			if r.Variant == image.ThumbnailVariant && (r.Width != tt.width || r.Height != tt.height) {
+				t.Errorf("renditions(%v) thumbnail = (%v, %v); want (%v, %v)", tt.rotation, r.Width, r.Height, tt.width, tt.height)
+			}
+		}
+	}
+}
+
+// memRefs is an `image.RefStorer` keeping the references of the blobs in memory.
+type memRefs map[string]string
+
+func (r memRefs) Hash(id string) (string, error) {
+	hash, ok := r[id]
+	if !ok {
+		return "", gorm.ErrRecordNotFound
+	}
+	return hash, nil
+}
+
+func (r memRefs) Reference(id string, hash string, size int64) (bool, error) {
+	written := false
+	for _, h := range r {
+		written = written || h == hash
+	}
+	r[id] = hash
+	return written, nil
+}
+
+func (r memRefs) Written(hash string) error {
+	return nil
+}
+
+func (r memRefs) Release(id string) (string, int, error) {
+	hash, ok := r[id]
+	if !ok {
+		return "", 0, gorm.ErrRecordNotFound
+	}
+	delete(r, id)
+	refs, _ := r.Refs(hash)
+	return hash, len(refs), nil
+}
+
+func (r memRefs) Refs(hash string) ([]string, error) {
+	var ids []string
+	for id, h := range r {
+		if h == hash {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func TestUploadFrozenContent(t *testing.T) {
+	frozen := pngContent(t)
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, goimage.NewRGBA(goimage.Rect(0, 0, 17, 17))); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	unique := buf.Bytes()
+
+	tests := []struct {
+		name      string
+		content   []byte
+		resumable bool
+		want      image.Tier
+	}{
+		{name: "frozen content", content: frozen, want: image.FrozenTier},
+		{name: "frozen content resumable", content: frozen, resumable: true, want: image.FrozenTier},
+		{name: "new content", content: unique, want: image.StandardTier},
+		{name: "new content resumable", content: unique, resumable: true, want: image.StandardTier},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+			if err != nil {
+				t.Fatalf("NewLocalStorer failed: %v", err)
+			}
+			var (
+				images = image.NewDedupStorer(local, memRefs{})
+				photos = map[string]Photo{}
+				usr    = &user.User{ID: uuid.New()}
+				config = &common.ImageStoreConfig{StagingPath: t.TempDir()}
+				s      = NewUploadService(memPhotos{photos: photos}, images, memTickets{}, config, image.DefaultResampling())
+				other  = uuid.NewString() // photo of another user with the same content, already frozen
+			)
+			if err = images.Store(other, image.RawVariant, frozen); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			if err = images.MoveTo(other, image.FrozenTier); err != nil {
+				t.Fatalf("MoveTo failed: %v", err)
+			}
+
+			var res UploadResult
+			if tt.resumable {
+				ticket, err := s.Stage(usr, "photo.png", int64(len(tt.content)), SkipDuplicates)
+				if err != nil {
+					t.Fatalf("Stage failed: %v", err)
+				}
+				_, finalized, err := s.Append(usr, ticket.ID, 0, bytes.NewReader(tt.content))
+				if err != nil || finalized == nil {
+					t.Fatalf("Append() = %v, %v; want finalized upload", finalized, err)
+				}
+				res = *finalized
+			} else {
+				var (
+					ch = make(chan UploadResult, 1)
+					wg sync.WaitGroup
+				)
+				wg.Add(1)
+				s.Upload(usr, UploadFile{Raw: formFile(t, "photo.png", tt.content)}, SkipDuplicates, ch, &wg)
+				res = <-ch
+			}
+			if res.Err != nil {
+				t.Fatalf("Upload = %v; want no error", res.Err)
+			}
+			if tier := photos[res.ID.String()].Tier; tier != tt.want {
+				t.Errorf("Upload stored tier %v; want %v", tier, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package photo
+
+import (
+	"io"
+	"mime/multipart"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/inokone/photostorage/image/xmp"
+	"github.com/inokone/photostorage/photo/descriptor"
+)
+
+const (
+	sidecarExtension = ".xmp"
+	// maxSidecarSize is the size limit of XMP sidecars, sidecars with long edit histories are still far below
+	maxSidecarSize = 4 << 20
+	maxRating      = 5
+)
+
+// UploadFile is a RAW file uploaded in a request, with the XMP sidecar uploaded along with it, if any.
+type UploadFile struct {
+	Raw     *multipart.FileHeader
+	Sidecar *multipart.FileHeader
+}
+
+// IsSidecar checks whether the file is an XMP sidecar based on its extension.
+func IsSidecar(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), sidecarExtension)
+}
+
+// MatchSidecars pairs the files uploaded in a request with the XMP sidecars uploaded along by base file name, both
+// `IMG_1.xmp` and `IMG_1.CR2.xmp` are the sidecar of `IMG_1.CR2`. Returns the files that are not sidecars, and the
+// sidecars without a matching file.
+func MatchSidecars(files []*multipart.FileHeader) ([]UploadFile, []*multipart.FileHeader) {
+	var (
+		res      []UploadFile
+		sidecars = make(map[string]*multipart.FileHeader)
+		matched  = make(map[*multipart.FileHeader]bool)
+	)
+	for _, f := range files {
+		if IsSidecar(f.Filename) {
+			sidecars[strings.ToLower(strings.TrimSuffix(filepath.Base(f.Filename), filepath.Ext(f.Filename)))] = f
+		}
+	}
+	for _, f := range files {
+		if IsSidecar(f.Filename) {
+			continue
+		}
+		var (
+			name    = strings.ToLower(filepath.Base(f.Filename))
+			sidecar = sidecars[name]
+		)
+		if sidecar == nil {
+			sidecar = sidecars[strings.TrimSuffix(name, filepath.Ext(name))]
+		}
+		if sidecar != nil {
+			matched[sidecar] = true
+		}
+		res = append(res, UploadFile{Raw: f, Sidecar: sidecar})
+	}
+
+	var unmatched []*multipart.FileHeader
+	for _, f := range files {
+		if IsSidecar(f.Filename) && !matched[f] {
+			unmatched = append(unmatched, f)
+		}
+	}
+	return res, unmatched
+}
+
+// readSidecar reads the content of an uploaded XMP sidecar, nil if there is no sidecar.
+func readSidecar(file *multipart.FileHeader) ([]byte, error) {
+	if file == nil {
+		return nil, nil
+	}
+	if file.Size > maxSidecarSize {
+		return nil, ErrInvalidSidecar
+	}
+	mp, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer closeRequestFile(mp)
+	return io.ReadAll(mp)
+}
+
+// parseSidecar parses the content of an XMP sidecar, nil if there is no sidecar.
+func parseSidecar(content []byte) (*xmp.Packet, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+	p, err := xmp.Parse(content)
+	if err != nil {
+		return nil, ErrInvalidSidecar
+	}
+	return p, nil
+}
+
+// applySidecar merges the rating, favorite, keywords, color label and title of the sidecar into the descriptor. The
+// properties missing from the sidecar are kept, the keywords are added to the tags.
+func applySidecar(d *descriptor.Descriptor, p *xmp.Packet) {
+	if p == nil {
+		return
+	}
+	if p.Rating != nil {
+		d.Rating = int8(min(max(*p.Rating, 0), maxRating)) // rejected photos are rated -1
+	}
+	if p.Favorite != nil {
+		d.Favorite = *p.Favorite
+	}
+	for _, k := range p.Keywords {
+		if len(k) > 0 && !slices.Contains(d.Tags, k) {
+			d.Tags = append(d.Tags, k)
+		}
+	}
+	if len(p.Label) > 0 {
+		d.Label = p.Label
+	}
+	if len(p.Title) > 0 {
+		d.Title = p.Title
+	}
+}
+
+// Sidecar returns the XMP sidecar of the photo, with the current rating, favorite, tags, color label and title, to
+// be imported by desktop editors.
+func Sidecar(p *Photo) []byte {
+	var (
+		rating   = int(p.Desc.Rating)
+		favorite = p.Desc.Favorite
+	)
+	return xmp.Marshal(&xmp.Packet{
+		Rating:   &rating,
+		Favorite: &favorite,
+		Label:    p.Desc.Label,
+		Title:    p.Desc.Title,
+		Keywords: p.Desc.Tags,
+	})
+}
+
+// SidecarName returns the file name of the XMP sidecar of the photo, the name of the RAW with `.xmp` extension.
+func SidecarName(p *Photo) string {
+	name := filepath.Base(p.Desc.FileName)
+	return strings.TrimSuffix(name, filepath.Ext(name)) + sidecarExtension
+}
@@ -0,0 +1,63 @@
+package photo
+
+import (
+	"mime/multipart"
+	"slices"
+	"testing"
+
+	"github.com/inokone/photostorage/image/xmp"
+	"github.com/inokone/photostorage/photo/descriptor"
+)
+
+func TestMatchSidecars(t *testing.T) {
+	var (
+		raw1     = &multipart.FileHeader{Filename: "IMG_1.CR2"}
+		raw2     = &multipart.FileHeader{Filename: "IMG_2.NEF"}
+		raw3     = &multipart.FileHeader{Filename: "IMG_3.ARW"}
+		sidecar1 = &multipart.FileHeader{Filename: "img_1.xmp"}
+		sidecar2 = &multipart.FileHeader{Filename: "IMG_2.NEF.XMP"}
+		orphan   = &multipart.FileHeader{Filename: "IMG_4.xmp"}
+	)
+	files, unmatched := MatchSidecars([]*multipart.FileHeader{sidecar1, raw1, raw2, sidecar2, orphan, raw3})
+
+	want := []UploadFile{{Raw: raw1, Sidecar: sidecar1}, {Raw: raw2, Sidecar: sidecar2}, {Raw: raw3}}
+	if !slices.Equal(files, want) {
+		t.Errorf("MatchSidecars = %v; want %v", files, want)
+	}
+	if !slices.Equal(unmatched, []*multipart.FileHeader{orphan}) {
+		t.Errorf("Unmatched = %v; want [%v]", unmatched, orphan)
+	}
+}
+
+func TestApplySidecar(t *testing.T) {
+	var (
+		five     = 5
+		high     = 7
+		rejected = -1
+		favorite = true
+	)
+	tests := []struct {
+		name string
+		in   *xmp.Packet
+		want descriptor.Descriptor
+	}{
+		{name: "nil", in: nil, want: descriptor.Descriptor{Rating: 2, Tags: []string{"sea"}, Label: "Red"}},
+		{name: "empty", in: &xmp.Packet{}, want: descriptor.Descriptor{Rating: 2, Tags: []string{"sea"}, Label: "Red"}},
+		{
+			name: "all",
+			in:   &xmp.Packet{Rating: &five, Favorite: &favorite, Keywords: []string{"sea", "beach", ""}, Label: "Green", Title: "Sunset"},
+			want: descriptor.Descriptor{Rating: 5, Favorite: true, Tags: []string{"sea", "beach"}, Label: "Green", Title: "Sunset"},
+		},
+		{name: "too high", in: &xmp.Packet{Rating: &high}, want: descriptor.Descriptor{Rating: 5, Tags: []string{"sea"}, Label: "Red"}},
+		{name: "rejected", in: &xmp.Packet{Rating: &rejected}, want: descriptor.Descriptor{Rating: 0, Tags: []string{"sea"}, Label: "Red"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := descriptor.Descriptor{Rating: 2, Tags: []string{"sea"}, Label: "Red"}
+			applySidecar(&d, tt.in)
+			if d.Rating != tt.want.Rating || d.Favorite != tt.want.Favorite || d.Label != tt.want.Label || d.Title != tt.want.Title || !slices.Equal(d.Tags, tt.want.Tags) {
+				t.Errorf("applySidecar = %+v; want %+v", d, tt.want)
+			}
+		})
+	}
+}
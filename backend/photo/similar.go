@@ -0,0 +1,99 @@
+package photo
+
+import (
+	"sort"
+	"time"
+
+	"github.com/inokone/photostorage/image"
+)
+
+const (
+	// defaultSimilarDistance is the default maximal distance of the perceptual hashes of similar photos
+	defaultSimilarDistance = 10
+	// defaultSimilarWindow is the default maximal time between the capture of similar photos
+	defaultSimilarWindow = 10 * time.Second
+)
+
+// Cluster is a function grouping near-duplicate photos, e.g. bursts and brackets: photos of the same group have
+// perceptual hashes within the distance and were captured within the time window of another photo of the group.
+// Photos without perceptual hash or capture time are not grouped. Returns the groups of at least two photos in the
+// order of capture, the photos of a group are ordered by capture time.
+func Cluster(photos []Photo, distance int, window time.Duration) [][]Photo {
+	var candidates []Photo
+	for _, p := range photos {
+		if p.Desc.DHash != nil && p.Desc.Metadata.Timestamp != 0 {
+			candidates = append(candidates, p)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Desc.Metadata.Timestamp < candidates[j].Desc.Metadata.Timestamp
+	})
+
+	parent := make([]int, len(candidates))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+
+	seconds := int64(window / time.Second)
+	for i := range candidates {
+		for j := i + 1; j < len(candidates); j++ {
+			if candidates[j].Desc.Metadata.Timestamp-candidates[i].Desc.Metadata.Timestamp > seconds {
+				break
+			}
+			if image.Distance(uint64(*candidates[i].Desc.DHash), uint64(*candidates[j].Desc.DHash)) <= distance {
+				parent[root(j)] = root(i)
+			}
+		}
+	}
+
+	var (
+		groups [][]Photo
+		index  = make(map[int]int)
+	)
+	for i, p := range candidates {
+		r := root(i)
+		g, ok := index[r]
+		if !ok {
+			g = len(groups)
+			index[r] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], p)
+	}
+
+	result := groups[:0]
+	for _, g := range groups {
+		if len(g) > 1 {
+			result = append(result, g)
+		}
+	}
+	return result
+}
+
+// best returns the photo to keep of a group of similar photos: the one rated highest, then the favorite, then the
+// largest one.
+func best(group []Photo) Photo {
+	res := group[0]
+	for _, p := range group[1:] {
+		switch {
+		case p.Desc.Rating != res.Desc.Rating:
+			if p.Desc.Rating > res.Desc.Rating {
+				res = p
+			}
+		case p.Desc.Favorite != res.Desc.Favorite:
+			if p.Desc.Favorite {
+				res = p
+			}
+		case p.Desc.Metadata.Width*p.Desc.Metadata.Height > res.Desc.Metadata.Width*res.Desc.Metadata.Height:
+			res = p
+		}
+	}
+	return res
+}
@@ -0,0 +1,90 @@
+package photo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/photo/descriptor"
+)
+
+func similarPhoto(name string, dHash uint64, timestamp int64) Photo {
+	h := int64(dHash)
+	return Photo{
+		ID: uuid.NewSHA1(uuid.Nil, []byte(name)),
+		Desc: descriptor.Descriptor{
+			FileName: name,
+			DHash:    &h,
+			Metadata: image.Metadata{Timestamp: timestamp},
+		},
+	}
+}
+
+func TestCluster(t *testing.T) {
+	var (
+		burst1  = similarPhoto("burst1", 0xF0F0F0F0F0F0F0F0, 1000)
+		burst2  = similarPhoto("burst2", 0xF0F0F0F0F0F0F0F1, 1002)
+		burst3  = similarPhoto("burst3", 0xF0F0F0F0F0F0F0F3, 1009)
+		later   = similarPhoto("later", 0xF0F0F0F0F0F0F0F0, 2000)
+		other   = similarPhoto("other", 0x0F0F0F0F0F0F0F0F, 1001)
+		unknown = similarPhoto("unknown", 0xF0F0F0F0F0F0F0F0, 0)
+	)
+	tests := []struct {
+		name   string
+		photos []Photo
+		window time.Duration
+		want   [][]string
+	}{
+		{name: "burst", photos: []Photo{burst3, other, burst1, later, burst2, unknown}, window: 10 * time.Second, want: [][]string{{"burst1", "burst2", "burst3"}}},
+		{name: "chained", photos: []Photo{burst1, burst2, burst3}, window: 7 * time.Second, want: [][]string{{"burst1", "burst2", "burst3"}}},
+		{name: "narrow window", photos: []Photo{burst1, burst2, burst3}, window: time.Second, want: nil},
+		{name: "wide window", photos: []Photo{burst1, later}, window: time.Hour, want: [][]string{{"burst1", "later"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := Cluster(tt.photos, defaultSimilarDistance, tt.window)
+			if len(groups) != len(tt.want) {
+				t.Fatalf("Cluster returned %v groups; want %v", len(groups), len(tt.want))
+			}
+			for i, g := range groups {
+				if len(g) != len(tt.want[i]) {
+					t.Fatalf("Group %v has %v photos; want %v", i, len(g), tt.want[i])
+				}
+				for j, p := range g {
+					if p.Desc.FileName != tt.want[i][j] {
+						t.Errorf("Group %v photo %v = %v; want %v", i, j, p.Desc.FileName, tt.want[i][j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBest(t *testing.T) {
+	small := similarPhoto("small", 0, 1)
+	small.Desc.Metadata.Width, small.Desc.Metadata.Height = 100, 100
+	large := similarPhoto("large", 0, 1)
+	large.Desc.Metadata.Width, large.Desc.Metadata.Height = 200, 200
+	favorite := similarPhoto("favorite", 0, 1)
+	favorite.Desc.Favorite = true
+	rated := similarPhoto("rated", 0, 1)
+	rated.Desc.Rating = 3
+
+	tests := []struct {
+		name  string
+		group []Photo
+		want  string
+	}{
+		{name: "largest", group: []Photo{small, large}, want: "large"},
+		{name: "favorite", group: []Photo{large, favorite}, want: "favorite"},
+		{name: "rated", group: []Photo{favorite, rated, large}, want: "rated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := best(tt.group); actual.Desc.FileName != tt.want {
+				t.Errorf("best = %v; want %v", actual.Desc.FileName, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package photo
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/image"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const uploadCleanupInterval = time.Hour
+
+var (
+	// ErrOffsetMismatch is an error for chunks of resumable uploads not continuing at the end of the staged file
+	ErrOffsetMismatch = errors.New("upload offset does not match the staged file")
+	// ErrUploadTooLarge is an error for resumable uploads over the maximal upload size, or over the declared size
+	ErrUploadTooLarge = errors.New("upload is too large")
+	// ErrNotStaged is an error for resumable upload requests of uploads not in the staging area
+	ErrNotStaged = errors.New("upload is not staged")
+	// ErrUploadLocked is an error for resumable upload requests of uploads with a chunk being appended by another
+	// request
+	ErrUploadLocked = errors.New("upload is locked by another request")
+)
+
+// uploadLocks is the set of resumable uploads with a chunk being appended, so concurrent requests of an upload do
+// not write the staged file at the same time.
+type uploadLocks struct {
+	mu  sync.Mutex
+	ids map[uuid.UUID]bool
+}
+
+func newUploadLocks() *uploadLocks {
+	return &uploadLocks{ids: make(map[uuid.UUID]bool)}
+}
+
+// lock locks the upload, returns false if it is already locked.
+func (l *uploadLocks) lock(id uuid.UUID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ids[id] {
+		return false
+	}
+	l.ids[id] = true
+	return true
+}
+
+func (l *uploadLocks) unlock(id uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.ids, id)
+}
+
+// Stage is a method of `UploadService` creating a resumable upload of a RAW file with the declared size. Chunks of
+// the file are appended to the staging area with `Append`, the upload is finalized when the last chunk arrives.
+// Duplicates are handled on finalize according to the duplicate policy.
+func (s UploadService) Stage(usr *user.User, filename string, size int64, policy DuplicatePolicy) (*UploadTicket, error) {
+	if !supported(filename) {
+		return nil, ErrUnsupportedFormat
+	}
+	if size <= 0 || (s.config.MaxUploadSize > 0 && size > s.config.MaxUploadSize) {
+		return nil, ErrUploadTooLarge
+	}
+	if exceeded, err := s.exceededUserQuota(usr, size); exceeded || err != nil {
+		return nil, ErrQuotaExceeded
+	}
+
+	ticket := &UploadTicket{
+		ID:        uuid.New(),
+		UserID:    usr.ID,
+		FileName:  filepath.Base(filename),
+		Size:      size,
+		Policy:    policy,
+		ExpiresAt: time.Now().Add(uploadTicketTTL),
+	}
+	if err := os.MkdirAll(s.config.StagingPath, 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(s.staged(ticket.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	if err = s.tickets.Store(ticket); err != nil {
+		os.Remove(s.staged(ticket.ID))
+		return nil, err
+	}
+	return ticket, nil
+}
+
+// MaxUploadSize is a method of `UploadService` returning the maximal size of a resumable upload, 0 for no limit.
+func (s UploadService) MaxUploadSize() int64 {
+	return s.config.MaxUploadSize
+}
+
+// Offset is a method of `UploadService` returning the ticket of a resumable upload and the number of bytes staged.
+func (s UploadService) Offset(usr *user.User, id uuid.UUID) (*UploadTicket, int64, error) {
+	ticket, err := s.ticket(usr, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	staged, err := s.stagedSize(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ticket, staged, nil
+}
+
+// Append is a method of `UploadService` appending a chunk, starting at the offset, to a resumable upload. A chunk
+// interrupted by a dropped connection is kept as far as it arrived. When the last chunk arrives the file is moved
+// to the image store and the upload is finalized into a `Photo`. Returns the new offset and the result of the upload
+// once it is finalized. Chunks of an upload are appended one at a time, `ErrUploadLocked` is returned while another
+// request is appending to the upload.
+func (s UploadService) Append(usr *user.User, id uuid.UUID, offset int64, chunk io.Reader) (int64, *UploadResult, error) {
+	ticket, err := s.ticket(usr, id)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !s.appending.lock(id) {
+		return 0, nil, ErrUploadLocked
+	}
+	defer s.appending.unlock(id)
+
+	staged, err := s.stagedSize(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	if offset != staged {
+		return staged, nil, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(s.staged(id), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return staged, nil, err
+	}
+	n, cerr := io.Copy(f, io.LimitReader(chunk, ticket.Size-staged+1))
+	if err = f.Close(); err != nil {
+		return staged, nil, err
+	}
+	staged += n
+	if staged > ticket.Size {
+		if err = os.Truncate(s.staged(id), ticket.Size); err != nil {
+			return offset, nil, err
+		}
+		return offset, nil, ErrUploadTooLarge
+	}
+	if cerr != nil {
+		return staged, nil, cerr
+	}
+	if staged < ticket.Size {
+		return staged, nil, nil
+	}
+
+	res, err := s.complete(usr, ticket)
+	if err != nil {
+		return staged, nil, err
+	}
+	return staged, &res, res.Err
+}
+
+// complete moves the staged file of the resumable upload to the image store and finalizes the upload.
+func (s UploadService) complete(usr *user.User, ticket *UploadTicket) (UploadResult, error) {
+	f, err := os.Open(s.staged(ticket.ID))
+	if err != nil {
+		return UploadResult{}, err
+	}
+	err = s.images.StoreStream(ticket.ID.String(), image.RawVariant, f)
+	f.Close()
+	if err != nil {
+		return UploadResult{}, err
+	}
+	if err = os.Remove(s.staged(ticket.ID)); err != nil {
+		log.Err(err).Str("id", ticket.ID.String()).Msg("Failed to remove staged upload!")
+	}
+	return s.finalize(usr, ticket.ID), nil
+}
+
+// Terminate is a method of `UploadService` cancelling a resumable upload and deleting the staged file. Returns
+// `ErrUploadLocked` while a chunk is being appended to the upload.
+func (s UploadService) Terminate(usr *user.User, id uuid.UUID) error {
+	ticket, err := s.ticket(usr, id)
+	if err != nil {
+		return err
+	}
+	if !s.appending.lock(id) {
+		return ErrUploadLocked
+	}
+	defer s.appending.unlock(id)
+	s.discard(ticket)
+	return nil
+}
+
+// Cleanup is a method of `UploadService` deleting the abandoned uploads: the files of expired tickets, either
+// staged or uploaded with presigned requests. Uploads with a chunk being appended are left for the next cleanup.
+// Returns the number of uploads deleted.
+func (s UploadService) Cleanup() (int, error) {
+	tickets, err := s.tickets.Expired(time.Now())
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := range tickets {
+		if !s.appending.lock(tickets[i].ID) {
+			continue
+		}
+		s.discard(&tickets[i])
+		s.appending.unlock(tickets[i].ID)
+		count++
+	}
+	return count, nil
+}
+
+// StartCleanup runs `Cleanup` in the background periodically.
+func (s UploadService) StartCleanup() {
+	go func() {
+		ticker := time.NewTicker(uploadCleanupInterval)
+		defer ticker.Stop()
+		for {
+			count, err := s.Cleanup()
+			if err != nil {
+				log.Err(err).Msg("Failed to clean up abandoned uploads")
+			} else if count > 0 {
+				log.Info().Int("uploads", count).Msg("Abandoned uploads cleaned up")
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func (s UploadService) ticket(usr *user.User, id uuid.UUID) (*UploadTicket, error) {
+	ticket, err := s.tickets.Load(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTicketNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ticket.UserID != usr.ID {
+		return nil, ErrTicketNotFound
+	}
+	if ticket.ExpiresAt.Before(time.Now()) {
+		s.discard(ticket)
+		return nil, ErrTicketExpired
+	}
+	return ticket, nil
+}
+
+// stagedSize returns the number of bytes staged of the resumable upload.
+func (s UploadService) stagedSize(id uuid.UUID) (int64, error) {
+	fi, err := os.Stat(s.staged(id))
+	if err != nil {
+		return 0, ErrNotStaged
+	}
+	return fi.Size(), nil
+}
+
+func (s UploadService) staged(id uuid.UUID) string {
+	return filepath.Join(s.config.StagingPath, id.String()+".part")
+}
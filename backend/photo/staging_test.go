@@ -0,0 +1,225 @@
+package photo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/common"
+	"github.com/inokone/photostorage/image"
+)
+
+func newTestStagingService(t *testing.T) (*UploadService, memTickets, map[string]Photo, *image.LocalStorer) {
+	images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	var (
+		tickets = memTickets{}
+		photos  = map[string]Photo{}
+		config  = &common.ImageStoreConfig{StagingPath: t.TempDir(), MaxUploadSize: 1 << 20}
+	)
+	return NewUploadService(memPhotos{photos: photos}, images, tickets, config, image.DefaultResampling()), tickets, photos, images
+}
+
+func TestStage(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		size     int64
+		wantErr  error
+	}{
+		{name: "staged", filename: "photo.png", size: 100},
+		{name: "unsupported", filename: "notes.txt", size: 100, wantErr: ErrUnsupportedFormat},
+		{name: "empty", filename: "photo.png", size: 0, wantErr: ErrUploadTooLarge},
+		{name: "too large", filename: "photo.png", size: 1<<20 + 1, wantErr: ErrUploadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				s, tickets, _, _ = newTestStagingService(t)
+				usr              = &user.User{ID: uuid.New()}
+			)
+			ticket, err := s.Stage(usr, tt.filename, tt.size, SkipDuplicates)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Stage() = %v; want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if len(tickets) != 0 {
+					t.Errorf("Stage() stored %v tickets; want none", len(tickets))
+				}
+				return
+			}
+			if stored := tickets[ticket.ID]; stored.UserID != usr.ID || stored.Size != tt.size || stored.FileName != tt.filename {
+				t.Errorf("Stage() stored %+v; want a ticket of the upload", stored)
+			}
+			if _, offset, err := s.Offset(usr, ticket.ID); err != nil || offset != 0 {
+				t.Errorf("Offset() = %v, %v; want 0", offset, err)
+			}
+		})
+	}
+}
+
+func TestAppend(t *testing.T) {
+	content := pngContent(t)
+	half := int64(len(content) / 2)
+	type chunk struct {
+		offset     int64
+		data       io.Reader
+		wantOffset int64
+		wantErr    error
+	}
+	tests := []struct {
+		name     string
+		chunks   []chunk
+		wantDone bool
+	}{
+		{name: "single chunk", wantDone: true, chunks: []chunk{
+			{offset: 0, data: bytes.NewReader(content), wantOffset: int64(len(content))},
+		}},
+		{name: "two chunks", wantDone: true, chunks: []chunk{
+			{offset: 0, data: bytes.NewReader(content[:half]), wantOffset: half},
+			{offset: half, data: bytes.NewReader(content[half:]), wantOffset: int64(len(content))},
+		}},
+		{name: "interrupted and resumed", wantDone: true, chunks: []chunk{
+			{
+				offset:     0,
+				data:       io.MultiReader(bytes.NewReader(content[:half]), iotest.ErrReader(io.ErrUnexpectedEOF)),
+				wantOffset: half,
+				wantErr:    io.ErrUnexpectedEOF,
+			},
+			{offset: half, data: bytes.NewReader(content[half:]), wantOffset: int64(len(content))},
+		}},
+		{name: "offset mismatch", chunks: []chunk{
+			{offset: 0, data: bytes.NewReader(content[:half]), wantOffset: half},
+			{offset: 0, data: bytes.NewReader(content[:half]), wantOffset: half, wantErr: ErrOffsetMismatch},
+		}},
+		{name: "over declared size", chunks: []chunk{
+			{offset: 0, data: bytes.NewReader(append(content, 0)), wantOffset: 0, wantErr: ErrUploadTooLarge},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				s, tickets, photos, images = newTestStagingService(t)
+				usr                        = &user.User{ID: uuid.New()}
+			)
+			ticket, err := s.Stage(usr, "photo.png", int64(len(content)), SkipDuplicates)
+			if err != nil {
+				t.Fatalf("Stage failed: %v", err)
+			}
+			var res *UploadResult
+			for i, c := range tt.chunks {
+				var offset int64
+				offset, res, err = s.Append(usr, ticket.ID, c.offset, c.data)
+				if !errors.Is(err, c.wantErr) || offset != c.wantOffset {
+					t.Fatalf("Append() of chunk %v = %v, %v; want %v, %v", i, offset, err, c.wantOffset, c.wantErr)
+				}
+			}
+
+			if (res != nil) != tt.wantDone {
+				t.Fatalf("Append() finalized = %v; want %v", res != nil, tt.wantDone)
+			}
+			if _, ok := photos[ticket.ID.String()]; ok != tt.wantDone {
+				t.Errorf("Append() stored photo = %v; want %v", ok, tt.wantDone)
+			}
+			if _, ok := tickets[ticket.ID]; ok == tt.wantDone {
+				t.Errorf("Append() kept ticket = %v; want %v", ok, !tt.wantDone)
+			}
+			if !tt.wantDone {
+				return
+			}
+			if raw, err := images.Load(ticket.ID.String(), image.RawVariant); err != nil || !bytes.Equal(raw, content) {
+				t.Errorf("Append() stored RAW = %v bytes, %v; want the uploaded file", len(raw), err)
+			}
+			if _, err = os.Stat(s.staged(ticket.ID)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Append() kept staged file: %v", err)
+			}
+		})
+	}
+}
+
+func TestAppendConcurrent(t *testing.T) {
+	var (
+		s, _, _, _ = newTestStagingService(t)
+		usr        = &user.User{ID: uuid.New()}
+		content    = pngContent(t)
+		half       = int64(len(content) / 2)
+	)
+	ticket, err := s.Stage(usr, "photo.png", int64(len(content)), SkipDuplicates)
+	if err != nil {
+		t.Fatalf("Stage failed: %v", err)
+	}
+
+	// the first request is stuck in the middle of its chunk
+	var (
+		r, w = io.Pipe()
+		done = make(chan error)
+	)
+	go func() {
+		_, _, err := s.Append(usr, ticket.ID, 0, r)
+		done <- err
+	}()
+	if _, err = w.Write(content[:half]); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if _, _, err = s.Append(usr, ticket.ID, 0, bytes.NewReader(content)); !errors.Is(err, ErrUploadLocked) {
+		t.Errorf("Append() during another append = %v; want %v", err, ErrUploadLocked)
+	}
+	if err = s.Terminate(usr, ticket.ID); !errors.Is(err, ErrUploadLocked) {
+		t.Errorf("Terminate() during an append = %v; want %v", err, ErrUploadLocked)
+	}
+
+	w.Close()
+	if err = <-done; err != nil {
+		t.Fatalf("Append() = %v; want no error", err)
+	}
+	if offset, _, err := s.Append(usr, ticket.ID, half, bytes.NewReader(content[half:])); err != nil || offset != int64(len(content)) {
+		t.Errorf("Append() after the other append = %v, %v; want %v", offset, err, len(content))
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	var (
+		s, tickets, _, _ = newTestStagingService(t)
+		usr              = &user.User{ID: uuid.New()}
+		ids              = map[string]uuid.UUID{}
+	)
+	for _, name := range []string{"live", "expired", "appending"} {
+		ticket, err := s.Stage(usr, name+".png", 100, SkipDuplicates)
+		if err != nil {
+			t.Fatalf("Stage failed: %v", err)
+		}
+		if name != "live" {
+			ticket.ExpiresAt = time.Now().Add(-time.Minute)
+			tickets[ticket.ID] = *ticket
+		}
+		ids[name] = ticket.ID
+	}
+	s.appending.lock(ids["appending"])
+
+	if count, err := s.Cleanup(); err != nil || count != 1 {
+		t.Fatalf("Cleanup() = %v, %v; want 1", count, err)
+	}
+	for name, want := range map[string]bool{"live": true, "expired": false, "appending": true} {
+		id := ids[name]
+		if _, ok := tickets[id]; ok != want {
+			t.Errorf("Cleanup() kept ticket of %v upload = %v; want %v", name, ok, want)
+		}
+		if _, err := os.Stat(s.staged(id)); (err == nil) != want {
+			t.Errorf("Cleanup() kept staged file of %v upload = %v; want %v", name, err == nil, want)
+		}
+	}
+
+	s.appending.unlock(ids["appending"])
+	if count, err := s.Cleanup(); err != nil || count != 1 {
+		t.Errorf("Cleanup() after the append = %v, %v; want 1", count, err)
+	}
+}
@@ -2,6 +2,7 @@ package photo
 
 import (
 	"github.com/google/uuid"
+	"github.com/inokone/photostorage/image"
 	_ "github.com/lib/pq" // Postgres driver package for GORM, no need to have a name
 	"gorm.io/gorm"
 )
@@ -10,12 +11,15 @@ import (
 type Writer interface {
 	Store(photo *Photo) (uuid.UUID, error)
 	Update(photo *Photo) error
+	UpdateRotation(photo *Photo) error
 	Delete(id string) error
 }
 
 // Loader is an interface for loading `Photo` entities from persistence.
 type Loader interface {
 	Load(id string) (*Photo, error)
+	ByChecksum(userID string, checksum string) (*Photo, error)
+	ByCapture(userID string, metadata image.Metadata) ([]Fingerprint, error)
 	All(userID string) ([]Photo, error)
 }
 
@@ -25,11 +29,25 @@ type Searcher interface {
 	Favorites(userID string) ([]Photo, error)
 }
 
+// Locator is an interface for querying the geotagged `Photo` entities on the map, and updating their locations.
+type Locator interface {
+	InBounds(userID string, bounds Bounds, limit int) ([]Photo, error)
+	Clusters(userID string, bounds Bounds, cell float64) ([]MapCluster, error)
+	UpdateLocation(photo *Photo) error
+}
+
+// Walker is an interface for iterating over all `Photo` entities in persistence, including deleted ones.
+type Walker interface {
+	Walk(fn func(photo *Photo) error) error
+}
+
 // Storer is an interface for types that can store `Photo`s.
 type Storer interface {
 	Writer
 	Loader
 	Searcher
+	Locator
+	Walker
 
 	UserStats(userID string) (UserStats, error)
 	Stats() (Stats, error)
@@ -67,6 +85,32 @@ func (s *GORMStorer) Update(photo *Photo) error {
 	return result.Error
 }
 
+// UpdateRotation is a method of `GORMStorer` for updating the rotation and the thumbnail size of a `Photo` entity in
+// persistence, including resetting the rotation.
+func (s *GORMStorer) UpdateRotation(photo *Photo) error {
+	result := s.db.Model(&photo.Desc).Updates(map[string]any{
+		"rotation":     photo.Desc.Rotation,
+		"thumb_width":  photo.Desc.ThumbWidth,
+		"thumb_height": photo.Desc.ThumbHeight,
+	})
+	return result.Error
+}
+
+// Walk is a method of `GORMStorer` for iterating over all `Photo` entities including deleted ones in batches,
+// calling `fn` for each.
+func (s *GORMStorer) Walk(fn func(photo *Photo) error) error {
+	var batch []Photo
+	result := s.db.Unscoped().Preload("Desc.Metadata").FindInBatches(&batch, 100, func(tx *gorm.DB, n int) error {
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return result.Error
+}
+
 // Load is a method of `GORMStorer` for loading a single `Photo` entity by ID provided as parameter.
 func (s *GORMStorer) Load(id string) (*Photo, error) {
 	var photo Photo
@@ -74,6 +118,25 @@ func (s *GORMStorer) Load(id string) (*Photo, error) {
 	return &photo, result.Error
 }
 
+// ByChecksum is a method of `GORMStorer` for loading a `Photo` of a user by the checksum of the RAW file.
+func (s *GORMStorer) ByChecksum(userID string, checksum string) (*Photo, error) {
+	var photo Photo
+	result := s.db.Where("user_id = ? AND checksum = ?", userID, checksum).First(&photo)
+	return &photo, result.Error
+}
+
+// ByCapture is a method of `GORMStorer` for loading the fingerprints of the `Photo`s of a user with the capture time
+// and dimensions of the metadata.
+func (s *GORMStorer) ByCapture(userID string, metadata image.Metadata) ([]Fingerprint, error) {
+	var fingerprints []Fingerprint
+	result := s.db.Raw(`SELECT p.id, d.format, m.data_size, d.d_hash FROM photos p
+		JOIN descriptors d ON d.id = p.desc_id
+		JOIN metadata m ON m.id = d.metadata_id
+		WHERE p.user_id = ? AND p.deleted_at IS NULL AND m.timestamp = ? AND m.width = ? AND m.height = ?`,
+		userID, metadata.Timestamp, metadata.Width, metadata.Height).Scan(&fingerprints)
+	return fingerprints, result.Error
+}
+
 // All is a method of `GORMStorer` for loading a all `Photo`s of a user specified by the ID as a parameter.
 func (s *GORMStorer) All(userID string) ([]Photo, error) {
 	var photos []Photo
@@ -106,6 +169,69 @@ func (s *GORMStorer) Search(userID string, searchText string) ([]Photo, error) {
 	return photos, result.Error
 }
 
+// InBounds is a method of `GORMStorer` for loading the geotagged `Photo`s of a user specified by the ID as a
+// parameter within the bounding box, latest captured first, at most `limit` photos.
+func (s *GORMStorer) InBounds(userID string, bounds Bounds, limit int) ([]Photo, error) {
+	var (
+		photos    []Photo
+		cond, arg = boundsCondition(bounds)
+	)
+	result := s.db.Preload(
+		"Desc.Metadata").Joins(
+		"JOIN descriptors ON descriptors.id = photos.desc_id").Joins(
+		"JOIN metadata m ON m.id = descriptors.metadata_id").Where(
+		"photos.user_id = ?", userID).Where(
+		cond, arg...).Order(
+		"m.timestamp DESC").Limit(limit).Find(&photos)
+	return photos, result.Error
+}
+
+// Clusters is a method of `GORMStorer` for grouping the geotagged `Photo`s of a user specified by the ID as a
+// parameter within the bounding box into the cells of a map grid with the cell size in degrees provided. The
+// markers are at the average coordinates of the photos of the cells.
+func (s *GORMStorer) Clusters(userID string, bounds Bounds, cell float64) ([]MapCluster, error) {
+	var (
+		clusters  []MapCluster
+		cond, arg = boundsCondition(bounds)
+		args      = append(append([]any{userID}, arg...), cell, cell)
+	)
+	result := s.db.Raw(`SELECT avg(m.gps_latitude) AS latitude, avg(m.gps_longitude) AS longitude, count(p.id) AS count,
+		(array_agg(p.id ORDER BY d.rating DESC, m.timestamp DESC))[1] AS photo_id
+		FROM photos p
+		JOIN descriptors d ON d.id = p.desc_id
+		JOIN metadata m ON m.id = d.metadata_id
+		WHERE p.user_id = ? AND p.deleted_at IS NULL AND `+cond+`
+		GROUP BY floor(m.gps_latitude / ?), floor(m.gps_longitude / ?)
+		ORDER BY count DESC`, args...).Scan(&clusters)
+	return clusters, result.Error
+}
+
+// UpdateLocation is a method of `GORMStorer` for updating the coordinates and the location of a `Photo` entity in
+// persistence, including clearing them.
+func (s *GORMStorer) UpdateLocation(photo *Photo) error {
+	m := photo.Desc.Metadata
+	result := s.db.Model(&m).Updates(map[string]any{
+		"gps_latitude":          m.GPS.Latitude,
+		"gps_longitude":         m.GPS.Longitude,
+		"gps_altitude":          m.GPS.Altitude,
+		"location_country_code": m.Location.CountryCode,
+		"location_country":      m.Location.Country,
+		"location_region":       m.Location.Region,
+		"location_city":         m.Location.City,
+	})
+	return result.Error
+}
+
+// boundsCondition returns the SQL condition of the coordinates of the metadata `m` within the bounding box.
+func boundsCondition(b Bounds) (string, []any) {
+	if b.West <= b.East {
+		return "m.gps_latitude BETWEEN ? AND ? AND m.gps_longitude BETWEEN ? AND ?",
+			[]any{b.South, b.North, b.West, b.East}
+	}
+	return "m.gps_latitude BETWEEN ? AND ? AND (m.gps_longitude >= ? OR m.gps_longitude <= ?)",
+		[]any{b.South, b.North, b.West, b.East}
+}
+
 // UserStats is a method of `GORMStorer` for collecting aggregated data on the photos of the user specified by the ID in the parameter.
 func (s *GORMStorer) UserStats(userID string) (UserStats, error) {
 	var (
@@ -117,7 +243,9 @@ func (s *GORMStorer) UserStats(userID string) (UserStats, error) {
 		return UserStats{}, res.Error
 	}
 
-	res = s.db.Raw("SELECT coalesce(sum(coalesce(used_space, 0)),0) FROM photos WHERE user_id = ?", userID).Scan(&usedSpace)
+	// photos in frozen storage count 0.5x from the quota
+	res = s.db.Raw(`SELECT coalesce(sum(CASE WHEN tier = ? THEN coalesce(used_space, 0) / 2 ELSE coalesce(used_space, 0) END), 0)
+		FROM photos WHERE user_id = ?`, image.FrozenTier, userID).Scan(&usedSpace)
 	if res.Error != nil {
 		return UserStats{}, res.Error
 	}
@@ -0,0 +1,50 @@
+package photo
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TicketStorer is an interface for persisting `UploadTicket`s of presigned uploads.
+type TicketStorer interface {
+	Store(ticket *UploadTicket) error
+	Load(id uuid.UUID) (*UploadTicket, error)
+	Delete(id uuid.UUID) error
+	Expired(before time.Time) ([]UploadTicket, error)
+}
+
+// GORMTicketStorer is an implementation of `TicketStorer` interface based on GORM library.
+type GORMTicketStorer struct {
+	db *gorm.DB
+}
+
+// NewGORMTicketStorer creates a new `GORMTicketStorer` instance based on the GORM library.
+func NewGORMTicketStorer(db *gorm.DB) *GORMTicketStorer {
+	return &GORMTicketStorer{db: db}
+}
+
+// Store is a method of `GORMTicketStorer` for persisting an `UploadTicket`.
+func (s *GORMTicketStorer) Store(ticket *UploadTicket) error {
+	return s.db.Create(ticket).Error
+}
+
+// Load is a method of `GORMTicketStorer` for loading an `UploadTicket` by ID.
+func (s *GORMTicketStorer) Load(id uuid.UUID) (*UploadTicket, error) {
+	var ticket UploadTicket
+	result := s.db.First(&ticket, "id = ?", id)
+	return &ticket, result.Error
+}
+
+// Delete is a method of `GORMTicketStorer` for deleting an `UploadTicket` by ID.
+func (s *GORMTicketStorer) Delete(id uuid.UUID) error {
+	return s.db.Delete(&UploadTicket{}, "id = ?", id).Error
+}
+
+// Expired is a method of `GORMTicketStorer` for loading the `UploadTicket`s expired before the time.
+func (s *GORMTicketStorer) Expired(before time.Time) ([]UploadTicket, error) {
+	var tickets []UploadTicket
+	result := s.db.Where("expires_at < ?", before).Find(&tickets)
+	return tickets, result.Error
+}
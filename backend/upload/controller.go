@@ -4,6 +4,7 @@ import (
 	"errors"
 	"mime/multipart"
 	"net/http"
+	"slices"
 	"sync"
 
 	"github.com/gin-gonic/gin"
@@ -20,47 +21,61 @@ type Controller struct {
 	uploads   collection.Storer
 	service   *collection.Service
 	uploader  *photo.UploadService
+	jobs      *photo.JobService
 	loader    *photo.LoadService
 	messaging common.EventMessaging
 }
 
 // NewController creates a new `Controller` instance based on the collection persistence provided in the parameter.
-func NewController(uploads collection.Storer, uploader *photo.UploadService, loader *photo.LoadService,
+func NewController(uploads collection.Storer, uploader *photo.UploadService, jobs *photo.JobService, loader *photo.LoadService,
 	service *collection.Service, messaging common.EventMessaging,
 ) Controller {
 	return Controller{
 		uploads:   uploads,
 		service:   service,
 		uploader:  uploader,
+		jobs:      jobs,
 		loader:    loader,
 		messaging: messaging,
 	}
 }
 
 // Upload is a method of `Controller`. Handles RAW and photo upload requests. Capable of handling multiple files
-// uploaded within a single request.
+// uploaded within a single request, the result of each file is returned and the upload collection is created from the
+// files uploaded successfully. Photos already uploaded by the user are rejected, skipped or linked to the upload by
+// the `duplicates` parameter or the preference of the user. XMP sidecars uploaded along with the files are matched by
+// file name, their rating, keywords, color label and title are merged into the new photos. With `async=true` the
+// files are only stored, the photos are processed in the background and the batch of jobs is returned, its status is
+// available on `/uploads/:id/status`.
 // @Summary Photo upload endpoint
 // @Schemes
 // @Tags photos
 // @Description Upload RAW files to store
 // @Accept multipart/form-data
 // @Produce json
-// @Param files[] formData file true "Photos to store"
-// @Success 201 {object} string
+// @Param files[] formData file true "Photos to store, with their XMP sidecars"
+// @Param async query bool false "Process the photos in the background"
+// @Param duplicates query string false "Handling of photos already uploaded: reject, skip or link, the default is the preference of the user"
+// @Success 200 {object} photo.UploadResponse
+// @Success 201 {object} photo.UploadResponse
+// @Success 202 {object} photo.BatchResponse
 // @Failure 400 {object} common.StatusMessage
 // @Failure 415 {object} common.StatusMessage
+// @Failure 422 {object} photo.UploadResponse
 // @Failure 500 {object} common.StatusMessage
 // @Router /uploads/ [post]
 func (c Controller) Upload(g *gin.Context) {
 	var (
-		usr   *user.User
-		err   error
-		form  *multipart.Form
-		files []*multipart.FileHeader
-		ids   []uuid.UUID
-		ch    chan photo.UploadResult
-		wg    *sync.WaitGroup
-		u     *collection.Collection
+		usr    *user.User
+		err    error
+		form   *multipart.Form
+		files  []photo.UploadFile
+		extra  []*multipart.FileHeader
+		ids    []uuid.UUID
+		ch     chan photo.UploadResult
+		wg     *sync.WaitGroup
+		u      *collection.Collection
+		policy photo.DuplicatePolicy
 	)
 
 	form, err = g.MultipartForm()
@@ -69,7 +84,7 @@ func (c Controller) Upload(g *gin.Context) {
 		return
 	}
 
-	files = form.File["files[]"]
+	files, extra = photo.MatchSidecars(form.File["files[]"])
 
 	if len(files) == 0 {
 		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "You have to upload at least 1 file!"})
@@ -87,21 +102,276 @@ func (c Controller) Upload(g *gin.Context) {
 		return
 	}
 
-	ch = make(chan photo.UploadResult, len(files))
+	policy, ok := duplicatePolicy(g, usr)
+	if !ok {
+		return
+	}
+
+	if g.Query("async") == "true" {
+		c.submit(g, usr, files, extra, policy)
+		return
+	}
+
+	ch = make(chan photo.UploadResult, len(files)+len(extra))
 	wg = new(sync.WaitGroup)
 	for _, file := range files {
 		wg.Add(1)
-		go c.uploader.Upload(usr, file, ch, wg)
+		go c.uploader.Upload(usr, file, policy, ch, wg)
 	}
 	wg.Wait()
+	for _, sidecar := range extra {
+		ch <- photo.UploadResult{FileName: sidecar.Filename, Err: photo.ErrUnmatchedSidecar}
+	}
 	close(ch)
+	res := photo.UploadResponse{Files: make([]photo.UploadFileResponse, 0, len(files)+len(extra))}
 	for result := range ch {
 		if result.Err != nil {
-			log.Err(result.Err).Msg("Failed to upload file!")
-			g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Uploaded file is corrupt!"})
+			log.Err(result.Err).Str("file", result.FileName).Msg("Failed to upload file!")
+		} else if !result.Skipped && !slices.Contains(ids, result.ID) {
+			ids = append(ids, result.ID)
+		}
+		res.Files = append(res.Files, result.AsResp())
+	}
+	if len(ids) == 0 {
+		if skipped(res.Files) {
+			g.JSON(http.StatusOK, res) // nothing new to upload
 			return
 		}
-		ids = append(ids, result.ID)
+		g.AbortWithStatusJSON(http.StatusUnprocessableEntity, res)
+		return
+	}
+
+	u, err = c.service.CreateUpload(*usr, ids)
+	if err != nil {
+		log.Err(err).Msg("Failed to create upload collection!")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Error with the upload. Please try again!"})
+		return
+	}
+
+	event := common.NewAuditEvent(
+		usr.ID.String(),
+		"upload",
+		common.UUIDtoString(ids),
+		"photo",
+		nil,
+		"success")
+	c.messaging.Publish(&event)
+
+	res.ID = u.ID.String()
+	g.JSON(http.StatusCreated, res)
+}
+
+func skipped(files []photo.UploadFileResponse) bool {
+	for _, f := range files {
+		if !f.Skipped {
+			return false
+		}
+	}
+	return true
+}
+
+// duplicatePolicy returns the duplicate policy of the `duplicates` query, the preferred one of the user by default.
+func duplicatePolicy(g *gin.Context, usr *user.User) (photo.DuplicatePolicy, bool) {
+	policy, err := photo.ParseDuplicatePolicy(g.DefaultQuery("duplicates", usr.DuplicatePolicy))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid duplicate policy!"})
+		return "", false
+	}
+	return policy, true
+}
+
+func (c Controller) submit(g *gin.Context, usr *user.User, files []photo.UploadFile, extra []*multipart.FileHeader, policy photo.DuplicatePolicy) {
+	if len(extra) > 0 {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Uploaded XMP sidecar does not match any uploaded file!"})
+		return
+	}
+	batch, jobs, err := c.jobs.Submit(usr, files, policy)
+	if errors.Is(err, photo.ErrUnsupportedFormat) {
+		g.AbortWithStatusJSON(http.StatusUnsupportedMediaType, common.StatusMessage{Code: 415, Message: "Uploaded file format is not supported!"})
+		return
+	}
+	if errors.Is(err, photo.ErrInvalidSidecar) {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Uploaded XMP sidecar is invalid!"})
+		return
+	}
+	if err != nil {
+		log.Err(err).Msg("Failed to submit upload!")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Error with the upload. Please try again!"})
+		return
+	}
+	g.JSON(http.StatusAccepted, batchResponse(batch, jobs))
+}
+
+// Status is a method of `Controller`. Handles status requests of asynchronous uploads. When the batch is done, the
+// IDs of the jobs processed can be finalized into an upload with the `/uploads/finalize` endpoint.
+// @Summary Asynchronous upload status endpoint
+// @Schemes
+// @Tags photos
+// @Description Returns the processing status of each file of an asynchronous upload
+// @Produce json
+// @Param id path string true "ID of the upload batch"
+// @Success 200 {object} photo.BatchResponse
+// @Failure 400 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /uploads/{id}/status [get]
+func (c Controller) Status(g *gin.Context) {
+	id, err := uuid.Parse(g.Param("id"))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid identifier!"})
+		return
+	}
+	usr, err := currentUser(g)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Error with the session. Please log in again!"})
+		return
+	}
+	jobs, err := c.jobs.Status(usr, id)
+	if errors.Is(err, photo.ErrBatchNotFound) {
+		g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Upload does not exist!"})
+		return
+	}
+	if err != nil {
+		log.Err(err).Msg("Failed to retrieve upload status!")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Failed to retrieve upload status!"})
+		return
+	}
+	g.JSON(http.StatusOK, batchResponse(id, jobs))
+}
+
+func batchResponse(id uuid.UUID, jobs []photo.UploadJob) photo.BatchResponse {
+	res := photo.BatchResponse{
+		ID:   id.String(),
+		Done: true,
+		Jobs: make([]photo.JobResponse, len(jobs)),
+	}
+	for i, j := range jobs {
+		res.Jobs[i] = j.AsResp()
+		if j.Status != photo.JobDone && j.Status != photo.JobFailed {
+			res.Done = false
+		}
+	}
+	return res
+}
+
+// Presign is a method of `Controller`. Handles requests for presigned uploads, so RAW files can be uploaded directly
+// to the image store. The uploads have to be finalized after the files are uploaded, duplicates are handled on
+// finalize by the `duplicates` parameter or the preference of the user.
+// @Summary Presigned upload endpoint
+// @Schemes
+// @Tags photos
+// @Description Returns presigned requests to upload RAW files directly to the image store
+// @Accept json
+// @Produce json
+// @Param data body photo.PresignUploadRequest true "Files to upload"
+// @Param duplicates query string false "Handling of photos already uploaded: reject, skip or link, the default is the preference of the user"
+// @Success 200 {array} photo.PresignUploadResponse
+// @Failure 400 {object} common.StatusMessage
+// @Failure 415 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Failure 501 {object} common.StatusMessage
+// @Router /uploads/presign [post]
+func (c Controller) Presign(g *gin.Context) {
+	var (
+		usr  *user.User
+		err  error
+		req  photo.PresignUploadRequest
+		resp []photo.PresignUploadResponse
+	)
+
+	if err = g.ShouldBindJSON(&req); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "You have to upload at least 1 file!"})
+		return
+	}
+
+	usr, err = currentUser(g)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Error with the session. Please log in again!"})
+		return
+	}
+
+	if !usr.IsActive() {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Your account has not been confirmed yet. Please confirm you e-mail address!"})
+		return
+	}
+
+	policy, ok := duplicatePolicy(g, usr)
+	if !ok {
+		return
+	}
+
+	resp, err = c.uploader.Presign(usr, req.Files, policy)
+	switch {
+	case errors.Is(err, photo.ErrPresignUnsupported):
+		g.AbortWithStatusJSON(http.StatusNotImplemented, common.StatusMessage{Code: 501, Message: "Direct uploads are not supported, please upload the files with the form!"})
+		return
+	case errors.Is(err, photo.ErrUnsupportedFormat):
+		g.AbortWithStatusJSON(http.StatusUnsupportedMediaType, common.StatusMessage{Code: 415, Message: "Uploaded file format is not supported!"})
+		return
+	case errors.Is(err, photo.ErrQuotaExceeded):
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "You can not upload files, you have reached your quota!"})
+		return
+	case err != nil:
+		log.Err(err).Msg("Failed to presign upload!")
+		g.AbortWithStatusJSON(http.StatusInternalServerError, common.StatusMessage{Code: 500, Message: "Error with the upload. Please try again!"})
+		return
+	}
+
+	g.JSON(http.StatusOK, resp)
+}
+
+// Finalize is a method of `Controller`. Handles finalizing presigned uploads: the uploaded RAW files are imported,
+// the photos and the upload collection are created. Each upload is finalized on its own, the collection is created
+// from the ones finalized successfully.
+// @Summary Presigned upload finalize endpoint
+// @Schemes
+// @Tags photos
+// @Description Imports RAW files uploaded with presigned requests
+// @Accept json
+// @Produce json
+// @Param data body photo.FinalizeRequest true "IDs of the presigned uploads"
+// @Success 200 {object} photo.UploadResponse
+// @Success 201 {object} photo.UploadResponse
+// @Failure 400 {object} common.StatusMessage
+// @Failure 422 {object} photo.UploadResponse
+// @Failure 500 {object} common.StatusMessage
+// @Router /uploads/finalize [post]
+func (c Controller) Finalize(g *gin.Context) {
+	var (
+		usr *user.User
+		err error
+		req photo.FinalizeRequest
+		ids []uuid.UUID
+		u   *collection.Collection
+	)
+
+	if err = g.ShouldBindJSON(&req); err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid upload identifiers!"})
+		return
+	}
+
+	usr, err = currentUser(g)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Error with the session. Please log in again!"})
+		return
+	}
+
+	res := photo.UploadResponse{Files: make([]photo.UploadFileResponse, 0, len(req.IDs))}
+	for _, result := range c.uploader.Finalize(usr, req.IDs) {
+		if result.Err != nil {
+			log.Err(result.Err).Str("file", result.FileName).Msg("Failed to finalize upload!")
+		} else if !result.Skipped && !slices.Contains(ids, result.ID) {
+			ids = append(ids, result.ID)
+		}
+		res.Files = append(res.Files, result.AsResp())
+	}
+	if len(ids) == 0 {
+		if skipped(res.Files) {
+			g.JSON(http.StatusOK, res) // nothing new to upload
+			return
+		}
+		g.AbortWithStatusJSON(http.StatusUnprocessableEntity, res)
+		return
 	}
 
 	u, err = c.service.CreateUpload(*usr, ids)
@@ -120,7 +390,8 @@ func (c Controller) Upload(g *gin.Context) {
 		"success")
 	c.messaging.Publish(&event)
 
-	g.JSON(http.StatusCreated, u.ID)
+	res.ID = u.ID.String()
+	g.JSON(http.StatusCreated, res)
 }
 
 // Get is the REST handler for retrieving an upload by ID.
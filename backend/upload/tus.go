@@ -0,0 +1,269 @@
+package upload
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/common"
+	"github.com/inokone/photostorage/photo"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	tusVersion      = "1.0.0"
+	tusExtensions   = "creation,termination,expiration"
+	tusContentType  = "application/offset+octet-stream"
+	tusResumable    = "Tus-Resumable"
+	tusUploadOffset = "Upload-Offset"
+	tusUploadLength = "Upload-Length"
+	tusUploadExpire = "Upload-Expires"
+	tusPhotoID      = "Upload-Photo-Id"
+)
+
+// TusOptions is a method of `Controller`. Handles discovery of the tus resumable upload protocol.
+// @Summary Resumable upload discovery endpoint
+// @Schemes
+// @Tags photos
+// @Description Returns the tus protocol version and extensions supported
+// @Success 204
+// @Router /uploads/tus/ [options]
+func (c Controller) TusOptions(g *gin.Context) {
+	g.Header(tusResumable, tusVersion)
+	g.Header("Tus-Version", tusVersion)
+	g.Header("Tus-Extension", tusExtensions)
+	if max := c.uploader.MaxUploadSize(); max > 0 {
+		g.Header("Tus-Max-Size", strconv.FormatInt(max, 10))
+	}
+	g.Status(http.StatusNoContent)
+}
+
+// TusCreate is a method of `Controller`. Handles creation of a resumable upload with the tus protocol, the file name
+// is expected in the `filename` metadata of the upload. Duplicates are handled on finalize by the `duplicates`
+// parameter or the preference of the user.
+// @Summary Resumable upload creation endpoint
+// @Schemes
+// @Tags photos
+// @Description Creates a resumable upload of a RAW file with the tus protocol
+// @Param Upload-Length header int true "Size of the file"
+// @Param Upload-Metadata header string true "Metadata of the upload with the file name"
+// @Param duplicates query string false "Handling of photos already uploaded: reject, skip or link, the default is the preference of the user"
+// @Success 201
+// @Failure 400 {object} common.StatusMessage
+// @Failure 412 {object} common.StatusMessage
+// @Failure 413 {object} common.StatusMessage
+// @Failure 415 {object} common.StatusMessage
+// @Failure 500 {object} common.StatusMessage
+// @Router /uploads/tus/ [post]
+func (c Controller) TusCreate(g *gin.Context) {
+	usr, ok := tusUser(g)
+	if !ok {
+		return
+	}
+	size, err := strconv.ParseInt(g.GetHeader(tusUploadLength), 10, 64)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid upload length!"})
+		return
+	}
+	filename := tusFileName(g.GetHeader("Upload-Metadata"))
+	if len(filename) == 0 {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "File name is missing from the upload metadata!"})
+		return
+	}
+
+	policy, ok := duplicatePolicy(g, usr)
+	if !ok {
+		return
+	}
+
+	ticket, err := c.uploader.Stage(usr, filename, size, policy)
+	if err != nil {
+		tusError(g, err)
+		return
+	}
+
+	protocol := "http"
+	if g.Request.TLS != nil {
+		protocol = "https"
+	}
+	g.Header("Location", protocol+"://"+g.Request.Host+"/api/v1/uploads/tus/"+ticket.ID.String())
+	g.Header(tusUploadExpire, ticket.ExpiresAt.UTC().Format(http.TimeFormat))
+	g.Status(http.StatusCreated)
+}
+
+// TusHead is a method of `Controller`. Handles offset requests of resumable uploads with the tus protocol.
+// @Summary Resumable upload offset endpoint
+// @Schemes
+// @Tags photos
+// @Description Returns the number of bytes received of a resumable upload
+// @Param id path string true "ID of the upload"
+// @Success 200
+// @Failure 404 {object} common.StatusMessage
+// @Failure 410 {object} common.StatusMessage
+// @Router /uploads/tus/{id} [head]
+func (c Controller) TusHead(g *gin.Context) {
+	usr, ok := tusUser(g)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(g.Param("id"))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Upload does not exist!"})
+		return
+	}
+	ticket, offset, err := c.uploader.Offset(usr, id)
+	if err != nil {
+		tusError(g, err)
+		return
+	}
+	g.Header("Cache-Control", "no-store")
+	g.Header(tusUploadOffset, strconv.FormatInt(offset, 10))
+	g.Header(tusUploadLength, strconv.FormatInt(ticket.Size, 10))
+	g.Header(tusUploadExpire, ticket.ExpiresAt.UTC().Format(http.TimeFormat))
+	g.Status(http.StatusOK)
+}
+
+// TusPatch is a method of `Controller`. Handles chunks of resumable uploads with the tus protocol. When the last
+// chunk arrives the upload is finalized, the ID of the photo is returned in the `Upload-Photo-Id` header, the ID of
+// the photo already uploaded for linked duplicates and none for skipped ones. Photos are collected into an upload by
+// finalizing their IDs with the `/uploads/finalize` endpoint.
+// @Summary Resumable upload chunk endpoint
+// @Schemes
+// @Tags photos
+// @Description Appends a chunk to a resumable upload
+// @Accept application/offset+octet-stream
+// @Param id path string true "ID of the upload"
+// @Param Upload-Offset header int true "Offset of the chunk"
+// @Success 204
+// @Failure 400 {object} common.StatusMessage
+// @Failure 404 {object} common.StatusMessage
+// @Failure 409 {object} common.StatusMessage
+// @Failure 410 {object} common.StatusMessage
+// @Failure 413 {object} common.StatusMessage
+// @Failure 415 {object} common.StatusMessage
+// @Failure 423 {object} common.StatusMessage
+// @Router /uploads/tus/{id} [patch]
+func (c Controller) TusPatch(g *gin.Context) {
+	usr, ok := tusUser(g)
+	if !ok {
+		return
+	}
+	if g.ContentType() != tusContentType {
+		g.AbortWithStatusJSON(http.StatusUnsupportedMediaType, common.StatusMessage{Code: 415, Message: "Invalid content type!"})
+		return
+	}
+	id, err := uuid.Parse(g.Param("id"))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Upload does not exist!"})
+		return
+	}
+	offset, err := strconv.ParseInt(g.GetHeader(tusUploadOffset), 10, 64)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Invalid upload offset!"})
+		return
+	}
+
+	offset, res, err := c.uploader.Append(usr, id, offset, g.Request.Body)
+	g.Header(tusUploadOffset, strconv.FormatInt(offset, 10))
+	if err != nil {
+		tusError(g, err)
+		return
+	}
+	if res != nil && !res.Skipped {
+		g.Header(tusPhotoID, res.ID.String())
+	}
+	g.Status(http.StatusNoContent)
+}
+
+// TusDelete is a method of `Controller`. Handles termination of resumable uploads with the tus protocol.
+// @Summary Resumable upload termination endpoint
+// @Schemes
+// @Tags photos
+// @Description Cancels a resumable upload and deletes the received chunks
+// @Param id path string true "ID of the upload"
+// @Success 204
+// @Failure 404 {object} common.StatusMessage
+// @Failure 423 {object} common.StatusMessage
+// @Router /uploads/tus/{id} [delete]
+func (c Controller) TusDelete(g *gin.Context) {
+	usr, ok := tusUser(g)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(g.Param("id"))
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Upload does not exist!"})
+		return
+	}
+	if err = c.uploader.Terminate(usr, id); err != nil {
+		tusError(g, err)
+		return
+	}
+	g.Status(http.StatusNoContent)
+}
+
+// tusUser checks the protocol version of the request and returns the active user of the session.
+func tusUser(g *gin.Context) (*user.User, bool) {
+	g.Header(tusResumable, tusVersion)
+	if g.GetHeader(tusResumable) != tusVersion {
+		g.Header("Tus-Version", tusVersion)
+		g.AbortWithStatusJSON(http.StatusPreconditionFailed, common.StatusMessage{Code: 412, Message: "Unsupported tus version!"})
+		return nil, false
+	}
+	usr, err := currentUser(g)
+	if err != nil {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Error with the session. Please log in again!"})
+		return nil, false
+	}
+	if !usr.IsActive() {
+		g.AbortWithStatusJSON(http.StatusUnauthorized, common.StatusMessage{Code: 401, Message: "Your account has not been confirmed yet. Please confirm you e-mail address!"})
+		return nil, false
+	}
+	return usr, true
+}
+
+// tusFileName returns the file name from the `Upload-Metadata` header: comma separated key and base64 encoded value
+// pairs.
+func tusFileName(metadata string) string {
+	for _, pair := range strings.Split(metadata, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key != "filename" && key != "name" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return ""
+		}
+		return string(decoded)
+	}
+	return ""
+}
+
+func tusError(g *gin.Context, err error) {
+	switch {
+	case errors.Is(err, photo.ErrTicketNotFound), errors.Is(err, photo.ErrNotStaged):
+		g.AbortWithStatusJSON(http.StatusNotFound, common.StatusMessage{Code: 404, Message: "Upload does not exist!"})
+	case errors.Is(err, photo.ErrTicketExpired):
+		g.AbortWithStatusJSON(http.StatusGone, common.StatusMessage{Code: 410, Message: "Upload expired, please upload the file again!"})
+	case errors.Is(err, photo.ErrOffsetMismatch):
+		g.AbortWithStatusJSON(http.StatusConflict, common.StatusMessage{Code: 409, Message: "Upload offset does not match!"})
+	case errors.Is(err, photo.ErrUploadLocked):
+		g.AbortWithStatusJSON(http.StatusLocked, common.StatusMessage{Code: 423, Message: "Upload is in progress in another request!"})
+	case errors.Is(err, photo.ErrUploadTooLarge):
+		g.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, common.StatusMessage{Code: 413, Message: "Uploaded file is too large!"})
+	case errors.Is(err, photo.ErrUnsupportedFormat):
+		g.AbortWithStatusJSON(http.StatusUnsupportedMediaType, common.StatusMessage{Code: 415, Message: "Uploaded file format is not supported!"})
+	case errors.Is(err, photo.ErrDuplicate):
+		g.AbortWithStatusJSON(http.StatusConflict, common.StatusMessage{Code: 409, Message: "Photo has already been uploaded!"})
+	case errors.Is(err, photo.ErrQuotaExceeded):
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "You can not upload files, you have reached your quota!"})
+	default:
+		log.Err(err).Msg("Failed to process resumable upload!")
+		g.AbortWithStatusJSON(http.StatusBadRequest, common.StatusMessage{Code: 400, Message: "Uploaded file is corrupt!"})
+	}
+}
@@ -0,0 +1,284 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/base64"
+	goimage "image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/inokone/photostorage/auth/user"
+	"github.com/inokone/photostorage/common"
+	"github.com/inokone/photostorage/image"
+	"github.com/inokone/photostorage/photo"
+	"gorm.io/gorm"
+)
+
+// memPhotos is a `photo.Storer` keeping the photos in memory, without duplicates.
+type memPhotos struct {
+	photo.Storer
+	photos map[string]photo.Photo
+}
+
+func (s memPhotos) Store(p *photo.Photo) (uuid.UUID, error) {
+	s.photos[p.ID.String()] = *p
+	return p.ID, nil
+}
+
+func (s memPhotos) Load(id string) (*photo.Photo, error) {
+	p, ok := s.photos[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &p, nil
+}
+
+func (s memPhotos) Delete(id string) error {
+	delete(s.photos, id)
+	return nil
+}
+
+func (s memPhotos) ByChecksum(userID string, checksum string) (*photo.Photo, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s memPhotos) ByCapture(userID string, metadata image.Metadata) ([]photo.Fingerprint, error) {
+	return nil, nil
+}
+
+// memTickets is a `photo.TicketStorer` keeping the tickets in memory.
+type memTickets map[uuid.UUID]photo.UploadTicket
+
+func (m memTickets) Store(ticket *photo.UploadTicket) error {
+	m[ticket.ID] = *ticket
+	return nil
+}
+
+func (m memTickets) Load(id uuid.UUID) (*photo.UploadTicket, error) {
+	ticket, ok := m[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &ticket, nil
+}
+
+func (m memTickets) Delete(id uuid.UUID) error {
+	delete(m, id)
+	return nil
+}
+
+func (m memTickets) Expired(before time.Time) ([]photo.UploadTicket, error) {
+	var res []photo.UploadTicket
+	for _, ticket := range m {
+		if ticket.ExpiresAt.Before(before) {
+			res = append(res, ticket)
+		}
+	}
+	return res, nil
+}
+
+// newTusRouter returns the tus endpoints of a controller with a confirmed user in the session, and the photos
+// uploaded.
+func newTusRouter(t *testing.T) (*gin.Engine, map[string]photo.Photo) {
+	images, err := image.NewLocalStorer(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorer failed: %v", err)
+	}
+	var (
+		photos   = map[string]photo.Photo{}
+		config   = &common.ImageStoreConfig{StagingPath: t.TempDir(), MaxUploadSize: 1 << 20}
+		uploader = photo.NewUploadService(memPhotos{photos: photos}, images, memTickets{}, config, image.DefaultResampling())
+		c        = NewController(nil, uploader, nil, nil, nil, nil)
+		usr      = &user.User{ID: uuid.New(), Enabled: true, Status: user.Confirmed}
+	)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	g := r.Group("/api/v1/uploads", func(g *gin.Context) {
+		g.Set("user", usr)
+	})
+	g.OPTIONS("/tus/", c.TusOptions)
+	g.POST("/tus/", c.TusCreate)
+	g.HEAD("/tus/:id", c.TusHead)
+	g.PATCH("/tus/:id", c.TusPatch)
+	g.DELETE("/tus/:id", c.TusDelete)
+	return r, photos
+}
+
+func tusRequest(r *gin.Engine, method, url string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, body)
+	req.Header.Set(tusResumable, tusVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func tusCreate(t *testing.T, r *gin.Engine, filename string, size int) string {
+	w := tusRequest(r, http.MethodPost, "/api/v1/uploads/tus/?duplicates=skip", nil, map[string]string{
+		tusUploadLength:   strconv.Itoa(size),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)),
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Create = %v %v; want 201", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	return location[strings.Index(location, "/api/"):]
+}
+
+func pngContent(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, goimage.NewRGBA(goimage.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestTusOptions(t *testing.T) {
+	r, _ := newTusRouter(t)
+	w := tusRequest(r, http.MethodOptions, "/api/v1/uploads/tus/", nil, nil)
+	if w.Code != http.StatusNoContent || w.Header().Get("Tus-Version") != tusVersion || w.Header().Get("Tus-Max-Size") != "1048576" {
+		t.Errorf("Options = %v %v; want 204 with version and maximal size", w.Code, w.Header())
+	}
+}
+
+func TestTusCreate(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "created", status: http.StatusCreated, headers: map[string]string{
+			tusUploadLength: "100", "Upload-Metadata": "filename cGhvdG8ucG5n",
+		}},
+		{name: "unsupported version", status: http.StatusPreconditionFailed, headers: map[string]string{
+			tusResumable: "0.2.2", tusUploadLength: "100", "Upload-Metadata": "filename cGhvdG8ucG5n",
+		}},
+		{name: "missing length", status: http.StatusBadRequest, headers: map[string]string{
+			"Upload-Metadata": "filename cGhvdG8ucG5n",
+		}},
+		{name: "missing file name", status: http.StatusBadRequest, headers: map[string]string{
+			tusUploadLength: "100",
+		}},
+		{name: "unsupported format", status: http.StatusUnsupportedMediaType, headers: map[string]string{
+			tusUploadLength: "100", "Upload-Metadata": "filename bm90ZXMudHh0",
+		}},
+		{name: "too large", status: http.StatusRequestEntityTooLarge, headers: map[string]string{
+			tusUploadLength: "1048577", "Upload-Metadata": "filename cGhvdG8ucG5n",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTusRouter(t)
+			w := tusRequest(r, http.MethodPost, "/api/v1/uploads/tus/", nil, tt.headers)
+			if w.Code != tt.status {
+				t.Errorf("Create = %v %v; want %v", w.Code, w.Body.String(), tt.status)
+			}
+			if created := len(w.Header().Get("Location")) > 0; created != (tt.status == http.StatusCreated) {
+				t.Errorf("Create returned location = %v; want %v", created, !created)
+			}
+		})
+	}
+}
+
+func TestTusPatch(t *testing.T) {
+	var (
+		r, photos = newTusRouter(t)
+		content   = pngContent(t)
+		half      = len(content) / 2
+		url       = tusCreate(t, r, "photo.png", len(content))
+		chunk     = map[string]string{"Content-Type": tusContentType, tusUploadOffset: "0"}
+	)
+
+	if w := tusRequest(r, http.MethodPatch, url, bytes.NewReader(content), map[string]string{tusUploadOffset: "0"}); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Patch without content type = %v; want 415", w.Code)
+	}
+	if w := tusRequest(r, http.MethodPatch, url, bytes.NewReader(content), map[string]string{"Content-Type": tusContentType}); w.Code != http.StatusBadRequest {
+		t.Errorf("Patch without offset = %v; want 400", w.Code)
+	}
+	if w := tusRequest(r, http.MethodPatch, "/api/v1/uploads/tus/"+uuid.NewString(), bytes.NewReader(content), chunk); w.Code != http.StatusNotFound {
+		t.Errorf("Patch of an unknown upload = %v; want 404", w.Code)
+	}
+
+	// the connection drops in the middle of the chunk, the bytes received are kept
+	interrupted := io.MultiReader(bytes.NewReader(content[:half]), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if w := tusRequest(r, http.MethodPatch, url, interrupted, chunk); w.Header().Get(tusUploadOffset) != strconv.Itoa(half) {
+		t.Errorf("Patch interrupted = %v with offset %v; want offset %v", w.Code, w.Header().Get(tusUploadOffset), half)
+	}
+	w := tusRequest(r, http.MethodHead, url, nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get(tusUploadOffset) != strconv.Itoa(half) || w.Header().Get(tusUploadLength) != strconv.Itoa(len(content)) {
+		t.Fatalf("Head = %v with offset %v; want 200 with offset %v", w.Code, w.Header().Get(tusUploadOffset), half)
+	}
+
+	if w = tusRequest(r, http.MethodPatch, url, bytes.NewReader(content), chunk); w.Code != http.StatusConflict {
+		t.Errorf("Patch at a stale offset = %v; want 409", w.Code)
+	}
+
+	resume := map[string]string{"Content-Type": tusContentType, tusUploadOffset: strconv.Itoa(half)}
+	if w = tusRequest(r, http.MethodPatch, url, bytes.NewReader(content[half:]), resume); w.Code != http.StatusNoContent {
+		t.Fatalf("Patch resumed = %v %v; want 204", w.Code, w.Body.String())
+	}
+	if w.Header().Get(tusUploadOffset) != strconv.Itoa(len(content)) {
+		t.Errorf("Patch resumed offset = %v; want %v", w.Header().Get(tusUploadOffset), len(content))
+	}
+	if _, ok := photos[w.Header().Get(tusPhotoID)]; !ok {
+		t.Errorf("Patch resumed photo %q is not stored", w.Header().Get(tusPhotoID))
+	}
+	if w = tusRequest(r, http.MethodHead, url, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Head of a finalized upload = %v; want 404", w.Code)
+	}
+}
+
+func TestTusDelete(t *testing.T) {
+	r, _ := newTusRouter(t)
+	url := tusCreate(t, r, "photo.png", 100)
+
+	if w := tusRequest(r, http.MethodDelete, url, nil, nil); w.Code != http.StatusNoContent {
+		t.Errorf("Delete = %v %v; want 204", w.Code, w.Body.String())
+	}
+	if w := tusRequest(r, http.MethodHead, url, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Head of a deleted upload = %v; want 404", w.Code)
+	}
+	if w := tusRequest(r, http.MethodDelete, url, nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Delete of a deleted upload = %v; want 404", w.Code)
+	}
+}
+
+func TestTusPatchConcurrent(t *testing.T) {
+	var (
+		r, _    = newTusRouter(t)
+		content = pngContent(t)
+		url     = tusCreate(t, r, "photo.png", len(content))
+		chunk   = map[string]string{"Content-Type": tusContentType, tusUploadOffset: "0"}
+		body, w = io.Pipe()
+		done    = make(chan int)
+	)
+	go func() {
+		done <- tusRequest(r, http.MethodPatch, url, body, chunk).Code
+	}()
+	if _, err := w.Write(content[:10]); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if res := tusRequest(r, http.MethodPatch, url, bytes.NewReader(content), chunk); res.Code != http.StatusLocked {
+		t.Errorf("Patch during another patch = %v; want 423", res.Code)
+	}
+	if res := tusRequest(r, http.MethodDelete, url, nil, nil); res.Code != http.StatusLocked {
+		t.Errorf("Delete during a patch = %v; want 423", res.Code)
+	}
+
+	w.Close()
+	if code := <-done; code != http.StatusNoContent {
+		t.Errorf("Patch = %v; want 204", code)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/inokone/photostorage/auth/user"
 	"github.com/inokone/photostorage/collection"
 	"github.com/inokone/photostorage/common"
+	"github.com/inokone/photostorage/export"
 	"github.com/inokone/photostorage/image"
 	"github.com/inokone/photostorage/mail"
 	"github.com/inokone/photostorage/onetime"
@@ -31,11 +32,18 @@ type Storers struct {
 	Rules       rule.Storer
 	RuleSets    ruleset.Storer
 	OneTime     onetime.Storer
+	Tickets     photo.TicketStorer
+	Jobs        photo.JobStorer
+	Takeouts    export.TakeoutStorer
 }
 
-// Services is a struct to collect all `Service` entities used by the application
+// Services is a struct to collect all `Service` entities used by the application, and the resampling of renditions
+// shared by them
 type Services struct {
-	Load photo.LoadService
+	Load       photo.LoadService
+	Jobs       *photo.JobService
+	Takeouts   *export.TakeoutService
+	Resampling image.Resampling
 }
 
 // InitPrivate is a function to initialize handler mapping for URLs protected with CORS
@@ -43,10 +51,10 @@ func InitPrivate(private *gin.RouterGroup, st Storers, se Services, c *common.Ap
 	var (
 		mailer   = mail.NewService(c.Mail)
 		colls    = collection.NewService(st.Collections)
-		uploader = photo.NewUploadService(st.Photos, st.Images, c.Store)
+		uploader = photo.NewUploadService(st.Photos, st.Images, st.Tickets, c.Store, se.Resampling)
 		loader   = photo.NewLoadService(st.Photos, st.Images, c.Store)
 		msg, err = common.NewEventMessaging(*c.Msg)
-		p        = photo.NewController(st.Photos, st.Images, c.Store)
+		p        = photo.NewController(st.Photos, st.Images, c.Store, se.Resampling)
 		m        = auth.NewJWTHandler(st.Users, c.Auth)
 		a        = auth.NewController(st.Users, st.Accounts, m, c.Auth)
 		ac       = account.NewController(st.Users, st.Accounts, mailer, c.Auth)
@@ -55,10 +63,11 @@ func InitPrivate(private *gin.RouterGroup, st Storers, se Services, c *common.Ap
 		u        = user.NewController(st.Users)
 		r        = role.NewController(st.Roles)
 		al       = album.NewController(st.Collections, loader, colls)
-		up       = upload.NewController(st.Collections, uploader, loader, colls, msg)
+		up       = upload.NewController(st.Collections, uploader, se.Jobs, loader, colls, msg)
 		rs       = ruleset.NewController(st.RuleSets, st.Rules)
 		ru       = rule.NewController(st.Rules)
 		ot       = onetime.NewController(st.OneTime, st.Images)
+		ex       = export.NewController(st.Collections, st.Photos, st.Images, se.Takeouts)
 	)
 
 	if err != nil {
@@ -82,16 +91,29 @@ func InitPrivate(private *gin.RouterGroup, st Storers, se Services, c *common.Ap
 		g.PUT("/password/reset", ac.ResetPassword)
 		g.PUT("/password/change", m.Validate, ac.ChangePassword)
 		g.GET("/profile", m.Validate, u.Profile)
+		g.POST("/takeout", m.Validate, ex.Takeout)
+		g.GET("/takeout/:id", m.Validate, ex.TakeoutStatus)
 	}
 
 	g = private.Group("/photos", m.Validate)
 	{
 		g.GET("/", p.List)
+		g.GET("/similar", p.Similar)
+		g.GET("/map", p.Map)
+		g.GET("/map/clusters", p.Clusters)
+		g.POST("/export", ex.Photos)
 		g.GET("/:id", p.Get)
 		g.PUT("/:id", p.Update)
 		g.DELETE("/:id", p.Delete)
 		g.GET("/:id/raw", p.Raw)
+		g.GET("/:id/xmp", p.Xmp)
 		g.GET("/:id/thumbnail", p.Thumbnail)
+		g.GET("/:id/preview/:size", p.Preview)
+		g.GET("/:id/render", p.Render)
+		g.PUT("/:id/tier", p.Move)
+		g.PUT("/:id/rotate", p.Rotate)
+		g.PUT("/:id/location", p.SetLocation)
+		g.DELETE("/:id/location", p.ClearLocation)
 	}
 
 	g = private.Group("/onetime", m.Validate)
@@ -102,8 +124,17 @@ func InitPrivate(private *gin.RouterGroup, st Storers, se Services, c *common.Ap
 	g = private.Group("/uploads", m.Validate)
 	{
 		g.POST("/", up.Upload)
+		g.POST("/presign", up.Presign)
+		g.POST("/finalize", up.Finalize)
+		g.OPTIONS("/tus/", up.TusOptions)
+		g.POST("/tus/", up.TusCreate)
+		g.HEAD("/tus/:id", up.TusHead)
+		g.PATCH("/tus/:id", up.TusPatch)
+		g.DELETE("/tus/:id", up.TusDelete)
 		g.GET("/", up.List)
 		g.GET("/:id", up.Get)
+		g.GET("/:id/status", up.Status)
+		g.GET("/:id/export", ex.Collection)
 	}
 
 	g = private.Group("/albums", m.Validate)
@@ -113,6 +144,7 @@ func InitPrivate(private *gin.RouterGroup, st Storers, se Services, c *common.Ap
 		g.GET("/", al.List)
 		g.GET("/:id", al.Get)
 		g.DELETE("/:id", al.Delete)
+		g.GET("/:id/export", ex.Collection)
 	}
 
 	g = private.Group("/search", m.Validate)
@@ -171,6 +203,7 @@ func InitPublic(public *gin.RouterGroup, st Storers, c *common.AppConfig) {
 	g := public.Group("/onetime")
 	{
 		g.GET("/raw/:id", ot.Raw)
+		g.GET("/archive/:id", ot.Archive)
 	}
 
 	g = public.Group("/auth")
@@ -61,7 +61,7 @@ const Photopea = () => {
             })
             .then(data => {
                 setSaving(false)
-                navigate('/uploads/' + data)
+                navigate('/uploads/' + data.id)
             })
             .catch(error => {
                 console.error('Error:', error);
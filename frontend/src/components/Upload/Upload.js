@@ -25,11 +25,17 @@ const useStyles = makeStyles(theme => createStyles({
   }
 }));
 
+const failures = (files) => files
+  .filter(file => !file.success)
+  .map(file => file.filename + ": " + file.message)
+  .join(" ")
+
 const Upload = () => {
   const navigate = useNavigate()
   const [loading, setLoading] = React.useState(false)
   const [error, setError] = React.useState(null)
   const [success, setSuccess] = React.useState(false)
+  const [notice, setNotice] = React.useState(null)
   const [files, setFiles] = React.useState([])
   const classes = useStyles();
 
@@ -57,14 +63,18 @@ const Upload = () => {
       .then(response => {
         if (!response.ok) {
           response.json().then(content => {
-            setError(content.message)
+            setError(content.files ? failures(content.files) : content.message)
             setLoading(false)
           });
         } else {
           response.json().then(content => {
-            setSuccess(true)
             setLoading(false)
-            navigate("/uploads/" + content)
+            if (!content.id) {
+              setNotice("All photos have already been uploaded, there is nothing new to add.")
+              return
+            }
+            setSuccess(true)
+            navigate("/uploads/" + content.id)
           })
         }
       })
@@ -81,11 +91,12 @@ const Upload = () => {
           <Box m={5}>
             {success && <Alert sx={{ mb: 1 }} onClose={() => setSuccess(null)} severity="success">Upload successful!</Alert>}
             {error && <Alert sx={{ mb: 1 }} onClose={() => setError(null)} severity="error">{error}</Alert>}
+            {notice && <Alert sx={{ mb: 1 }} onClose={() => setNotice(null)} severity="info">{notice}</Alert>}
             <DropzoneArea 
               m={5}  
               filesLimit={20}
               onChange={handleChange}
-              acceptedFiles={[".dng, .arw, .cr2, .crw, .nef, .orf, .raf, .jpg, .jpeg, .png, .gif"]}
+              acceptedFiles={[".dng, .arw, .cr2, .crw, .nef, .orf, .raf, .jpg, .jpeg, .png, .gif, .xmp"]}
               maxFileSize={100000000} sx={{ flexGrow: 1 }}
               showPreviews={true}
               showPreviewsInDropzone={false}